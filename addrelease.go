@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// addRelease adds the reference rows for a new release: its db4s_release_info entry, plus a db4s_download_info entry
+// and request path mapping for each of its artifacts.  Anything not given as a flag is prompted for
+func addRelease(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	var date dateFlag
+	var files listFlag
+	fs := flag.NewFlagSet("add-release", flag.ContinueOnError)
+	version := fs.String("version", "", "version number of the release (eg 3.13.1)")
+	fs.Var(&date, "date", "release date (YYYY-MM-DD)")
+	fs.Var(&files, "artifact", "request path of an artifact, optionally followed by =platform (eg "+
+		"/DB.Browser.for.SQLite-v3.13.1-win64.msi='Win64 MSI').  Can be given more than once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Prompt for whatever wasn't given
+	in := bufio.NewReader(os.Stdin)
+	interactive := *version == "" || len(files) == 0
+	if *version == "" {
+		v, err := prompt(in, "Version", "")
+		if err != nil {
+			return err
+		}
+		*version = v
+		if date.IsZero() {
+			d, err := prompt(in, "Release date (YYYY-MM-DD, blank if unknown)", "")
+			if err != nil {
+				return err
+			}
+			if d != "" {
+				if err = date.Set(d); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if *version == "" {
+		return errors.New("add-release needs a version")
+	}
+	if len(files) == 0 {
+		for {
+			request, err := prompt(in, "Artifact request path (blank when done)", "")
+			if err != nil {
+				return err
+			}
+			if request == "" {
+				break
+			}
+			platform, err := prompt(in, "Platform", artifactPlatform(*version, request))
+			if err != nil {
+				return err
+			}
+			files = append(files, request+"="+platform)
+		}
+	}
+	if len(files) == 0 {
+		return errors.New("add-release needs at least one artifact")
+	}
+
+	var artifacts []store.Artifact
+	for _, f := range files {
+		request, platform, _ := strings.Cut(f, "=")
+		if !strings.HasPrefix(request, "/") {
+			return fmt.Errorf("invalid request path '%v', it needs to start with a /", request)
+		}
+		if platform == "" {
+			platform = artifactPlatform(*version, request)
+		}
+		artifacts = append(artifacts, store.Artifact{Name: *version + " " + platform, Request: request})
+	}
+
+	// Check with the user before changing anything, when they were prompted
+	if interactive {
+		fmt.Printf("Adding release %v", *version)
+		if !date.IsZero() {
+			fmt.Printf(" (released %v)", date)
+		}
+		fmt.Println(", with the downloads:")
+		for _, a := range artifacts {
+			fmt.Printf("  %v: %v\n", a.Name, a.Request)
+		}
+		ok, err := prompt(in, "Add these? (y/n)", "n")
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(ok, "y") && !strings.EqualFold(ok, "yes") {
+			return errors.New("cancelled, nothing was added")
+		}
+	}
+
+	ids, err := db.AddRelease(ctx, *version, date.Time, artifacts)
+	if err != nil {
+		return err
+	}
+	for i, a := range artifacts {
+		log.Printf("Added download %d '%v' (%v)\n", ids[i], a.Name, a.Request)
+	}
+	log.Printf("Added release %v\n", *version)
+	return nil
+}
+
+// artifactPlatform returns the platform part of the friendly name for an artifact, going by its file name.  eg
+// "Win64 MSI" for /DB.Browser.for.SQLite-v3.13.1-win64.msi
+func artifactPlatform(version, request string) string {
+	return strings.TrimPrefix(store.DownloadLabel(request), version+" ")
+}
+
+// prompt asks the user for a value on stdout, returning the default when they just press enter
+func prompt(in *bufio.Reader, question, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%v [%v]: ", question, def)
+	} else {
+		fmt.Printf("%v: ", question)
+	}
+	answer, err := in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+		if errors.Is(err, io.EOF) {
+			return def, nil
+		}
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/report"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// adoptionReport overlays the adoption curves of two or more releases, counting the days from each release date
+func adoptionReport(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("adoption", flag.ContinueOnError)
+	versions := fs.String("versions", "", "comma separated releases to compare (eg 3.12.2,3.13.0)")
+	days := fs.Int("days", 90, "number of days since each release date to cover")
+	format := fs.String("format", "csv", "output format (csv, json or svg)")
+	out := fs.String("out", "", "file to write the report to, instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	list := splitList(*versions)
+	if len(list) < 2 {
+		return errors.New("adoption needs at least two --versions to compare")
+	}
+	if *days < 1 {
+		return errors.New("--days needs to be at least 1")
+	}
+	var write func(io.Writer, []report.Curve) error
+	switch *format {
+	case "csv":
+		write = report.WriteAdoptionCSV
+	case "json":
+		write = report.WriteAdoptionJSON
+	case "svg":
+		write = report.WriteAdoptionChart
+	default:
+		return fmt.Errorf("unknown adoption format '%v'", *format)
+	}
+
+	curves, err := report.AdoptionCurves(ctx, db, list, *days, time.Now())
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return write(os.Stdout, curves)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = write(f, curves)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote %v\n", *out)
+	return nil
+}
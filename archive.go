@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/archive"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/ingest"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// archiveColumns are the columns of the archived download log files, matching the download_log ones.  Apart from the
+// request time they're optional, so NULLs are kept apart from empty strings and zeros
+var archiveColumns = []archive.Column{
+	{Name: "client_ipv4", Type: archive.String, Optional: true},
+	{Name: "client_ipv6", Type: archive.String, Optional: true},
+	{Name: "client_ip_strange", Type: archive.String, Optional: true},
+	{Name: "client_port", Type: archive.Int32, Optional: true},
+	{Name: "remote_user", Type: archive.String, Optional: true},
+	{Name: "request_time", Type: archive.Timestamp},
+	{Name: "request_type", Type: archive.String, Optional: true},
+	{Name: "request", Type: archive.String, Optional: true},
+	{Name: "protocol", Type: archive.String, Optional: true},
+	{Name: "status", Type: archive.Int32, Optional: true},
+	{Name: "body_bytes_sent", Type: archive.Int64, Optional: true},
+	{Name: "http_referer", Type: archive.String, Optional: true},
+	{Name: "http_user_agent", Type: archive.String, Optional: true},
+}
+
+// archiveLogs exports the download_log rows older than the retention window to a Parquet file per month, either in a
+// local directory or the [archive] bucket, optionally deleting them from the database afterwards.  Only whole months
+// are archived
+func archiveLogs(ctx context.Context, conf config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	retention := fs.Int("retention", 365, "number of days of download logs to keep in the database")
+	dir := fs.String("dir", ".", "directory to write the files to, when there's no [archive] bucket")
+	del := fs.Bool("delete", false, "delete the archived rows from download_log once their file is written")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *retention < 1 {
+		return errors.New("the --retention period needs to be at least one day")
+	}
+	var bucket *ingest.Bucket
+	if conf.Archive.Bucket != "" {
+		var err error
+		bucket, err = ingest.NewBucket(conf.Archive)
+		if err != nil {
+			return err
+		}
+	}
+
+	oldest, ok, err := db.OldestLogTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Println("No download logs to archive")
+		return nil
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -*retention)
+	first := time.Date(oldest.Year(), oldest.Month(), 1, 0, 0, 0, 0, time.UTC)
+	archived := 0
+	for month := first; !month.AddDate(0, 1, 0).After(cutoff); month = month.AddDate(0, 1, 0) {
+		name := "download_log_" + month.Format("2006-01") + ".parquet"
+		var rows int64
+		if bucket != nil {
+			rows, err = archiveMonthToBucket(ctx, db, bucket, path.Join(conf.Archive.Prefix, name), month)
+		} else {
+			rows, err = archiveMonthToFile(ctx, db, filepath.Join(*dir, name), month)
+		}
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			continue
+		}
+		archived++
+		log.Printf("Archived %d download log entries to %v\n", rows, name)
+
+		if *del {
+			err = db.DeleteLogs(ctx, month, month.AddDate(0, 1, 0), rows)
+			if err != nil {
+				return err
+			}
+			log.Printf("Deleted the archived download log entries for %v\n", month.Format("2006 Jan"))
+		}
+	}
+	if archived == 0 {
+		log.Printf("No download logs older than %d days to archive\n", *retention)
+	}
+	return nil
+}
+
+// archiveMonthToFile writes a month of download logs to a local Parquet file, returning the number of rows archived.
+// The file isn't written when there aren't any
+func archiveMonthToFile(ctx context.Context, db *store.DB, fileName string, month time.Time) (rows int64, err error) {
+	// The rows are written to a temporary file first, so a failed run doesn't leave a partial file behind
+	tmpName := fileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return
+	}
+	rows, err = writeArchive(ctx, db, f, month)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || rows == 0 {
+		os.Remove(tmpName)
+		return
+	}
+	err = os.Rename(tmpName, fileName)
+	return
+}
+
+// archiveMonthToBucket uploads a month of download logs to a bucket as a Parquet file, returning the number of rows
+// archived.  The file isn't uploaded when there aren't any
+func archiveMonthToBucket(ctx context.Context, db *store.DB, bucket *ingest.Bucket, key string, month time.Time) (rows int64, err error) {
+	f, err := os.CreateTemp("", "db4s_archive_*.parquet")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	rows, err = writeArchive(ctx, db, f, month)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || rows == 0 {
+		return
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return
+	}
+	err = bucket.Put(ctx, key, data)
+	return
+}
+
+// writeArchive writes a month of download logs in Parquet format, then checks the number of rows written matches the
+// number in the database.  The rows are read a day at a time, so each query stays within the per query timeout
+func writeArchive(ctx context.Context, db *store.DB, f *os.File, month time.Time) (rows int64, err error) {
+	pw, err := archive.NewWriter(f, archiveColumns)
+	if err != nil {
+		return
+	}
+	end := month.AddDate(0, 1, 0)
+	for day := month; day.Before(end); day = day.AddDate(0, 0, 1) {
+		_, err = db.ReadLogs(ctx, day, day.AddDate(0, 0, 1), func(values []any) error {
+			return pw.Write(values...)
+		})
+		if err != nil {
+			return
+		}
+	}
+	if err = pw.Close(); err != nil {
+		return
+	}
+
+	rows = pw.Rows()
+	count, err := db.CountLogs(ctx, month, end)
+	if err != nil {
+		return
+	}
+	if count != rows {
+		err = fmt.Errorf("wrote %d download log entries for %v, but there are now %d of them", rows,
+			month.Format("2006 Jan"), count)
+	}
+	return
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// backfill reprocesses a single metric family for a date range, instead of doing a full run of every metric family
+func backfill(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	var from, to dateFlag
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	metric := fs.String("metric", "", "metric family to reprocess (eg downloads-weekly)")
+	fs.Var(&from, "from", "first date to reprocess (YYYY-MM-DD)")
+	fs.Var(&to, "to", "last date to reprocess (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fam, ok := stats.FamilyByName(*metric)
+	if !ok {
+		return fmt.Errorf("unknown metric family '%v'", *metric)
+	}
+	if from.IsZero() || to.IsZero() {
+		return errors.New("backfill needs both --from and --to dates")
+	}
+	if to.Before(from.Time) {
+		return errors.New("the --to date is before the --from date")
+	}
+
+	// The --to date is inclusive, so process up to the start of the following day
+	gen := stats.Generator{DB: db, Verbosity: db.Verbosity}
+	started := time.Now()
+	err := gen.Backfill(ctx, fam, from.Time, to.AddDate(0, 0, 1))
+	recordRun(db, "backfill", &gen, started, err)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backfilled %v from %v to %v\n", fam.Name, from, to)
+	return nil
+}
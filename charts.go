@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/chart"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/export"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// DefaultChartPeriods is how many time periods the trend charts cover, when the config file doesn't say
+const DefaultChartPeriods = 52
+
+// DefaultChartFamilies are the metric families charted, when the config file doesn't say
+var DefaultChartFamilies = []string{"users-weekly", "users-monthly", "downloads-weekly", "downloads-monthly"}
+
+// charts writes the trend charts into a directory, the same as is done after each run when the config file has a
+// [charts] dir
+func charts(ctx context.Context, conf config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("charts", flag.ContinueOnError)
+	dir := fs.String("dir", conf.Charts.Dir, "directory to write the charts to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		*dir = "."
+	}
+	c := conf.Charts
+	c.Dir = *dir
+	files, err := writeCharts(ctx, c, conf.Product.Name, db)
+	for _, f := range files {
+		log.Printf("Wrote %v\n", f)
+	}
+	return err
+}
+
+// writeCharts renders a trend chart of the totals of each configured metric family, as <family>.svg and/or
+// <family>.png files in the charts directory, returning the files written.  The charts end with the last completed time
+// period, as the current one would look like a sudden drop
+func writeCharts(ctx context.Context, conf config.ChartsInfo, product string, db store.Store) (files []string, err error) {
+	names := conf.Families
+	if len(names) == 0 {
+		names = DefaultChartFamilies
+	}
+	formats := conf.Formats
+	if len(formats) == 0 {
+		formats = []string{"svg", "png"}
+	}
+	for _, format := range formats {
+		if format != "svg" && format != "png" {
+			return nil, fmt.Errorf("unknown chart format '%v'", format)
+		}
+	}
+	periods := conf.Periods
+	if periods <= 0 {
+		periods = DefaultChartPeriods
+	}
+	if product == "" {
+		product = "DB4S"
+	}
+
+	now := time.Now().UTC()
+	for _, name := range names {
+		fam, ok := stats.FamilyByName(name)
+		if !ok {
+			return files, fmt.Errorf("unknown metric family '%v'", name)
+		}
+		endDate := fam.Granularity.Start(now)
+		startDate := endDate
+		for i := 0; i < periods; i++ {
+			startDate = fam.Granularity.Previous(startDate)
+		}
+		var rows []export.Row
+		rows, err = export.Collect(ctx, db, fam, startDate, endDate)
+		if err != nil {
+			return
+		}
+		c := chart.Chart{Title: product + " " + fam.Name}
+		for _, r := range rows {
+			if r.ID == fam.TotalID {
+				c.Points = append(c.Points, chart.Point{Date: r.Date, Value: r.Count})
+			}
+		}
+
+		for _, format := range formats {
+			fileName := filepath.Join(conf.Dir, fam.Name+"."+format)
+			if err = writeChart(c, format, fileName); err != nil {
+				return
+			}
+			files = append(files, fileName)
+		}
+	}
+	return
+}
+
+// writeChart writes a chart image file.  It's written to a temporary file first then renamed into place, so the
+// website never serves a partly written image
+func writeChart(c chart.Chart, format, fileName string) error {
+	tmpName := fileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if format == "png" {
+		err = c.PNG(f)
+	} else {
+		err = c.SVG(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
@@ -0,0 +1,169 @@
+// Package client is a Go client for the stats API of the serve mode, for the downloads site and other tooling.  The
+// request and response types here are the ones the server uses, and its OpenAPI document (served at /openapi.json)
+// is generated from them
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Badge is a shields.io endpoint badge (https://shields.io/badges/endpoint-badge), for the total of the most recent
+// completed time period of a metric family
+type Badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// Metric is an entry in the list of available query targets
+type Metric struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// QueryRequest asks for the time series of the targets over a date range.  A target is a metric family name for its
+// totals (eg "users-weekly"), or a metric family name plus a release or download name for just that one (eg
+// "users-weekly:3.12.2")
+type QueryRequest struct {
+	Range   Range    `json:"range"`
+	Targets []Target `json:"targets"`
+}
+
+// Range is the date range of a query
+type Range struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Target is a time series to query
+type Target struct {
+	Target string `json:"target"`
+	Hide   bool   `json:"hide,omitempty"`
+}
+
+// Series is the time series of a query target.  Each datapoint is a value plus the Unix timestamp in milliseconds of
+// the start of its time period
+type Series struct {
+	Target     string     `json:"target"`
+	Datapoints [][2]int64 `json:"datapoints"`
+}
+
+// Point is a single value of a time series
+type Point struct {
+	Date  time.Time
+	Value int64
+}
+
+// Points returns the datapoints of the time series with their dates
+func (s Series) Points() []Point {
+	points := make([]Point, len(s.Datapoints))
+	for i, d := range s.Datapoints {
+		points[i] = Point{Date: time.UnixMilli(d[1]).UTC(), Value: d[0]}
+	}
+	return points
+}
+
+// Error is an unsuccessful response from the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stats API request failed: %v %v", e.StatusCode, e.Message)
+}
+
+// Client makes requests to the stats API.  Set either Token, or Username and Password, for the endpoints which need
+// credentials
+type Client struct {
+	URL      string // eg https://stats.example.org
+	Token    string
+	Username string
+	Password string
+
+	// The HTTP client to make requests with, defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// New returns a client for the stats API at the given address, without credentials
+func New(apiURL string) *Client {
+	return &Client{URL: apiURL}
+}
+
+// Badge returns the badge for a metric family.  eg "downloads-monthly"
+func (c *Client) Badge(ctx context.Context, metric string) (b Badge, err error) {
+	err = c.do(ctx, http.MethodGet, "/badge/"+url.PathEscape(metric), nil, &b)
+	return
+}
+
+// Search returns the names of the available query targets
+func (c *Client) Search(ctx context.Context) (targets []string, err error) {
+	err = c.do(ctx, http.MethodPost, "/grafana/search", nil, &targets)
+	return
+}
+
+// Metrics returns the available query targets, as label/value pairs
+func (c *Client) Metrics(ctx context.Context) (metrics []Metric, err error) {
+	err = c.do(ctx, http.MethodPost, "/grafana/metrics", nil, &metrics)
+	return
+}
+
+// Query returns the time series of the targets, for the time periods starting in the given date range
+func (c *Client) Query(ctx context.Context, from, to time.Time, targets ...string) (series []Series, err error) {
+	req := QueryRequest{Range: Range{From: from, To: to}}
+	for _, t := range targets {
+		req.Targets = append(req.Targets, Target{Target: t})
+	}
+	err = c.do(ctx, http.MethodPost, "/grafana/query", req, &series)
+	return
+}
+
+// do sends a request, with the given value as its JSON body when not nil, then decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,90 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/client"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/server"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store/memstore"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	db := memstore.New()
+	db.Releases["3.12.2"] = 2
+	lastWeek := stats.Weekly.Previous(stats.Weekly.Start(time.Now()))
+	weekBefore := stats.Weekly.Previous(lastWeek)
+	for i, week := range []time.Time{weekBefore, lastWeek} {
+		err := db.SaveWeeklyUsersStats(ctx, week, 1000+500*i, map[string]int{"sqlitebrowser 3.12.2": 600 + 100*i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &server.Server{DB: db, Public: []string{server.ScopeBadges}, Credentials: []server.Credential{
+		{Name: "grafana", Token: "t0ken", Scopes: []string{server.ScopeTotals, server.ScopeBreakdowns}},
+	}}
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	// The badges are public, while the Grafana endpoints need the token
+	c := client.New(srv.URL + "/")
+	badge, err := c.Badge(ctx, "users-weekly")
+	expectedBadge := client.Badge{SchemaVersion: 1, Label: "active users last week", Message: "1.5k", Color: "blue"}
+	if err != nil || badge != expectedBadge {
+		t.Errorf("Badge() = %+v, %v, expected %+v", badge, err, expectedBadge)
+	}
+	var apiErr *client.Error
+	if _, err = c.Search(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Search() returned error %v without the token, expected a %d", err, http.StatusUnauthorized)
+	}
+	c.Token = "t0ken"
+
+	targets, err := c.Search(ctx)
+	if err != nil || !slices.Contains(targets, "users-weekly") || len(targets) != len(stats.Families()) {
+		t.Errorf("Search() = %v, %v, expected the metric families", targets, err)
+	}
+	metrics, err := c.Metrics(ctx)
+	if err != nil || len(metrics) != len(targets) ||
+		metrics[0] != (client.Metric{Label: targets[0], Value: targets[0]}) {
+		t.Errorf("Metrics() = %v, %v, expected the metric families", metrics, err)
+	}
+
+	series, err := c.Query(ctx, weekBefore, lastWeek.AddDate(0, 0, 7), "users-weekly", "users-weekly:3.12.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []client.Series{
+		{Target: "users-weekly", Datapoints: [][2]int64{{1000, weekBefore.UnixMilli()}, {1500, lastWeek.UnixMilli()}}},
+		{Target: "users-weekly:3.12.2", Datapoints: [][2]int64{{600, weekBefore.UnixMilli()},
+			{700, lastWeek.UnixMilli()}}},
+	}
+	if len(series) != len(expected) {
+		t.Fatalf("Query() = %v, expected %v", series, expected)
+	}
+	for i, got := range series {
+		if got.Target != expected[i].Target || !slices.Equal(got.Datapoints, expected[i].Datapoints) {
+			t.Errorf("Query() series %d = %v, expected %v", i, got, expected[i])
+		}
+	}
+	points := series[0].Points()
+	if len(points) != 2 || !points[1].Date.Equal(lastWeek) || points[1].Value != 1500 {
+		t.Errorf("Points() = %v, expected last week's value %d", points, 1500)
+	}
+
+	// Unsuccessful responses give the status and message
+	_, err = c.Query(ctx, weekBefore, lastWeek, "users-hourly")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest ||
+		apiErr.Message != "Unknown target 'users-hourly'" {
+		t.Errorf("Query() returned error %v for an unknown target, expected a %d", err, http.StatusBadRequest)
+	}
+	if _, err = c.Badge(ctx, "users-hourly"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Badge() returned error %v for an unknown metric, expected a %d", err, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/channels"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// collect fetches the install counts from the configured distribution channels (eg Flathub, Chocolatey) into the
+// db4s_channel_downloads table
+func collect(ctx context.Context, conf config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("collect", flag.ContinueOnError)
+	since := fs.String("since", "", "first day to collect for channels without any counts saved yet (default 30 days ago)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	from := time.Now().AddDate(0, 0, -30)
+	if *since != "" {
+		var err error
+		from, err = time.Parse("2006-01-02", *since)
+		if err != nil {
+			return errors.New("--since needs a date in YYYY-MM-DD format")
+		}
+	}
+
+	var collectors []channels.Collector
+	if conf.Flathub.Enabled {
+		collectors = append(collectors, channels.NewFlathub(conf.Flathub.AppID, conf.Flathub.URL))
+	}
+	var snapshots []channels.Snapshot
+	if conf.Chocolatey.Enabled {
+		snapshots = append(snapshots, channels.NewChocolatey(conf.Chocolatey.Package, conf.Chocolatey.URL))
+	}
+	if conf.Winget.Enabled {
+		token := conf.Winget.Token
+		if token == "" {
+			token = conf.GitHub.Token
+		}
+		snapshots = append(snapshots, channels.NewWinget(conf.Winget.Package, conf.Winget.URL, token))
+	}
+	if len(collectors) == 0 && len(snapshots) == 0 {
+		return errors.New("no distribution channels are enabled in the config file")
+	}
+	return channels.Collect(ctx, db, collectors, snapshots, from, time.Now())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/ingest"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// consume loads access log events from a Kafka topic into the download_log table as they arrive, keeping today's
+// daily stats up to date until interrupted.  The batch runs still own every earlier time period
+func consume(_ context.Context, conf config.Config, db *store.DB, _ []string) error {
+	if conf.Kafka.RestProxy == "" || conf.Kafka.Topic == "" {
+		return errors.New("consume needs the rest_proxy and topic values in the [kafka] config section")
+	}
+	if conf.Kafka.Group == "" {
+		conf.Kafka.Group = "db4s_stats_gen"
+	}
+	format := conf.Kafka.Format
+	if format == "" {
+		format = conf.Ingest.Format
+	}
+	if format == "" {
+		format = "nginx"
+	}
+	parser, err := ingest.ParserByName(format)
+	if err != nil {
+		return err
+	}
+	refresh := time.Minute
+	if conf.Kafka.Refresh > 0 {
+		refresh = time.Duration(conf.Kafka.Refresh) * time.Second
+	}
+
+	// As with the serve mode, the run deadline doesn't apply
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	consumer, err := ingest.NewKafkaConsumer(ctx, conf.Kafka)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := consumer.Close(closeCtx); err != nil {
+			log.Printf("Closing the Kafka consumer failed: %v\n", err)
+		}
+	}()
+	log.Printf("Consuming access log events from Kafka topic '%v'\n", conf.Kafka.Topic)
+
+	today := newTodayStats(db)
+	var lastRefresh time.Time
+	pending := false
+	for ctx.Err() == nil {
+		events, err := consumer.Poll(ctx, 5*time.Second)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		if len(events) > 0 {
+			entries, invalid := ingest.ParseAll(parser, events)
+			if len(entries) > 0 {
+				if _, err = db.InsertLogEntries(ctx, entries); err != nil {
+					return err
+				}
+				pending = true
+			}
+			// Only commit the offsets once the entries are saved, so nothing is lost if we stop in between
+			if err = consumer.Commit(ctx); err != nil {
+				return err
+			}
+			if db.Verbosity >= verbosity.Verbose {
+				log.Printf("Added %v entries (%v events couldn't be parsed)\n", len(entries), invalid)
+			}
+		}
+
+		// Regenerate today's daily stats from the saved entries every so often
+		if !pending || time.Since(lastRefresh) < refresh {
+			continue
+		}
+		if err = today.update(ctx); err != nil {
+			return err
+		}
+		lastRefresh, pending = time.Now(), false
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// periodGranularities maps the --period values of the diff command to the granularity of the metric families compared
+var periodGranularities = map[string]string{
+	"day":   "daily",
+	"week":  "weekly",
+	"month": "monthly",
+}
+
+// diffStats prints the per release or download changes in the saved stats between two time periods, or between the
+// saved and freshly recomputed stats of one time period
+func diffStats(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	period := fs.String("period", "month", "length of the time periods compared (day, week or month)")
+	metric := fs.String("metric", "", "only compare the users or downloads stats")
+	a := fs.String("a", "", "date in the first time period (YYYY-MM-DD, or YYYY-MM for months)")
+	b := fs.String("b", "", "date in the second time period (YYYY-MM-DD, or YYYY-MM for months)")
+	recompute := fs.Bool("recompute", false, "compare the saved stats of the --a time period with freshly recomputed ones")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	granularity, ok := periodGranularities[*period]
+	if !ok {
+		return fmt.Errorf("unknown period '%v', it needs to be day, week or month", *period)
+	}
+	if *metric != "" && *metric != "users" && *metric != "downloads" {
+		return fmt.Errorf("unknown metric '%v', it needs to be users or downloads", *metric)
+	}
+	if *a == "" || (*b == "") != *recompute {
+		return errors.New("diff needs --a, plus either --b or --recompute")
+	}
+	dateA, err := parsePeriodDate(*a)
+	if err != nil {
+		return err
+	}
+	var dateB time.Time
+	if !*recompute {
+		if dateB, err = parsePeriodDate(*b); err != nil {
+			return err
+		}
+	}
+
+	only := []string{granularity}
+	if *metric != "" {
+		only = []string{*metric + "-" + granularity}
+	}
+	families, err := stats.SelectFamilies(only, nil)
+	if err != nil {
+		return err
+	}
+	gen := stats.Generator{DB: db, Verbosity: db.Verbosity, Families: families}
+	deltas, err := gen.Diff(ctx, dateA, dateB)
+	if err != nil {
+		return err
+	}
+
+	columnB := *b
+	if *recompute {
+		columnB = "RECOMPUTED"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FAMILY\tID\tNAME\t%v\t%v\tCHANGE\t%%\n", *a, columnB)
+	for _, d := range deltas {
+		pct := "-"
+		if p := d.Percent(); p != nil {
+			pct = fmt.Sprintf("%+.1f", *p)
+		}
+		fmt.Fprintf(w, "%v\t%d\t%v\t%d\t%d\t%+d\t%v\n", d.Family, d.ID, d.Name, d.A, d.B, d.Change(), pct)
+	}
+	return w.Flush()
+}
+
+// parsePeriodDate parses a date given to the diff command, either as YYYY-MM-DD or as YYYY-MM for the first of the month
+func parsePeriodDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01", s); err == nil {
+		return t, nil
+	}
+	var d dateFlag
+	if err := d.Set(s); err != nil {
+		return time.Time{}, err
+	}
+	return d.Time, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// Exit codes, so the cron wrapper and monitoring can tell the ways a run can fail apart
+const (
+	exitOK         = 0 // Success
+	exitFailure    = 1 // Failed, for any reason not covered below
+	exitConfig     = 2 // The configuration (file, environment variables or command line) is invalid
+	exitConnection = 3 // The database (or the secret store holding its credentials) couldn't be reached
+	exitPartial    = 4 // Failed part way through, after saving the stats for some time periods
+	exitLocked     = 5 // Another run is already generating the stats
+	exitWarnings   = 6 // Succeeded, but logged warnings which might need a look
+)
+
+// fatal logs the arguments then exits with the given exit code.  This is logged even in quiet mode
+func fatal(code int, v ...any) {
+	log.SetOutput(os.Stderr)
+	log.Print(v...)
+	os.Exit(code)
+}
+
+// fatalf logs the formatted arguments then exits with the given exit code.  This is logged even in quiet mode
+func fatalf(code int, format string, v ...any) {
+	log.SetOutput(os.Stderr)
+	log.Printf(format, v...)
+	os.Exit(code)
+}
+
+// runExitCode returns the exit code for a stats generation run, going by its error and how far it got
+func runExitCode(gen *stats.Generator, err error) int {
+	switch {
+	case errors.Is(err, store.ErrRunLocked):
+		return exitLocked
+	case err != nil && gen.Processed() > 0:
+		return exitPartial
+	case err != nil:
+		return exitFailure
+	case len(gen.Warnings()) > 0:
+		return exitWarnings
+	default:
+		return exitOK
+	}
+}
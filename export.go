@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/export"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/sheets"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// DefaultSheet is the Google Sheet tab the monthly summary is written to, when the config file doesn't give one
+const DefaultSheet = "Monthly summary"
+
+// exportStats writes the saved users and downloads stats out to files, optionally limited to a date range
+func exportStats(ctx context.Context, conf config.Config, db *store.DB, args []string) error {
+	var from, to dateFlag
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "output format (csv, json, xlsx, or sheets for the monthly summary in Google Sheets)")
+	dir := fs.String("dir", ".", "directory to write the files to")
+	top := fs.Int("top", 0, "only keep the top N releases or downloads per time period, folding the rest into \"Other\"")
+	fs.Var(&from, "from", "first date to export (YYYY-MM-DD)")
+	fs.Var(&to, "to", "last date to export (YYYY-MM-DD)")
+	publish := fs.Bool("publish", false, "also upload the files to the [publish] bucket")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from.Time) {
+		return errors.New("the --to date is before the --from date")
+	}
+
+	// The --to date is inclusive, so export up to the start of the following day
+	endDate := to.Time
+	if !endDate.IsZero() {
+		endDate = endDate.AddDate(0, 0, 1)
+	}
+
+	var files []string
+	switch *format {
+	case "csv":
+		var err error
+		files, err = export.WriteCSV(ctx, db, stats.Families(), *dir, from.Time, endDate, *top)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			log.Printf("Wrote %v\n", f)
+		}
+	case "json":
+		path := filepath.Join(*dir, "db4s_stats.json")
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = export.WriteJSON(ctx, db, stats.Families(), f, from.Time, endDate, *top)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Wrote %v\n", path)
+		files = []string{path}
+	case "xlsx":
+		path := filepath.Join(*dir, "db4s_stats.xlsx")
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = export.WriteXLSX(ctx, db, stats.Families(), f, from.Time, endDate, *top)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Wrote %v\n", path)
+		files = []string{path}
+	case "sheets":
+		return exportSheets(ctx, conf.Sheets, db, from.Time, endDate)
+	default:
+		return fmt.Errorf("unknown export format '%v'", *format)
+	}
+	if *publish {
+		return publishFiles(ctx, conf.Publish, files)
+	}
+	return nil
+}
+
+// exportSheets writes the monthly summary into the [sheets] Google Sheet, replacing what was there
+func exportSheets(ctx context.Context, conf config.SheetsInfo, db *store.DB, startDate, endDate time.Time) error {
+	if conf.CredentialsFile == "" || conf.SpreadsheetID == "" {
+		return errors.New("the sheets export needs a credentials_file and spreadsheet_id in the [sheets] config section")
+	}
+	sheet := conf.Sheet
+	if sheet == "" {
+		sheet = DefaultSheet
+	}
+	client, err := sheets.NewClient(conf.URL, conf.CredentialsFile)
+	if err != nil {
+		return err
+	}
+	summary, err := export.MonthlySummary(ctx, db, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	err = client.Replace(ctx, conf.SpreadsheetID, sheet, summary)
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote %d month(s) to the '%v' sheet\n", len(summary)-1, sheet)
+	return nil
+}
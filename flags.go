@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateFlag is a command line flag holding a date, in YYYY-MM-DD format
+type dateFlag struct {
+	time.Time
+}
+
+func (d *dateFlag) Set(s string) error {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return fmt.Errorf("invalid date '%v', expected YYYY-MM-DD", s)
+	}
+	d.Time = t
+	return nil
+}
+
+func (d *dateFlag) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format("2006-01-02")
+}
+
+// splitList splits a comma separated command line value, ignoring empty entries
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// listFlag is a command line flag which can be given more than once, collecting each value
+type listFlag []string
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// importStats loads historical stats from a CSV file into the stats table of a metric family, for the time periods
+// before the download logs start.  The CSV file needs a header row of "date,id,count"
+func importStats(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	metric := fs.String("metric", "", "metric family to import into (eg downloads-monthly)")
+	file := fs.String("file", "", "CSV file to import")
+	source := fs.String("source", "import", "where the stats came from, saved with each row (eg old-hosting)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fam, ok := stats.FamilyByName(*metric)
+	if !ok {
+		return fmt.Errorf("unknown metric family '%v'", *metric)
+	}
+	if *file == "" {
+		return errors.New("import needs a --file to import")
+	}
+	if strings.TrimSpace(*source) == "" {
+		return errors.New("the --source can't be empty")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	counts, err := readImportCSV(f, fam)
+	if err != nil {
+		return fmt.Errorf("%v: %w", *file, err)
+	}
+
+	// Check the IDs are known, so the imported rows show up with a name
+	known := make(map[int]bool)
+	if fam.Kind == stats.KindUsers {
+		releases, err := db.ListReleases(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range releases {
+			known[r.ID] = true
+		}
+	} else {
+		downloads, err := db.ListDownloads(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range downloads {
+			known[d.ID] = true
+		}
+	}
+	rows := 0
+	for _, row := range counts {
+		for id := range row {
+			if !known[id] {
+				return fmt.Errorf("unknown %v ID %d, add it first (eg with add-release)", fam.TotalColumn, id)
+			}
+			rows++
+		}
+	}
+
+	err = db.ImportStats(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, *source, counts)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d row(s) for %d time period(s) into %v\n", rows, len(counts), fam.Table)
+	return nil
+}
+
+// readImportCSV reads the stats to import for a metric family, keyed by time period start date then release or
+// download ID.  Each date has to be the start of a time period before the family's first one.  The time periods without
+// a totals row get one, adding up their other rows
+func readImportCSV(r io.Reader, fam stats.Family) (map[time.Time]map[int]int64, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header row failed: %w", err)
+	}
+	if strings.Join(header, ",") != "date,id,count" {
+		return nil, fmt.Errorf("unexpected header row '%v', it needs to be 'date,id,count'", strings.Join(header, ","))
+	}
+
+	counts := make(map[time.Time]map[int]int64)
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		date, err := time.Parse("2006-01-02", record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date '%v', expected YYYY-MM-DD", line, record[0])
+		}
+		if !fam.Granularity.Start(date).Equal(date) {
+			return nil, fmt.Errorf("line %d: %v isn't the start of a %v time period", line, record[0], fam.Granularity)
+		}
+		if !date.Before(fam.FirstPeriod) {
+			return nil, fmt.Errorf("line %d: %v isn't before the download logs start (%v), so would clash with the "+
+				"generated stats", line, record[0], fam.FirstPeriod.Format("2006-01-02"))
+		}
+		id, err := strconv.Atoi(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid ID '%v'", line, record[1])
+		}
+		count, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("line %d: invalid count '%v'", line, record[2])
+		}
+		if counts[date] == nil {
+			counts[date] = make(map[int]int64)
+		}
+		if _, ok := counts[date][id]; ok {
+			return nil, fmt.Errorf("line %d: duplicate row for ID %d on %v", line, id, record[0])
+		}
+		counts[date][id] = count
+	}
+
+	for _, row := range counts {
+		if _, ok := row[fam.TotalID]; ok {
+			continue
+		}
+		var total int64
+		for _, n := range row {
+			total += n
+		}
+		row[fam.TotalID] = total
+	}
+	return counts, nil
+}
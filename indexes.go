@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// ensureIndexes checks download_log and the stats tables for the indexes the queries rely on, reporting any which are
+// missing and optionally creating them
+func ensureIndexes(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("ensure-indexes", flag.ContinueOnError)
+	create := fs.Bool("create", false, "create the missing indexes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	missing, err := db.MissingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, idx := range missing {
+		fmt.Printf("Missing index on %v\n", idx)
+	}
+
+	switch {
+	case len(missing) == 0:
+		log.Println("No missing indexes found")
+	case *create:
+		for _, idx := range missing {
+			log.Printf("Creating index %v\n", idx.Name)
+			err = db.CreateIndex(ctx, idx)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("Created %d index(es)\n", len(missing))
+	default:
+		return fmt.Errorf("found %d missing index(es), rerun with --create to add them", len(missing))
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/ingest"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// ingestLogs loads web server access log files into the download_log table, either the local ones given or the new
+// ones in the configured bucket
+func ingestLogs(ctx context.Context, conf config.Config, db *store.DB, args []string) error {
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	format := fs.String("format", conf.Ingest.Format, "access log format (nginx, apache, caddy or cloudflare)")
+	generate := fs.Bool("generate", false, "generate the stats afterwards, if any entries were added")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = "nginx"
+	}
+	parser, err := ingest.ParserByName(*format)
+	if err != nil {
+		return err
+	}
+
+	var added int64
+	switch {
+	case fs.NArg() > 0:
+		added, err = ingestFiles(ctx, db, *format, parser, fs.Args())
+	case *format == "cloudflare" && conf.Cloudflare.Bucket != "":
+		added, err = ingestBucket(ctx, db, "cloudflare", conf.Cloudflare, parser)
+	case conf.S3.Bucket != "":
+		added, err = ingestBucket(ctx, db, "s3", conf.S3, parser)
+	default:
+		return errors.New("ingest needs one or more log files (or - for stdin), or a bucket in the config file")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Tell the listen mode about the newly added entries
+	if conf.Realtime.Notify && added > 0 {
+		err = db.Notify(ctx, conf.RealtimeChannel(), strconv.FormatInt(added, 10))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Process the newly added entries into the stats
+	if *generate && added > 0 {
+		gen := stats.Generator{DB: db, Mode: stats.ModeResume, Verbosity: db.Verbosity}
+		return gen.Run(ctx)
+	}
+	return nil
+}
+
+// ingestFiles loads local log files.  Cloudflare Logpush files each cover a known time range, so they're tracked to
+// avoid loading them twice
+func ingestFiles(ctx context.Context, db *store.DB, format string, parser ingest.Parser, paths []string) (added int64, err error) {
+	for _, path := range paths {
+		var n int64
+		if format == "cloudflare" {
+			n, err = ingestTracked(ctx, db, "cloudflare", filepath.Base(path), parser, func() (io.ReadCloser, error) {
+				return ingest.Open(path)
+			})
+			if err != nil {
+				return
+			}
+			added += n
+			continue
+		}
+
+		var f io.ReadCloser
+		f, err = ingest.Open(path)
+		if err != nil {
+			return
+		}
+		var res ingest.Result
+		res, err = ingest.Ingest(ctx, db, f, parser)
+		f.Close()
+		if err != nil {
+			return
+		}
+		log.Printf("Added %v entries from %v (%v lines couldn't be parsed)\n", res.Added, path, res.Invalid)
+		added += res.Added
+	}
+	return
+}
+
+// ingestBucket loads the log files in a bucket which haven't been loaded by an earlier run
+func ingestBucket(ctx context.Context, db *store.DB, source string, conf config.BucketInfo, parser ingest.Parser) (added int64, err error) {
+	bucket, err := ingest.NewBucket(conf)
+	if err != nil {
+		return
+	}
+	objects, err := bucket.List(ctx, conf.Prefix)
+	if err != nil {
+		return
+	}
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") {
+			// Folder placeholder
+			continue
+		}
+		var n int64
+		n, err = ingestTracked(ctx, db, source, obj.Key, parser, func() (io.ReadCloser, error) {
+			r, err := bucket.Get(ctx, obj.Key)
+			if err != nil {
+				return nil, err
+			}
+			return ingest.Decompress(obj.Key, r)
+		})
+		if err != nil {
+			return
+		}
+		added += n
+	}
+	return
+}
+
+// ingestTracked loads a log file unless it's already been loaded, recording it in the ingested_logs table in the same
+// transaction as its entries
+func ingestTracked(ctx context.Context, db *store.DB, source, name string, parser ingest.Parser, open func() (io.ReadCloser, error)) (int64, error) {
+	rangeStart, rangeEnd, _ := ingest.LogpushRange(name)
+	done, err := db.LogIngested(ctx, source, name, rangeStart, rangeEnd)
+	if err != nil {
+		return 0, err
+	}
+	if done {
+		if db.Verbosity >= verbosity.Verbose {
+			log.Printf("Skipping %v, as it's already been loaded\n", name)
+		}
+		return 0, nil
+	}
+
+	f, err := open()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	tx, err := db.BeginLogs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	res, err := ingest.Ingest(ctx, tx, f, parser)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit(ctx, source, name, rangeStart, rangeEnd, res.Added)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Added %v entries from %v (%v lines couldn't be parsed)\n", res.Added, name, res.Invalid)
+	return res.Added, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// initSchema creates the stats tables, so a new environment (staging, dev laptop) can be stood up without copying the
+// DDL from production
+func initSchema(ctx context.Context, conf config.Config, db *store.DB, _ []string) error {
+	err := db.InitSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if conf.Timescale.Enabled {
+		err = db.InitTimescale(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if conf.Matviews.Enabled {
+		err = db.InitMatviews(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for _, m := range conf.Metrics {
+		err = db.InitMetricTable(ctx, m.Table)
+		if err != nil {
+			return err
+		}
+	}
+	log.Println("Database schema initialised")
+	return nil
+}
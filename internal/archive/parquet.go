@@ -0,0 +1,363 @@
+// Package archive writes the download log rows out to Parquet files, for keeping them after they're deleted from the
+// database.  Only the subset of Parquet needed for that is written: flat schemas of required or optional columns,
+// PLAIN encoded and gzip compressed, with one data page per column in each row group
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// rowGroupSize is the number of rows buffered up before they're written out as a row group
+var rowGroupSize int64 = 100000
+
+// ColumnType is the type of a Parquet column
+type ColumnType int
+
+const (
+	Int32 ColumnType = iota
+	Int64
+	String
+	Timestamp // Microseconds since the Unix epoch, in UTC
+)
+
+// Column is a column of a Parquet file.  Optional columns can hold NULLs
+type Column struct {
+	Name     string
+	Type     ColumnType
+	Optional bool
+}
+
+// Parquet physical types, converted types, encodings, compression codecs and page types, as per parquet.thrift
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	pageData = 0
+)
+
+// columnChunk is the metadata of a column chunk already written out
+type columnChunk struct {
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// rowGroup is the metadata of a row group already written out
+type rowGroup struct {
+	chunks  []columnChunk
+	numRows int64
+}
+
+// Writer writes rows to a Parquet file.  Close must be called once all of the rows are written, to write the footer
+type Writer struct {
+	w         io.Writer
+	columns   []Column
+	offset    int64
+	buffers   []bytes.Buffer // PLAIN encoded values of the current row group, per column
+	levels    [][]byte       // Definition levels of the current row group, per optional column
+	buffered  int64
+	rowGroups []rowGroup
+	rows      int64
+}
+
+// NewWriter starts a Parquet file with the given columns
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("parquet: no columns given")
+	}
+	pw := &Writer{
+		w:       w,
+		columns: columns,
+		buffers: make([]bytes.Buffer, len(columns)),
+		levels:  make([][]byte, len(columns)),
+	}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Write adds a row.  The values must match the column types: int32 for Int32, int64 for Int64, string for String and
+// time.Time for Timestamp.  Optional columns also take nil, for NULL
+func (pw *Writer) Write(values ...any) error {
+	if len(values) != len(pw.columns) {
+		return fmt.Errorf("parquet: %d values given for %d columns", len(values), len(pw.columns))
+	}
+
+	// All of the values are checked before any are buffered, so a bad one doesn't leave a partial row behind
+	for i, c := range pw.columns {
+		if err := c.check(values[i]); err != nil {
+			return err
+		}
+	}
+	for i, c := range pw.columns {
+		if c.Optional {
+			if values[i] == nil {
+				pw.levels[i] = append(pw.levels[i], 0)
+				continue
+			}
+			pw.levels[i] = append(pw.levels[i], 1)
+		}
+		if err := encodePlain(&pw.buffers[i], values[i]); err != nil {
+			return err
+		}
+	}
+	pw.buffered++
+	if pw.buffered >= rowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// check returns an error if a value doesn't match the type of the column
+func (c Column) check(value any) error {
+	switch v := value.(type) {
+	case nil:
+		if !c.Optional {
+			return fmt.Errorf("parquet: nil value given for required column %v", c.Name)
+		}
+	case int32:
+		if c.Type != Int32 {
+			return fmt.Errorf("parquet: int32 value given for column %v", c.Name)
+		}
+	case int64:
+		if c.Type != Int64 {
+			return fmt.Errorf("parquet: int64 value given for column %v", c.Name)
+		}
+	case string:
+		if c.Type != String {
+			return fmt.Errorf("parquet: string value given for column %v", c.Name)
+		}
+		if len(v) > math.MaxInt32 {
+			return fmt.Errorf("parquet: value too long for column %v", c.Name)
+		}
+	case time.Time:
+		if c.Type != Timestamp {
+			return fmt.Errorf("parquet: time value given for column %v", c.Name)
+		}
+	default:
+		return fmt.Errorf("parquet: unsupported value type %T for column %v", v, c.Name)
+	}
+	return nil
+}
+
+// encodePlain appends the PLAIN encoding of a value to a buffer
+func encodePlain(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case int32:
+		return binary.Write(buf, binary.LittleEndian, v)
+	case int64:
+		return binary.Write(buf, binary.LittleEndian, v)
+	case string:
+		if err := binary.Write(buf, binary.LittleEndian, uint32(len(v))); err != nil {
+			return err
+		}
+		_, err := buf.WriteString(v)
+		return err
+	case time.Time:
+		return binary.Write(buf, binary.LittleEndian, v.UnixMicro())
+	}
+	return fmt.Errorf("parquet: unsupported value type %T", value)
+}
+
+// encodeLevels returns the definition levels of an optional column in the RLE/bit-packing hybrid encoding, prefixed
+// with its length as data pages need.  Only RLE runs are used, with a bit width of 1
+func encodeLevels(levels []byte) []byte {
+	var runs []byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		runs = binary.AppendUvarint(runs, uint64(j-i)<<1)
+		runs = append(runs, levels[i])
+		i = j
+	}
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(runs))), runs...)
+}
+
+// Rows returns the number of rows written so far
+func (pw *Writer) Rows() int64 {
+	return pw.rows + pw.buffered
+}
+
+// Close writes out any buffered rows, then the file footer.  It doesn't close the underlying writer
+func (pw *Writer) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	var meta thriftWriter
+	pw.fileMetaData(&meta)
+	footer := meta.Bytes()
+	if err := pw.write(footer); err != nil {
+		return err
+	}
+	if err := pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))); err != nil {
+		return err
+	}
+	return pw.write([]byte(parquetMagic))
+}
+
+// flush writes the buffered rows out as a row group, with a single gzip compressed data page per column
+func (pw *Writer) flush() error {
+	if pw.buffered == 0 {
+		return nil
+	}
+	group := rowGroup{numRows: pw.buffered}
+	for i, c := range pw.columns {
+		// Optional columns have their definition levels ahead of the values, saying which rows aren't NULL
+		values := pw.buffers[i].Bytes()
+		if c.Optional {
+			values = append(encodeLevels(pw.levels[i]), values...)
+		}
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(values); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if len(values) > math.MaxInt32 || compressed.Len() > math.MaxInt32 {
+			return fmt.Errorf("parquet: page too large for column %v", c.Name)
+		}
+
+		// PageHeader, with its DataPageHeader.  The columns are flat, so there aren't any repetition levels
+		var header thriftWriter
+		header.beginStruct()
+		header.i32Field(1, pageData)
+		header.i32Field(2, int32(len(values)))
+		header.i32Field(3, int32(compressed.Len()))
+		header.structField(5)
+		header.i32Field(1, int32(pw.buffered))
+		header.i32Field(2, encodingPlain)
+		header.i32Field(3, encodingRLE)
+		header.i32Field(4, encodingRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := columnChunk{
+			offset:           pw.offset,
+			numValues:        pw.buffered,
+			uncompressedSize: int64(header.Len() + len(values)),
+			compressedSize:   int64(header.Len() + compressed.Len()),
+		}
+		if err := pw.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		pw.buffers[i].Reset()
+		pw.levels[i] = pw.levels[i][:0]
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.rows += pw.buffered
+	pw.buffered = 0
+	return nil
+}
+
+// fileMetaData writes the FileMetaData footer struct
+func (pw *Writer) fileMetaData(t *thriftWriter) {
+	t.beginStruct()
+	t.i32Field(1, 1) // version
+
+	// The schema is a root element holding the columns
+	t.listField(2, thriftStruct, len(pw.columns)+1)
+	t.beginStruct()
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(pw.columns)))
+	t.endStruct()
+	for _, c := range pw.columns {
+		physical, converted := c.Type.types()
+		t.beginStruct()
+		t.i32Field(1, physical)
+		if c.Optional {
+			t.i32Field(3, repetitionOptional)
+		} else {
+			t.i32Field(3, repetitionRequired)
+		}
+		t.stringField(4, c.Name)
+		if converted >= 0 {
+			t.i32Field(6, converted)
+		}
+		t.endStruct()
+	}
+	t.i64Field(3, pw.rows)
+
+	t.listField(4, thriftStruct, len(pw.rowGroups))
+	for _, g := range pw.rowGroups {
+		var totalSize int64
+		t.beginStruct()
+		t.listField(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			physical, _ := pw.columns[i].Type.types()
+			totalSize += chunk.uncompressedSize
+			t.beginStruct()
+			t.i64Field(2, chunk.offset)
+			t.structField(3)
+			t.i32Field(1, physical)
+			t.listField(2, thriftI32, 2)
+			t.i32(encodingPlain)
+			t.i32(encodingRLE)
+			t.listField(3, thriftBinary, 1)
+			t.string(pw.columns[i].Name)
+			t.i32Field(4, codecGzip)
+			t.i64Field(5, chunk.numValues)
+			t.i64Field(6, chunk.uncompressedSize)
+			t.i64Field(7, chunk.compressedSize)
+			t.i64Field(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64Field(2, totalSize)
+		t.i64Field(3, g.numRows)
+		t.endStruct()
+	}
+	t.stringField(6, "db4s_daily_stats_gen")
+	t.endStruct()
+}
+
+// types returns the physical and converted (or -1 for none) Parquet types of a column type
+func (c ColumnType) types() (physical, converted int32) {
+	switch c {
+	case Int32:
+		return typeInt32, -1
+	case Int64:
+		return typeInt64, -1
+	case Timestamp:
+		return typeInt64, convertedTimestampMicros
+	}
+	return typeByteArray, convertedUTF8
+}
+
+// write writes to the underlying writer, keeping track of the file offset
+func (pw *Writer) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
@@ -0,0 +1,315 @@
+package archive
+
+// The round trip tests read the written Parquet files back independently of the writer: the magic numbers and footer,
+// the schema, then the page header, gzip compressed page, definition levels and values of each column chunk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readParquet decodes a Parquet file as written by Writer, returning its FileMetaData and rows
+func readParquet(t *testing.T, data []byte) (meta map[int16]any, rows [][]any) {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatal("file doesn't start and end with the Parquet magic number")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLen
+	if footerStart < 4 {
+		t.Fatalf("footer length %d is longer than the file", footerLen)
+	}
+	r := thriftReader{t: t, data: data[footerStart : len(data)-8]}
+	meta = r.readStruct()
+	if r.pos != footerLen {
+		t.Fatalf("footer is %d bytes, but its FileMetaData is %d", footerLen, r.pos)
+	}
+
+	schema := meta[2].([]any)
+	if children := schema[0].(map[int16]any)[5]; children != int64(len(schema)-1) {
+		t.Fatalf("schema root has %v children, but there are %d columns", children, len(schema)-1)
+	}
+	columns := schema[1:]
+
+	// Each row group is expected to start straight after the previous one, with a column chunk after another
+	expectedOffset := int64(len(parquetMagic))
+	for _, g := range meta[4].([]any) {
+		group := g.(map[int16]any)
+		numRows := group[3].(int64)
+		chunks := group[1].([]any)
+		if len(chunks) != len(columns) {
+			t.Fatalf("row group has %d column chunks for %d columns", len(chunks), len(columns))
+		}
+		groupRows := make([][]any, numRows)
+		for i := range groupRows {
+			groupRows[i] = make([]any, len(columns))
+		}
+		var totalSize int64
+		for i, c := range chunks {
+			chunk := c.(map[int16]any)
+			column := columns[i].(map[int16]any)
+			md := chunk[3].(map[int16]any)
+			offset := md[9].(int64)
+			if offset != expectedOffset || chunk[2] != offset {
+				t.Fatalf("column chunk %v is at offset %d (file offset %v), expected %d", column[4], offset, chunk[2],
+					expectedOffset)
+			}
+			if md[1] != column[1] || !reflect.DeepEqual(md[3], []any{column[4]}) || md[4] != int64(codecGzip) {
+				t.Fatalf("column chunk metadata %v doesn't match the schema element %v", md, column)
+			}
+			if md[5] != numRows {
+				t.Fatalf("column chunk %v has %v values, expected %d", column[4], md[5], numRows)
+			}
+
+			// Page header
+			r := thriftReader{t: t, data: data[offset:footerStart]}
+			header := r.readStruct()
+			dataHeader := header[5].(map[int16]any)
+			if header[1] != int64(pageData) || dataHeader[1] != numRows || dataHeader[2] != int64(encodingPlain) {
+				t.Fatalf("unexpected page header %v", header)
+			}
+			uncompressedSize, compressedSize := header[2].(int64), header[3].(int64)
+			if md[6] != int64(r.pos)+uncompressedSize || md[7] != int64(r.pos)+compressedSize {
+				t.Fatalf("column chunk sizes %v and %v don't match the page header %v", md[6], md[7], header)
+			}
+			totalSize += md[6].(int64)
+
+			// Page
+			pageStart := offset + int64(r.pos)
+			zr, err := gzip.NewReader(bytes.NewReader(data[pageStart : pageStart+compressedSize]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(page)) != uncompressedSize {
+				t.Fatalf("page is %d bytes uncompressed, but its header says %d", len(page), uncompressedSize)
+			}
+			for row, v := range readPage(t, page, column, int(numRows)) {
+				groupRows[row][i] = v
+			}
+			expectedOffset = pageStart + compressedSize
+		}
+		if group[2] != totalSize {
+			t.Fatalf("row group total size is %v, expected %d", group[2], totalSize)
+		}
+		rows = append(rows, groupRows...)
+	}
+	if expectedOffset != int64(footerStart) {
+		t.Fatalf("column chunks end at %d, but the footer starts at %d", expectedOffset, footerStart)
+	}
+	if meta[3] != int64(len(rows)) {
+		t.Fatalf("file has %v rows, but its row groups have %d", meta[3], len(rows))
+	}
+	return
+}
+
+// readPage decodes the definition levels and PLAIN encoded values of a page
+func readPage(t *testing.T, page []byte, column map[int16]any, numRows int) []any {
+	t.Helper()
+	levels := make([]byte, 0, numRows)
+	if column[3] == int64(repetitionOptional) {
+		n := int(binary.LittleEndian.Uint32(page))
+		runs := page[4 : 4+n]
+		page = page[4+n:]
+		for len(runs) > 0 {
+			header, size := binary.Uvarint(runs)
+			if size <= 0 || header&1 != 0 || len(runs) < size+1 {
+				t.Fatalf("invalid RLE run in the definition levels of %v", column[4])
+			}
+			for i := uint64(0); i < header>>1; i++ {
+				levels = append(levels, runs[size])
+			}
+			runs = runs[size+1:]
+		}
+	} else {
+		for i := 0; i < numRows; i++ {
+			levels = append(levels, 1)
+		}
+	}
+	if len(levels) != numRows {
+		t.Fatalf("%d definition levels for %d rows in %v", len(levels), numRows, column[4])
+	}
+
+	values := make([]any, numRows)
+	for i, level := range levels {
+		if level == 0 {
+			continue
+		}
+		switch column[1] {
+		case int64(typeInt32):
+			values[i] = int32(binary.LittleEndian.Uint32(page))
+			page = page[4:]
+		case int64(typeInt64):
+			v := int64(binary.LittleEndian.Uint64(page))
+			page = page[8:]
+			values[i] = v
+			if column[6] == int64(convertedTimestampMicros) {
+				values[i] = time.UnixMicro(v).UTC()
+			}
+		case int64(typeByteArray):
+			n := int(binary.LittleEndian.Uint32(page))
+			values[i] = string(page[4 : 4+n])
+			page = page[4+n:]
+		default:
+			t.Fatalf("unexpected physical type %v for %v", column[1], column[4])
+		}
+	}
+	if len(page) != 0 {
+		t.Fatalf("%d bytes left over at the end of the page for %v", len(page), column[4])
+	}
+	return values
+}
+
+var testColumns = []Column{
+	{Name: "name", Type: String, Optional: true},
+	{Name: "port", Type: Int32, Optional: true},
+	{Name: "bytes", Type: Int64},
+	{Name: "time", Type: Timestamp},
+	{Name: "status", Type: Int32},
+}
+
+func TestRoundTrip(t *testing.T) {
+	// A small row group size gives several row groups, with the last one partly full
+	defer func(size int64) { rowGroupSize = size }(rowGroupSize)
+	rowGroupSize = 3
+
+	day := time.Date(2024, 2, 29, 23, 59, 59, 123456000, time.UTC)
+	rows := [][]any{
+		{"3.12.2", int32(443), int64(0), day, int32(200)},
+		{nil, nil, int64(-1), day.Add(time.Microsecond), int32(0)},
+		{"", int32(0), int64(1 << 40), day.AddDate(-30, 0, 0), int32(-5)},
+		{nil, int32(80), int64(12), day, int32(404)},
+		{"DB4S/3.13.0 (Linüx) 🙂", nil, int64(7), day, int32(200)},
+		{strings.Repeat("x", 70000), int32(-1), int64(9), day, int32(206)},
+		{nil, nil, int64(3), day, int32(500)},
+	}
+	var buf bytes.Buffer
+	pw, err := NewWriter(&buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err = pw.Write(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pw.Rows() != int64(len(rows)) {
+		t.Errorf("Rows() = %d, expected %d", pw.Rows(), len(rows))
+	}
+	if err = pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, got := readParquet(t, buf.Bytes())
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("read back rows %v, expected %v", got, rows)
+	}
+	if groups := len(meta[4].([]any)); groups != 3 {
+		t.Errorf("%d row groups, expected 3", groups)
+	}
+	if meta[6] != "db4s_daily_stats_gen" {
+		t.Errorf("created_by is %v", meta[6])
+	}
+
+	schema := meta[2].([]any)
+	for i, c := range testColumns {
+		physical, converted := c.Type.types()
+		expected := map[int16]any{1: int64(physical), 3: int64(repetitionRequired), 4: c.Name}
+		if c.Optional {
+			expected[3] = int64(repetitionOptional)
+		}
+		if converted >= 0 {
+			expected[6] = int64(converted)
+		}
+		if got := schema[i+1]; !reflect.DeepEqual(got, expected) {
+			t.Errorf("schema element %v, expected %v", got, expected)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := NewWriter(&buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta, rows := readParquet(t, buf.Bytes())
+	if len(rows) != 0 || len(meta[4].([]any)) != 0 {
+		t.Errorf("empty file has %d rows in %d row groups", len(rows), len(meta[4].([]any)))
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	if _, err := NewWriter(io.Discard, nil); err == nil {
+		t.Error("no error for a file without columns")
+	}
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		row  []any
+	}{
+		{"too few values", []any{"a", int32(1), int64(1), day}},
+		{"nil for a required column", []any{"a", int32(1), nil, day, int32(200)}},
+		{"wrong type", []any{"a", int64(1), int64(1), day, int32(200)}},
+		{"unsupported type", []any{"a", int32(1), int64(1), day, 200}},
+		{"string for a timestamp", []any{"a", int32(1), int64(1), "2024-01-01", int32(200)}},
+	}
+	var buf bytes.Buffer
+	pw, err := NewWriter(&buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := []any{"a", nil, int64(1), day, int32(200)}
+	for _, test := range tests {
+		if err := pw.Write(test.row...); err == nil {
+			t.Errorf("no error for %v", test.name)
+		}
+
+		// A rejected row mustn't leave any of its values behind
+		if err := pw.Write(good...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, rows := readParquet(t, buf.Bytes())
+	if len(rows) != len(tests) {
+		t.Fatalf("read back %d rows, expected %d", len(rows), len(tests))
+	}
+	for _, row := range rows {
+		if !reflect.DeepEqual(row, good) {
+			t.Errorf("read back row %v, expected %v", row, good)
+		}
+	}
+}
+
+func TestEncodeLevels(t *testing.T) {
+	tests := []struct {
+		levels []byte
+		want   []byte
+	}{
+		{nil, []byte{0, 0, 0, 0}},
+		{[]byte{1}, []byte{2, 0, 0, 0, 2, 1}},
+		{[]byte{1, 1, 0, 1}, []byte{6, 0, 0, 0, 4, 1, 2, 0, 2, 1}},
+		{bytes.Repeat([]byte{0}, 100), []byte{3, 0, 0, 0, 0xc8, 0x01, 0}},
+	}
+	for _, test := range tests {
+		if got := encodeLevels(test.levels); !bytes.Equal(got, test.want) {
+			t.Errorf("encodeLevels(%v) = %v, expected %v", test.levels, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol types, as used in field headers and list headers
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Parquet metadata structs with the Thrift compact protocol.  Each struct (including the
+// outermost one, and each struct in a list) is started with beginStruct or structField, and finished with endStruct
+type thriftWriter struct {
+	bytes.Buffer
+	lastField []int16 // The last field ID written in each of the structs being written
+}
+
+// beginStruct starts a struct which isn't a field of another one, ie the outermost struct or a list element
+func (t *thriftWriter) beginStruct() {
+	t.lastField = append(t.lastField, 0)
+}
+
+// endStruct finishes the current struct
+func (t *thriftWriter) endStruct() {
+	t.WriteByte(0)
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+// fieldHeader writes the header of a field of the current struct.  Field IDs must be written in increasing order
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &t.lastField[len(t.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+// structField starts a struct field of the current struct
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.i32(v)
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) stringField(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.string(s)
+}
+
+// listField starts a list field of the current struct.  The elements are written straight after it
+func (t *thriftWriter) listField(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.WriteByte(0xf0 | elemType)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) i32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) string(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.Write(binary.AppendUvarint(nil, v))
+}
+
+// zigzag maps signed integers to unsigned ones, so small negative numbers have short varint encodings too
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// thriftReader decodes Thrift compact protocol structs, as a check on thriftWriter.  Structs are decoded as maps of
+// field ID to value, lists as slices, integers as int64s and binaries as strings
+type thriftReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	r.t.Helper()
+	if r.pos >= len(r.data) {
+		r.t.Fatalf("thrift: unexpected end of data at offset %d", r.pos)
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	r.t.Helper()
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("thrift: invalid varint at offset %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// readStruct decodes a struct, up to and including its stop field
+func (r *thriftReader) readStruct() map[int16]any {
+	r.t.Helper()
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		b := r.byte()
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		if _, found := fields[id]; found {
+			r.t.Fatalf("thrift: field %d repeated", id)
+		}
+		fields[id] = r.readValue(b & 0x0f)
+		last = id
+	}
+}
+
+// readValue decodes a value of the given compact protocol type
+func (r *thriftReader) readValue(typ byte) any {
+	r.t.Helper()
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		if r.pos+n > len(r.data) {
+			r.t.Fatalf("thrift: binary of %d bytes runs past the end of the data", n)
+		}
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		b := r.byte()
+		size := int(b >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = r.readValue(b & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.t.Fatalf("thrift: unsupported type %d", typ)
+	return nil
+}
+
+func TestThriftWriter(t *testing.T) {
+	var w thriftWriter
+	w.beginStruct()
+	w.i32Field(1, -1)
+	w.i64Field(2, 1<<40)
+	w.stringField(3, "héllo")
+	w.structField(4)
+	w.i32Field(1, 7)
+	w.endStruct()
+
+	// A field ID more than 15 past the previous one needs the long form of the field header
+	w.i64Field(40, -1<<40)
+	w.listField(41, thriftI32, 20)
+	want := make([]any, 20)
+	for i := range want {
+		w.i32(int32(i - 10))
+		want[i] = int64(i - 10)
+	}
+	w.listField(42, thriftStruct, 2)
+	for i := 0; i < 2; i++ {
+		w.beginStruct()
+		w.stringField(1, "x")
+		w.endStruct()
+	}
+	w.endStruct()
+
+	r := thriftReader{t: t, data: w.Bytes()}
+	got := r.readStruct()
+	if r.pos != len(r.data) {
+		t.Errorf("%d bytes left over after the struct", len(r.data)-r.pos)
+	}
+	expected := map[int16]any{
+		1:  int64(-1),
+		2:  int64(1 << 40),
+		3:  "héllo",
+		4:  map[int16]any{1: int64(7)},
+		40: int64(-1 << 40),
+		41: want,
+		42: []any{map[int16]any{1: "x"}, map[int16]any{1: "x"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("decoded struct is %v, expected %v", got, expected)
+	}
+}
+
+func TestZigzag(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want uint64
+	}{
+		{0, 0},
+		{-1, 1},
+		{1, 2},
+		{-2, 3},
+		{2147483647, 4294967294},
+		{-2147483648, 4294967295},
+	}
+	for _, test := range tests {
+		if got := zigzag(test.in); got != test.want {
+			t.Errorf("zigzag(%d) = %d, expected %d", test.in, got, test.want)
+		}
+	}
+}
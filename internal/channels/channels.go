@@ -0,0 +1,95 @@
+// Package channels collects the install counts of DB4S from distribution channels other than our download mirrors
+// (eg Flathub), saving them into the cross-channel downloads table
+package channels
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Counts holds the install counts of a distribution channel for a single day
+type Counts struct {
+	Installs int64
+	Updates  int64
+}
+
+// Collector fetches the daily install counts of a distribution channel
+type Collector interface {
+	// Channel returns the name the counts are saved under, eg "flathub"
+	Channel() string
+
+	// Collect returns the install counts for each day from the from date, up to (but not including) the to date.  Days
+	// without any counts available yet can be left out
+	Collect(ctx context.Context, from, to time.Time) (map[time.Time]Counts, error)
+}
+
+// Snapshot fetches the running total of installs from a distribution channel which doesn't publish daily counts (eg
+// Chocolatey)
+type Snapshot interface {
+	// Channel returns the name the totals are saved under, eg "chocolatey"
+	Channel() string
+
+	// Total returns the running total of installs as of now
+	Total(ctx context.Context) (int64, error)
+}
+
+// Store is where the collected counts are saved.  store.DB is the PostgreSQL implementation
+type Store interface {
+	// LastChannelDate returns the last day with install counts saved for a distribution channel, if there is one
+	LastChannelDate(ctx context.Context, channel string) (time.Time, bool, error)
+
+	// SaveChannelDownloads saves the install counts of a distribution channel for the given day
+	SaveChannelDownloads(ctx context.Context, channel string, date time.Time, installs, updates int64) error
+
+	// SaveChannelTotal saves the running total of installs of a distribution channel for the given day
+	SaveChannelTotal(ctx context.Context, channel string, date time.Time, total int64) error
+}
+
+// Collect runs each of the collectors, saving the counts for the days since the last one saved for its channel.  For
+// channels without any counts saved yet, collection starts at the since date.  Only completed days are collected.  The
+// current totals from the snapshots are saved for today, so running this daily gives the installs per day for them too
+func Collect(ctx context.Context, db Store, collectors []Collector, snapshots []Snapshot, since, now time.Time) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+	for _, c := range collectors {
+		from := since.UTC().Truncate(24 * time.Hour)
+		last, ok, err := db.LastChannelDate(ctx, c.Channel())
+		if err != nil {
+			return err
+		}
+		if ok {
+			from = last.AddDate(0, 0, 1)
+		}
+		if !from.Before(today) {
+			continue
+		}
+		counts, err := c.Collect(ctx, from, today)
+		if err != nil {
+			return fmt.Errorf("collecting the %v install counts failed: %w", c.Channel(), err)
+		}
+		for day := from; day.Before(today); day = day.AddDate(0, 0, 1) {
+			n, ok := counts[day]
+			if !ok {
+				continue
+			}
+			err = db.SaveChannelDownloads(ctx, c.Channel(), day, n.Installs, n.Updates)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("Collected %d day(s) of %v install counts\n", len(counts), c.Channel())
+	}
+	for _, s := range snapshots {
+		total, err := s.Total(ctx)
+		if err != nil {
+			return fmt.Errorf("collecting the %v install total failed: %w", s.Channel(), err)
+		}
+		err = db.SaveChannelTotal(ctx, s.Channel(), today, total)
+		if err != nil {
+			return err
+		}
+		log.Printf("Collected the %v install total: %d\n", s.Channel(), total)
+	}
+	return nil
+}
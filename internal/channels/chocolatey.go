@@ -0,0 +1,81 @@
+package channels
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultChocolateyPackage is the Chocolatey package ID of DB4S
+const DefaultChocolateyPackage = "sqlitebrowser"
+
+// DefaultChocolateyURL is the Chocolatey package feed, when the config file doesn't give another
+const DefaultChocolateyURL = "https://community.chocolatey.org/api/v2"
+
+// Chocolatey fetches the running total of installs of a package from the Chocolatey community feed.  Chocolatey only
+// publishes the download count of each package across all its versions, so the installs per day come from the
+// increase in it between runs
+type Chocolatey struct {
+	Package string
+	URL     string
+}
+
+// chocolateyFeed is the part of the OData (Atom) feed of a package's versions we use.  Each version has the same
+// DownloadCount, for the package as a whole
+type chocolateyFeed struct {
+	Entries []struct {
+		DownloadCount int64 `xml:"properties>DownloadCount"`
+	} `xml:"entry"`
+}
+
+// NewChocolatey returns a Chocolatey snapshot for the given package, using the default package and feed when they're
+// empty
+func NewChocolatey(pkg, feed string) *Chocolatey {
+	if pkg == "" {
+		pkg = DefaultChocolateyPackage
+	}
+	if feed == "" {
+		feed = DefaultChocolateyURL
+	}
+	return &Chocolatey{Package: pkg, URL: strings.TrimSuffix(feed, "/")}
+}
+
+// Channel returns the name the Chocolatey totals are saved under
+func (c *Chocolatey) Channel() string {
+	return "chocolatey"
+}
+
+// Total returns the download count of the package, across all its versions
+func (c *Chocolatey) Total(ctx context.Context) (int64, error) {
+	u := c.URL + "/FindPackagesById()?id=" + url.QueryEscape("'"+c.Package+"'")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/atom+xml")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("chocolatey returned status %v", resp.Status)
+	}
+	var feed chocolateyFeed
+	err = xml.NewDecoder(resp.Body).Decode(&feed)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chocolatey package feed: %w", err)
+	}
+	if len(feed.Entries) == 0 {
+		return 0, errors.New("chocolatey package not found: " + c.Package)
+	}
+	var total int64
+	for _, e := range feed.Entries {
+		total = max(total, e.DownloadCount)
+	}
+	return total, nil
+}
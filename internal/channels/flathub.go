@@ -0,0 +1,96 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultFlathubAppID is the Flathub application ID of DB4S
+const DefaultFlathubAppID = "io.github.sqlitebrowser.sqlitebrowser"
+
+// DefaultFlathubURL is where the Flathub stats are published, when the config file doesn't give somewhere else
+const DefaultFlathubURL = "https://flathub.org/stats"
+
+// Flathub collects the daily install counts of an application from the Flathub stats.  These are published as a
+// JSON file per day, holding the downloads and updates of each application per architecture
+type Flathub struct {
+	AppID string
+	URL   string
+}
+
+// flathubDay is the part of a Flathub daily stats file we use.  The refs map the application IDs to the
+// [downloads, updates] counts for each architecture
+type flathubDay struct {
+	Refs map[string]map[string][]int64 `json:"refs"`
+}
+
+// NewFlathub returns a Flathub collector for the given application ID, using the default ID and stats location when
+// they're empty
+func NewFlathub(appID, url string) *Flathub {
+	if appID == "" {
+		appID = DefaultFlathubAppID
+	}
+	if url == "" {
+		url = DefaultFlathubURL
+	}
+	return &Flathub{AppID: appID, URL: strings.TrimSuffix(url, "/")}
+}
+
+// Channel returns the name the Flathub counts are saved under
+func (f *Flathub) Channel() string {
+	return "flathub"
+}
+
+// Collect returns the Flathub install counts for each day in the given range.  Days whose stats file hasn't been
+// published yet are left out
+func (f *Flathub) Collect(ctx context.Context, from, to time.Time) (map[time.Time]Counts, error) {
+	counts := make(map[time.Time]Counts)
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		n, ok, err := f.day(ctx, day)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			counts[day] = n
+		}
+	}
+	return counts, nil
+}
+
+// day fetches the install counts for a single day.  The Flathub downloads include the updates, so the installs are
+// the downloads minus the updates
+func (f *Flathub) day(ctx context.Context, day time.Time) (Counts, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL+"/"+day.Format("2006/01/02")+".json", nil)
+	if err != nil {
+		return Counts{}, false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Counts{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Counts{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Counts{}, false, fmt.Errorf("flathub returned status %v for %v", resp.Status, day.Format("2006-01-02"))
+	}
+	var stats flathubDay
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return Counts{}, false, fmt.Errorf("invalid flathub stats for %v: %w", day.Format("2006-01-02"), err)
+	}
+	var n Counts
+	for _, counts := range stats.Refs[f.AppID] {
+		if len(counts) < 2 {
+			continue
+		}
+		n.Installs += counts[0] - counts[1]
+		n.Updates += counts[1]
+	}
+	return n, true, nil
+}
@@ -0,0 +1,118 @@
+package channels
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/github"
+)
+
+// DefaultWingetPackage is the winget package identifier of DB4S
+const DefaultWingetPackage = "DBBrowserForSQLite.DBBrowserForSQLite"
+
+// Winget fetches the running total of downloads of the installers in a package's winget manifests.  winget doesn't
+// publish any install stats itself, but its manifests point at the installers to download, so for installers hosted
+// as GitHub release assets their download counts are used.  These include people downloading the same installers
+// from the GitHub releases page.  Installers hosted anywhere else (eg our download mirrors) are skipped, as they're
+// in the download logs already
+type Winget struct {
+	Package string
+	GitHub  *github.Client
+}
+
+// githubContent is an entry of a GitHub directory listing
+type githubContent struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// NewWinget returns a winget snapshot for the given package, using the default package and GitHub API address when
+// they're empty
+func NewWinget(pkg, api, token string) *Winget {
+	if pkg == "" {
+		pkg = DefaultWingetPackage
+	}
+	return &Winget{Package: pkg, GitHub: github.NewClient(api, token)}
+}
+
+// Channel returns the name the winget totals are saved under
+func (w *Winget) Channel() string {
+	return "winget"
+}
+
+// Total returns the download count of the installers in all the versions of the package's winget manifests
+func (w *Winget) Total(ctx context.Context) (int64, error) {
+	// The manifests are in eg manifests/d/DBBrowserForSQLite/DBBrowserForSQLite/<version>/ of microsoft/winget-pkgs
+	dir := "manifests/" + strings.ToLower(w.Package[:1]) + "/" + strings.ReplaceAll(w.Package, ".", "/")
+	var versions []githubContent
+	err := w.GitHub.Get(ctx, "/repos/microsoft/winget-pkgs/contents/"+dir, &versions)
+	if err != nil {
+		return 0, err
+	}
+	var installers []string
+	for _, v := range versions {
+		if v.Type != "dir" {
+			continue
+		}
+		var manifest []byte
+		err = w.GitHub.Get(ctx, "/repos/microsoft/winget-pkgs/contents/"+dir+"/"+v.Name+"/"+w.Package+".installer.yaml", &manifest)
+		if err != nil {
+			return 0, err
+		}
+		installers = append(installers, installerURLs(manifest)...)
+	}
+
+	// Fetch the release of each installer hosted on GitHub, going by its download URL
+	// (https://github.com/<owner>/<repo>/releases/download/<tag>/<file>)
+	releases := make(map[string]github.Release)
+	var total int64
+	for _, installer := range installers {
+		u, err := url.Parse(installer)
+		if err != nil || u.Host != "github.com" {
+			log.Printf("Skipping winget installer not hosted on GitHub: %v\n", installer)
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+		if len(parts) != 6 || parts[2] != "releases" || parts[3] != "download" {
+			log.Printf("Skipping winget installer not hosted on GitHub: %v\n", installer)
+			continue
+		}
+		path := "/repos/" + parts[0] + "/" + parts[1] + "/releases/tags/" + parts[4]
+		release, ok := releases[path]
+		if !ok {
+			err = w.GitHub.Get(ctx, path, &release)
+			if err != nil {
+				return 0, err
+			}
+			releases[path] = release
+		}
+		for _, asset := range release.Assets {
+			if asset.DownloadURL == installer {
+				total += asset.DownloadCount
+			}
+		}
+	}
+	return total, nil
+}
+
+// installerURLs returns the InstallerUrl values of a winget installer manifest.  The manifests have one per
+// installer, as a plain "InstallerUrl: <url>" line, so this doesn't need a full YAML parser
+func installerURLs(manifest []byte) (urls []string) {
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "-"))
+		value, ok := strings.CutPrefix(line, "InstallerUrl:")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if value != "" {
+			urls = append(urls, value)
+		}
+	}
+	return
+}
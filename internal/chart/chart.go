@@ -0,0 +1,241 @@
+// Package chart renders trend charts of the users and downloads stats as standalone SVG or PNG images, for embedding
+// in the website and release announcements.  The drawing is done by go-chart, with the gridlines and date labels
+// picked here so they fall on round numbers and evenly spaced dates
+package chart
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	gochart "github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+// Dimensions of the images, in pixels
+const (
+	Width  = 800
+	Height = 300
+
+	padLeft   = 20
+	padRight  = 20
+	padTop    = 50
+	padBottom = 20
+)
+
+// yTicks is the most gridlines dividing up the value axis
+const yTicks = 4
+
+// xLabels is the most dates labelled along the time axis
+const xLabels = 6
+
+// Colours of the charts
+var (
+	background = drawing.ColorWhite
+	gridGrey   = drawing.ColorFromHex("e5e5e5")
+	labelGrey  = drawing.ColorFromHex("666666")
+	lineBlue   = drawing.ColorFromHex("2a6ebb")
+	titleBlack = drawing.ColorFromHex("222222")
+)
+
+// Point is a single value on a chart
+type Point struct {
+	Date  time.Time
+	Value int64
+}
+
+// Chart is a line chart of values over time
+type Chart struct {
+	Title  string
+	Points []Point // Ordered by date
+}
+
+// scale is the range of the value axis, and the gridlines dividing it up
+type scale struct {
+	max   int64 // The value at the top of the plot area
+	step  int64 // The value between gridlines
+	ticks int   // How many gridlines there are above the time axis
+}
+
+// newScale returns the scale fitting the chart's values, rounded up so the gridlines fall on round numbers
+func (c Chart) newScale() scale {
+	var top int64
+	for _, p := range c.Points {
+		top = max(top, p.Value)
+	}
+
+	// Use the smallest step of 1, 2 or 5 times a power of 10 that fits the values into the gridlines
+	for mult := int64(1); ; mult *= 10 {
+		for _, m := range []int64{1, 2, 5} {
+			step := m * mult
+			if ticks := int((top + step - 1) / step); ticks <= yTicks {
+				ticks = max(ticks, 1)
+				return scale{max: step * int64(ticks), step: step, ticks: ticks}
+			}
+		}
+	}
+}
+
+// labelled returns the indexes of the points whose dates are labelled along the time axis, spread evenly and always
+// including the first and last ones
+func (c Chart) labelled() []int {
+	n := len(c.Points)
+	if n == 0 {
+		return nil
+	}
+	if n == 1 {
+		return []int{0}
+	}
+	count := min(n, xLabels)
+	idx := make([]int, count)
+	for i := range idx {
+		idx[i] = i * (n - 1) / (count - 1)
+	}
+	return idx
+}
+
+// label formats a value for the value axis.  eg "12k"
+func label(value int64) string {
+	switch {
+	case value >= 1000000 && value%1000000 == 0:
+		return strconv.FormatInt(value/1000000, 10) + "M"
+	case value >= 1000 && value%1000 == 0:
+		return strconv.FormatInt(value/1000, 10) + "k"
+	default:
+		return strconv.FormatInt(value, 10)
+	}
+}
+
+// SVG writes the chart as a standalone SVG image, with its styles inline so it displays the same wherever it's
+// embedded
+func (c Chart) SVG(w io.Writer) error {
+	// go-chart writes text into the SVG as-is, so the title is escaped first
+	var b strings.Builder
+	if err := c.render(gochart.SVG, &b, escape(c.Title)); err != nil {
+		return err
+	}
+
+	// go-chart only gives the image a viewBox, but the size is needed when it's embedded with an <img> tag
+	svg := strings.Replace(b.String(), "<svg ", fmt.Sprintf(`<svg width="%d" height="%d" `, Width, Height), 1)
+	_, err := io.WriteString(w, svg)
+	return err
+}
+
+// PNG writes the chart as a PNG image
+func (c Chart) PNG(w io.Writer) error {
+	return c.render(gochart.PNG, w, c.Title)
+}
+
+// render draws the chart with the given go-chart renderer
+func (c Chart) render(rp gochart.RendererProvider, w io.Writer, title string) error {
+	if len(c.Points) == 0 {
+		return renderNoData(rp, w, title)
+	}
+
+	series := gochart.TimeSeries{
+		XValues: make([]time.Time, len(c.Points)),
+		YValues: make([]float64, len(c.Points)),
+		Style: gochart.Style{
+			StrokeColor: lineBlue,
+			StrokeWidth: 2,
+			FillColor:   lineBlue.WithAlpha(38),
+		},
+	}
+	for i, p := range c.Points {
+		series.XValues[i], series.YValues[i] = p.Date, float64(p.Value)
+	}
+
+	// go-chart fits the axes to their ticks.  A single point is drawn as a dot, in the middle of unlabelled ticks a
+	// day either side of it as the time axis has to cover some time
+	var dateTicks []gochart.Tick
+	for _, i := range c.labelled() {
+		date := c.Points[i].Date
+		dateTicks = append(dateTicks, gochart.Tick{Value: gochart.TimeToFloat64(date), Label: date.Format("2006-01-02")})
+	}
+	if len(c.Points) == 1 {
+		date := c.Points[0].Date
+		dateTicks = []gochart.Tick{
+			{Value: gochart.TimeToFloat64(date.AddDate(0, 0, -1))},
+			dateTicks[0],
+			{Value: gochart.TimeToFloat64(date.AddDate(0, 0, 1))},
+		}
+		series.Style.DotWidth, series.Style.DotColor = 3, lineBlue
+		series.Style.FillColor = drawing.ColorTransparent
+	}
+
+	// The gridlines, labelled with their values
+	s := c.newScale()
+	var valueTicks []gochart.Tick
+	var gridLines []gochart.GridLine
+	for i := 0; i <= s.ticks; i++ {
+		v := s.step * int64(i)
+		valueTicks = append(valueTicks, gochart.Tick{Value: float64(v), Label: label(v)})
+		gridLines = append(gridLines, gochart.GridLine{Value: float64(v)})
+	}
+
+	axisStyle := gochart.Style{FontColor: labelGrey, FontSize: 9, StrokeColor: gridGrey}
+	gc := gochart.Chart{
+		Title:      title,
+		TitleStyle: gochart.Style{FontColor: titleBlack, FontSize: 14},
+		Width:      Width,
+		Height:     Height,
+		Background: gochart.Style{
+			FillColor: background,
+			Padding:   gochart.Box{Top: padTop, Left: padLeft, Right: padRight, Bottom: padBottom},
+		},
+		XAxis: gochart.XAxis{
+			Style:          axisStyle,
+			Ticks:          dateTicks,
+			GridMajorStyle: gochart.Hidden(),
+			GridMinorStyle: gochart.Hidden(),
+		},
+		YAxis: gochart.YAxis{
+			Style:          axisStyle,
+			Ticks:          valueTicks,
+			GridLines:      gridLines,
+			GridMajorStyle: gochart.Style{StrokeColor: gridGrey, StrokeWidth: 1},
+		},
+		YAxisSecondary: gochart.HideYAxis(),
+		Series:         []gochart.Series{series},
+	}
+	return gc.Render(rp, w)
+}
+
+// renderNoData draws a chart without any points, which is just its title and a note there's nothing to show yet.
+// go-chart won't draw a chart without values, so this uses its renderer directly
+func renderNoData(rp gochart.RendererProvider, w io.Writer, title string) error {
+	r, err := rp(Width, Height)
+	if err != nil {
+		return err
+	}
+	font, err := gochart.GetDefaultFont()
+	if err != nil {
+		return err
+	}
+	gochart.Draw.Box(r, gochart.Box{Right: Width, Bottom: Height}, gochart.Style{FillColor: background})
+	gochart.Draw.TextWithin(r, title, gochart.Box{Top: 10, Right: Width, Bottom: padTop}, gochart.Style{
+		Font:                font,
+		FontColor:           titleBlack,
+		FontSize:            14,
+		TextHorizontalAlign: gochart.TextHorizontalAlignCenter,
+	})
+	gochart.Draw.TextWithin(r, "No data", gochart.Box{Top: padTop, Right: Width, Bottom: Height - padBottom},
+		gochart.Style{
+			Font:                font,
+			FontColor:           labelGrey,
+			FontSize:            11,
+			TextHorizontalAlign: gochart.TextHorizontalAlignCenter,
+			TextVerticalAlign:   gochart.TextVerticalAlignMiddle,
+		})
+	return r.Save(w)
+}
+
+// escape escapes text for use in the SVG
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
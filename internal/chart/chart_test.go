@@ -0,0 +1,180 @@
+package chart
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+	"testing"
+	"time"
+)
+
+// svgDoc is the subset of an SVG chart checked by the tests
+type svgDoc struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2000/svg svg"`
+	Width   int      `xml:"width,attr"`
+	Height  int      `xml:"height,attr"`
+	Texts   []string `xml:"text"`
+	Circles []struct {
+		CX string `xml:"cx,attr"`
+	} `xml:"circle"`
+}
+
+// testChart returns a chart with the given values, a week apart
+func testChart(title string, values ...int64) Chart {
+	c := Chart{Title: title}
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range values {
+		c.Points = append(c.Points, Point{Date: date.AddDate(0, 0, 7*i), Value: v})
+	}
+	return c
+}
+
+func parseSVG(t *testing.T, c Chart) svgDoc {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.SVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc svgDoc
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("SVG doesn't parse: %v\n%s", err, buf.String())
+	}
+	if doc.Width != Width || doc.Height != Height {
+		t.Errorf("SVG is %dx%d, expected %dx%d", doc.Width, doc.Height, Width, Height)
+	}
+	return doc
+}
+
+func TestSVG(t *testing.T) {
+	doc := parseSVG(t, testChart(`Users <weekly> & "more"`, 120, 3400, 2900, 4100))
+	if !slices.Contains(doc.Texts, `Users <weekly> & "more"`) {
+		t.Errorf("no title in %q", doc.Texts)
+	}
+
+	// The highest value of 4100 is rounded up to a top gridline of 6k, in steps of 2k
+	for _, want := range []string{"0", "2k", "4k", "6k", "2024-01-01", "2024-01-22"} {
+		if !slices.Contains(doc.Texts, want) {
+			t.Errorf("no %q label in %q", want, doc.Texts)
+		}
+	}
+	if slices.Contains(doc.Texts, "8k") {
+		t.Errorf("unexpected 8k label in %q", doc.Texts)
+	}
+}
+
+func TestSVGEmpty(t *testing.T) {
+	doc := parseSVG(t, testChart("Nothing yet"))
+	if !slices.Equal(doc.Texts, []string{"Nothing yet", "No data"}) {
+		t.Errorf("empty chart texts are %q, expected the title then \"No data\"", doc.Texts)
+	}
+}
+
+func TestSVGSinglePoint(t *testing.T) {
+	// A single point has nothing to draw a line between, so it's drawn as a dot
+	doc := parseSVG(t, testChart("One", 0))
+	if len(doc.Circles) != 1 {
+		t.Errorf("expected a single dot, got %+v", doc.Circles)
+	}
+	if !slices.Contains(doc.Texts, "2024-01-01") {
+		t.Errorf("no date label in %q", doc.Texts)
+	}
+}
+
+// decodePNG renders a chart as a PNG, then decodes it back
+func decodePNG(t *testing.T, c Chart) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.PNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("PNG doesn't decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+		t.Errorf("PNG is %dx%d, expected %dx%d", b.Dx(), b.Dy(), Width, Height)
+	}
+	return img
+}
+
+// blueish returns whether a pixel is mostly the line colour
+func blueish(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return b > r+0x4000 && b > g+0x2000
+}
+
+// linePixels returns how many pixels of an image are drawn in the line colour
+func linePixels(img image.Image) int {
+	var n int
+	for y := 0; y < Height; y++ {
+		for x := 0; x < Width; x++ {
+			if blueish(img.At(x, y)) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestPNG(t *testing.T) {
+	img := decodePNG(t, testChart("Downloads", 120, 3400, 2900, 4100))
+	if r, g, b, _ := img.At(0, 0).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("background isn't white")
+	}
+
+	// The line runs across most of the image, so it's drawn in at least as many pixels as half the image is wide
+	if n := linePixels(img); n < Width/2 {
+		t.Errorf("only %d pixels of the line are drawn", n)
+	}
+}
+
+func TestPNGEmpty(t *testing.T) {
+	if n := linePixels(decodePNG(t, testChart("Nothing yet"))); n != 0 {
+		t.Errorf("empty chart has %d pixels of a line drawn", n)
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		top   int64
+		want  scale
+		label string
+	}{
+		{0, scale{max: 1, step: 1, ticks: 1}, "1"},
+		{3, scale{max: 3, step: 1, ticks: 3}, "3"},
+		{7, scale{max: 8, step: 2, ticks: 4}, "8"},
+		{100, scale{max: 100, step: 50, ticks: 2}, "100"},
+		{12345, scale{max: 15000, step: 5000, ticks: 3}, "15k"},
+		{3999999, scale{max: 4000000, step: 1000000, ticks: 4}, "4M"},
+	}
+	for _, test := range tests {
+		got := testChart("", 0, test.top).newScale()
+		if got != test.want {
+			t.Errorf("scale for %d is %+v, expected %+v", test.top, got, test.want)
+		}
+		if l := label(got.max); l != test.label {
+			t.Errorf("label(%d) = %v, expected %v", got.max, l, test.label)
+		}
+	}
+}
+
+func TestLabelled(t *testing.T) {
+	tests := []struct {
+		points int
+		want   []int
+	}{
+		{0, nil},
+		{1, []int{0}},
+		{3, []int{0, 1, 2}},
+		{52, []int{0, 10, 20, 30, 40, 51}},
+	}
+	for _, test := range tests {
+		got := testChart("", make([]int64, test.points)...).labelled()
+		if !slices.Equal(got, test.want) {
+			t.Errorf("labelled points of %d are %v, expected %v", test.points, got, test.want)
+		}
+	}
+}
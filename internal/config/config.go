@@ -0,0 +1,366 @@
+// Package config reads the TOML configuration file used by the DB4S stats generator
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Default timeouts, used when the config file doesn't give one
+const (
+	DefaultQueryTimeout = 10 * time.Minute
+	DefaultRunTimeout   = 12 * time.Hour
+)
+
+// DefaultFoldWindow is the time without requests from a client for a file before a new download is counted, when
+// folding partial downloads and the config file doesn't give one
+const DefaultFoldWindow = time.Hour
+
+// DefaultListen is the address the serve mode listens on, when the config file doesn't give one
+const DefaultListen = "localhost:8080"
+
+// DefaultRateBurst is how many requests a client of the serve mode can make at once when rate limited, when the
+// config file doesn't say
+const DefaultRateBurst = 10
+
+// DefaultCacheMaxAge is how long the serve mode's JSON responses can be cached for, when the config file doesn't say
+const DefaultCacheMaxAge = 5 * time.Minute
+
+// Config holds the contents of the configuration file
+type Config struct {
+	Archive    BucketInfo // Bucket the archive command uploads the Parquet files to
+	AWS        AWSInfo
+	Bots       BotsInfo
+	Charts     ChartsInfo
+	Chocolatey ChocolateyInfo
+	ClickHouse ClickHouseInfo
+	Cloudflare BucketInfo // Bucket the Cloudflare Logpush files are pushed to
+	Downloads  DownloadsInfo
+	Exclude    ExcludeInfo
+	Flathub    FlathubInfo
+	GitHub     GitHubInfo `toml:"github"`
+	Ingest     IngestInfo
+	Kafka      KafkaInfo
+	Mastodon   MastodonInfo
+	Matviews   MatviewsInfo
+	Metrics    []MetricInfo // Simple metrics declared in the config file, as [[metrics]] entries
+	Monitor    MonitorInfo
+	Notify     NotifyInfo
+	Partitions PartitionsInfo
+	Pg         PGInfo
+	PgRead     PGInfo `toml:"pg_read"` // Optional read replica for the download logs
+	Product    ProductInfo
+	Proxy      ProxyInfo
+	Publish    BucketInfo // Bucket the export and report commands publish their files to, eg for the website's CDN
+	Quality    QualityInfo
+	Realtime   RealtimeInfo
+	S3         BucketInfo // Bucket holding access log files to load
+	Server     ServerInfo
+	Sheets     SheetsInfo
+	Timescale  TimescaleInfo
+	Timeouts   TimeoutInfo
+	Users      UsersInfo
+	Vault      VaultInfo
+	Webhook    WebhookInfo
+	Winget     WingetInfo
+}
+type AWSInfo struct {
+	Region    string // Defaults to the AWS_REGION environment variable
+	SecretID  string `toml:"secret_id"` // Secrets Manager secret name or ARN
+	Parameter string // SSM Parameter Store parameter name, when not using Secrets Manager
+	Endpoint  string // Defaults to the AWS one for the service.  eg http://localhost:4566 for LocalStack
+}
+type BotsInfo struct {
+	UserAgents  []string `toml:"user_agents"`  // Regular expressions matching the user agents of bots
+	IPs         []string `toml:"ips"`          // IP addresses and CIDR ranges of bots
+	MaxRequests int      `toml:"max_requests"` // Version checks per IP address per day, above which it's treated as a bot
+}
+type BucketInfo struct {
+	Bucket          string
+	Prefix          string
+	Endpoint        string // Defaults to AWS S3.  eg https://<account id>.r2.cloudflarestorage.com for R2
+	Region          string // Defaults to the AWS_REGION environment variable
+	AccessKeyID     string `toml:"access_key_id"` // Defaults to the AWS_ACCESS_KEY_ID environment variable
+	SecretAccessKey string `toml:"secret_access_key"`
+}
+type ChartsInfo struct {
+	Dir      string   // Directory the trend charts are written to after each run, no charts when empty
+	Families []string // Metric families to chart, defaults to the weekly and monthly users and downloads
+	Formats  []string // Image formats to write, svg and/or png.  Defaults to both
+	Periods  int      // Time periods each chart covers, defaults to 52
+}
+type ChocolateyInfo struct {
+	Enabled bool
+	Package string // Defaults to sqlitebrowser
+	URL     string // Package feed, defaults to https://community.chocolatey.org/api/v2
+}
+type ClickHouseInfo struct {
+	URL      string // HTTP interface address, eg http://clickhouse.example.org:8123.  ClickHouse isn't used when empty
+	Database string
+	Table    string // Defaults to download_log
+	Username string
+	Password string
+}
+type DownloadsInfo struct {
+	AutoAdd        string   `toml:"auto_add"` // Regular expression matching the request paths of new artifacts to add downloads for
+	Bandwidth      bool     // Generate bandwidth stats from the body_bytes_sent column of download_log
+	ChannelRollup  bool     `toml:"channel_rollup"` // Roll the downloads up with the other channels into db4s_downloads_by_channel
+	Failed         bool     // Count the failed (403, 404 and 5xx) requests for download artifacts into db4s_failed_downloads
+	FoldPartial    bool     `toml:"fold_partial"`    // Count the 200 and 206 requests from a client for a file as one download
+	FoldWindow     int      `toml:"fold_window"`     // Seconds without requests before a new download is counted, defaults to 3600
+	IgnoreRequests []string `toml:"ignore_requests"` // Regular expressions matching the request paths left out of the downloads stats
+	Referrers      int      // The number of top referrers kept for each download, no referrer stats when zero
+	Statuses       []int    // HTTP status codes counted as downloads, defaults to 200
+	Unique         bool     // Fill in the unique_downloads column, counting each IP address once per file per day
+}
+type ExcludeInfo struct {
+	MaxDownloads int      `toml:"max_downloads"` // Download requests per IP address per day, above which its downloads are left out
+	Networks     []string // IP addresses and CIDR ranges whose requests are left out of the users and downloads stats
+}
+type FlathubInfo struct {
+	Enabled bool
+	AppID   string `toml:"app_id"` // Defaults to io.github.sqlitebrowser.sqlitebrowser
+	URL     string // Where the daily stats files are published, defaults to https://flathub.org/stats
+}
+type GitHubInfo struct {
+	SyncReleases bool   `toml:"sync_releases"` // Copy the release details from GitHub into db4s_release_info each run
+	Repo         string // Defaults to sqlitebrowser/sqlitebrowser
+	URL          string // API address, defaults to https://api.github.com
+	Token        string // Optional, for the higher API rate limit
+}
+type IngestInfo struct {
+	Format string // Access log format: nginx (the default), apache or caddy
+}
+type KafkaInfo struct {
+	RestProxy   string `toml:"rest_proxy"` // Kafka REST Proxy address, eg http://kafka-rest.example.org:8082
+	Topic       string
+	Group       string // Consumer group, defaults to db4s_stats_gen
+	Format      string // Access log format of the events, defaults to the [ingest] one
+	Refresh     int    // Seconds between updates of today's stats, defaults to 60
+	OffsetReset string `toml:"offset_reset"` // Where a new consumer group starts, "latest" (the default) or "earliest"
+}
+type MastodonInfo struct {
+	Enabled  bool
+	Server   string // eg https://fosstodon.org
+	Token    string
+	Template string
+}
+type MatviewsInfo struct {
+	Enabled bool // Refresh the materialized views holding the users and downloads stats, instead of generating them
+}
+type MetricInfo struct {
+	Name    string
+	Kind    string // The metric families it's generated for, downloads (the default) or users
+	Filter  string // SQL condition on the download_log rows to count, eg "request LIKE '%.AppImage'"
+	GroupBy string `toml:"group_by"` // Optional download_log column (or expression) to break the counts down by
+	Table   string // Table the counts are saved in, created by the init-schema command
+}
+type MonitorInfo struct {
+	URL     string // Ping URL, eg https://hc-ping.com/<uuid> or https://cronitor.link/p/<api key>/<monitor key>
+	Service string // healthchecks (the default) or cronitor
+}
+type NotifyInfo struct {
+	Webhook string // Slack or Mattermost incoming webhook URL
+}
+type PartitionsInfo struct {
+	Name     string // Go time layout of the download_log partition names, eg download_log_2006_01.  Not used when empty
+	Interval string // month (the default), week or day
+}
+type PGInfo struct {
+	ApplicationName   string `toml:"application_name"` // Defaults to db4s_stats_gen
+	Database          string
+	HealthCheckPeriod int  `toml:"health_check_period"` // Seconds
+	LogQueries        bool `toml:"log_queries"`         // Log every query with its duration, parameters and row count
+	MaxConnIdleTime   int  `toml:"max_conn_idle_time"`  // Seconds
+	MaxConnLifetime   int  `toml:"max_conn_lifetime"`   // Seconds
+	MinConnections    int  `toml:"min_connections"`
+	NumConnections    int  `toml:"num_connections"`
+	Port              int
+	Password          string
+	PassFile          string `toml:"pass_file"` // Defaults to ~/.pgpass, used when no password is given
+	PgBouncer         bool   // Use the simple protocol, for PgBouncer in transaction pooling mode
+	Server            string
+	Service           string // Name of a pg_service.conf entry providing defaults for the other values
+	ServiceFile       string `toml:"service_file"` // Defaults to ~/.pg_service.conf
+	SSL               bool
+	SSLCert           string `toml:"ssl_cert"`          // Client certificate, for mutual TLS
+	SSLKey            string `toml:"ssl_key"`           // Client certificate key
+	SSLRootCert       string `toml:"ssl_root_cert"`     // CA bundle for verifying the server, instead of the system one
+	StatementTimeout  int    `toml:"statement_timeout"` // Seconds
+	Username          string
+}
+type ProductInfo struct {
+	Name        string // Used in the notifications, defaults to DB4S
+	Downloads   string // Regular expression matching the request paths of the downloads, instead of the DB4S ones
+	TablePrefix string `toml:"table_prefix"` // Prefix of the stats tables, instead of db4s_.  eg dbhub_
+}
+type ProxyInfo struct {
+	ForwardedFor string   `toml:"forwarded_for"` // download_log column holding the X-Forwarded-For header, eg http_x_forwarded_for
+	Trusted      []string // IP addresses and CIDR ranges of the reverse proxies whose X-Forwarded-For headers are used
+}
+type QualityInfo struct {
+	Save bool // Save the data quality report of each run in the stats_quality_reports table
+}
+type RealtimeInfo struct {
+	Channel string // PostgreSQL channel the log ingestion process notifies after each batch, defaults to download_log_batches
+	Refresh int    // Minimum seconds between updates of today's stats, defaults to 60
+	Notify  bool   // Have the ingest command notify the channel after adding entries
+}
+type ServerInfo struct {
+	CacheMaxAge    int      `toml:"cache_max_age"` // Seconds the JSON responses can be cached for, defaults to 300
+	CORSOrigins    []string `toml:"cors_origins"`  // Origins of the web pages allowed to fetch the stats, or "*" for any
+	Listen         string
+	Public         []string    // Scopes open to anyone when there are tokens.  eg ["badges", "totals"]
+	RateBurst      int         `toml:"rate_burst"` // Requests a client can make at once, defaults to 10
+	RateLimit      int         `toml:"rate_limit"` // Requests per minute per client IP address, no limit when 0
+	Tokens         []TokenInfo // [[server.tokens]] credentials for the endpoints, which are all public without any
+	TrustedProxies []string    `toml:"trusted_proxies"` // Reverse proxies whose X-Forwarded-For headers are used
+}
+type SheetsInfo struct {
+	CredentialsFile string `toml:"credentials_file"` // Google service account key file (JSON)
+	SpreadsheetID   string `toml:"spreadsheet_id"`   // The ID in the spreadsheet's URL
+	Sheet           string // Tab to write the monthly summary to, defaults to "Monthly summary"
+	URL             string // API address, defaults to https://sheets.googleapis.com
+}
+type TimescaleInfo struct {
+	Enabled bool // download_log is a TimescaleDB hypertable
+}
+type TimeoutInfo struct {
+	Query int // Seconds
+	Run   int // Seconds
+}
+type TokenInfo struct {
+	Name     string // Identifies the credentials in the logs
+	Token    string // Sent as an "Authorization: Bearer <token>" header
+	Username string // HTTP basic authentication instead of a token, when given
+	Password string
+	Scopes   []string // badges, totals, breakdowns, or * for all of them
+}
+type UsersInfo struct {
+	CheckIns          bool     `toml:"check_ins"`           // Track the distribution of the daily version checks per IP address
+	ChecksPerUser     int      `toml:"checks_per_user"`     // Version checks a single user makes per day, no estimated users when zero
+	ExcludeUserAgents []string `toml:"exclude_user_agents"` // User agents containing these aren't version checks
+	IPv6Prefix        bool     `toml:"ipv6_prefix"`         // Count the IPv6 addresses in the same /64 as a single IP address
+	PerOS             bool     `toml:"per_os"`              // Count the unique IP addresses per OS, going by the user agents
+	UpdatePending     bool     `toml:"update_pending"`      // Track the users on an out of date release, needs [github] sync_releases
+	UserAgents        []string `toml:"user_agents"`         // Version check user agent prefixes
+}
+type VaultInfo struct {
+	Address      string // eg https://vault.example.org:8200.  Vault isn't used when empty
+	Namespace    string
+	Path         string // eg secret/data/db4s_stats (KV version 2) or database/creds/db4s_stats (dynamic credentials)
+	Token        string
+	RoleID       string `toml:"role_id"` // AppRole authentication is used instead of the token when given
+	SecretID     string `toml:"secret_id"`
+	AppRoleMount string `toml:"approle_mount"`
+	UsernameKey  string `toml:"username_key"`
+	PasswordKey  string `toml:"password_key"`
+}
+type WebhookInfo struct {
+	URL    string // Receives a JSON summary of each run, eg for website cache invalidation
+	Secret string // Optional, signs the payloads with HMAC-SHA256
+}
+type WingetInfo struct {
+	Enabled bool
+	Package string // Defaults to DBBrowserForSQLite.DBBrowserForSQLite
+	URL     string // GitHub API address, defaults to https://api.github.com
+	Token   string // Optional GitHub token, for the higher API rate limit.  Defaults to the [github] one
+}
+
+// Path returns the location of the configuration file.  This is ~/.db4s/daily_stats_gen.toml, unless overridden by
+// the CONFIG_FILE environment variable
+func Path() (string, error) {
+	// TODO: Might be a good idea to add permission checks of the dir & conf file, to ensure they're not
+	//       world readable.  Similar in concept to what ssh does for its config files.
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		return configFile, nil
+	}
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.New("user home directory couldn't be determined")
+	}
+	return filepath.Join(userHome, ".db4s", "daily_stats_gen.toml"), nil
+}
+
+// Load reads the configuration file at the given path
+func Load(path string) (conf Config, err error) {
+	_, err = toml.DecodeFile(path, &conf)
+	return
+}
+
+// Listen returns the address the serve mode listens on
+func (c Config) Listen() string {
+	if c.Server.Listen != "" {
+		return c.Server.Listen
+	}
+	return DefaultListen
+}
+
+// ReadPG returns the connection settings of the read replica, if one is configured.  Values not given for the replica
+// are taken from the primary
+func (c Config) ReadPG() (PGInfo, bool) {
+	if c.PgRead == (PGInfo{}) {
+		return PGInfo{}, false
+	}
+	merged := c.Pg
+	primary, replica := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(c.PgRead)
+	for i := 0; i < replica.NumField(); i++ {
+		if !replica.Field(i).IsZero() {
+			primary.Field(i).Set(replica.Field(i))
+		}
+	}
+	return merged, true
+}
+
+// CacheMaxAge returns how long the serve mode's JSON responses can be cached for
+func (c Config) CacheMaxAge() time.Duration {
+	if c.Server.CacheMaxAge > 0 {
+		return time.Duration(c.Server.CacheMaxAge) * time.Second
+	}
+	return DefaultCacheMaxAge
+}
+
+// RateBurst returns how many requests a client of the serve mode can make at once when rate limited
+func (c Config) RateBurst() int {
+	if c.Server.RateBurst > 0 {
+		return c.Server.RateBurst
+	}
+	return DefaultRateBurst
+}
+
+// RealtimeChannel returns the PostgreSQL channel notified after each batch of download log entries
+func (c Config) RealtimeChannel() string {
+	if c.Realtime.Channel != "" {
+		return c.Realtime.Channel
+	}
+	return "download_log_batches"
+}
+
+// FoldWindow returns the time without requests from a client for a file, before a new download is counted
+func (c Config) FoldWindow() time.Duration {
+	if c.Downloads.FoldWindow > 0 {
+		return time.Duration(c.Downloads.FoldWindow) * time.Second
+	}
+	return DefaultFoldWindow
+}
+
+// QueryTimeout returns the maximum time allowed for any single database query
+func (c Config) QueryTimeout() time.Duration {
+	if c.Timeouts.Query > 0 {
+		return time.Duration(c.Timeouts.Query) * time.Second
+	}
+	return DefaultQueryTimeout
+}
+
+// RunTimeout returns the maximum time allowed for a run as a whole
+func (c Config) RunTimeout() time.Duration {
+	if c.Timeouts.Run > 0 {
+		return time.Duration(c.Timeouts.Run) * time.Second
+	}
+	return DefaultRunTimeout
+}
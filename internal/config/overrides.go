@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every config file value can be overridden by an environment variable or a command line flag, for container
+// deployments.  The names are derived from the config file ones.  eg "num_connections" in the [pg] section is
+// DB4S_PG_NUM_CONNECTIONS in the environment, and --pg.num_connections on the command line.  Lists are given comma
+// separated, eg DB4S_DOWNLOADS_STATUSES=200,206.  The standard PostgreSQL environment variables (PGHOST, PGPORT, etc)
+// are also used, but only for the values the config file leaves out, so a stray PGHOST in a shell doesn't silently
+// point a run at another database
+//
+// The order of precedence is: command line flags, DB4S_ environment variables, the config file, then PG environment
+// variables
+
+// pgEnvVars maps the standard PostgreSQL environment variables to their config keys
+var pgEnvVars = map[string]string{
+	"PGDATABASE": "pg.database",
+	"PGHOST":     "pg.server",
+	"PGPASSWORD": "pg.password",
+	"PGPORT":     "pg.port",
+	"PGSSLMODE":  "pg.ssl",
+	"PGUSER":     "pg.username",
+}
+
+// Keys returns the names of all of the config values, in "section.key" form.  eg "pg.num_connections"
+func Keys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			// Lists of entries (eg [[metrics]]) can only be given in the config file
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			f := section.Type.Field(j)
+			if !settable(f.Type) {
+				// Nor can lists of entries within a section (eg [[server.tokens]])
+				continue
+			}
+			keys = append(keys, keyName(section)+"."+keyName(f))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvName returns the environment variable overriding a config value.  eg DB4S_PG_NUM_CONNECTIONS
+func EnvName(key string) string {
+	return "DB4S_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ApplyEnv overrides the config values with any matching environment variables, as returned by the getenv function
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	for envVar, key := range pgEnvVars {
+		value := getenv(envVar)
+		if value == "" {
+			continue
+		}
+		if f, err := c.field(key); err == nil && !f.IsZero() {
+			// The config file value takes precedence
+			continue
+		}
+		if envVar == "PGSSLMODE" {
+			// Anything other than "disable" (or "allow"/"prefer", which don't insist on it) means using SSL
+			value = strconv.FormatBool(value != "disable" && value != "allow" && value != "prefer")
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("%v: %w", envVar, err)
+		}
+	}
+	for _, key := range Keys() {
+		if value := getenv(EnvName(key)); value != "" {
+			if err := c.Set(key, value); err != nil {
+				return fmt.Errorf("%v: %w", EnvName(key), err)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyFlags overrides the config values with any matching command line flags, in either "--key value" or
+// "--key=value" form, returning the remaining arguments.  This allows them anywhere on the command line, including
+// after a sub-command name
+func (c *Config) ApplyFlags(args []string) (rest []string, err error) {
+	known := make(map[string]bool)
+	for _, key := range Keys() {
+		known[key] = true
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(rest, args[i:]...), nil
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || !known[name] {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			// A following flag (eg -v) means the value was left out, rather than being the value.  A value really
+			// starting with "-" can still be given in the "--key=value" form
+			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+				return nil, fmt.Errorf("flag --%v needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if err = c.Set(name, value); err != nil {
+			return nil, fmt.Errorf("--%v: %w", name, err)
+		}
+	}
+	return rest, nil
+}
+
+// Set changes a config value, given in "section.key" form
+func (c *Config) Set(key, value string) error {
+	f, err := c.field(key)
+	if err != nil {
+		return err
+	}
+	return setValue(f, value)
+}
+
+// field returns the struct field of a config value, given in "section.key" form
+func (c *Config) field(key string) (reflect.Value, error) {
+	sectionName, fieldName, _ := strings.Cut(key, ".")
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if keyName(v.Type().Field(i)) != sectionName {
+			continue
+		}
+		section := v.Field(i)
+		if section.Kind() != reflect.Struct {
+			break
+		}
+		for j := 0; j < section.NumField(); j++ {
+			if keyName(section.Type().Field(j)) == fieldName && settable(section.Type().Field(j).Type) {
+				return section.Field(j), nil
+			}
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown config value '%v'", key)
+}
+
+// keyName returns the config file name of a struct field, as per its toml tag or else its lower cased name
+func keyName(f reflect.StructField) string {
+	if tag := f.Tag.Get("toml"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	return strings.ToLower(f.Name)
+}
+
+// settable returns whether setValue can parse a string into a config field of the given type
+func settable(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// setValue parses a string into a config field.  Lists are given comma separated
+func setValue(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.Slice:
+		list := reflect.MakeSlice(f.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			v := reflect.New(f.Type().Elem()).Elem()
+			if err := setValue(v, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, v)
+		}
+		f.Set(list)
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number '%v'", value)
+		}
+		f.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean '%v'", value)
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported config value type %v", f.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	keys := Keys()
+	if !slices.IsSorted(keys) {
+		t.Error("Keys() aren't sorted")
+	}
+	wantKeys := []string{"pg.num_connections", "pg_read.server", "downloads.statuses", "github.sync_releases"}
+	for _, want := range wantKeys {
+		if !slices.Contains(keys, want) {
+			t.Errorf("Keys() is missing %q", want)
+		}
+	}
+
+	// Lists of entries can only be given in the config file
+	for _, key := range keys {
+		if strings.HasPrefix(key, "metrics.") || key == "server.tokens" {
+			t.Errorf("Keys() includes %q", key)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("pg_read.num_connections"); got != "DB4S_PG_READ_NUM_CONNECTIONS" {
+		t.Errorf("EnvName() = %v, expected DB4S_PG_READ_NUM_CONNECTIONS", got)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    PGInfo // The [pg] section of the config file
+		env     map[string]string
+		want    PGInfo
+		wantErr bool
+	}{
+		{"nothing set", PGInfo{Server: "db.example.org"}, nil, PGInfo{Server: "db.example.org"}, false},
+		{"DB4S_ variable", PGInfo{Server: "db.example.org", Port: 5432},
+			map[string]string{"DB4S_PG_SERVER": "other.example.org"},
+			PGInfo{Server: "other.example.org", Port: 5432}, false},
+		{"PG variable fills in", PGInfo{}, map[string]string{"PGHOST": "pg.example.org", "PGPORT": "5433"},
+			PGInfo{Server: "pg.example.org", Port: 5433}, false},
+		{"config file over PG variable", PGInfo{Server: "db.example.org"},
+			map[string]string{"PGHOST": "pg.example.org"}, PGInfo{Server: "db.example.org"}, false},
+		{"DB4S_ over PG variable", PGInfo{},
+			map[string]string{"PGHOST": "pg.example.org", "DB4S_PG_SERVER": "other.example.org"},
+			PGInfo{Server: "other.example.org"}, false},
+		{"PGSSLMODE require", PGInfo{}, map[string]string{"PGSSLMODE": "require"}, PGInfo{SSL: true}, false},
+		{"PGSSLMODE prefer", PGInfo{}, map[string]string{"PGSSLMODE": "prefer"}, PGInfo{}, false},
+		{"boolean", PGInfo{}, map[string]string{"DB4S_PG_PGBOUNCER": "true"}, PGInfo{PgBouncer: true}, false},
+		{"bad number", PGInfo{}, map[string]string{"DB4S_PG_PORT": "five"}, PGInfo{}, true},
+		{"bad PG number", PGInfo{}, map[string]string{"PGPORT": "five"}, PGInfo{}, true},
+	}
+	for _, test := range tests {
+		c := Config{Pg: test.file}
+		err := c.ApplyEnv(func(name string) string { return test.env[name] })
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: ApplyEnv() error = %v, expected an error: %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && c.Pg != test.want {
+			t.Errorf("%v: [pg] is %+v after ApplyEnv(), expected %+v", test.name, c.Pg, test.want)
+		}
+	}
+
+	// Lists are comma separated
+	var c Config
+	err := c.ApplyEnv(func(name string) string {
+		if name == "DB4S_DOWNLOADS_STATUSES" {
+			return "200, 206"
+		}
+		return ""
+	})
+	if err != nil || !slices.Equal(c.Downloads.Statuses, []int{200, 206}) {
+		t.Errorf("DB4S_DOWNLOADS_STATUSES=200,206 gives statuses %v (%v), expected [200 206]", c.Downloads.Statuses,
+			err)
+	}
+}
+
+func TestApplyFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		want     PGInfo
+		wantRest []string
+		wantErr  bool
+	}{
+		{"no flags", []string{"users", "2024-01"}, PGInfo{Server: "db.example.org"}, []string{"users", "2024-01"},
+			false},
+		{"separate value", []string{"--pg.server", "other.example.org", "users"},
+			PGInfo{Server: "other.example.org"}, []string{"users"}, false},
+		{"equals value", []string{"users", "--pg.port=5433"}, PGInfo{Server: "db.example.org", Port: 5433},
+			[]string{"users"}, false},
+		{"single dash", []string{"-pg.port", "5433"}, PGInfo{Server: "db.example.org", Port: 5433}, nil, false},
+		{"value starting with dashes", []string{"--pg.password=--secret"},
+			PGInfo{Server: "db.example.org", Password: "--secret"}, nil, false},
+		{"later flag wins", []string{"--pg.port=1", "--pg.port=2"}, PGInfo{Server: "db.example.org", Port: 2}, nil,
+			false},
+		{"unknown flags are left", []string{"--verbose", "--pg.ssl=true"}, PGInfo{Server: "db.example.org", SSL: true},
+			[]string{"--verbose"}, false},
+		{"after --", []string{"users", "--", "--pg.port=1"}, PGInfo{Server: "db.example.org"},
+			[]string{"users", "--", "--pg.port=1"}, false},
+		{"missing value", []string{"--pg.server"}, PGInfo{}, nil, true},
+		{"flag instead of value", []string{"--pg.server", "--pg.port", "1"}, PGInfo{}, nil, true},
+		{"short flag instead of value", []string{"--pg.server", "-v"}, PGInfo{}, nil, true},
+		{"negative number", []string{"--pg.port", "-1"}, PGInfo{}, nil, true},
+		{"value starting with a dash", []string{"--pg.password=-secret"},
+			PGInfo{Server: "db.example.org", Password: "-secret"}, nil, false},
+		{"bad number", []string{"--pg.port", "x"}, PGInfo{}, nil, true},
+		{"bad boolean", []string{"--pg.ssl=maybe"}, PGInfo{}, nil, true},
+	}
+	for _, test := range tests {
+		c := Config{Pg: PGInfo{Server: "db.example.org"}}
+		rest, err := c.ApplyFlags(test.args)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: ApplyFlags(%q) error = %v, expected an error: %v", test.name, test.args, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if c.Pg != test.want || !slices.Equal(rest, test.wantRest) {
+			t.Errorf("%v: ApplyFlags(%q) gives %+v and %q, expected %+v and %q", test.name, test.args, c.Pg, rest,
+				test.want, test.wantRest)
+		}
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	var c Config
+	tests := []struct {
+		key, value string
+		wantErr    bool
+	}{
+		{"pg.server", "db.example.org", false},
+		{"pg.port", "5432", false},
+		{"pg.port", "-1", false},
+		{"pg.port", "5432.5", true},
+		{"pg.ssl", "1", false},
+		{"pg.ssl", "yes", true},
+		{"downloads.statuses", "200,,206,", false},
+		{"downloads.statuses", "200,OK", true},
+		{"users.user_agents", "sqlitebrowser ,DB4S", false},
+		{"pg.nonexistent", "1", true},
+		{"metrics.name", "x", true},
+		{"username", "x", true},
+	}
+	for _, test := range tests {
+		if err := c.Set(test.key, test.value); (err != nil) != test.wantErr {
+			t.Errorf("Set(%q, %q) error = %v, expected an error: %v", test.key, test.value, err, test.wantErr)
+		}
+	}
+	if c.Pg.Server != "db.example.org" || c.Pg.Port != -1 || !c.Pg.SSL {
+		t.Errorf("unexpected [pg] values %+v", c.Pg)
+	}
+	if !slices.Equal(c.Downloads.Statuses, []int{200, 206}) {
+		t.Errorf("downloads.statuses is %v, expected [200 206]", c.Downloads.Statuses)
+	}
+	if !slices.Equal(c.Users.UserAgents, []string{"sqlitebrowser", "DB4S"}) {
+		t.Errorf("users.user_agents is %q, expected [sqlitebrowser DB4S]", c.Users.UserAgents)
+	}
+}
@@ -0,0 +1,62 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// WriteCSV writes the saved stats of each metric family to a CSV file in the given directory, named after its stats
+// table.  eg db4s_users_daily.csv.  If top is above 0, only that many releases or downloads are kept for each date,
+// with the rest folded into an "Other" row
+func WriteCSV(ctx context.Context, db store.Store, families []stats.Family, dir string, startDate, endDate time.Time, top int) (files []string, err error) {
+	for _, fam := range families {
+		var rows []Row
+		rows, err = Collect(ctx, db, fam, startDate, endDate)
+		if err != nil {
+			return
+		}
+		rows = TopN(rows, fam, top)
+		path := filepath.Join(dir, fam.Table+".csv")
+		err = writeCSVFile(path, fam, rows)
+		if err != nil {
+			return
+		}
+		files = append(files, path)
+	}
+	return
+}
+
+// writeCSVFile writes the stats rows of a metric family to a CSV file, with a header row using the stats table columns
+func writeCSVFile(path string, fam stats.Family, rows []Row) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	err = w.Write([]string{"stats_date", fam.TotalColumn, "name", fam.ValueColumn})
+	for _, r := range rows {
+		if err != nil {
+			break
+		}
+		err = w.Write([]string{r.Date.Format("2006-01-02"), strconv.Itoa(r.ID), r.Name, strconv.FormatInt(r.Count, 10)})
+	}
+	if err == nil {
+		w.Flush()
+		err = w.Error()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %v failed: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Package export writes the saved stats out in formats suitable for use outside of PostgreSQL
+package export
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// Row is a single saved stats value
+type Row struct {
+	Date  time.Time
+	ID    int
+	Name  string
+	Count int64
+}
+
+// Collect returns the saved stats of a metric family for the dates from the start date up to (but not including) the
+// end date, ordered by date then release or download ID.  A zero start or end date leaves that side of the range open,
+// so a zero start date includes any imported history from before the family's first time period
+func Collect(ctx context.Context, db store.Store, fam stats.Family, startDate, endDate time.Time) ([]Row, error) {
+	if endDate.IsZero() {
+		endDate = time.Now().UTC().AddDate(1, 0, 0)
+	}
+	counts, err := db.StatsRange(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	names, err := fam.Names(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+	for date, row := range counts {
+		for id, count := range row {
+			rows = append(rows, Row{Date: date, ID: id, Name: names[id], Count: count})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].Date.Equal(rows[j].Date) {
+			return rows[i].Date.Before(rows[j].Date)
+		}
+		return rows[i].ID < rows[j].ID
+	})
+	return rows, nil
+}
+
+// OtherID is the ID of the row holding the folded together counts left out by TopN
+const OtherID = -1
+
+// TopN keeps the totals row plus the n largest other rows for each date, folding the rest into a single "Other" row.
+// The rows must be ordered by date, as returned by Collect.  A value of n below 1 returns the rows unchanged
+func TopN(rows []Row, fam stats.Family, n int) []Row {
+	if n < 1 {
+		return rows
+	}
+	var out []Row
+	for start := 0; start < len(rows); {
+		// Find the rows for this date
+		end := start
+		for end < len(rows) && rows[end].Date.Equal(rows[start].Date) {
+			end++
+		}
+
+		var detail []Row
+		for _, r := range rows[start:end] {
+			if r.ID == fam.TotalID {
+				out = append(out, r)
+			} else {
+				detail = append(detail, r)
+			}
+		}
+		sort.SliceStable(detail, func(i, j int) bool { return detail[i].Count > detail[j].Count })
+		if len(detail) > n {
+			other := Row{Date: rows[start].Date, ID: OtherID, Name: "Other"}
+			for _, r := range detail[n:] {
+				other.Count += r.Count
+			}
+			detail = append(detail[:n], other)
+		}
+		out = append(out, detail...)
+		start = end
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package export
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+)
+
+func TestTopN(t *testing.T) {
+	fam, _ := stats.FamilyByName(stats.FamilyUsersMonthly)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := jan.AddDate(0, 1, 0)
+	rows := []Row{
+		{jan, fam.TotalID, "Total", 100},
+		{jan, 2, "3.12.0", 10},
+		{jan, 3, "3.12.2", 60},
+		{jan, 4, "3.13.0", 25},
+		{jan, 5, "3.13.1", 5},
+		{feb, fam.TotalID, "Total", 50},
+		{feb, 4, "3.13.0", 30},
+		{feb, 5, "3.13.1", 20},
+	}
+	tests := []struct {
+		name string
+		n    int
+		want []Row
+	}{
+		{"unlimited", 0, rows},
+		{"top 2", 2, []Row{
+			{jan, fam.TotalID, "Total", 100},
+			{jan, 3, "3.12.2", 60},
+			{jan, 4, "3.13.0", 25},
+			{jan, OtherID, "Other", 15},
+			{feb, fam.TotalID, "Total", 50},
+			{feb, 4, "3.13.0", 30},
+			{feb, 5, "3.13.1", 20},
+		}},
+		{"top 1", 1, []Row{
+			{jan, fam.TotalID, "Total", 100},
+			{jan, 3, "3.12.2", 60},
+			{jan, OtherID, "Other", 40},
+			{feb, fam.TotalID, "Total", 50},
+			{feb, 4, "3.13.0", 30},
+			{feb, OtherID, "Other", 20},
+		}},
+		{"more than there are", 10, []Row{
+			{jan, fam.TotalID, "Total", 100},
+			{jan, 3, "3.12.2", 60},
+			{jan, 4, "3.13.0", 25},
+			{jan, 2, "3.12.0", 10},
+			{jan, 5, "3.13.1", 5},
+			{feb, fam.TotalID, "Total", 50},
+			{feb, 4, "3.13.0", 30},
+			{feb, 5, "3.13.1", 20},
+		}},
+	}
+	for _, test := range tests {
+		if got := TopN(rows, fam, test.n); !slices.Equal(got, test.want) {
+			t.Errorf("%v: TopN(%d) = %v, expected %v", test.name, test.n, got, test.want)
+		}
+	}
+	if got := TopN(nil, fam, 2); len(got) != 0 {
+		t.Errorf("TopN() of no rows = %v, expected none", got)
+	}
+}
@@ -0,0 +1,84 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// JSONSchemaVersion is the version of the JSON export structure.  Adding fields is fine without changing it, but
+// renaming, removing or changing the meaning of a field needs it bumped, so consumers can tell the difference
+const JSONSchemaVersion = 1
+
+// JSONExport is the top level of the JSON export
+type JSONExport struct {
+	SchemaVersion int                   `json:"schema_version"`
+	Generated     time.Time             `json:"generated"`
+	Metrics       map[string]JSONMetric `json:"metrics"`
+}
+
+// JSONMetric holds the stats of a metric family, keyed in JSONExport.Metrics by its name.  eg "users-daily"
+type JSONMetric struct {
+	Granularity string       `json:"granularity"`
+	Periods     []JSONPeriod `json:"periods"`
+}
+
+// JSONPeriod holds the stats of a metric family for a single time period
+type JSONPeriod struct {
+	// The start date of the time period, in YYYY-MM-DD format
+	Start string `json:"start"`
+
+	// The total number of unique IP addresses (users) or downloads for the time period
+	Total int64 `json:"total"`
+
+	// The count for each release (users) or download file (downloads), in ID order.  When limited to the top N,
+	// they're in descending count order instead, followed by an "Other" value with an ID of -1
+	Values []JSONValue `json:"values"`
+}
+
+// JSONValue is the count for a single release or download file
+type JSONValue struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// WriteJSON writes the saved stats of each metric family as a single JSON document.  If top is above 0, only that
+// many releases or downloads are kept for each time period, with the rest folded into an "Other" value
+func WriteJSON(ctx context.Context, db store.Store, families []stats.Family, w io.Writer, startDate, endDate time.Time, top int) error {
+	out := JSONExport{
+		SchemaVersion: JSONSchemaVersion,
+		Generated:     time.Now().UTC().Truncate(time.Second),
+		Metrics:       make(map[string]JSONMetric, len(families)),
+	}
+	for _, fam := range families {
+		rows, err := Collect(ctx, db, fam, startDate, endDate)
+		if err != nil {
+			return err
+		}
+		rows = TopN(rows, fam, top)
+		metric := JSONMetric{Granularity: fam.Granularity.String(), Periods: []JSONPeriod{}}
+		for _, r := range rows {
+			// The rows are ordered by date, so a new date starts a new time period
+			start := r.Date.Format("2006-01-02")
+			if n := len(metric.Periods); n == 0 || metric.Periods[n-1].Start != start {
+				metric.Periods = append(metric.Periods, JSONPeriod{Start: start, Values: []JSONValue{}})
+			}
+			p := &metric.Periods[len(metric.Periods)-1]
+			if r.ID == fam.TotalID {
+				p.Total = r.Count
+				continue
+			}
+			p.Values = append(p.Values, JSONValue{ID: r.ID, Name: r.Name, Count: r.Count})
+		}
+		out.Metrics[fam.Name] = metric
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,41 @@
+package export
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// MonthlySummary returns the monthly users and downloads totals as a table with a header row, one row per month in
+// date order.  eg for writing into a spreadsheet.  A zero start or end date leaves that side of the range open
+func MonthlySummary(ctx context.Context, db store.Store, startDate, endDate time.Time) ([][]any, error) {
+	totals := make(map[time.Time][2]int64)
+	for i, name := range []string{stats.FamilyUsersMonthly, stats.FamilyDownloadsMonthly} {
+		fam, _ := stats.FamilyByName(name)
+		rows, err := Collect(ctx, db, fam, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			if r.ID == fam.TotalID {
+				t := totals[r.Date]
+				t[i] = r.Count
+				totals[r.Date] = t
+			}
+		}
+	}
+	months := make([]time.Time, 0, len(totals))
+	for m := range totals {
+		months = append(months, m)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+
+	table := [][]any{{"Month", "Users", "Downloads"}}
+	for _, m := range months {
+		table = append(table, []any{m.Format("2006-01"), totals[m][0], totals[m][1]})
+	}
+	return table, nil
+}
@@ -0,0 +1,174 @@
+package export
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// xlsxDateFormat is the number format of the dates, in the sheets and on the charts
+const xlsxDateFormat = "yyyy-mm-dd"
+
+// xlsxSheet is the layout of a metric family's sheet: a row per time period, with columns for the date, the total,
+// then each release or download
+type xlsxSheet struct {
+	Name    string
+	Title   string
+	Columns []string
+	Dates   []time.Time
+	Values  [][]int64 // Per date, per column after the date one
+}
+
+// WriteXLSX writes the saved stats of each metric family as a sheet of an .xlsx workbook, each with a line chart of
+// its totals.  If top is above 0, only the releases or downloads with the top counts over the whole date range get their
+// own column, with the rest folded into an "Other" column
+func WriteXLSX(ctx context.Context, db store.Store, families []stats.Family, w io.Writer, startDate, endDate time.Time, top int) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	format := xlsxDateFormat
+	dateStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &format})
+	if err != nil {
+		return err
+	}
+	for i, fam := range families {
+		rows, err := Collect(ctx, db, fam, startDate, endDate)
+		if err != nil {
+			return err
+		}
+		s := xlsxLayout(fam, rows, top)
+
+		// New workbooks start with a single empty sheet, which becomes the first family's
+		if i == 0 {
+			err = f.SetSheetName(f.GetSheetName(0), s.Name)
+		} else {
+			_, err = f.NewSheet(s.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if err = xlsxWriteSheet(f, s, dateStyle); err != nil {
+			return err
+		}
+	}
+	return f.Write(w)
+}
+
+// xlsxWriteSheet fills in the sheet of a metric family, with the header row frozen in place and a line chart of its
+// totals column by date to the right of its columns
+func xlsxWriteSheet(f *excelize.File, s xlsxSheet, dateStyle int) error {
+	header := make([]any, len(s.Columns))
+	for i, name := range s.Columns {
+		header[i] = name
+	}
+	if err := f.SetSheetRow(s.Name, "A1", &header); err != nil {
+		return err
+	}
+	for i, date := range s.Dates {
+		row := []any{date}
+		for _, v := range s.Values[i] {
+			row = append(row, v)
+		}
+		if err := f.SetSheetRow(s.Name, "A"+strconv.Itoa(i+2), &row); err != nil {
+			return err
+		}
+	}
+	err := f.SetPanes(s.Name, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	if err == nil {
+		err = f.SetColWidth(s.Name, "A", "A", 12)
+	}
+	if err != nil || len(s.Dates) == 0 {
+		return err
+	}
+	last := strconv.Itoa(len(s.Dates) + 1)
+	if err = f.SetCellStyle(s.Name, "A2", "A"+last, dateStyle); err != nil {
+		return err
+	}
+
+	chartColumn, err := excelize.ColumnNumberToName(len(s.Columns) + 2)
+	if err != nil {
+		return err
+	}
+	sheet := "'" + strings.ReplaceAll(s.Name, "'", "''") + "'"
+	return f.AddChart(s.Name, chartColumn+"2", &excelize.Chart{
+		Type: excelize.Line,
+		Series: []excelize.ChartSeries{{
+			Name:       sheet + "!$B$1",
+			Categories: sheet + "!$A$2:$A$" + last,
+			Values:     sheet + "!$B$2:$B$" + last,
+			Marker:     excelize.ChartMarker{Symbol: "none"},
+		}},
+		Title:  []excelize.RichTextRun{{Text: s.Title}},
+		Legend: excelize.ChartLegend{Position: "none"},
+		XAxis:  excelize.ChartAxis{NumFmt: excelize.ChartNumFmt{CustomNumFmt: xlsxDateFormat}},
+		YAxis:  excelize.ChartAxis{MajorGridLines: true},
+	})
+}
+
+// xlsxLayout arranges the stats rows of a metric family into the columns of its sheet
+func xlsxLayout(fam stats.Family, rows []Row, top int) xlsxSheet {
+	s := xlsxSheet{Name: fam.Name, Title: fam.Name + " total", Columns: []string{"Date", "Total"}}
+
+	// Pick the releases or downloads with their own column, largest first
+	sums := make(map[int]int64)
+	names := make(map[int]string)
+	for _, r := range rows {
+		if r.ID != fam.TotalID {
+			sums[r.ID] += r.Count
+			names[r.ID] = r.Name
+		}
+	}
+	var ids []int
+	for id, sum := range sums {
+		if sum > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if sums[ids[i]] != sums[ids[j]] {
+			return sums[ids[i]] > sums[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	other := top > 0 && len(ids) > top
+	if other {
+		ids = ids[:top]
+	}
+	column := make(map[int]int)
+	for i, id := range ids {
+		column[id] = i + 1
+		name := names[id]
+		if name == "" {
+			name = strconv.Itoa(id)
+		}
+		s.Columns = append(s.Columns, name)
+	}
+	if other {
+		s.Columns = append(s.Columns, "Other")
+	}
+
+	// The rows are ordered by date, as returned by Collect
+	for _, r := range rows {
+		if n := len(s.Dates); n == 0 || !s.Dates[n-1].Equal(r.Date) {
+			s.Dates = append(s.Dates, r.Date)
+			s.Values = append(s.Values, make([]int64, len(s.Columns)-1))
+		}
+		values := s.Values[len(s.Values)-1]
+		switch c, ok := column[r.ID]; {
+		case r.ID == fam.TotalID:
+			values[0] = r.Count
+		case ok:
+			values[c] = r.Count
+		case other:
+			values[len(values)-1] += r.Count
+		}
+	}
+	return s
+}
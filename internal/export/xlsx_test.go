@@ -0,0 +1,120 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store/memstore"
+)
+
+func TestWriteXLSX(t *testing.T) {
+	ctx := context.Background()
+	db := memstore.New()
+	db.Releases["3.12.2"] = 2
+	db.Releases["3.13.0"] = 3
+	db.Releases["3.13.1"] = 4
+	week := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	counts := []map[string]int{
+		{"sqlitebrowser 3.12.2": 50, "sqlitebrowser 3.13.0": 30, "sqlitebrowser 3.13.1": 1},
+		{"sqlitebrowser 3.12.2": 40, "sqlitebrowser 3.13.0": 45, "sqlitebrowser 3.13.1": 2},
+	}
+	for i, c := range counts {
+		if err := db.SaveWeeklyUsersStats(ctx, week.AddDate(0, 0, 7*i), 100+i, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fam, _ := stats.FamilyByName("users-weekly")
+	empty, _ := stats.FamilyByName("downloads-weekly")
+
+	var buf bytes.Buffer
+	if err := WriteXLSX(ctx, db, []stats.Family{fam, empty}, &buf, week, week.AddDate(0, 1, 0), 2); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if sheets := f.GetSheetList(); !reflect.DeepEqual(sheets, []string{"users-weekly", "downloads-weekly"}) {
+		t.Fatalf("workbook sheets are %v", sheets)
+	}
+
+	// The top 2 releases over the month get their own columns, with the rest folded into "Other"
+	rows, err := f.GetRows("users-weekly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"Date", "Total", "3.12.2", "3.13.0", "Other"},
+		{"2024-01-01", "100", "50", "30", "1"},
+		{"2024-01-08", "101", "40", "45", "2"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("users-weekly sheet is %v, expected %v", rows, expected)
+	}
+
+	// The dates are stored as dates rather than text, so they sort and chart properly
+	raw, err := f.GetCellValue("users-weekly", "A2", excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw != "45292" {
+		t.Errorf("date cell holds %q, expected the date serial number 45292", raw)
+	}
+
+	// A family without any stats still gets its header row, but no chart
+	rows, err = f.GetRows("downloads-weekly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || len(rows[0]) != 2 {
+		t.Errorf("downloads-weekly sheet has %v, expected just the header row", rows)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var charts int
+	for _, file := range zr.File {
+		if matched, _ := path.Match("xl/charts/chart*.xml", file.Name); matched {
+			charts++
+		}
+	}
+	if charts != 1 {
+		t.Errorf("workbook has %d charts, expected 1", charts)
+	}
+}
+
+func TestXLSXEscaping(t *testing.T) {
+	// Sheet names and column headers with XML special characters and quotes survive the round trip
+	f := excelize.NewFile()
+	defer f.Close()
+	s := xlsxSheet{Name: "it's", Title: "a < b", Columns: []string{"Date", `"Q&A" <1>`},
+		Dates: []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, Values: [][]int64{{7}}}
+	if err := f.SetSheetName(f.GetSheetName(0), s.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := xlsxWriteSheet(f, s, 0); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if v, err := r.GetCellValue("it's", "B1"); err != nil || v != `"Q&A" <1>` {
+		t.Errorf("header cell is %q (%v), expected %q", v, err, `"Q&A" <1>`)
+	}
+}
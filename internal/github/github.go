@@ -0,0 +1,94 @@
+// Package github is a minimal client for the parts of the GitHub REST API we use, ie the releases of a repository and
+// the contents of files in one
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the GitHub API address, when the config file doesn't give another
+const DefaultURL = "https://api.github.com"
+
+// Client fetches from the GitHub API.  The token is optional, but without one the API rate limit is very low
+type Client struct {
+	URL   string
+	Token string
+}
+
+// Release is the part of a GitHub release we use
+type Release struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []Asset   `json:"assets"`
+}
+
+// Asset is a file attached to a GitHub release
+type Asset struct {
+	Name          string `json:"name"`
+	DownloadURL   string `json:"browser_download_url"`
+	DownloadCount int64  `json:"download_count"`
+}
+
+// NewClient returns a client for the given GitHub API address, using the default one when it's empty
+func NewClient(api, token string) *Client {
+	if api == "" {
+		api = DefaultURL
+	}
+	return &Client{URL: strings.TrimSuffix(api, "/"), Token: token}
+}
+
+// Releases returns all the releases of a repository (eg "sqlitebrowser/sqlitebrowser"), newest first
+func (c *Client) Releases(ctx context.Context, repo string) ([]Release, error) {
+	var releases []Release
+	for page := 1; ; page++ {
+		var batch []Release
+		err := c.Get(ctx, fmt.Sprintf("/repos/%s/releases?per_page=100&page=%d", repo, page), &batch)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, batch...)
+		if len(batch) < 100 {
+			return releases, nil
+		}
+	}
+}
+
+// Get fetches a GitHub API path, decoding the JSON response into v.  When v is a *[]byte the raw file contents are
+// fetched instead
+func (c *Client) Get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	raw, isRaw := v.(*[]byte)
+	if isRaw {
+		req.Header.Set("Accept", "application/vnd.github.raw")
+	} else {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub returned status %v for %v", resp.Status, path)
+	}
+	if isRaw {
+		*raw, err = io.ReadAll(resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
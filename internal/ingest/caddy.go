@@ -0,0 +1,81 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// caddyEntry holds the fields we use from a Caddy JSON access log entry
+type caddyEntry struct {
+	TS      float64 `json:"ts"`
+	Request struct {
+		RemoteIP   string              `json:"remote_ip"`
+		RemotePort string              `json:"remote_port"`
+		RemoteAddr string              `json:"remote_addr"` // Caddy versions before 2.5
+		ClientIP   string              `json:"client_ip"`   // Set when the request came through a trusted proxy
+		Proto      string              `json:"proto"`
+		Method     string              `json:"method"`
+		URI        string              `json:"uri"`
+		Headers    map[string][]string `json:"headers"`
+	} `json:"request"`
+	UserID string `json:"user_id"`
+	Size   int64  `json:"size"`
+	Status int    `json:"status"`
+}
+
+// Caddy parses Caddy's JSON access logs
+type Caddy struct{}
+
+func (Caddy) Parse(line []byte) (e store.LogEntry, err error) {
+	var c caddyEntry
+	err = json.Unmarshal(line, &c)
+	if err != nil {
+		return
+	}
+
+	// Caddy writes its other log messages to the same place by default, so skip anything without a request
+	if c.Request.URI == "" {
+		return e, ErrSkip
+	}
+	if c.TS == 0 {
+		return e, errors.New("missing timestamp")
+	}
+	sec, frac := math.Modf(c.TS)
+	e.RequestTime = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+
+	ip, port := c.Request.ClientIP, c.Request.RemotePort
+	if ip == "" {
+		ip = c.Request.RemoteIP
+	}
+	if ip == "" && c.Request.RemoteAddr != "" {
+		ip, port, err = net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil {
+			return
+		}
+	}
+	setClientIP(&e, ip)
+	if p, err := strconv.Atoi(port); err == nil && c.Request.ClientIP == "" {
+		// The port is of the connecting address, so it doesn't apply when the client IP came from a proxy header
+		e.ClientPort = p
+	}
+
+	e.RemoteUser = c.UserID
+	e.RequestType = c.Request.Method
+	e.Request = c.Request.URI
+	e.Protocol = c.Request.Proto
+	e.Status = c.Status
+	e.BodyBytesSent = c.Size
+	if v := c.Request.Headers["Referer"]; len(v) > 0 {
+		e.Referer = v[0]
+	}
+	if v := c.Request.Headers["User-Agent"]; len(v) > 0 {
+		e.UserAgent = v[0]
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package ingest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+func TestCaddy(t *testing.T) {
+	when := time.Date(2023, 10, 10, 13, 55, 36, 500000000, time.UTC)
+	tests := []struct {
+		name string
+		line string
+		want store.LogEntry
+	}{
+		{"current", `{"level":"info","ts":1696946136.5,"logger":"http.log.access","request":{"remote_ip":"1.2.3.4",` +
+			`"remote_port":"51234","proto":"HTTP/2.0","method":"GET","uri":"/currentrelease","headers":` +
+			`{"User-Agent":["sqlitebrowser 3.12.2"],"Referer":["https://sqlitebrowser.org/"]}},"user_id":"alice",` +
+			`"size":55,"status":200}`,
+			store.LogEntry{ClientIPv4: "1.2.3.4", ClientPort: 51234, RemoteUser: "alice", RequestTime: when,
+				RequestType: "GET", Request: "/currentrelease", Protocol: "HTTP/2.0", Status: 200, BodyBytesSent: 55,
+				Referer: "https://sqlitebrowser.org/", UserAgent: "sqlitebrowser 3.12.2"}},
+
+		// Behind a trusted proxy the client IP comes from its header, so the connection's port doesn't apply
+		{"proxied", `{"ts":1696946136.5,"request":{"remote_ip":"10.0.0.1","remote_port":"443",` +
+			`"client_ip":"2001:db8::1","method":"GET","uri":"/x.dmg"},"status":404}`,
+			store.LogEntry{ClientIPv6: "2001:db8::1", RequestTime: when, RequestType: "GET", Request: "/x.dmg",
+				Status: 404}},
+
+		// Caddy versions before 2.5 only give the remote address
+		{"old", `{"ts":1696946136.5,"request":{"remote_addr":"[2001:db8::2]:8080","method":"GET","uri":"/"},` +
+			`"status":200}`,
+			store.LogEntry{ClientIPv6: "2001:db8::2", ClientPort: 8080, RequestTime: when, RequestType: "GET",
+				Request: "/", Status: 200}},
+	}
+	for _, test := range tests {
+		got, err := Caddy{}.Parse([]byte(test.line))
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v: parsed as %+v, expected %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCaddyInvalid(t *testing.T) {
+	tests := []struct {
+		line string
+		skip bool
+	}{
+		{`{"level":"info","ts":1696946136.5,"msg":"serving initial configuration"}`, true},
+		{`{"ts":1696946136.5,"request":{"remote_ip":"1.2.3.4"},"status":200}`, true},
+		{`{"request":{"remote_ip":"1.2.3.4","uri":"/"},"status":200}`, false},
+		{`{"ts":1696946136.5,"request":{"remote_addr":"1.2.3.4","uri":"/"},"status":200}`, false},
+		{`not json`, false},
+		{``, false},
+	}
+	for _, test := range tests {
+		_, err := Caddy{}.Parse([]byte(test.line))
+		if err == nil || errors.Is(err, ErrSkip) != test.skip {
+			t.Errorf("Parse(%q) error is %v, expected skip %v", test.line, err, test.skip)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// cloudflareEntry holds the fields we use from a Cloudflare Logpush HTTP requests entry.  The Logpush job needs to
+// include these fields
+type cloudflareEntry struct {
+	ClientIP               string          `json:"ClientIP"`
+	ClientSrcPort          int             `json:"ClientSrcPort"`
+	ClientRequestMethod    string          `json:"ClientRequestMethod"`
+	ClientRequestURI       string          `json:"ClientRequestURI"`
+	ClientRequestProtocol  string          `json:"ClientRequestProtocol"`
+	ClientRequestReferer   string          `json:"ClientRequestReferer"`
+	ClientRequestUserAgent string          `json:"ClientRequestUserAgent"`
+	EdgeResponseBytes      int64           `json:"EdgeResponseBytes"`
+	EdgeResponseStatus     int             `json:"EdgeResponseStatus"`
+	EdgeStartTimestamp     json.RawMessage `json:"EdgeStartTimestamp"`
+}
+
+// Cloudflare parses the NDJSON files written by Cloudflare Logpush for the HTTP requests dataset
+type Cloudflare struct{}
+
+func (Cloudflare) Parse(line []byte) (e store.LogEntry, err error) {
+	var c cloudflareEntry
+	err = json.Unmarshal(line, &c)
+	if err != nil {
+		return
+	}
+	if c.ClientRequestURI == "" {
+		return e, errors.New("missing ClientRequestURI field")
+	}
+	e.RequestTime, err = cloudflareTime(c.EdgeStartTimestamp)
+	if err != nil {
+		return
+	}
+	setClientIP(&e, c.ClientIP)
+	e.ClientPort = c.ClientSrcPort
+	e.RequestType = c.ClientRequestMethod
+	e.Request = c.ClientRequestURI
+	e.Protocol = c.ClientRequestProtocol
+	e.Status = c.EdgeResponseStatus
+	e.BodyBytesSent = c.EdgeResponseBytes
+	e.Referer = c.ClientRequestReferer
+	e.UserAgent = c.ClientRequestUserAgent
+	return
+}
+
+// cloudflareTime parses a Logpush timestamp, which can be in any of the timestamp_format options of the job.  ie
+// nanoseconds since the epoch (the default), seconds since the epoch, or RFC 3339
+func cloudflareTime(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 {
+		return time.Time{}, errors.New("missing EdgeStartTimestamp field")
+	}
+	if bytes.HasPrefix(raw, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return time.Time{}, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t.UTC(), err
+	}
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return time.Time{}, fmt.Errorf("unknown EdgeStartTimestamp format: %s", raw)
+	}
+	if n > 1e12 {
+		return time.Unix(0, n).UTC(), nil
+	}
+	return time.Unix(n, 0).UTC(), nil
+}
+
+// logpushName matches the names of Logpush files, which start with the time range they cover.  eg
+// 20231010T135500Z_20231010T140000Z_d2a1a5c9.log.gz
+var logpushName = regexp.MustCompile(`^(\d{8}T\d{6}Z)_(\d{8}T\d{6}Z)_`)
+
+// LogpushRange returns the time range covered by a Logpush file, from its name
+func LogpushRange(name string) (start, end time.Time, ok bool) {
+	m := logpushName.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return
+	}
+	start, err := time.Parse("20060102T150405Z", m[1])
+	if err != nil {
+		return
+	}
+	end, err = time.Parse("20060102T150405Z", m[2])
+	if err != nil {
+		return
+	}
+	return start, end, true
+}
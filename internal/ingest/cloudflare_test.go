@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+func TestCloudflare(t *testing.T) {
+	line := `{"ClientIP":"2001:db8::1","ClientSrcPort":51234,"ClientRequestMethod":"GET",` +
+		`"ClientRequestURI":"/currentrelease","ClientRequestProtocol":"HTTP/2","ClientRequestReferer":"",` +
+		`"ClientRequestUserAgent":"sqlitebrowser 3.12.2","EdgeResponseBytes":55,"EdgeResponseStatus":200,` +
+		`"EdgeStartTimestamp":1696946136123456789,"RayID":"8134b7f1dd3b1234"}`
+	want := store.LogEntry{ClientIPv6: "2001:db8::1", ClientPort: 51234, RequestType: "GET",
+		Request: "/currentrelease", Protocol: "HTTP/2", Status: 200, BodyBytesSent: 55,
+		RequestTime: time.Date(2023, 10, 10, 13, 55, 36, 123456789, time.UTC), UserAgent: "sqlitebrowser 3.12.2"}
+	got, err := Cloudflare{}.Parse([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("parsed as %+v, expected %+v", got, want)
+	}
+
+	for _, invalid := range []string{
+		`{"ClientIP":"1.2.3.4","EdgeStartTimestamp":1696946136}`,
+		`{"ClientIP":"1.2.3.4","ClientRequestURI":"/"}`,
+		`{"ClientRequestURI":"/","EdgeStartTimestamp":"yesterday"}`,
+		`not json`,
+	} {
+		if e, err := (Cloudflare{}).Parse([]byte(invalid)); err == nil {
+			t.Errorf("no error for %q, parsed as %+v", invalid, e)
+		}
+	}
+}
+
+func TestCloudflareTime(t *testing.T) {
+	// Each of the Logpush timestamp formats
+	tests := []struct {
+		raw  string
+		want time.Time
+	}{
+		{`1696946136123456789`, time.Date(2023, 10, 10, 13, 55, 36, 123456789, time.UTC)},
+		{`1696946136`, time.Date(2023, 10, 10, 13, 55, 36, 0, time.UTC)},
+		{`"2023-10-10T13:55:36Z"`, time.Date(2023, 10, 10, 13, 55, 36, 0, time.UTC)},
+		{`"2023-10-10T15:55:36.5+02:00"`, time.Date(2023, 10, 10, 13, 55, 36, 500000000, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := cloudflareTime(json.RawMessage(test.raw))
+		if err != nil || !got.Equal(test.want) || got.Location() != time.UTC {
+			t.Errorf("cloudflareTime(%v) = %v, %v, expected %v", test.raw, got, err, test.want)
+		}
+	}
+	for _, raw := range []string{``, `"2023-10-10"`, `1.5`, `true`} {
+		if got, err := cloudflareTime(json.RawMessage(raw)); err == nil {
+			t.Errorf("no error for %q, parsed as %v", raw, got)
+		}
+	}
+}
+
+func TestLogpushRange(t *testing.T) {
+	start, end, ok := LogpushRange("logs/20231010/20231010T135500Z_20231010T140000Z_d2a1a5c9.log.gz")
+	if !ok || !start.Equal(time.Date(2023, 10, 10, 13, 55, 0, 0, time.UTC)) ||
+		!end.Equal(time.Date(2023, 10, 10, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("LogpushRange gives %v to %v, %v", start, end, ok)
+	}
+	for _, name := range []string{"access.log", "20231010T135500Z.log.gz", "20231310T135500Z_20231010T140000Z_x.log"} {
+		if _, _, ok := LogpushRange(name); ok {
+			t.Errorf("no error for %q", name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// combinedLine matches the "combined" log format used by default by both nginx and Apache:
+//
+//	1.2.3.4 - user [10/Oct/2023:13:55:36 +0000] "GET /path HTTP/1.1" 200 2326 "referer" "user agent"
+var combinedLine = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"`)
+
+// Combined parses the combined log format of nginx and Apache
+type Combined struct{}
+
+func (Combined) Parse(line []byte) (e store.LogEntry, err error) {
+	m := combinedLine.FindStringSubmatch(string(line))
+	if m == nil {
+		return e, fmt.Errorf("not in the combined log format")
+	}
+	e.RequestTime, err = time.Parse("02/Jan/2006:15:04:05 -0700", m[3])
+	if err != nil {
+		return
+	}
+	e.RequestTime = e.RequestTime.UTC()
+	e.Status, err = strconv.Atoi(m[5])
+	if err != nil {
+		return
+	}
+	if m[6] != "-" {
+		e.BodyBytesSent, err = strconv.ParseInt(m[6], 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	setClientIP(&e, m[1])
+	if m[2] != "-" {
+		e.RemoteUser = m[2]
+	}
+
+	// The request line is normally "METHOD /path PROTOCOL", but garbage requests are logged as-is
+	request := unescapeQuoted(m[4])
+	parts := strings.Split(request, " ")
+	if len(parts) == 3 {
+		e.RequestType, e.Request, e.Protocol = parts[0], parts[1], parts[2]
+	} else {
+		e.Request = request
+	}
+	if referer := unescapeQuoted(m[7]); referer != "-" {
+		e.Referer = referer
+	}
+	if userAgent := unescapeQuoted(m[8]); userAgent != "-" {
+		e.UserAgent = userAgent
+	}
+	return
+}
+
+// unescapeQuoted reverses the escaping of quotes and backslashes in a quoted log field
+func unescapeQuoted(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,63 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+func TestCombined(t *testing.T) {
+	when := time.Date(2023, 10, 10, 13, 55, 36, 0, time.UTC)
+	tests := []struct {
+		line string
+		want store.LogEntry
+	}{
+		{`1.2.3.4 - - [10/Oct/2023:13:55:36 +0000] "GET /currentrelease HTTP/1.1" 200 55 "-" "sqlitebrowser 3.12.2"`,
+			store.LogEntry{ClientIPv4: "1.2.3.4", RequestTime: when, RequestType: "GET", Request: "/currentrelease",
+				Protocol: "HTTP/1.1", Status: 200, BodyBytesSent: 55, UserAgent: "sqlitebrowser 3.12.2"}},
+
+		// Times are converted to UTC
+		{`2001:db8::1 - alice [10/Oct/2023:15:55:36 +0200] "HEAD /x.dmg HTTP/2.0" 304 - "https://sqlitebrowser.org/" ` +
+			`"curl/8.0"`,
+			store.LogEntry{ClientIPv6: "2001:db8::1", RemoteUser: "alice", RequestTime: when, RequestType: "HEAD",
+				Request: "/x.dmg", Protocol: "HTTP/2.0", Status: 304, Referer: "https://sqlitebrowser.org/",
+				UserAgent: "curl/8.0"}},
+
+		// Escaped quotes and backslashes, and a garbage request line kept as is
+		{`unknown - - [10/Oct/2023:13:55:36 +0000] "\x16\x03\x01" 400 150 "-" "say \"hi\" \\o/"`,
+			store.LogEntry{ClientIPStrange: "unknown", RequestTime: when, Request: `\x16\x03\x01`, Status: 400,
+				BodyBytesSent: 150, UserAgent: `say "hi" \o/`}},
+
+		// Apache adds more fields after the user agent with some LogFormat settings
+		{`1.2.3.4 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.1" 200 0 "-" "-" 1234 example.org`,
+			store.LogEntry{ClientIPv4: "1.2.3.4", RequestTime: when, RequestType: "GET", Request: "/",
+				Protocol: "HTTP/1.1", Status: 200}},
+	}
+	for _, test := range tests {
+		got, err := Combined{}.Parse([]byte(test.line))
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.line, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Parse(%q) = %+v, expected %+v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestCombinedInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"not a log line",
+		`1.2.3.4 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.1" 200 55`,
+		`1.2.3.4 - - [2023-10-10T13:55:36Z] "GET / HTTP/1.1" 200 55 "-" "-"`,
+		`1.2.3.4 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.1" 200 lots "-" "-"`,
+		`1.2.3.4 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.1" 2000 55 "-" "-"`,
+	}
+	for _, line := range tests {
+		if e, err := (Combined{}).Parse([]byte(line)); err == nil {
+			t.Errorf("no error for %q, parsed as %+v", line, e)
+		}
+	}
+}
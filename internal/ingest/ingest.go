@@ -0,0 +1,185 @@
+// Package ingest loads web server access logs into the download_log table, using a parser for each supported log
+// format
+package ingest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// BatchSize is the number of log entries added to the database at a time
+const BatchSize = 10000
+
+// ErrSkip is returned by a parser for a line which isn't an access log entry (eg a startup message), and should be
+// silently ignored
+var ErrSkip = errors.New("not an access log entry")
+
+// Parser parses a single line of an access log
+type Parser interface {
+	Parse(line []byte) (store.LogEntry, error)
+}
+
+// Loader adds log entries to the download_log table
+type Loader interface {
+	InsertLogEntries(ctx context.Context, entries []store.LogEntry) (int64, error)
+}
+
+// parsers holds the supported log formats.  nginx and Apache both default to the same "combined" format
+var parsers = map[string]Parser{
+	"apache":     Combined{},
+	"caddy":      Caddy{},
+	"cloudflare": Cloudflare{},
+	"combined":   Combined{},
+	"nginx":      Combined{},
+}
+
+// ParserByName returns the parser for the named log format
+func ParserByName(name string) (Parser, error) {
+	p, ok := parsers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format '%v' (should be one of %v)", name, strings.Join(Formats(), ", "))
+	}
+	return p, nil
+}
+
+// Formats returns the names of the supported log formats
+func Formats() []string {
+	var names []string
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Result holds the counts from ingesting a log
+type Result struct {
+	Added   int64 // Entries added to the database
+	Invalid int   // Lines which couldn't be parsed
+}
+
+// Ingest parses each line of an access log, adding the entries to the database in batches.  Lines which can't be
+// parsed are logged and counted, rather than stopping the whole log from being loaded
+func Ingest(ctx context.Context, db Loader, r io.Reader, p Parser) (res Result, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var batch []store.LogEntry
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := db.InsertLogEntries(ctx, batch)
+		res.Added += n
+		batch = batch[:0]
+		return err
+	}
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var e store.LogEntry
+		e, err = p.Parse(line)
+		if errors.Is(err, ErrSkip) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't parse line %v: %v\n", lineNum, err)
+			res.Invalid++
+			continue
+		}
+		batch = append(batch, e)
+		if len(batch) >= BatchSize {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	err = flush()
+	return
+}
+
+// ParseAll parses a list of log lines (eg events from a message queue), returning the entries plus the number of
+// lines which couldn't be parsed
+func ParseAll(p Parser, lines [][]byte) (entries []store.LogEntry, invalid int) {
+	for _, line := range lines {
+		e, err := p.Parse(line)
+		if errors.Is(err, ErrSkip) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't parse log line: %v\n", err)
+			invalid++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+// Open opens a log file for reading, decompressing it if its name ends in .gz.  A name of "-" reads from stdin
+func Open(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decompress(path, f)
+}
+
+// Decompress returns a reader decompressing a log file if its name ends in .gz, or the file as-is otherwise.  Closing
+// the returned reader closes the file too
+func Decompress(name string, f io.ReadCloser) (io.ReadCloser, error) {
+	if !strings.HasSuffix(name, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{Reader: gz, file: f}, nil
+}
+
+// gzipFile closes both the decompressor and the underlying file
+type gzipFile struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// setClientIP sets the IP address fields of a log entry, as per the download_log table.  Addresses which aren't valid
+// IPv4 or IPv6 ones go in the client_ip_strange field
+func setClientIP(e *store.LogEntry, addr string) {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		e.ClientIPStrange = addr
+	case ip.To4() != nil:
+		e.ClientIPv4 = addr
+	default:
+		e.ClientIPv6 = addr
+	}
+}
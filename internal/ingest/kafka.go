@@ -0,0 +1,160 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+// kafkaContentType is the Content-Type of Kafka REST Proxy (API v2) requests
+const kafkaContentType = "application/vnd.kafka.v2+json"
+
+// KafkaConsumer consumes access log events from a Kafka topic, through the Kafka REST Proxy API (v2).  This is
+// provided by the Confluent REST Proxy, and natively by Redpanda
+type KafkaConsumer struct {
+	baseURI string
+	client  *http.Client
+}
+
+// NewKafkaConsumer creates a consumer instance in the configured consumer group, subscribed to the topic.  Offsets are
+// only committed by Commit, so events which haven't been saved yet are consumed again after a restart.  A new consumer
+// group starts with the latest events unless configured otherwise, as the batch runs own the earlier ones
+func NewKafkaConsumer(ctx context.Context, conf config.KafkaInfo) (*KafkaConsumer, error) {
+	offsetReset := conf.OffsetReset
+	if offsetReset == "" {
+		offsetReset = "latest"
+	}
+	host, _ := os.Hostname()
+	c := &KafkaConsumer{client: &http.Client{Timeout: time.Minute}}
+	var instance struct {
+		BaseURI string `json:"base_uri"`
+	}
+	err := c.post(ctx, strings.TrimSuffix(conf.RestProxy, "/")+"/consumers/"+url.PathEscape(conf.Group), map[string]any{
+		"name":               fmt.Sprintf("db4s_stats_gen-%v-%v", host, os.Getpid()),
+		"format":             "json",
+		"auto.offset.reset":  offsetReset,
+		"auto.commit.enable": "false",
+	}, &instance)
+	if err != nil {
+		return nil, err
+	}
+	c.baseURI = instance.BaseURI
+
+	err = c.post(ctx, c.baseURI+"/subscription", map[string]any{"topics": []string{conf.Topic}}, nil)
+	if err != nil {
+		c.Close(ctx)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Poll returns the next events from the topic, waiting up to the given time for some to arrive.  Events holding a JSON
+// string (eg a line from an nginx log) are returned as the string, and any other JSON (eg a Cloudflare Logpush entry)
+// as-is
+func (c *KafkaConsumer) Poll(ctx context.Context, wait time.Duration) (events [][]byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.baseURI+"/records?timeout="+strconv.FormatInt(wait.Milliseconds(), 10), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/vnd.kafka.json.v2+json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, kafkaError(resp)
+	}
+	var records []struct {
+		Value json.RawMessage `json:"value"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&records)
+	if err != nil {
+		return
+	}
+	for _, r := range records {
+		value := []byte(r.Value)
+		if bytes.HasPrefix(value, []byte(`"`)) {
+			var s string
+			if err = json.Unmarshal(value, &s); err != nil {
+				return
+			}
+			value = []byte(s)
+		}
+		events = append(events, value)
+	}
+	return
+}
+
+// Commit commits the offsets of all of the events returned by Poll so far
+func (c *KafkaConsumer) Commit(ctx context.Context) error {
+	return c.post(ctx, c.baseURI+"/offsets", nil, nil)
+}
+
+// Close removes the consumer instance, so its partitions are reassigned straight away rather than after a timeout
+func (c *KafkaConsumer) Close(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.baseURI, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return kafkaError(resp)
+	}
+	return nil
+}
+
+// post sends a REST Proxy request, decoding any JSON response into out
+func (c *KafkaConsumer) post(ctx context.Context, reqURL string, body, out any) error {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return kafkaError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// kafkaError returns the error message from a failed REST Proxy request
+func kafkaError(resp *http.Response) error {
+	var e struct {
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+	return fmt.Errorf("kafka: %v %v returned status %v: %v", resp.Request.Method, resp.Request.URL.Path, resp.Status,
+		e.Message)
+}
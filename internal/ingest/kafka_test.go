@@ -0,0 +1,203 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+// fakeRestProxy is a Kafka REST Proxy holding a single topic partition, read by a single consumer group
+type fakeRestProxy struct {
+	mu        sync.Mutex
+	records   []string          // The record values, as JSON
+	committed int               // The group's committed offset, or -1 before the first commit
+	positions map[string]int    // The offset each consumer instance has read up to
+	instance  map[string]string // The settings the last consumer instance was created with
+	created   int
+}
+
+func (p *fakeRestProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if r.Method != http.MethodGet && r.Header.Get("Content-Type") != kafkaContentType {
+		http.Error(w, `{"error_code": 415, "message": "HTTP 415 Unsupported Media Type"}`,
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Create consumer instances at /consumers/<group>, which start reading from the committed offset.  Before the
+	// group has committed one, that's given by the auto.offset.reset setting
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) == 2 && parts[0] == "consumers" && r.Method == http.MethodPost {
+		p.instance = nil
+		if err := json.NewDecoder(r.Body).Decode(&p.instance); err != nil {
+			http.Error(w, `{"error_code": 422, "message": "Unrecognized field"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		p.created++
+		name := "instance" + strconv.Itoa(p.created)
+		switch {
+		case p.committed >= 0:
+			p.positions[name] = p.committed
+		case p.instance["auto.offset.reset"] == "earliest":
+			p.positions[name] = 0
+		default:
+			p.positions[name] = len(p.records)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"instance_id": name,
+			"base_uri": "http://" + r.Host + "/consumers/" + parts[1] + "/instances/" + name})
+		return
+	}
+	if len(parts) < 4 || parts[0] != "consumers" || parts[1] != "db4s" || parts[2] != "instances" {
+		http.NotFound(w, r)
+		return
+	}
+	name := parts[3]
+	pos, ok := p.positions[name]
+	if !ok {
+		http.Error(w, `{"error_code": 40403, "message": "Consumer instance not found."}`, http.StatusNotFound)
+		return
+	}
+	switch action := strings.Join(parts[4:], "/"); {
+	case action == "subscription" && r.Method == http.MethodPost:
+		var body struct {
+			Topics []string `json:"topics"`
+		}
+		if json.NewDecoder(r.Body).Decode(&body) != nil || !slices.Equal(body.Topics, []string{"logs"}) {
+			http.Error(w, `{"error_code": 40401, "message": "Topic not found."}`, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "records" && r.Method == http.MethodGet:
+		if r.Header.Get("Accept") != "application/vnd.kafka.json.v2+json" {
+			http.Error(w, `{"error_code": 406, "message": "HTTP 406 Not Acceptable"}`, http.StatusNotAcceptable)
+			return
+		}
+		var values []string
+		for _, v := range p.records[pos:] {
+			values = append(values, `{"topic": "logs", "partition": 0, "value": `+v+`}`)
+		}
+		p.positions[name] = len(p.records)
+		w.Write([]byte("[" + strings.Join(values, ",") + "]"))
+	case action == "offsets" && r.Method == http.MethodPost:
+		// Without a body, the offsets of all the records returned so far are committed
+		p.committed = pos
+		w.WriteHeader(http.StatusOK)
+	case action == "" && r.Method == http.MethodDelete:
+		delete(p.positions, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestKafkaConsumer(t *testing.T) {
+	ctx := context.Background()
+	proxy := &fakeRestProxy{committed: -1, positions: make(map[string]int)}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+	conf := config.KafkaInfo{RestProxy: srv.URL + "/", Topic: "logs", Group: "db4s"}
+
+	// A new consumer group starts after the events already in the topic, as the batch runs own those, and offsets
+	// are only committed explicitly
+	proxy.records = []string{`"loaded by the batch runs"`}
+	c, err := NewKafkaConsumer(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedInstance := map[string]string{"auto.offset.reset": "latest", "auto.commit.enable": "false",
+		"format": "json"}
+	for k, v := range expectedInstance {
+		if proxy.instance[k] != v {
+			t.Errorf("NewKafkaConsumer() created the consumer instance with %v = %q, expected %q", k,
+				proxy.instance[k], v)
+		}
+	}
+	events, err := c.Poll(ctx, time.Second)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("Poll() = %q, %v for a new consumer group, expected no events", eventStrings(events), err)
+	}
+
+	// Log lines are given as JSON strings, and other events as they are
+	proxy.records = append(proxy.records,
+		`"1.2.3.4 - - [10/Oct/2023:13:55:36 +0000] \"GET /currentrelease HTTP/1.1\" 200 55"`,
+		`{"ClientIP": "1.2.3.4"}`)
+	events, err = c.Poll(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`1.2.3.4 - - [10/Oct/2023:13:55:36 +0000] "GET /currentrelease HTTP/1.1" 200 55`,
+		`{"ClientIP": "1.2.3.4"}`}
+	if got := eventStrings(events); !slices.Equal(got, expected) {
+		t.Errorf("Poll() = %q, expected %q", got, expected)
+	}
+	if err = c.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if proxy.committed != 3 {
+		t.Errorf("Commit() left the committed offset at %d, expected 3", proxy.committed)
+	}
+
+	// Events polled but not committed are consumed again by the next consumer instance
+	proxy.records = append(proxy.records, `"third"`, `"fourth"`)
+	if events, err = c.Poll(ctx, time.Second); err != nil || len(events) != 2 {
+		t.Fatalf("Poll() = %q, %v, expected the new events", eventStrings(events), err)
+	}
+	if err = c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(proxy.positions) != 0 {
+		t.Errorf("Close() left consumer instances %v", proxy.positions)
+	}
+	c, err = NewKafkaConsumer(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	events, err = c.Poll(ctx, time.Second)
+	if got := eventStrings(events); err != nil || !slices.Equal(got, []string{"third", "fourth"}) {
+		t.Errorf("Poll() = %q, %v after a restart, expected the uncommitted events again", got, err)
+	}
+	if events, err = c.Poll(ctx, time.Second); err != nil || len(events) != 0 {
+		t.Errorf("Poll() = %q, %v, expected no more events", eventStrings(events), err)
+	}
+
+	// The earliest events can be asked for instead, for a new consumer group
+	proxy.committed = -1
+	conf.OffsetReset = "earliest"
+	c2, err := NewKafkaConsumer(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close(ctx)
+	if events, err = c2.Poll(ctx, time.Second); err != nil || len(events) != len(proxy.records) {
+		t.Errorf("Poll() = %q, %v with offset_reset = \"earliest\", expected all the events", eventStrings(events), err)
+	}
+
+	// The REST Proxy's error messages are passed on
+	conf.Topic = "missing"
+	_, err = NewKafkaConsumer(ctx, conf)
+	if err == nil || !strings.Contains(err.Error(), "Topic not found.") {
+		t.Errorf("NewKafkaConsumer() returned error %v for a missing topic", err)
+	}
+	if len(proxy.positions) != 2 {
+		t.Errorf("NewKafkaConsumer() didn't remove the consumer instance after failing to subscribe")
+	}
+}
+
+func eventStrings(events [][]byte) []string {
+	s := make([]string, len(events))
+	for i, e := range events {
+		s[i] = string(e)
+	}
+	return s
+}
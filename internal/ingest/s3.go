@@ -0,0 +1,233 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+// emptySHA256 is the SHA-256 hash of an empty request body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Bucket is an S3 compatible object store bucket (eg AWS S3 or Cloudflare R2) holding log files
+type Bucket struct {
+	endpoint     string
+	region       string
+	name         string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+	now          func() time.Time // Returns the time requests are signed at
+}
+
+// Object is a file in a bucket
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// NewBucket returns the bucket given in the config.  Values not given there are taken from the usual AWS_REGION,
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+func NewBucket(conf config.BucketInfo) (*Bucket, error) {
+	if conf.Bucket == "" {
+		return nil, errors.New("s3: no bucket given")
+	}
+	b := &Bucket{
+		endpoint:  strings.TrimSuffix(conf.Endpoint, "/"),
+		region:    conf.Region,
+		name:      conf.Bucket,
+		accessKey: conf.AccessKeyID,
+		secretKey: conf.SecretAccessKey,
+		client:    &http.Client{},
+		now:       time.Now,
+	}
+	if b.region == "" {
+		b.region = os.Getenv("AWS_REGION")
+	}
+	if b.region == "" {
+		// R2 (and most other S3 compatible stores) accept any region
+		b.region = "auto"
+	}
+	if b.endpoint == "" {
+		b.endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", b.region)
+	}
+	if b.accessKey == "" {
+		b.accessKey, b.secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		b.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, errors.New("s3: no access key given")
+	}
+	return b, nil
+}
+
+// List returns the objects in the bucket whose keys start with the given prefix, ordered by key
+func (b *Bucket) List(ctx context.Context, prefix string) (objects []Object, err error) {
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var resp *http.Response
+		resp, err = b.do(ctx, http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return
+		}
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: listing %v: %w", b.name, err)
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, LastModified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return
+}
+
+// Get returns the contents of an object.  The caller needs to close it
+func (b *Bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put uploads an object, replacing any existing one with the same key
+func (b *Bucket) Put(ctx context.Context, key string, body []byte) error {
+	return b.PutContent(ctx, key, "", body)
+}
+
+// PutContent uploads an object as per Put, with the given Content-Type so it's served correctly (eg from a CDN).  An
+// empty content type leaves it to the store's default
+func (b *Bucket) PutContent(ctx context.Context, key, contentType string, body []byte) error {
+	resp, err := b.do(ctx, http.MethodPut, key, nil, body, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a request for an object (or the bucket itself, for an empty key), signed with Signature Version 4.  Path
+// style URLs are used, as not all S3 compatible stores support virtual host style ones
+func (b *Bucket) do(ctx context.Context, method, key string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	escapedPath := "/" + awsEscape(b.name, false)
+	if key != "" {
+		escapedPath += "/" + awsEscape(key, true)
+	}
+	var params []string
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	sort.Strings(params)
+	canonicalQuery := strings.Join(params, "&")
+	reqURL := b.endpoint + escapedPath
+	if canonicalQuery != "" {
+		reqURL += "?" + canonicalQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		hash := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(hash[:])
+	}
+
+	now := b.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	if b.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.sessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + b.sessionToken + "\n"
+	}
+
+	// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+	canonicalRequest := method + "\n" + escapedPath + "\n" + canonicalQuery + "\n" + canonicalHeaders + "\n" + signedHeaders +
+		"\n" + payloadHash
+	scope := now.Format("20060102") + "/" + b.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	signingKey := hmacSHA256([]byte("AWS4"+b.secretKey), now.Format("20060102"))
+	for _, part := range []string{b.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3: %v %v returned status %v", method, escapedPath, resp.Status)
+	}
+	return resp, nil
+}
+
+// awsEscape URI encodes a value as required for AWS signatures.  Only the unreserved characters are left as-is, plus
+// "/" when encoding an object key
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
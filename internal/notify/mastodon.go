@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// DefaultTootTemplate is the text of the monthly Mastodon post, when the config file doesn't give one
+const DefaultTootTemplate = `DB Browser for SQLite was downloaded {{.Downloads}} times in {{.Month}}, ` +
+	`with an estimated {{.Users}} active users. Thanks everyone! #SQLite #DB4S`
+
+// Milestone holds the numbers for a closed month, as given to the toot template
+type Milestone struct {
+	Month     string // eg "August 2018"
+	Downloads int64
+	Users     int64
+}
+
+// MonthMilestone returns the numbers for the month starting at the given date
+func MonthMilestone(ctx context.Context, db store.Store, month time.Time) (m Milestone, err error) {
+	m.Month = month.Format("January 2006")
+	for _, name := range []string{stats.FamilyDownloadsMonthly, stats.FamilyUsersMonthly} {
+		fam, _ := stats.FamilyByName(name)
+		var counts map[int]int64
+		counts, err = db.StoredStats(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, month)
+		if err != nil {
+			return
+		}
+		if name == stats.FamilyDownloadsMonthly {
+			m.Downloads = counts[fam.TotalID]
+		} else {
+			m.Users = counts[fam.TotalID]
+		}
+	}
+	return
+}
+
+// Toot posts the milestone to a Mastodon account, using the given template for the text
+func Toot(ctx context.Context, server, token, tmpl string, m Milestone) error {
+	if tmpl == "" {
+		tmpl = DefaultTootTemplate
+	}
+	t, err := template.New("toot").Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("invalid toot template: %w", err)
+	}
+	var status strings.Builder
+	err = t.Execute(&status, m)
+	if err != nil {
+		return fmt.Errorf("invalid toot template: %w", err)
+	}
+
+	form := url.Values{"status": {status.String()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(server, "/")+"/api/v1/statuses",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Mastodon ignores repeats of the same idempotency key for a while, so a rerun can't post the same month twice
+	req.Header.Set("Idempotency-Key", "db4s-stats-"+m.Month)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("mastodon returned status %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Monitor services, for dead man's switch pings
+const (
+	MonitorCronitor     = "cronitor"
+	MonitorHealthchecks = "healthchecks"
+)
+
+// PingStart tells the monitoring service a run has started.  Healthchecks.io and Cronitor both use this to measure
+// the run duration, and to alert when a started run doesn't finish
+func PingStart(ctx context.Context, service, pingURL string) error {
+	if service == MonitorCronitor {
+		return ping(ctx, http.MethodGet, cronitorURL(pingURL, "run", nil), "")
+	}
+	return ping(ctx, http.MethodPost, strings.TrimSuffix(pingURL, "/")+"/start", "")
+}
+
+// PingFinish tells the monitoring service a run has finished, successfully or not, including the run duration and
+// number of time periods processed
+func PingFinish(ctx context.Context, service, pingURL string, s Summary) error {
+	if service == MonitorCronitor {
+		state := "complete"
+		if s.Err != nil {
+			state = "fail"
+		}
+		params := url.Values{
+			"message": {s.Text()},
+			"metric": {
+				"duration:" + strconv.FormatFloat(s.Duration.Seconds(), 'f', 0, 64),
+				"count:" + strconv.Itoa(s.Processed),
+			},
+		}
+		if s.Err != nil {
+			params.Add("metric", "error_count:1")
+		}
+		return ping(ctx, http.MethodGet, cronitorURL(pingURL, state, params), "")
+	}
+
+	// Healthchecks.io shows the request body in the ping details
+	u := strings.TrimSuffix(pingURL, "/")
+	if s.Err != nil {
+		u += "/fail"
+	}
+	return ping(ctx, http.MethodPost, u, s.Text())
+}
+
+// cronitorURL returns a Cronitor telemetry URL for the given state
+func cronitorURL(pingURL, state string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("state", state)
+	sep := "?"
+	if strings.Contains(pingURL, "?") {
+		sep = "&"
+	}
+	return pingURL + sep + params.Encode()
+}
+
+// ping sends a request to the monitoring service
+func ping(ctx context.Context, method, pingURL, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, pingURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("monitor ping returned status %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package notify posts a summary of each run to a chat webhook
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// Summary describes a finished run
+type Summary struct {
+	Product   string // Defaults to DB4S
+	Duration  time.Duration
+	Err       error
+	Processed int
+	Headlines []Headline
+	Warnings  []string
+}
+
+// Headline is the total of the most recent completed time period of a metric family, along with the total of the
+// time period before it for comparison
+type Headline struct {
+	Family   string `json:"family"`
+	Label    string `json:"label"`
+	Total    int64  `json:"total"`
+	Previous int64  `json:"previous"`
+}
+
+// Headlines returns the headline numbers of each metric family
+func Headlines(ctx context.Context, db store.Store, families []stats.Family, now time.Time) ([]Headline, error) {
+	var headlines []Headline
+	for _, fam := range families {
+		latest := fam.Granularity.Previous(fam.Granularity.Start(now))
+		current, err := db.StoredStats(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, latest)
+		if err != nil {
+			return nil, err
+		}
+		previous, err := db.StoredStats(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, fam.Granularity.Previous(latest))
+		if err != nil {
+			return nil, err
+		}
+		headlines = append(headlines, Headline{
+			Family:   fam.Name,
+			Label:    fam.Granularity.Label(latest),
+			Total:    current[fam.TotalID],
+			Previous: previous[fam.TotalID],
+		})
+	}
+	return headlines, nil
+}
+
+// Text returns the summary as a short chat message
+func (s Summary) Text() string {
+	product := s.Product
+	if product == "" {
+		product = "DB4S"
+	}
+	var b strings.Builder
+	if s.Err != nil {
+		fmt.Fprintf(&b, ":x: %v stats run failed after %v: %v\n", product, s.Duration.Round(time.Second), s.Err)
+	} else {
+		fmt.Fprintf(&b, ":white_check_mark: %v stats run finished in %v, %d time period(s) updated\n", product,
+			s.Duration.Round(time.Second), s.Processed)
+	}
+	for _, h := range s.Headlines {
+		fmt.Fprintf(&b, "* %v (%v): %d%v\n", h.Family, h.Label, h.Total, change(h.Total, h.Previous))
+	}
+	for _, w := range s.Warnings {
+		fmt.Fprintf(&b, ":warning: %v\n", w)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// change returns the percentage change from the previous value, for display after the current one.  eg " (+5.2%)"
+func change(current, previous int64) string {
+	if previous == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.1f%%)", float64(current-previous)*100/float64(previous))
+}
+
+// Send posts the summary to a Slack or Mattermost incoming webhook
+func Send(ctx context.Context, webhookURL string, s Summary) error {
+	body, err := json.Marshal(map[string]string{"text": s.Text()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader holds the HMAC-SHA256 of the JSON payload when a webhook secret is set, as "sha256=<hex digest>"
+const SignatureHeader = "X-DB4S-Signature"
+
+// RunPayload is the JSON summary of a run, posted to the generic webhook
+type RunPayload struct {
+	Product   string     `json:"product"`
+	Mode      string     `json:"mode"`
+	Success   bool       `json:"success"`
+	Error     string     `json:"error,omitempty"`
+	Finished  time.Time  `json:"finished"`
+	Duration  float64    `json:"duration_seconds"`
+	Processed int        `json:"periods_processed"`
+	Headlines []Headline `json:"headlines"`
+	Warnings  []string   `json:"warnings"`
+}
+
+// Payload returns the summary as the JSON payload of the generic webhook
+func (s Summary) Payload(mode string, finished time.Time) RunPayload {
+	p := RunPayload{
+		Product:   s.Product,
+		Mode:      mode,
+		Success:   s.Err == nil,
+		Finished:  finished.UTC(),
+		Duration:  s.Duration.Seconds(),
+		Processed: s.Processed,
+		Headlines: s.Headlines,
+		Warnings:  s.Warnings,
+	}
+	if p.Product == "" {
+		p.Product = "DB4S"
+	}
+	if s.Err != nil {
+		p.Error = s.Err.Error()
+	}
+	if p.Headlines == nil {
+		p.Headlines = []Headline{}
+	}
+	if p.Warnings == nil {
+		p.Warnings = []string{}
+	}
+	return p
+}
+
+// Sign returns the signature header value of a webhook payload, so the receiver can check it came from us
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// PostRun posts the JSON summary of a run to a generic webhook, eg for website cache invalidation.  When a secret is
+// given, the payload is signed with it
+func PostRun(ctx context.Context, webhookURL, secret string, p RunPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %v", resp.Status)
+	}
+	return nil
+}
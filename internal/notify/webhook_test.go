@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// Known HMAC-SHA256 values
+	tests := []struct {
+		secret, body, want string
+	}{
+		{"", "", "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+		{"key", "The quick brown fox jumps over the lazy dog",
+			"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+	}
+	for _, test := range tests {
+		if got := Sign(test.secret, []byte(test.body)); got != test.want {
+			t.Errorf("Sign(%q, %q) = %v, expected %v", test.secret, test.body, got, test.want)
+		}
+	}
+}
+
+func TestPostRun(t *testing.T) {
+	finished := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		secret  string
+		summary Summary
+		status  int
+		wantErr bool
+	}{
+		{"unsigned", "", Summary{Processed: 3}, http.StatusOK, false},
+		{"signed", "s3cret", Summary{Product: "DBHub.io", Processed: 3}, http.StatusNoContent, false},
+		{"failed run", "s3cret", Summary{Err: errors.New("database unavailable")}, http.StatusOK, false},
+		{"rejected", "", Summary{}, http.StatusBadGateway, true},
+	}
+	for _, test := range tests {
+		var body []byte
+		var signature string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = io.ReadAll(r.Body)
+			signature = r.Header.Get(SignatureHeader)
+			w.WriteHeader(test.status)
+		}))
+		p := test.summary.Payload("resume", finished)
+		err := PostRun(context.Background(), srv.URL, test.secret, p)
+		srv.Close()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: PostRun() error = %v, expected an error: %v", test.name, err, test.wantErr)
+			continue
+		}
+
+		// The receiver can check the signature against the payload, using the shared secret
+		if test.secret == "" && signature != "" {
+			t.Errorf("%v: unsigned payload has signature %q", test.name, signature)
+		}
+		if test.secret != "" && signature != Sign(test.secret, body) {
+			t.Errorf("%v: signature %q doesn't match the payload", test.name, signature)
+		}
+		var got RunPayload
+		if err = json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("%v: payload doesn't parse: %v", test.name, err)
+		}
+		if got.Success != (test.summary.Err == nil) || got.Processed != test.summary.Processed ||
+			!got.Finished.Equal(finished) || got.Product == "" || got.Warnings == nil {
+			t.Errorf("%v: unexpected payload %+v", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// An adoption curve is the number of unique users of a release on each day since its release date, so the uptake of
+// releases made years apart can be overlaid and compared.  eg whether 3.13 is being adopted faster than 3.12 was
+
+// adoptionColours are the line colours of the adoption chart, reused in order when there are more releases
+var adoptionColours = []string{"#2a6ebb", "#d9534f", "#5cb85c", "#f0ad4e", "#8e44ad", "#17a2b8"}
+
+// Curve is the adoption curve of a release
+type Curve struct {
+	Version  string
+	Released time.Time
+
+	// The number of unique users on each day, starting with the release date.  It stops at the last completed day
+	Users []int64
+}
+
+// AdoptionCurves returns the adoption curves of the given versions, covering up to the given number of days from each
+// release date.  The versions need a release date in db4s_release_info
+func AdoptionCurves(ctx context.Context, db store.Store, versions []string, days int, now time.Time) ([]Curve, error) {
+	fam, _ := stats.FamilyByName(stats.FamilyUsersDaily)
+	ids, err := db.ReleaseIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := db.DatedReleases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	today := fam.Granularity.Start(now)
+
+	var curves []Curve
+	for _, v := range versions {
+		id, ok := ids[v]
+		if !ok {
+			return nil, fmt.Errorf("unknown release '%v'", v)
+		}
+		released, ok := dates[id]
+		if !ok {
+			return nil, fmt.Errorf("release '%v' has no release date", v)
+		}
+		startDate := fam.Granularity.Start(released)
+		endDate := startDate.AddDate(0, 0, days)
+		if endDate.After(today) {
+			endDate = today
+		}
+		c := Curve{Version: v, Released: startDate}
+		if endDate.After(startDate) {
+			counts, err := db.StatsRange(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, startDate, endDate)
+			if err != nil {
+				return nil, err
+			}
+			for d := startDate; d.Before(endDate); d = fam.Granularity.Next(d) {
+				c.Users = append(c.Users, counts[d][id])
+			}
+		}
+		curves = append(curves, c)
+	}
+	return curves, nil
+}
+
+// WriteAdoptionCSV writes the adoption curves as CSV, with a row for each day since the release dates and a column for
+// each release.  Days past the end of a shorter curve are left blank
+func WriteAdoptionCSV(w io.Writer, curves []Curve) error {
+	cw := csv.NewWriter(w)
+	header := []string{"day"}
+	length := 0
+	for _, c := range curves {
+		header = append(header, c.Version)
+		length = max(length, len(c.Users))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for day := 0; day < length; day++ {
+		record := []string{strconv.Itoa(day)}
+		for _, c := range curves {
+			value := ""
+			if day < len(c.Users) {
+				value = strconv.FormatInt(c.Users[day], 10)
+			}
+			record = append(record, value)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonCurve is an adoption curve as written by WriteAdoptionJSON
+type jsonCurve struct {
+	Version  string  `json:"version"`
+	Released string  `json:"released"` // In YYYY-MM-DD format
+	Users    []int64 `json:"users"`
+}
+
+// WriteAdoptionJSON writes the adoption curves as a JSON array
+func WriteAdoptionJSON(w io.Writer, curves []Curve) error {
+	out := make([]jsonCurve, len(curves))
+	for i, c := range curves {
+		out[i] = jsonCurve{Version: c.Version, Released: c.Released.Format("2006-01-02"), Users: c.Users}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// WriteAdoptionChart writes the adoption curves as a standalone SVG line chart, with the days since release along the
+// bottom and a line per release
+func WriteAdoptionChart(w io.Writer, curves []Curve) error {
+	var maxUsers int64 = 1
+	length := 2
+	for _, c := range curves {
+		length = max(length, len(c.Users))
+		for _, n := range c.Users {
+			maxUsers = max(maxUsers, n)
+		}
+	}
+
+	plotWidth := float64(chartWidth - 2*chartPadding)
+	plotHeight := float64(chartHeight - 2*chartPadding)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" font-family="sans-serif">`,
+		chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, chartPadding, chartHeight-chartPadding,
+		chartWidth-chartPadding, chartHeight-chartPadding)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, chartPadding, chartPadding, chartPadding,
+		chartHeight-chartPadding)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="#666" text-anchor="end">%d</text>`, chartPadding-4,
+		chartPadding+4, maxUsers)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="#666" text-anchor="end">0</text>`, chartPadding-4,
+		chartHeight-chartPadding+4)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="#666">day 0</text>`, chartPadding,
+		chartHeight-chartPadding+16)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="#666" text-anchor="end">day %d</text>`,
+		chartWidth-chartPadding, chartHeight-chartPadding+16, length-1)
+	for i, c := range curves {
+		colour := adoptionColours[i%len(adoptionColours)]
+		coords := make([]string, len(c.Users))
+		for day, n := range c.Users {
+			x := float64(chartPadding) + plotWidth*float64(day)/float64(length-1)
+			y := float64(chartPadding) + plotHeight*(1-float64(n)/float64(maxUsers))
+			coords[day] = fmt.Sprintf("%.1f,%.1f", x, y)
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(coords, " "),
+			colour)
+
+		// The legend, along the top
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="%s">%s</text>`, chartPadding+8+i*100,
+			chartPadding-12, colour, html.EscapeString(c.Version))
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,214 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/export"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// The monthly PDF report is a summary of one month, for attaching to grant reports and sponsorship
+// updates: the users and downloads totals compared to the month before and the year before, a chart of each over the
+// last 12 months, and the top releases and downloads of the month
+
+// trendMonths is how many months the charts of the monthly report cover, ending with the reported month
+const trendMonths = 12
+
+// barColour is the fill colour of the chart bars, matching the lines of the HTML report charts
+var barColour = [3]float64{0.165, 0.431, 0.733}
+
+// monthlyMetric is the part of the monthly report for the users or downloads
+type monthlyMetric struct {
+	Title     string // eg "Unique users"
+	Column    string // The heading of the breakdown's names column.  eg "Version"
+	Total     int64
+	PrevMonth int64
+	PrevYear  int64
+	Trend     []point // The monthly totals, oldest first
+	Top       []export.Row
+}
+
+// RenderPDF writes the monthly report for the month starting at the given date as a PDF.  If top is above 0, the
+// breakdowns only list that many releases or downloads, with the rest folded into an "Other" row
+func RenderPDF(ctx context.Context, db store.Store, w io.Writer, month time.Time, top int) error {
+	month = stats.Monthly.Start(month)
+	var metrics []monthlyMetric
+	for _, name := range []string{"users-monthly", "downloads-monthly"} {
+		fam, ok := stats.FamilyByName(name)
+		if !ok {
+			return fmt.Errorf("unknown metric family '%v'", name)
+		}
+		m, err := monthly(ctx, db, fam, month, top)
+		if err != nil {
+			return err
+		}
+		metrics = append(metrics, m)
+	}
+
+	doc := newPDF()
+	doc.text(pdfMargin, doc.y-20, fontBold, 20, 0, "DB Browser for SQLite usage report")
+	doc.text(pdfMargin, doc.y-42, fontRegular, 13, 0.3, month.Format("January 2006"))
+	doc.textRight(pdfWidth-pdfMargin, doc.y-42, fontRegular, 9, 0.5,
+		"Generated "+time.Now().UTC().Format("2006-01-02"))
+	doc.line(pdfMargin, doc.y-52, pdfWidth-pdfMargin, doc.y-52, 0.5, 0.8)
+	doc.y -= 70
+
+	// The totals side by side, with how they've changed
+	colWidth := float64(pdfWidth-2*pdfMargin) / float64(len(metrics))
+	for i, m := range metrics {
+		x := pdfMargin + float64(i)*colWidth
+		prevMonth, prevYear := month.AddDate(0, -1, 0), month.AddDate(-1, 0, 0)
+		doc.text(x, doc.y-10, fontRegular, 10, 0.4, m.Title)
+		doc.text(x, doc.y-36, fontBold, 24, 0, thousands(m.Total))
+		doc.text(x, doc.y-52, fontRegular, 9, 0.4, change(m.Total, m.PrevMonth, prevMonth))
+		doc.text(x, doc.y-64, fontRegular, 9, 0.4, change(m.Total, m.PrevYear, prevYear))
+	}
+	doc.y -= 90
+
+	for _, m := range metrics {
+		doc.barChart(fmt.Sprintf("%v, last %d months", m.Title, trendMonths), m.Trend)
+	}
+
+	// The breakdowns side by side, continuing on the next page if they're too long
+	header := func() {
+		for i, m := range metrics {
+			x := pdfMargin + float64(i)*colWidth
+			doc.text(x, doc.y-12, fontBold, 9, 0, m.Column)
+			doc.textRight(x+colWidth-20, doc.y-12, fontBold, 9, 0, m.Title)
+			doc.line(x, doc.y-16, x+colWidth-20, doc.y-16, 0.5, 0.6)
+		}
+		doc.y -= 20
+	}
+	doc.need(60)
+	for i, m := range metrics {
+		doc.text(pdfMargin+float64(i)*colWidth, doc.y-12, fontBold, 12, 0, "Top "+m.Column+"s")
+	}
+	doc.y -= 22
+	header()
+	var rows int
+	for _, m := range metrics {
+		rows = max(rows, len(m.Top))
+	}
+	for r := 0; r < rows; r++ {
+		if doc.y-14 < pdfMargin {
+			doc.newPage()
+			header()
+		}
+		for i, m := range metrics {
+			if r >= len(m.Top) {
+				continue
+			}
+			x := pdfMargin + float64(i)*colWidth
+			grey := 0.0
+			if m.Top[r].ID == export.OtherID {
+				grey = 0.4
+			}
+			doc.text(x, doc.y-10, fontRegular, 9, grey, m.Top[r].Name)
+			doc.textRight(x+colWidth-20, doc.y-10, fontRegular, 9, grey, thousands(m.Top[r].Count))
+		}
+		doc.y -= 14
+	}
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// monthly gathers the totals, trend and breakdown of a monthly metric family for the monthly report
+func monthly(ctx context.Context, db store.Store, fam stats.Family, month time.Time, top int) (monthlyMetric, error) {
+	m := monthlyMetric{Title: "Unique users", Column: "Version"}
+	if fam.Kind == stats.KindDownloads {
+		m.Title, m.Column = "Downloads", "Download"
+	}
+	startDate := month.AddDate(-1, 0, 0)
+	if trend := month.AddDate(0, 1-trendMonths, 0); trend.Before(startDate) {
+		startDate = trend
+	}
+	rows, err := export.Collect(ctx, db, fam, startDate, month.AddDate(0, 1, 0))
+	if err != nil {
+		return m, err
+	}
+
+	totals := make(map[time.Time]int64)
+	for _, r := range rows {
+		if r.ID == fam.TotalID {
+			totals[r.Date] = r.Count
+		} else if r.Date.Equal(month) && r.Count > 0 {
+			m.Top = append(m.Top, r)
+		}
+	}
+	m.Total, m.PrevMonth, m.PrevYear = totals[month], totals[month.AddDate(0, -1, 0)], totals[month.AddDate(-1, 0, 0)]
+	for i := trendMonths - 1; i >= 0; i-- {
+		d := month.AddDate(0, -i, 0)
+		m.Trend = append(m.Trend, point{Date: d, Value: totals[d]})
+	}
+	sort.SliceStable(m.Top, func(i, j int) bool { return m.Top[i].Count > m.Top[j].Count })
+	m.Top = export.TopN(m.Top, fam, top)
+	return m, nil
+}
+
+// barChart draws a titled bar chart of monthly values across the page, labelling each bar with its month and value
+func (d *pdfDoc) barChart(title string, points []point) {
+	const height = 110
+	d.need(height + 50)
+	d.text(pdfMargin, d.y-12, fontBold, 12, 0, title)
+	base := d.y - 30 - height
+	d.line(pdfMargin, base, pdfWidth-pdfMargin, base, 0.5, 0.6)
+
+	var max int64 = 1
+	for _, p := range points {
+		if p.Value > max {
+			max = p.Value
+		}
+	}
+	slot := float64(pdfWidth-2*pdfMargin) / float64(len(points))
+	for i, p := range points {
+		x := pdfMargin + float64(i)*slot
+		h := (height - 12) * float64(p.Value) / float64(max)
+		d.rect(x+slot*0.15, base, slot*0.7, h, barColour)
+		label := compact(p.Value)
+		d.text(x+(slot-textWidth(label, 7))/2, base+h+3, fontRegular, 7, 0.3, label)
+		month := p.Date.Format("Jan 06")
+		d.text(x+(slot-textWidth(month, 7))/2, base-10, fontRegular, 7, 0.4, month)
+	}
+	d.y = base - 30
+}
+
+// change describes the percentage change from the value of a previous month.  eg "+4.2% on March 2023"
+func change(value, previous int64, month time.Time) string {
+	if previous == 0 {
+		return "No data for " + month.Format("January 2006")
+	}
+	return fmt.Sprintf("%+.1f%% on %v", float64(value-previous)*100/float64(previous), month.Format("January 2006"))
+}
+
+// thousands formats a number with comma separators.  eg "12,345"
+func thousands(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	start := 0
+	if n < 0 {
+		start = 1
+	}
+	for i := len(s) - 3; i > start; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// compact formats a number to fit above a chart bar.  eg "12.3k"
+func compact(n int64) string {
+	switch {
+	case n >= 1000000:
+		return strconv.FormatFloat(float64(n)/1000000, 'f', 1, 64) + "M"
+	case n >= 10000:
+		return strconv.FormatFloat(float64(n)/1000, 'f', 0, 64) + "k"
+	case n >= 1000:
+		return strconv.FormatFloat(float64(n)/1000, 'f', 1, 64) + "k"
+	default:
+		return strconv.FormatInt(n, 10)
+	}
+}
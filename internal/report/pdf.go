@@ -0,0 +1,155 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The PDF is written directly rather than through a library.  It only uses the standard Helvetica fonts, which every
+// PDF viewer has built in so nothing needs embedding, plus lines and filled rectangles for the charts
+
+// Dimensions of an A4 page and its margins, in points
+const (
+	pdfWidth  = 595
+	pdfHeight = 842
+	pdfMargin = 50
+)
+
+// The fonts available to the pages
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// helveticaWidths holds the widths of the printable ASCII characters in Helvetica, in thousandths of the font size.
+// Helvetica Bold is a little wider, so text measured with these only lines up exactly in the regular font
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// pdfDoc builds up the pages of a PDF document.  Positions are in points from the bottom left corner of the page
+type pdfDoc struct {
+	pages []*strings.Builder
+	y     float64 // How far down the current page has been filled, as the position of its next line
+}
+
+// newPDF returns a document with a single empty page
+func newPDF() *pdfDoc {
+	d := &pdfDoc{}
+	d.newPage()
+	return d
+}
+
+// newPage starts a new page, with the next line at the top margin
+func (d *pdfDoc) newPage() {
+	d.pages = append(d.pages, &strings.Builder{})
+	d.y = pdfHeight - pdfMargin
+}
+
+// need starts a new page when there isn't the given height left on the current one
+func (d *pdfDoc) need(height float64) {
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+}
+
+// page returns the content of the current page
+func (d *pdfDoc) page() *strings.Builder {
+	return d.pages[len(d.pages)-1]
+}
+
+// text writes a line of text in the given font, size and grey level (0 for black), with its baseline at y
+func (d *pdfDoc) text(x, y float64, font string, size, grey float64, s string) {
+	fmt.Fprintf(d.page(), "%.3g g BT /%s %g Tf %.1f %.1f Td (%s) Tj ET\n", grey, font, size, x, y, pdfString(s))
+}
+
+// textRight writes a line of text ending at x, for lining up numbers
+func (d *pdfDoc) textRight(x, y float64, font string, size, grey float64, s string) {
+	d.text(x-textWidth(s, size), y, font, size, grey, s)
+}
+
+// line draws a straight line in the given grey level
+func (d *pdfDoc) line(x1, y1, x2, y2, width, grey float64) {
+	fmt.Fprintf(d.page(), "%.3g G %g w %.1f %.1f m %.1f %.1f l S\n", grey, width, x1, y1, x2, y2)
+}
+
+// rect draws a rectangle filled with the given RGB colour, with its bottom left corner at x, y
+func (d *pdfDoc) rect(x, y, width, height float64, colour [3]float64) {
+	fmt.Fprintf(d.page(), "%.3g %.3g %.3g rg %.1f %.1f %.1f %.1f re f\n", colour[0], colour[1], colour[2], x, y,
+		width, height)
+}
+
+// WriteTo writes out the document
+func (d *pdfDoc) WriteTo(w io.Writer) (int64, error) {
+	// The objects are numbered from 1: the catalog, the page tree, the two fonts, then a page and its content for
+	// each page
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // The page tree, filled in below once the page object numbers are known
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	var kids []string
+	for _, p := range d.pages {
+		n := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", n))
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>", pdfWidth, pdfHeight, fontRegular,
+			fontBold, n+1))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", p.Len(), p.String()))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	// The cross-reference table gives the byte offset of each object in the file
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.WriteTo(w)
+}
+
+// pdfString escapes text for use in a PDF string.  Characters outside of printable ASCII are replaced with "?", as
+// the names in the stats are ASCII anyway
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ' || r > '~':
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// textWidth returns the width of a line of Helvetica text at the given size, in points
+func textWidth(s string, size float64) float64 {
+	var width int
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		width += helveticaWidths[r-' ']
+	}
+	return float64(width) * size / 1000
+}
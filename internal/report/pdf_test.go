@@ -0,0 +1,190 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store/memstore"
+)
+
+var (
+	startxrefPattern = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	trailerPattern   = regexp.MustCompile(`^trailer\n<< /Size (\d+) /Root (\d+) 0 R >>\n`)
+	kidsPattern      = regexp.MustCompile(`^<< /Type /Pages /Kids \[([0-9 R]*)\] /Count (\d+) >>$`)
+	contentsPattern  = regexp.MustCompile(`^<< /Type /Page /Parent 2 0 R .* /Contents (\d+) 0 R >>$`)
+	streamPattern    = regexp.MustCompile(`(?s)^<< /Length (\d+) >>\nstream\n(.*)\nendstream$`)
+)
+
+// readPDF checks the structure of a PDF written by pdfDoc: the header, that the cross-reference table offsets point
+// at the objects and the trailer matches it, then the page tree.  It returns the content stream of each page
+func readPDF(t *testing.T, data []byte) []string {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatal("file doesn't start with the PDF header")
+	}
+	m := startxrefPattern.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("file doesn't end with startxref and the end of file marker")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if xref >= len(data) || !bytes.HasPrefix(data[xref:], []byte("xref\n0 ")) {
+		t.Fatalf("startxref %d doesn't point at the cross-reference table", xref)
+	}
+
+	// The cross-reference table, with its fixed width entries
+	rest := string(data[xref+len("xref\n0 "):])
+	line, rest, _ := strings.Cut(rest, "\n")
+	size, err := strconv.Atoi(line)
+	if err != nil || len(rest) < 20*size {
+		t.Fatalf("invalid cross-reference table size %q", line)
+	}
+	if rest[:20] != "0000000000 65535 f \n" {
+		t.Fatalf("first cross-reference entry %q isn't the free list head", rest[:20])
+	}
+	objects := make(map[int]string)
+	for n := 1; n < size; n++ {
+		entry := rest[20*n : 20*n+20]
+		if !strings.HasSuffix(entry, " 00000 n \n") {
+			t.Fatalf("cross-reference entry %q for object %d isn't in use", entry, n)
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		start := fmt.Sprintf("%d 0 obj\n", n)
+		if offset >= xref || !bytes.HasPrefix(data[offset:], []byte(start)) {
+			t.Fatalf("cross-reference offset %d doesn't point at object %d", offset, n)
+		}
+		obj, _, found := strings.Cut(string(data[offset+len(start):xref]), "\nendobj\n")
+		if !found {
+			t.Fatalf("object %d isn't ended", n)
+		}
+		objects[n] = obj
+	}
+
+	// The trailer follows straight after the table, with the same size
+	tm := trailerPattern.FindStringSubmatch(rest[20*size:])
+	if tm == nil {
+		t.Fatalf("invalid trailer %q", rest[20*size:])
+	}
+	if tm[1] != strconv.Itoa(size) || tm[2] != "1" {
+		t.Fatalf("trailer has /Size %v and /Root %v, expected %d and 1", tm[1], tm[2], size)
+	}
+	if objects[1] != "<< /Type /Catalog /Pages 2 0 R >>" {
+		t.Fatalf("object 1 is %q, not the catalog", objects[1])
+	}
+
+	// Each page in the page tree has a content stream of the given length
+	km := kidsPattern.FindStringSubmatch(objects[2])
+	if km == nil {
+		t.Fatalf("object 2 is %q, not the page tree", objects[2])
+	}
+	kids := strings.Fields(strings.ReplaceAll(km[1], " 0 R", ""))
+	if km[2] != strconv.Itoa(len(kids)) {
+		t.Fatalf("page tree has /Count %v for %d kids", km[2], len(kids))
+	}
+	var pages []string
+	for _, kid := range kids {
+		n, _ := strconv.Atoi(kid)
+		cm := contentsPattern.FindStringSubmatch(objects[n])
+		if cm == nil {
+			t.Fatalf("object %d is %q, not a page", n, objects[n])
+		}
+		c, _ := strconv.Atoi(cm[1])
+		sm := streamPattern.FindStringSubmatch(objects[c])
+		if sm == nil {
+			t.Fatalf("object %d isn't a content stream", c)
+		}
+		if sm[1] != strconv.Itoa(len(sm[2])) {
+			t.Fatalf("content stream %d has /Length %v, but is %d bytes", c, sm[1], len(sm[2]))
+		}
+		pages = append(pages, sm[2])
+	}
+	return pages
+}
+
+func TestPDFStructure(t *testing.T) {
+	doc := newPDF()
+	doc.text(pdfMargin, doc.y, fontBold, 12, 0, `Title (with) \ brackets`)
+	doc.newPage()
+	doc.line(0, 0, 10, 10, 1, 0.5)
+	doc.rect(1, 2, 3, 4, [3]float64{1, 0, 0})
+	doc.newPage()
+
+	var buf bytes.Buffer
+	n, err := doc.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, but wrote %d bytes", n, buf.Len())
+	}
+	pages := readPDF(t, buf.Bytes())
+	if len(pages) != 3 {
+		t.Fatalf("%d pages, expected 3", len(pages))
+	}
+	if !strings.Contains(pages[0], `/F2 12 Tf 50.0 792.0 Td (Title \(with\) \\ brackets) Tj ET`) {
+		t.Errorf("first page doesn't have the escaped title: %q", pages[0])
+	}
+	if !strings.Contains(pages[1], "m 10.0 10.0 l S") || !strings.Contains(pages[1], "re f") {
+		t.Errorf("second page doesn't have the line and rectangle: %q", pages[1])
+	}
+	if pages[2] != "" {
+		t.Errorf("third page isn't empty: %q", pages[2])
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	ctx := context.Background()
+	db := memstore.New()
+	month := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Enough releases that the breakdown continues onto a second page
+	counts := make(map[string]int)
+	for i := 0; i < 80; i++ {
+		v := fmt.Sprintf("3.%d.0", i)
+		db.Releases[v] = i + 2
+		counts["sqlitebrowser "+v] = 1000 - i
+	}
+	for i := 0; i < 13; i++ {
+		if err := db.SaveMonthlyUsersStats(ctx, month.AddDate(0, -i, 0), 50000+i, counts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := RenderPDF(ctx, db, &buf, month.AddDate(0, 0, 10), 0); err != nil {
+		t.Fatal(err)
+	}
+	pages := readPDF(t, buf.Bytes())
+	if len(pages) < 2 {
+		t.Fatalf("%d pages, expected the breakdown to continue onto a second one", len(pages))
+	}
+	for _, want := range []string{"(DB Browser for SQLite usage report)", "(March 2024)", "(50,000)", "(3.0.0)"} {
+		if !strings.Contains(pages[0], want) {
+			t.Errorf("first page doesn't have %v", want)
+		}
+	}
+	if !strings.Contains(strings.Join(pages[1:], ""), "(3.79.0)") {
+		t.Error("the last release isn't on a later page")
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"3.12.2", "3.12.2"},
+		{`a(b)c\d`, `a\(b\)c\\d`},
+		{"tab\there", "tab?here"},
+		{"Linüx", "Lin?x"},
+	}
+	for _, test := range tests {
+		if got := pdfString(test.in); got != test.want {
+			t.Errorf("pdfString(%q) = %q, expected %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Package report renders the saved stats into a self-contained static HTML page, or a monthly PDF report
+package report
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/export"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+//go:embed templates/report.html
+var templates embed.FS
+
+var reportTemplate = template.Must(template.ParseFS(templates, "templates/report.html"))
+
+// Page is the data the report template is rendered with
+type Page struct {
+	Generated time.Time
+	Sections  []Section
+}
+
+// Section is the part of the report for a single metric family
+type Section struct {
+	Name  string
+	Title string
+	Chart template.HTML
+
+	// The breakdown by release or download file for the most recent completed time period
+	Latest      string
+	LatestTotal int64
+	Breakdown   []export.Row
+}
+
+// Render writes the report for the given metric families, covering the dates from the start date up to (but not
+// including) the end date.  A zero start or end date leaves that side of the range open.  If top is above 0, the
+// breakdowns only list that many releases or downloads, with the rest folded into an "Other" row
+func Render(ctx context.Context, db store.Store, families []stats.Family, w io.Writer, startDate, endDate time.Time, top int) error {
+	page := Page{Generated: time.Now().UTC().Truncate(time.Second)}
+	for _, fam := range families {
+		s, err := section(ctx, db, fam, startDate, endDate, page.Generated, top)
+		if err != nil {
+			return err
+		}
+		page.Sections = append(page.Sections, s)
+	}
+	return reportTemplate.Execute(w, page)
+}
+
+// section gathers the chart and breakdown of a metric family
+func section(ctx context.Context, db store.Store, fam stats.Family, startDate, endDate, now time.Time, top int) (Section, error) {
+	rows, err := export.Collect(ctx, db, fam, startDate, endDate)
+	if err != nil {
+		return Section{}, err
+	}
+	s := Section{Name: fam.Name, Title: title(fam)}
+
+	// Chart the totals of each time period
+	var points []point
+	for _, r := range rows {
+		if r.ID == fam.TotalID {
+			points = append(points, point{Date: r.Date, Value: r.Count})
+		}
+	}
+	s.Chart = lineChart(points)
+
+	// Break down the most recent time period which has finished, as the current one is still incomplete
+	var latest time.Time
+	for _, p := range points {
+		if !fam.Granularity.Next(p.Date).After(now) {
+			latest = p.Date
+		}
+	}
+	if latest.IsZero() {
+		return s, nil
+	}
+	s.Latest = fam.Granularity.Label(latest)
+	for _, r := range rows {
+		if !r.Date.Equal(latest) {
+			continue
+		}
+		if r.ID == fam.TotalID {
+			s.LatestTotal = r.Count
+		} else if r.Count > 0 {
+			s.Breakdown = append(s.Breakdown, r)
+		}
+	}
+	sort.SliceStable(s.Breakdown, func(i, j int) bool { return s.Breakdown[i].Count > s.Breakdown[j].Count })
+	s.Breakdown = export.TopN(s.Breakdown, fam, top)
+	return s, nil
+}
+
+// title returns the heading for a metric family.  eg "Weekly users"
+func title(fam stats.Family) string {
+	what := "users"
+	if fam.TotalColumn == "db4s_download" {
+		what = "downloads"
+	}
+	g := fam.Granularity.String()
+	return string(g[0]-'a'+'A') + g[1:] + " " + what
+}
@@ -0,0 +1,69 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Dimensions of the charts, in pixels
+const (
+	chartWidth   = 800
+	chartHeight  = 240
+	chartPadding = 40
+)
+
+// point is a single value on a chart
+type point struct {
+	Date  time.Time
+	Value int64
+}
+
+// lineChart pre-renders the points as an inline SVG line chart, so the report doesn't need any JavaScript
+func lineChart(points []point) template.HTML {
+	if len(points) == 0 {
+		return template.HTML(`<p class="empty">No data</p>`)
+	}
+	var max int64
+	for _, p := range points {
+		if p.Value > max {
+			max = p.Value
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	// Scale the points to fit the plot area
+	plotWidth := float64(chartWidth - 2*chartPadding)
+	plotHeight := float64(chartHeight - 2*chartPadding)
+	coords := make([]string, len(points))
+	for i, p := range points {
+		x := float64(chartPadding)
+		if len(points) > 1 {
+			x += plotWidth * float64(i) / float64(len(points)-1)
+		}
+		y := float64(chartPadding) + plotHeight*(1-float64(p.Value)/float64(max))
+		coords[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="chart">`, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="axis"/>`, chartPadding, chartHeight-chartPadding,
+		chartWidth-chartPadding, chartHeight-chartPadding)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="axis"/>`, chartPadding, chartPadding, chartPadding,
+		chartHeight-chartPadding)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label" text-anchor="end">%d</text>`, chartPadding-4, chartPadding+4, max)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label" text-anchor="end">0</text>`, chartPadding-4,
+		chartHeight-chartPadding+4)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label">%s</text>`, chartPadding, chartHeight-chartPadding+16,
+		points[0].Date.Format("2006-01-02"))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label" text-anchor="end">%s</text>`, chartWidth-chartPadding,
+		chartHeight-chartPadding+16, points[len(points)-1].Date.Format("2006-01-02"))
+	fmt.Fprintf(&b, `<polyline points="%s" class="line"/>`, strings.Join(coords, " "))
+	b.WriteString(`</svg>`)
+
+	// The SVG is built entirely from numbers and formatted dates, so it's safe to include as-is
+	return template.HTML(b.String())
+}
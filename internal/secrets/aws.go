@@ -0,0 +1,199 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+// Secret holds the PostgreSQL connection settings retrieved from AWS.  The field names match the JSON form used by AWS
+// for database secrets, so the settings can be either a Secrets Manager secret or an SSM parameter holding the same
+type Secret struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+	Port     any    `json:"port"` // AWS uses both numbers and strings for this
+	DBName   string `json:"dbname"`
+}
+
+// PortNumber returns the port, or 0 when not given
+func (s Secret) PortNumber() int {
+	switch p := s.Port.(type) {
+	case float64:
+		return int(p)
+	case string:
+		n, _ := strconv.Atoi(p)
+		return n
+	}
+	return 0
+}
+
+// AWSSecret retrieves the PostgreSQL connection settings from AWS Secrets Manager (when a secret ID is configured) or
+// SSM Parameter Store (when a parameter name is).  The AWS credentials come from the usual AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+func AWSSecret(ctx context.Context, conf config.AWSInfo) (Secret, error) {
+	region := conf.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return Secret{}, errors.New("aws: no region given")
+	}
+
+	var value string
+	var err error
+	switch {
+	case conf.SecretID != "":
+		var resp struct {
+			SecretString string
+		}
+		err = awsRequest(ctx, conf.Endpoint, region, "secretsmanager", "secretsmanager.GetSecretValue",
+			map[string]any{"SecretId": conf.SecretID}, &resp)
+		value = resp.SecretString
+	case conf.Parameter != "":
+		var resp struct {
+			Parameter struct {
+				Value string
+			}
+		}
+		err = awsRequest(ctx, conf.Endpoint, region, "ssm", "AmazonSSM.GetParameter",
+			map[string]any{"Name": conf.Parameter, "WithDecryption": true}, &resp)
+		value = resp.Parameter.Value
+	default:
+		return Secret{}, errors.New("aws: either a secret_id or a parameter needs to be given")
+	}
+	if err != nil {
+		return Secret{}, err
+	}
+
+	var s Secret
+	err = json.Unmarshal([]byte(value), &s)
+	if err != nil {
+		return Secret{}, fmt.Errorf("aws: the secret isn't in the expected JSON form: %w", err)
+	}
+	return s, nil
+}
+
+// awsRequest calls an AWS JSON API action, decoding the JSON response into out.  The endpoint defaults to the AWS one
+// for the service in the region
+func awsRequest(ctx context.Context, endpoint, region, service, target string, body, out any) error {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return errors.New("aws: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY need to be set")
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/",
+		bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signV4(req, payload, region, service, accessKey, secretKey, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("aws: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("aws: %v returned status %v", target, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// signV4 signs a request with Signature Version 4, setting its X-Amz-Date and Authorization headers.  The host and all
+// of the request's other headers are signed
+func signV4(req *http.Request, payload []byte, region, service, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	var params []string
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			params = append(params, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(params)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	// See https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+	canonicalRequest := req.Method + "\n" + path + "\n" + strings.Join(params, "&") + "\n" + canonicalHeaders.String() +
+		"\n" + signedHeaders + "\n" + sha256Hex(payload)
+	scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+secretKey), now.Format("20060102"))
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// awsEscape URI encodes a value as required for AWS signatures, leaving only the unreserved characters as-is
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,135 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+func TestSignV4(t *testing.T) {
+	// The IAM ListUsers example request from the AWS docs, at
+	// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Version=2010-05-08&Action=ListUsers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, nil, "us-east-1", "iam", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("signV4() gave Authorization %q, expected %q", got, expected)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("signV4() gave X-Amz-Date %q, expected %q", got, "20150830T123600Z")
+	}
+}
+
+// fakeAWS returns a test server answering the Secrets Manager and SSM actions like AWS does, after checking their
+// signatures.  The session token of the last request is saved in token
+func fakeAWS(t *testing.T, token *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		*token = r.Header.Get("X-Amz-Security-Token")
+
+		// Sign the request again with the same date and credentials, which should give the same signature
+		at, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if err != nil {
+			t.Errorf("request has X-Amz-Date %q", r.Header.Get("X-Amz-Date"))
+		}
+		check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+		for _, name := range []string{"Content-Type", "X-Amz-Target", "X-Amz-Security-Token"} {
+			if v := r.Header.Get(name); v != "" {
+				check.Header.Set(name, v)
+			}
+		}
+		service := map[string]string{"secretsmanager.GetSecretValue": "secretsmanager",
+			"AmazonSSM.GetParameter": "ssm"}[r.Header.Get("X-Amz-Target")]
+		signV4(check, payload, "eu-west-1", service, "AKID", "secret", at)
+		if got, expected := r.Header.Get("Authorization"), check.Header.Get("Authorization"); got != expected {
+			http.Error(w, `{"__type": "InvalidSignatureException"}`, http.StatusBadRequest)
+			return
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(payload, &body); err != nil {
+			http.Error(w, `{"__type": "SerializationException"}`, http.StatusBadRequest)
+			return
+		}
+		const value = `{"username": "db4s", "password": "p\"w", "host": "db.example.org", "port": %v,
+			"dbname": "stats"}`
+		var resp any
+		switch {
+		case service == "secretsmanager" && body["SecretId"] == "db4s_stats":
+			resp = map[string]any{"SecretString": fmt.Sprintf(value, 5432)}
+		case service == "ssm" && body["Name"] == "/db4s/stats/pg" && body["WithDecryption"] == true:
+			resp = map[string]any{"Parameter": map[string]any{"Value": fmt.Sprintf(value, `"5433"`)}}
+		case service == "ssm" && body["Name"] == "/db4s/stats/plain":
+			resp = map[string]any{"Parameter": map[string]any{"Value": "not JSON"}}
+		default:
+			http.Error(w, `{"__type": "ResourceNotFoundException"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestAWSSecret(t *testing.T) {
+	var token string
+	srv := fakeAWS(t, &token)
+	defer srv.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	tests := []struct {
+		name string
+		conf config.AWSInfo
+		port int
+		ok   bool
+	}{
+		{"Secrets Manager", config.AWSInfo{SecretID: "db4s_stats"}, 5432, true},
+		{"SSM Parameter Store", config.AWSInfo{Parameter: "/db4s/stats/pg"}, 5433, true},
+		{"Secrets Manager used over SSM", config.AWSInfo{SecretID: "db4s_stats", Parameter: "/db4s/stats/plain"}, 5432,
+			true},
+		{"missing secret", config.AWSInfo{SecretID: "missing"}, 0, false},
+		{"not JSON", config.AWSInfo{Parameter: "/db4s/stats/plain"}, 0, false},
+		{"neither given", config.AWSInfo{}, 0, false},
+		{"wrong region", config.AWSInfo{Region: "us-east-1", SecretID: "db4s_stats"}, 0, false},
+	}
+	for _, test := range tests {
+		test.conf.Endpoint = srv.URL
+		s, err := AWSSecret(context.Background(), test.conf)
+		if (err == nil) != test.ok {
+			t.Errorf("%v: AWSSecret() returned error %v", test.name, err)
+			continue
+		}
+		expected := Secret{}
+		if test.ok {
+			expected = Secret{Username: "db4s", Password: `p"w`, Host: "db.example.org", DBName: "stats"}
+		}
+		port := s.PortNumber()
+		s.Port = nil
+		if s != expected || port != test.port {
+			t.Errorf("%v: AWSSecret() = %+v with port %d, expected %+v with port %d", test.name, s, port, expected,
+				test.port)
+		}
+	}
+
+	// A session token is sent and signed when there is one
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	_, err := AWSSecret(context.Background(), config.AWSInfo{SecretID: "db4s_stats", Endpoint: srv.URL})
+	if err != nil || token != "session" {
+		t.Errorf("AWSSecret() returned error %v with session token %q, expected %q", err, token, "session")
+	}
+}
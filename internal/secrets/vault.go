@@ -0,0 +1,114 @@
+// Package secrets retrieves the PostgreSQL credentials from secret stores, so they don't have to live in the config
+// file
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+// VaultCredentials retrieves the PostgreSQL username and password from HashiCorp Vault.  The secret can be in a KV
+// (version 1 or 2) secrets engine, or be dynamic credentials from a database secrets engine, as they all return the
+// values in the same form
+func VaultCredentials(ctx context.Context, conf config.VaultInfo) (username, password string, err error) {
+	token := conf.Token
+	if conf.RoleID != "" {
+		token, err = vaultAppRoleLogin(ctx, conf)
+		if err != nil {
+			return
+		}
+	}
+	if token == "" {
+		return "", "", errors.New("vault: either a token or an AppRole role_id needs to be given")
+	}
+
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	err = vaultRequest(ctx, conf, http.MethodGet, conf.Path, token, nil, &resp)
+	if err != nil {
+		return
+	}
+
+	// KV version 2 nests the values in a second "data" level
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		data = nested
+	}
+	usernameKey, passwordKey := conf.UsernameKey, conf.PasswordKey
+	if usernameKey == "" {
+		usernameKey = "username"
+	}
+	if passwordKey == "" {
+		passwordKey = "password"
+	}
+	username, _ = data[usernameKey].(string)
+	password, _ = data[passwordKey].(string)
+	if username == "" || password == "" {
+		return "", "", fmt.Errorf("vault: secret at '%v' doesn't have both '%v' and '%v' values", conf.Path,
+			usernameKey, passwordKey)
+	}
+	return
+}
+
+// vaultAppRoleLogin logs in using AppRole authentication, returning the client token
+func vaultAppRoleLogin(ctx context.Context, conf config.VaultInfo) (string, error) {
+	mount := conf.AppRoleMount
+	if mount == "" {
+		mount = "approle"
+	}
+	body := map[string]string{"role_id": conf.RoleID, "secret_id": conf.SecretID}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	err := vaultRequest(ctx, conf, http.MethodPost, "auth/"+mount+"/login", "", body, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", errors.New("vault: AppRole login didn't return a token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+// vaultRequest calls the Vault HTTP API, decoding the JSON response into out
+func vaultRequest(ctx context.Context, conf config.VaultInfo, method, path, token string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	url := strings.TrimSuffix(conf.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", conf.Namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault: %v %v returned status %v", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+// fakeVault returns a test server answering like Vault does, with AppRole logins giving the "approle-token" token.
+// The namespace headers of the requests are added to namespaces
+func fakeVault(namespaces *[]string) *httptest.Server {
+	secrets := map[string]string{
+		"/v1/secret/data/db4s_stats":     `{"data": {"data": {"username": "kv2user", "password": "kv2pass"}}}`,
+		"/v1/kv/db4s_stats":              `{"data": {"username": "kv1user", "password": "kv1pass"}}`,
+		"/v1/database/creds/db4s_stats":  `{"data": {"user": "dynuser", "pass": "dynpass"}, "lease_duration": 3600}`,
+		"/v1/secret/data/no_password":    `{"data": {"data": {"username": "kv2user"}}}`,
+		"/v1/secret/data/wrong_password": `{"data": {"data": {"username": "kv2user", "password": 42}}}`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*namespaces = append(*namespaces, r.Header.Get("X-Vault-Namespace"))
+		login := r.URL.Path == "/v1/auth/approle/login" || r.URL.Path == "/v1/auth/ci/login"
+		if r.Method == http.MethodPost && login {
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["role_id"] != "role" ||
+				body["secret_id"] != "secret" {
+				http.Error(w, `{"errors": ["invalid role or secret ID"]}`, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"auth": {"client_token": "approle-token"}}`))
+			return
+		}
+		if token := r.Header.Get("X-Vault-Token"); r.Method != http.MethodGet ||
+			(token != "token" && token != "approle-token") {
+			http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		secret, ok := secrets[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(secret))
+	}))
+}
+
+func TestVaultCredentials(t *testing.T) {
+	var namespaces []string
+	srv := fakeVault(&namespaces)
+	defer srv.Close()
+
+	tests := []struct {
+		name               string
+		conf               config.VaultInfo
+		username, password string
+		ok                 bool
+	}{
+		{"KV version 2", config.VaultInfo{Path: "secret/data/db4s_stats", Token: "token"}, "kv2user", "kv2pass", true},
+		{"KV version 1", config.VaultInfo{Path: "/kv/db4s_stats", Token: "token"}, "kv1user", "kv1pass", true},
+		{"dynamic credentials", config.VaultInfo{Path: "database/creds/db4s_stats", Token: "token", UsernameKey: "user",
+			PasswordKey: "pass"}, "dynuser", "dynpass", true},
+		{"AppRole", config.VaultInfo{Path: "secret/data/db4s_stats", RoleID: "role", SecretID: "secret"}, "kv2user",
+			"kv2pass", true},
+		{"AppRole on another mount", config.VaultInfo{Path: "secret/data/db4s_stats", RoleID: "role",
+			SecretID: "secret", AppRoleMount: "ci"}, "kv2user", "kv2pass", true},
+		{"AppRole used over the token", config.VaultInfo{Path: "secret/data/db4s_stats", Token: "bad", RoleID: "role",
+			SecretID: "secret"}, "kv2user", "kv2pass", true},
+		{"wrong AppRole secret ID", config.VaultInfo{Path: "secret/data/db4s_stats", RoleID: "role", SecretID: "bad"},
+			"", "", false},
+		{"wrong token", config.VaultInfo{Path: "secret/data/db4s_stats", Token: "bad"}, "", "", false},
+		{"no token", config.VaultInfo{Path: "secret/data/db4s_stats"}, "", "", false},
+		{"missing secret", config.VaultInfo{Path: "secret/data/missing", Token: "token"}, "", "", false},
+		{"missing password", config.VaultInfo{Path: "secret/data/no_password", Token: "token"}, "", "", false},
+		{"password isn't a string", config.VaultInfo{Path: "secret/data/wrong_password", Token: "token"}, "", "",
+			false},
+	}
+	for _, test := range tests {
+		test.conf.Address = srv.URL + "/"
+		username, password, err := VaultCredentials(context.Background(), test.conf)
+		if (err == nil) != test.ok || username != test.username || password != test.password {
+			t.Errorf("%v: VaultCredentials() = %q, %q, %v, expected %q, %q", test.name, username, password, err,
+				test.username, test.password)
+		}
+	}
+
+	// The namespace is sent with every request, including the AppRole login
+	namespaces = nil
+	conf := config.VaultInfo{Address: srv.URL, Namespace: "db4s", Path: "secret/data/db4s_stats", RoleID: "role",
+		SecretID: "secret"}
+	_, _, err := VaultCredentials(context.Background(), conf)
+	if err != nil || !slices.Equal(namespaces, []string{"db4s", "db4s"}) {
+		t.Errorf("VaultCredentials() = %v with namespaces %q, expected the login and read both in %q", err, namespaces,
+			"db4s")
+	}
+}
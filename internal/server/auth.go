@@ -0,0 +1,122 @@
+package server
+
+// Each endpoint needs a read scope, so the headline numbers can be public while the detailed breakdowns stay private:
+//
+//   badges      the /badge endpoints
+//   totals      the /grafana endpoints, for the totals of the metric families
+//   breakdowns  the /grafana queries for single releases or downloads, and the /dashboard page
+//
+// Requests authenticate with a token in an "Authorization: Bearer <token>" header, or with HTTP basic authentication.
+// When no credentials are configured at all, every endpoint is public
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// The read scopes of the endpoints
+const (
+	ScopeBadges     = "badges"
+	ScopeBreakdowns = "breakdowns"
+	ScopeTotals     = "totals"
+)
+
+// ScopeAll grants all of the scopes
+const ScopeAll = "*"
+
+// Credential is a token, or a username and password, along with the scopes it grants
+type Credential struct {
+	Name     string
+	Token    string
+	Username string
+	Password string
+	Scopes   []string
+}
+
+// CheckScopes returns an error if any of the scopes isn't a known one
+func CheckScopes(scopes []string) error {
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeAll, ScopeBadges, ScopeBreakdowns, ScopeTotals:
+		default:
+			return fmt.Errorf("unknown scope '%v', it should be badges, totals, breakdowns or *", scope)
+		}
+	}
+	return nil
+}
+
+// require wraps a handler so it's only run for requests allowed the given scope
+func (s *Server) require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.allowed(w, r, scope) {
+			h(w, r)
+		}
+	}
+}
+
+// allowed checks whether a request is allowed the given scope.  If it isn't, the error response is sent
+func (s *Server) allowed(w http.ResponseWriter, r *http.Request, scope string) bool {
+	if s.public(scope) {
+		return true
+	}
+	c, given, ok := s.credential(r)
+	switch {
+	case !given || !ok:
+		// Let browsers prompt for a username and password, when there are any
+		challenge := `Bearer realm="db4s stats"`
+		if slices.ContainsFunc(s.Credentials, func(c Credential) bool { return c.Username != "" }) {
+			challenge = `Basic realm="db4s stats", charset="UTF-8"`
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		if given && s.Verbosity >= verbosity.Verbose {
+			log.Printf("Invalid credentials for %v %v from %v\n", r.Method, r.URL.Path, r.RemoteAddr)
+		}
+		return false
+	case !slices.Contains(c.Scopes, scope) && !slices.Contains(c.Scopes, ScopeAll):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		if s.Verbosity >= verbosity.Verbose {
+			log.Printf("Credentials '%v' don't have the %v scope for %v %v\n", c.Name, scope, r.Method, r.URL.Path)
+		}
+		return false
+	}
+	return true
+}
+
+// public returns whether an endpoint needing the given scope is open to everyone
+func (s *Server) public(scope string) bool {
+	return len(s.Credentials) == 0 || slices.Contains(s.Public, scope)
+}
+
+// credential returns the credentials a request authenticated with, if any.  given is whether the request had an
+// Authorization header at all
+func (s *Server) credential(r *http.Request) (c Credential, given, ok bool) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return
+	}
+	given = true
+	username, password, basic := r.BasicAuth()
+	token, bearer := strings.CutPrefix(auth, "Bearer ")
+	for _, c = range s.Credentials {
+		// The comparisons take the same time whether or not they match, so they don't leak the secrets
+		switch {
+		case bearer && c.Token != "" && equal(token, c.Token):
+			return c, true, true
+		case basic && c.Username != "" && equal(username, c.Username) && equal(password, c.Password):
+			return c, true, true
+		}
+	}
+	return Credential{}, true, false
+}
+
+// equal compares two strings in constant time
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
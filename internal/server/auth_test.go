@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// do sends a request to the server's handler, returning the response
+func do(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// search returns a request for the /grafana/search endpoint, which needs the totals scope
+func search() *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/grafana/search", nil)
+}
+
+func testCredentials() []Credential {
+	return []Credential{
+		{Name: "grafana", Token: "t0ken", Scopes: []string{ScopeTotals}},
+		{Name: "badges", Token: "b4dges", Scopes: []string{ScopeBadges}},
+		{Name: "admin", Username: "admin", Password: "s3cret", Scopes: []string{ScopeAll}},
+	}
+}
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		username      string
+		password      string
+		want          int
+	}{
+		{"no credentials", "", "", "", http.StatusUnauthorized},
+		{"token", "Bearer t0ken", "", "", http.StatusOK},
+		{"wrong token", "Bearer t0ke", "", "", http.StatusUnauthorized},
+		{"token without the scope", "Bearer b4dges", "", "", http.StatusForbidden},
+		{"token given as basic", "", "grafana", "t0ken", http.StatusUnauthorized},
+		{"basic", "", "admin", "s3cret", http.StatusOK},
+		{"wrong password", "", "admin", "s3cre", http.StatusUnauthorized},
+		{"password given as token", "Bearer s3cret", "", "", http.StatusUnauthorized},
+		{"unknown scheme", "Digest t0ken", "", "", http.StatusUnauthorized},
+	}
+	s := &Server{Credentials: testCredentials()}
+	for _, test := range tests {
+		r := search()
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		if test.username != "" {
+			r.SetBasicAuth(test.username, test.password)
+		}
+		w := do(s, r)
+		if w.Code != test.want {
+			t.Errorf("%v: status %d, expected %d", test.name, w.Code, test.want)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); (w.Code == http.StatusUnauthorized) != (challenge != "") {
+			t.Errorf("%v: status %d with WWW-Authenticate %q", test.name, w.Code, challenge)
+		}
+	}
+}
+
+func TestAuthChallenge(t *testing.T) {
+	// Browsers are only asked for a username and password when there are some
+	tests := []struct {
+		credentials []Credential
+		want        string
+	}{
+		{testCredentials()[:2], "Bearer "},
+		{testCredentials(), "Basic "},
+	}
+	for _, test := range tests {
+		w := do(&Server{Credentials: test.credentials}, search())
+		if challenge := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, test.want) {
+			t.Errorf("challenge %q, expected a %v one", challenge, strings.TrimSpace(test.want))
+		}
+	}
+}
+
+func TestAuthPublic(t *testing.T) {
+	// Without any credentials everything is open, otherwise just the public scopes
+	if w := do(&Server{}, search()); w.Code != http.StatusOK {
+		t.Errorf("status %d without any credentials, expected %d", w.Code, http.StatusOK)
+	}
+	s := &Server{Credentials: testCredentials(), Public: []string{ScopeTotals}}
+	if w := do(s, search()); w.Code != http.StatusOK {
+		t.Errorf("status %d for a public scope, expected %d", w.Code, http.StatusOK)
+	}
+	s.Public = []string{ScopeBadges}
+	if w := do(s, search()); w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d for a private scope, expected %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// The API description is always open
+	r := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	if w := do(s, r); w.Code != http.StatusOK {
+		t.Errorf("status %d for the API description, expected %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	if err := CheckScopes([]string{ScopeAll, ScopeBadges, ScopeBreakdowns, ScopeTotals}); err != nil {
+		t.Error(err)
+	}
+	if err := CheckScopes([]string{ScopeTotals, "total"}); err == nil {
+		t.Error("no error for an unknown scope")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+// The badge endpoints return shields.io "endpoint badge" JSON (https://shields.io/badges/endpoint-badge) for the
+// totals of the most recent completed time period of a metric family.  eg for the downloads last month:
+//
+//   https://img.shields.io/endpoint?url=https://<server>/badge/downloads-monthly
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/client"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+)
+
+// badgeHandler returns the badge for the most recent completed time period of a metric family
+func (s *Server) badgeHandler(w http.ResponseWriter, r *http.Request) {
+	fam, ok := stats.FamilyByName(r.PathValue("metric"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The current time period is still incomplete, so use the one before it
+	date := fam.Granularity.Previous(fam.Granularity.Start(time.Now()))
+	counts, err := s.DB.StoredStats(r.Context(), fam.Table, fam.TotalColumn, fam.ValueColumn, date)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, client.Badge{
+		SchemaVersion: 1,
+		Label:         badgeLabel(fam),
+		Message:       compactNumber(counts[fam.TotalID]),
+		Color:         "blue",
+	})
+}
+
+// badgeLabel returns the badge label for a metric family.  eg "downloads last month"
+func badgeLabel(fam stats.Family) string {
+	what := "active users"
+	if fam.TotalColumn == "db4s_download" {
+		what = "downloads"
+	}
+	switch fam.Granularity {
+	case stats.Weekly:
+		return what + " last week"
+	case stats.Monthly:
+		return what + " last month"
+	default:
+		return what + " yesterday"
+	}
+}
+
+// compactNumber formats a number for display on a badge.  eg 1234 as "1.2k", and 5678901 as "5.7M"
+func compactNumber(n int64) string {
+	switch {
+	case n >= 999950: // Anything which would round up to "1000.0k"
+
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	default:
+		return fmt.Sprint(n)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/client"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+)
+
+func TestBadge(t *testing.T) {
+	db, _ := testStore(t)
+	month := stats.Monthly.Previous(stats.Monthly.Start(time.Now().UTC()))
+	if err := db.SaveMonthlyDownloadsStats(context.Background(), month, 1234, map[int]int32{2: 1234}); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{DB: db, CacheMaxAge: 10 * time.Minute}
+
+	// The metrics without stats for their last time period show zero
+	tests := []struct {
+		metric string
+		want   client.Badge
+	}{
+		{"users-weekly", client.Badge{SchemaVersion: 1, Label: "active users last week", Message: "120",
+			Color: "blue"}},
+		{"downloads-monthly", client.Badge{SchemaVersion: 1, Label: "downloads last month", Message: "1.2k",
+			Color: "blue"}},
+		{"users-daily", client.Badge{SchemaVersion: 1, Label: "active users yesterday", Message: "0", Color: "blue"}},
+	}
+	for _, test := range tests {
+		w := do(s, httptest.NewRequest(http.MethodGet, "/badge/"+test.metric, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v: status %d, expected %d", test.metric, w.Code, http.StatusOK)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%v: Content-Type is %q, expected %q", test.metric, got, "application/json")
+		}
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=600" || w.Header().Get("ETag") == "" {
+			t.Errorf("%v: Cache-Control is %q with ETag %q, expected a public max-age of 600", test.metric, got,
+				w.Header().Get("ETag"))
+		}
+		var got client.Badge
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%v: %v", test.metric, err)
+		}
+		if got != test.want {
+			t.Errorf("%v: badge is %+v, expected %+v", test.metric, got, test.want)
+		}
+	}
+
+	if w := do(s, httptest.NewRequest(http.MethodGet, "/badge/users-hourly", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("status %d for an unknown metric, expected %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCompactNumber(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1.0k"},
+		{1234, "1.2k"},
+		{999949, "999.9k"},
+		{999950, "1.0M"},
+		{5678901, "5.7M"},
+	}
+	for _, test := range tests {
+		if got := compactNumber(test.n); got != test.want {
+			t.Errorf("compactNumber(%d) = %q, expected %q", test.n, got, test.want)
+		}
+	}
+}
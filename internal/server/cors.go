@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// corsMaxAge is how long browsers can cache the answer to a CORS preflight request, in seconds
+const corsMaxAge = 3600
+
+// cors wraps a handler with the CORS headers letting the web pages from the allowed origins fetch from the endpoints
+// (https://fetch.spec.whatwg.org/#http-cors-protocol), and answers the preflight requests browsers send first for
+// the POST and authenticated ones
+func (s *Server) cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(s.CORSOrigins) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// Caches need to keep the responses for each origin apart, as the headers differ
+		w.Header().Add("Vary", "Origin")
+		allowed := origin != "" && (slices.Contains(s.CORSOrigins, "*") || slices.Contains(s.CORSOrigins, origin))
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// preflight returns a CORS preflight request for the /grafana/search endpoint, from the given origin
+func preflight(origin string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/grafana/search", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "authorization")
+	return r
+}
+
+func TestCORSPreflight(t *testing.T) {
+	tests := []struct {
+		origins []string
+		origin  string
+		allowed bool
+	}{
+		{[]string{"https://stats.example.org"}, "https://stats.example.org", true},
+		{[]string{"https://stats.example.org"}, "https://evil.example.com", false},
+		{[]string{"https://stats.example.org"}, "http://stats.example.org", false},
+		{[]string{"*"}, "https://anywhere.example.com", true},
+	}
+	for _, test := range tests {
+		// Preflight requests don't carry credentials, so they're answered before the authentication
+		s := &Server{CORSOrigins: test.origins, Credentials: testCredentials()}
+		w := do(s, preflight(test.origin))
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%v: preflight status %d, expected %d", test.origin, w.Code, http.StatusNoContent)
+		}
+		h := w.Header()
+		want := ""
+		if test.allowed {
+			want = test.origin
+		}
+		if got := h.Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%v: Access-Control-Allow-Origin is %q, expected %q", test.origin, got, want)
+		}
+		if got := h.Get("Access-Control-Allow-Headers") != ""; got != test.allowed {
+			t.Errorf("%v: Access-Control-Allow-Headers given is %v, expected %v", test.origin, got, test.allowed)
+		}
+		if test.allowed && h.Get("Access-Control-Max-Age") != "3600" {
+			t.Errorf("%v: Access-Control-Max-Age is %q", test.origin, h.Get("Access-Control-Max-Age"))
+		}
+		if h.Get("Vary") != "Origin" {
+			t.Errorf("%v: Vary is %q, expected Origin", test.origin, h.Get("Vary"))
+		}
+	}
+}
+
+func TestCORSRequest(t *testing.T) {
+	s := &Server{CORSOrigins: []string{"https://stats.example.org"}}
+	r := search()
+	r.Header.Set("Origin", "https://stats.example.org")
+	w := do(s, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://stats.example.org" {
+		t.Errorf("Access-Control-Allow-Origin is %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "ETag" {
+		t.Errorf("Access-Control-Expose-Headers is %q, expected ETag", got)
+	}
+
+	// Without any allowed origins, there are no CORS headers, and OPTIONS requests aren't answered
+	w = do(&Server{}, preflight("https://stats.example.org"))
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "" {
+		t.Errorf("CORS headers given without any allowed origins: %v", w.Header())
+	}
+	if w.Code == http.StatusNoContent {
+		t.Error("preflight answered without any allowed origins")
+	}
+}
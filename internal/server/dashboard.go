@@ -0,0 +1,151 @@
+package server
+
+// The dashboard at /dashboard/ is a single page of the users and downloads over time, plus the version and download
+// mix of the most recent completed time period.  It's rendered on the server, with the charts as inline SVG, so there's
+// no JavaScript to keep up to date.  ?period=daily, weekly (the default) or monthly picks the time periods shown
+//
+// There's no geographic breakdown, as nothing records where the requests come from.  download_log only has the client
+// IP addresses, and the stats tables are kept per release or download.  Adding one would need a GeoIP database (eg
+// MaxMind GeoLite2, which has its own licence and update cycle) to look the addresses up while generating the stats,
+// plus a per country stats table for the dashboard to read
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/chart"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/export"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+)
+
+//go:embed templates/dashboard.html
+var templates embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(templates, "templates/dashboard.html"))
+
+// dashboardTop is how many releases or downloads the breakdowns list, with the rest folded into "Other"
+const dashboardTop = 10
+
+// dashboardPeriods is how many time periods the dashboard charts cover, for each period choice
+var dashboardPeriods = map[stats.Granularity]int{
+	stats.Daily:   90,
+	stats.Weekly:  52,
+	stats.Monthly: 36,
+}
+
+// dashboardPage is the data the dashboard template is rendered with
+type dashboardPage struct {
+	Period    string
+	Periods   []string
+	Generated time.Time
+	Panels    []dashboardPanel
+}
+
+// dashboardPanel is the part of the dashboard for the users or downloads
+type dashboardPanel struct {
+	Title     string
+	Chart     template.HTML
+	Latest    string // The label of the most recent completed time period, which is broken down
+	Total     int64
+	Breakdown []dashboardRow
+}
+
+// dashboardRow is a release or download in a breakdown, with its share of the total
+type dashboardRow struct {
+	Name    string
+	Count   int64
+	Percent float64
+}
+
+// dashboard renders the dashboard page
+func (s *Server) dashboard(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = stats.Weekly.String()
+	}
+	page := dashboardPage{Period: period, Generated: time.Now().UTC().Truncate(time.Second)}
+	for _, g := range []stats.Granularity{stats.Daily, stats.Weekly, stats.Monthly} {
+		page.Periods = append(page.Periods, g.String())
+	}
+
+	for _, kind := range []string{stats.KindUsers, stats.KindDownloads} {
+		fam, ok := stats.FamilyByName(kind + "-" + period)
+		if !ok {
+			http.Error(w, "Unknown period, it should be daily, weekly or monthly", http.StatusBadRequest)
+			return
+		}
+		panel, err := s.dashboardPanel(r, fam, page.Generated)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		page.Panels = append(page.Panels, panel)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		serverError(w, r, err)
+	}
+}
+
+// dashboardPanel gathers the chart and breakdown of a metric family.  The charts end with the last completed time
+// period, as the current one would look like a sudden drop
+func (s *Server) dashboardPanel(r *http.Request, fam stats.Family, now time.Time) (dashboardPanel, error) {
+	panel := dashboardPanel{Title: strings.ToUpper(fam.Kind[:1]) + fam.Kind[1:]}
+	endDate := fam.Granularity.Start(now)
+	startDate := endDate
+	for i := 0; i < dashboardPeriods[fam.Granularity]; i++ {
+		startDate = fam.Granularity.Previous(startDate)
+	}
+	rows, err := export.Collect(r.Context(), s.DB, fam, startDate, endDate)
+	if err != nil {
+		return panel, err
+	}
+
+	c := chart.Chart{Title: panel.Title + " (" + fam.Name + ")"}
+	for _, row := range rows {
+		if row.ID == fam.TotalID {
+			c.Points = append(c.Points, chart.Point{Date: row.Date, Value: row.Count})
+		}
+	}
+	var svg strings.Builder
+	if err = c.SVG(&svg); err != nil {
+		return panel, err
+	}
+
+	// The SVG is built from numbers, dates and the escaped title, so it's safe to include as-is
+	panel.Chart = template.HTML(svg.String())
+	if len(c.Points) == 0 {
+		return panel, nil
+	}
+
+	// Break down the most recent completed time period
+	latest := c.Points[len(c.Points)-1].Date
+	panel.Latest = fam.Granularity.Label(latest)
+	var breakdown []export.Row
+	for _, row := range rows {
+		if !row.Date.Equal(latest) {
+			continue
+		}
+		if row.ID == fam.TotalID {
+			panel.Total = row.Count
+			breakdown = append(breakdown, row)
+		} else if row.Count > 0 {
+			breakdown = append(breakdown, row)
+		}
+	}
+	for _, row := range export.TopN(breakdown, fam, dashboardTop) {
+		if row.ID == fam.TotalID {
+			continue
+		}
+		d := dashboardRow{Name: row.Name, Count: row.Count}
+		if panel.Total > 0 {
+			d.Percent = float64(row.Count) * 100 / float64(panel.Total)
+		}
+		panel.Breakdown = append(panel.Breakdown, d)
+	}
+	return panel, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+)
+
+func TestDashboard(t *testing.T) {
+	db, weeks := testStore(t)
+	s := &Server{DB: db, Credentials: testCredentials()}
+	admin := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:s3cret"))
+	dashboard := func(query, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/dashboard/"+query, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return do(s, r)
+	}
+
+	// The page shows the charts, and breaks down the most recent completed week
+	w := dashboard("", admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type is %q, expected %q", got, "text/html; charset=utf-8")
+	}
+	page := w.Body.String()
+	latest := "<caption>" + stats.Weekly.Label(weeks[2]) + "</caption>"
+	for _, want := range []string{"<svg", "Users (users-weekly)", latest, `<td class="count">120</td>`,
+		"<td>3.13.0</td>", "66.7%", "<td>3.12.2</td>", "33.3%", `<a href="?period=weekly" class="current">`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("dashboard page doesn't include %q", want)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		query         string
+		authorization string
+		want          int
+	}{
+		{"daily", "?period=daily", admin, http.StatusOK},
+		{"monthly", "?period=monthly", admin, http.StatusOK},
+		{"unknown period", "?period=hourly", admin, http.StatusBadRequest},
+		{"no credentials", "", "", http.StatusUnauthorized},
+
+		// The breakdowns need their own scope, beyond the totals Grafana reads
+		{"totals scope", "", "Bearer t0ken", http.StatusForbidden},
+		{"badges scope", "", "Bearer b4dges", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if w := dashboard(test.query, test.authorization); w.Code != test.want {
+			t.Errorf("%v: status %d, expected %d", test.name, w.Code, test.want)
+		}
+	}
+}
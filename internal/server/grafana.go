@@ -0,0 +1,84 @@
+package server
+
+// The Grafana endpoints implement the query contract of the Grafana JSON datasource plugin
+// (https://grafana.com/grafana/plugins/simpod-json-datasource/), so the stats can be graphed in Grafana.  Add a JSON
+// datasource pointing at http://<listen address>/grafana, then query it with targets of either a metric family name
+// for its totals (eg "users-weekly"), or a metric family name plus a release or download name for just that one (eg
+// "users-weekly:3.12.2")
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/client"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/export"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+)
+
+// grafanaHealth answers the datasource connection test
+func (s *Server) grafanaHealth(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// grafanaSearch returns the available targets, as plain strings
+func (s *Server) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for _, fam := range stats.Families() {
+		names = append(names, fam.Name)
+	}
+	s.writeJSON(w, r, names)
+}
+
+// grafanaMetrics returns the available targets, as label/value pairs
+func (s *Server) grafanaMetrics(w http.ResponseWriter, r *http.Request) {
+	var metrics []client.Metric
+	for _, fam := range stats.Families() {
+		metrics = append(metrics, client.Metric{Label: fam.Name, Value: fam.Name})
+	}
+	s.writeJSON(w, r, metrics)
+}
+
+// grafanaQuery returns the time series for each requested target
+func (s *Server) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req client.QueryRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid query request", http.StatusBadRequest)
+		return
+	}
+
+	series := []client.Series{}
+	for _, t := range req.Targets {
+		if t.Hide || t.Target == "" {
+			continue
+		}
+		famName, name, _ := strings.Cut(t.Target, ":")
+		fam, ok := stats.FamilyByName(famName)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown target '%v'", t.Target), http.StatusBadRequest)
+			return
+		}
+		if name != "" && !s.allowed(w, r, ScopeBreakdowns) {
+			return
+		}
+
+		// Include the time period the range starts in, as its start date is likely before the range does
+		rows, err := export.Collect(r.Context(), s.DB, fam, fam.Granularity.Start(req.Range.From), req.Range.To)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		ts := client.Series{Target: t.Target, Datapoints: [][2]int64{}}
+		for _, row := range rows {
+			if (name == "" && row.ID == fam.TotalID) || (name != "" && row.Name == name) {
+				ts.Datapoints = append(ts.Datapoints, [2]int64{row.Count, row.Date.UnixMilli()})
+			}
+		}
+		sort.Slice(ts.Datapoints, func(i, j int) bool { return ts.Datapoints[i][1] < ts.Datapoints[j][1] })
+		series = append(series, ts)
+	}
+	s.writeJSON(w, r, series)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/client"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store/memstore"
+)
+
+// testStore returns a store holding the weekly users stats of the 3 weeks before the current one, which are returned
+// oldest first.  The totals are 100, 110 and 120, split between releases 3.12.2 and 3.13.0
+func testStore(t *testing.T) (*memstore.Store, []time.Time) {
+	db := memstore.New()
+	db.Releases["3.12.2"] = 2
+	db.Releases["3.13.0"] = 3
+	week := stats.Weekly.Start(time.Now().UTC())
+	weeks := make([]time.Time, 3)
+	for i := range weeks {
+		week = stats.Weekly.Previous(week)
+		weeks[len(weeks)-1-i] = week
+	}
+	for i, week := range weeks {
+		counts := map[string]int{"sqlitebrowser 3.12.2": 60 - 10*i, "sqlitebrowser 3.13.0": 40 + 20*i}
+		if err := db.SaveWeeklyUsersStats(context.Background(), week, 100+10*i, counts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, weeks
+}
+
+// query returns a request for the /grafana/query endpoint
+func query(from, to time.Time, targets ...client.Target) *http.Request {
+	body, _ := json.Marshal(client.QueryRequest{Range: client.Range{From: from, To: to}, Targets: targets})
+	return httptest.NewRequest(http.MethodPost, "/grafana/query", strings.NewReader(string(body)))
+}
+
+func TestGrafanaSearch(t *testing.T) {
+	db, _ := testStore(t)
+	s := &Server{DB: db}
+	var names []string
+	var metrics []client.Metric
+	for path, out := range map[string]any{"/grafana/search": &names, "/grafana/metrics": &metrics} {
+		w := do(s, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: status %d, expected %d", path, w.Code, http.StatusOK)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+	}
+
+	// Every metric family is a target, given as both a name and a label/value pair
+	families := stats.Families()
+	if len(names) != len(families) || len(metrics) != len(families) {
+		t.Fatalf("search returned %d targets and metrics returned %d, expected %d", len(names), len(metrics),
+			len(families))
+	}
+	for i, fam := range families {
+		if names[i] != fam.Name || metrics[i] != (client.Metric{Label: fam.Name, Value: fam.Name}) {
+			t.Errorf("target %d is %q and %+v, expected %q", i, names[i], metrics[i], fam.Name)
+		}
+	}
+
+	// The health check used by Grafana's connection test
+	if w := do(s, httptest.NewRequest(http.MethodGet, "/grafana/", nil)); w.Code != http.StatusOK {
+		t.Errorf("health check status %d, expected %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestGrafanaQuery(t *testing.T) {
+	db, weeks := testStore(t)
+	s := &Server{DB: db}
+	ms := func(week int) int64 { return weeks[week].UnixMilli() }
+	tests := []struct {
+		name     string
+		from, to time.Time
+		targets  []client.Target
+		want     []client.Series
+	}{
+		{"totals", weeks[0], weeks[2].AddDate(0, 0, 7), []client.Target{{Target: "users-weekly"}},
+			[]client.Series{{Target: "users-weekly", Datapoints: [][2]int64{{100, ms(0)}, {110, ms(1)},
+				{120, ms(2)}}}}},
+		{"release", weeks[0], weeks[2].AddDate(0, 0, 7), []client.Target{{Target: "users-weekly:3.13.0"}},
+			[]client.Series{{Target: "users-weekly:3.13.0", Datapoints: [][2]int64{{40, ms(0)}, {60, ms(1)},
+				{80, ms(2)}}}}},
+
+		// A range starting part way through a week includes that week, and the end of the range isn't included
+		{"part way through a week", weeks[0].AddDate(0, 0, 3), weeks[2], []client.Target{{Target: "users-weekly"}},
+			[]client.Series{{Target: "users-weekly", Datapoints: [][2]int64{{100, ms(0)}, {110, ms(1)}}}}},
+		{"before the stats", weeks[0].AddDate(0, -6, 0), weeks[0], []client.Target{{Target: "users-weekly"}},
+			[]client.Series{{Target: "users-weekly", Datapoints: [][2]int64{}}}},
+
+		{"hidden and empty targets", weeks[0], weeks[2], []client.Target{{Target: "users-weekly", Hide: true},
+			{Target: ""}, {Target: "users-weekly:3.12.2"}},
+			[]client.Series{{Target: "users-weekly:3.12.2", Datapoints: [][2]int64{{60, ms(0)}, {50, ms(1)}}}}},
+		{"unknown release", weeks[0], weeks[2], []client.Target{{Target: "users-weekly:9.9.9"}},
+			[]client.Series{{Target: "users-weekly:9.9.9", Datapoints: [][2]int64{}}}},
+		{"no targets", weeks[0], weeks[2], nil, []client.Series{}},
+	}
+	for _, test := range tests {
+		w := do(s, query(test.from, test.to, test.targets...))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v: status %d, expected %d", test.name, w.Code, http.StatusOK)
+			continue
+		}
+		var got []client.Series
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: query returned %v, expected %v", test.name, got, test.want)
+		}
+	}
+
+	// Unknown metric families and garbled requests are rejected
+	w := do(s, query(weeks[0], weeks[2], client.Target{Target: "users-hourly"}))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "users-hourly") {
+		t.Errorf("status %d (%q) for an unknown target, expected %d", w.Code, w.Body.String(), http.StatusBadRequest)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/grafana/query", strings.NewReader("{"))
+	if w = do(s, r); w.Code != http.StatusBadRequest {
+		t.Errorf("status %d for an invalid request, expected %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Targets for single releases also need the breakdowns scope
+	s.Credentials = testCredentials()
+	scoped := map[string]int{"users-weekly": http.StatusOK, "users-weekly:3.12.2": http.StatusForbidden}
+	for target, want := range scoped {
+		r := query(weeks[0], weeks[2], client.Target{Target: target})
+		r.Header.Set("Authorization", "Bearer t0ken")
+		if w := do(s, r); w.Code != want {
+			t.Errorf("%v: status %d with the totals scope, expected %d", target, w.Code, want)
+		}
+	}
+}
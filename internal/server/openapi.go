@@ -0,0 +1,212 @@
+package server
+
+// The OpenAPI document (https://spec.openapis.org/oas/v3.1.0) describing the endpoints is generated from the
+// operations table and the request and response types of the client package, so it can't drift from what's served
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// openAPIVersion is the version of the API given in the OpenAPI document
+const openAPIVersion = "1.0.0"
+
+// pathParam matches the wildcards in the route patterns
+var pathParam = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// openAPI sends the OpenAPI document describing the endpoints
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, s.openAPIDocument())
+}
+
+// openAPIDocument returns the OpenAPI document describing the endpoints
+func (s *Server) openAPIDocument() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+	for _, op := range s.operations() {
+		path := strings.TrimSuffix(op.Path, "{$}")
+		o := map[string]any{
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+			"responses":   s.responses(op, schemas),
+		}
+
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, p := range op.Params {
+			schema := map[string]any{"type": "string"}
+			if len(p.Enum) > 0 {
+				schema["enum"] = p.Enum
+			}
+			params = append(params, map[string]any{
+				"name": p.Name, "in": "query", "description": p.Description, "schema": schema,
+			})
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+		if op.Request != nil {
+			o["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.Request), schemas)},
+				},
+			}
+		}
+		if s.public(op.Scope) {
+			o["security"] = []any{}
+		} else {
+			o["x-scope"] = op.Scope
+		}
+
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = o
+	}
+
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "DB4S stats",
+			"version": openAPIVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+				"basicAuth":  map[string]any{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+	if len(s.Credentials) > 0 {
+		doc["security"] = []any{map[string]any{"bearerAuth": []any{}}, map[string]any{"basicAuth": []any{}}}
+	}
+	return doc
+}
+
+// responses returns the possible responses of an endpoint
+func (s *Server) responses(op operation, schemas map[string]any) map[string]any {
+	ok := map[string]any{"description": "OK"}
+	switch {
+	case op.Response != nil:
+		ok["content"] = map[string]any{
+			"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.Response), schemas)},
+		}
+	case op.ContentType != "":
+		ok["content"] = map[string]any{op.ContentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+	}
+	responses := map[string]any{"200": ok}
+	if op.Response != nil {
+		responses["304"] = map[string]any{"description": "Not Modified, the response matches the If-None-Match ETag"}
+	}
+	if op.Request != nil || op.Params != nil {
+		responses["400"] = map[string]any{"description": "Bad Request"}
+	}
+	if pathParam.MatchString(strings.TrimSuffix(op.Path, "{$}")) {
+		responses["404"] = map[string]any{"description": "Not Found"}
+	}
+	if !s.public(op.Scope) {
+		responses["401"] = map[string]any{"description": "Unauthorized, credentials are needed"}
+		responses["403"] = map[string]any{"description": "Forbidden, the credentials don't have the " + op.Scope +
+			" scope"}
+	}
+	if s.RateLimit > 0 {
+		responses["429"] = map[string]any{"description": "Too Many Requests, try again after the Retry-After delay"}
+	}
+	return responses
+}
+
+// operationID returns a name for an endpoint, from its method and path.  eg "postGrafanaQuery"
+func operationID(op operation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return !isAlphanumeric(r) }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// isAlphanumeric returns whether a rune is an ASCII letter or digit
+func isAlphanumeric(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// schemaOf returns the JSON schema of a Go type, as encoding/json marshals it.  Named structs are added to the
+// component schemas and referred to
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas), "minItems": t.Len(),
+			"maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, found := schemas[t.Name()]; !found {
+			// Claim the name first, in case the struct refers to itself
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema returns the JSON schema of a struct type, with the fields which aren't omitempty required
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaOf(f.Type, schemas)
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// jsonValue returns a value as it comes back from a JSON round trip, for comparing with a decoded document
+func jsonValue(t *testing.T, v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out any
+	if err = json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// checkRefs reports any schema references in a document which don't resolve to a component schema
+func checkRefs(t *testing.T, v any, schemas map[string]any) {
+	switch v := v.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok {
+			name, found := strings.CutPrefix(ref, "#/components/schemas/")
+			if _, exists := schemas[name]; !found || !exists {
+				t.Errorf("schema reference %q doesn't resolve", ref)
+			}
+		}
+		for _, e := range v {
+			checkRefs(t, e, schemas)
+		}
+	case []any:
+		for _, e := range v {
+			checkRefs(t, e, schemas)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	s := &Server{Credentials: testCredentials(), Public: []string{ScopeBadges}}
+	w := do(s, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, expected %d", w.Code, http.StatusOK)
+	}
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi is %q, expected %q", doc.OpenAPI, "3.1.0")
+	}
+
+	// Every route is in the document, with its parameters and the schema of its response
+	count := 0
+	for _, op := range s.operations() {
+		name := op.Method + " " + op.Path
+		o, ok := doc.Paths[strings.TrimSuffix(op.Path, "{$}")][strings.ToLower(op.Method)]
+		if !ok {
+			t.Errorf("%v: missing from the document", name)
+			continue
+		}
+		count++
+		if o["operationId"] != operationID(op) || o["summary"] != op.Summary {
+			t.Errorf("%v: operationId %v and summary %q", name, o["operationId"], o["summary"])
+		}
+
+		var params, expectedParams []string
+		list, _ := o["parameters"].([]any)
+		for _, p := range list {
+			p, _ := p.(map[string]any)
+			params = append(params, fmt.Sprint(p["in"], " ", p["name"]))
+		}
+		for _, m := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+			expectedParams = append(expectedParams, "path "+m[1])
+		}
+		for _, p := range op.Params {
+			expectedParams = append(expectedParams, "query "+p.Name)
+		}
+		if !slices.Equal(params, expectedParams) {
+			t.Errorf("%v: parameters %v, expected %v", name, params, expectedParams)
+		}
+
+		responses, _ := o["responses"].(map[string]any)
+		ok200, _ := responses["200"].(map[string]any)
+		content, _ := ok200["content"].(map[string]any)
+		switch {
+		case op.Response != nil:
+			expected := jsonValue(t, schemaOf(reflect.TypeOf(op.Response), map[string]any{}))
+			if got := content["application/json"]; !reflect.DeepEqual(got, map[string]any{"schema": expected}) {
+				t.Errorf("%v: response %v, expected the schema %v", name, got, expected)
+			}
+		case op.ContentType != "":
+			if _, ok := content[op.ContentType]; !ok {
+				t.Errorf("%v: response %v, expected %v", name, content, op.ContentType)
+			}
+		}
+		if (op.Request != nil) != (o["requestBody"] != nil) {
+			t.Errorf("%v: request body %v", name, o["requestBody"])
+		}
+		if _, public := o["security"]; public != s.public(op.Scope) {
+			t.Errorf("%v: security %v for scope %v", name, o["security"], op.Scope)
+		}
+	}
+
+	// There's nothing in the document which isn't a route, and all the schemas it refers to are there
+	documented := 0
+	for _, item := range doc.Paths {
+		documented += len(item)
+	}
+	if documented != count {
+		t.Errorf("document has %d operations, expected %d", documented, count)
+	}
+	checkRefs(t, jsonValue(t, doc.Paths), doc.Components.Schemas)
+	checkRefs(t, jsonValue(t, doc.Components.Schemas), doc.Components.Schemas)
+
+	// The schemas follow the JSON encoding of the client types
+	expected := jsonValue(t, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"target": map[string]any{"type": "string"},
+			"datapoints": map[string]any{"type": "array", "items": map[string]any{"type": "array",
+				"items": map[string]any{"type": "integer", "format": "int64"}, "minItems": 2, "maxItems": 2}},
+		},
+		"required": []string{"target", "datapoints"},
+	})
+	if got := doc.Components.Schemas["Series"]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Series schema is %v, expected %v", got, expected)
+	}
+	target, _ := doc.Components.Schemas["Target"].(map[string]any)
+	if required, _ := target["required"].([]any); len(required) != 1 || required[0] != "target" {
+		t.Errorf("Target schema requires %v, expected only the target, as hide is omitempty", required)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// Each client IP address gets a token bucket (https://en.wikipedia.org/wiki/Token_bucket) holding up to the burst
+// size of requests, refilled at the rate limit.  A request with the bucket empty gets a 429 Too Many Requests
+// response, rather than reaching the database.  IPv6 addresses are limited by their /64, as a single client can
+// easily have the whole range
+
+// limiterSweep is how often the buckets of the clients which have gone quiet are dropped, to bound the memory used
+const limiterSweep = time.Minute
+
+// limiter is a rate limiter per client IP address
+type limiter struct {
+	rate  float64 // Requests added to each bucket per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[netip.Addr]*bucket
+	lastSweep time.Time
+}
+
+// bucket is the requests a client has left, as of a time
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a limiter allowing the given number of requests per minute, with bursts of up to burst requests
+func newLimiter(perMinute, burst int) *limiter {
+	return &limiter{rate: float64(perMinute) / 60, burst: float64(burst), buckets: make(map[netip.Addr]*bucket)}
+}
+
+// allow takes a request from a client's bucket, returning whether there was one.  If not, it also returns how long
+// until there will be
+func (l *limiter) allow(addr netip.Addr, now time.Time) (ok bool, retryAfter time.Duration) {
+	if addr.Is6() {
+		addr = netip.PrefixFrom(addr, 64).Masked().Addr()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= limiterSweep {
+		// A bucket which would have refilled by now is the same as no bucket at all
+		for a, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, a)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, found := l.buckets[addr]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[addr] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimit wraps a handler so each client IP address is limited to the configured rate of requests
+func (s *Server) rateLimit(h http.Handler) http.Handler {
+	if s.RateLimit <= 0 {
+		return h
+	}
+	l := newLimiter(s.RateLimit, s.RateBurst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := s.clientAddr(r)
+		if ok {
+			if allowed, retryAfter := l.allow(addr, time.Now()); !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				if s.Verbosity >= verbosity.Verbose {
+					log.Printf("Rate limited %v %v from %v\n", r.Method, r.URL.Path, addr)
+				}
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientAddr returns the IP address of the client making a request.  When the request comes through one of the
+// trusted proxies, it's the last address in the X-Forwarded-For header that isn't a trusted proxy
+func (s *Server) clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !s.trusted(addr) {
+		return addr, true
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !s.trusted(addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// trusted returns whether an IP address is one of the trusted proxies
+func (s *Server) trusted(addr netip.Addr) bool {
+	for _, network := range s.TrustedProxies {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	// 60 requests a minute is one a second, in bursts of up to 3
+	l := newLimiter(60, 3)
+	client := netip.MustParseAddr("192.0.2.1")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow(client, now); !ok {
+			t.Fatalf("request %d of the burst isn't allowed", i+1)
+		}
+	}
+	ok, retryAfter := l.allow(client, now)
+	if ok || retryAfter != time.Second {
+		t.Fatalf("request after the burst gives %v, retry after %v.  Expected false, after 1s", ok, retryAfter)
+	}
+
+	// Other clients have their own buckets
+	if ok, _ = l.allow(netip.MustParseAddr("192.0.2.2"), now); !ok {
+		t.Error("another client is limited too")
+	}
+
+	// Half a second only refills half a request, then a second refills one
+	if ok, retryAfter = l.allow(client, now.Add(500*time.Millisecond)); ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("request after half a second gives %v, retry after %v.  Expected false, after 500ms", ok, retryAfter)
+	}
+	if ok, _ = l.allow(client, now.Add(1500*time.Millisecond)); !ok {
+		t.Error("request isn't allowed after the bucket refills")
+	}
+	if ok, _ = l.allow(client, now.Add(1500*time.Millisecond)); ok {
+		t.Error("bucket refilled more than the rate")
+	}
+
+	// The bucket never holds more than the burst size, however long the client is quiet
+	later := now.Add(time.Hour)
+	for i := 0; i < 4; i++ {
+		ok, _ = l.allow(client, later)
+	}
+	if ok {
+		t.Error("bucket refilled past the burst size")
+	}
+}
+
+func TestLimiterIPv6(t *testing.T) {
+	// The addresses in the same /64 share a bucket
+	l := newLimiter(60, 1)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if ok, _ := l.allow(netip.MustParseAddr("2001:db8:1:2::1"), now); !ok {
+		t.Fatal("first request isn't allowed")
+	}
+	if ok, _ := l.allow(netip.MustParseAddr("2001:db8:1:2:ffff::9"), now); ok {
+		t.Error("address in the same /64 has its own bucket")
+	}
+	if ok, _ := l.allow(netip.MustParseAddr("2001:db8:1:3::1"), now); !ok {
+		t.Error("address in another /64 shares the bucket")
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := newLimiter(60, 2)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.allow(netip.MustParseAddr("192.0.2.1"), now)
+	l.allow(netip.MustParseAddr("192.0.2.2"), now.Add(limiterSweep))
+
+	// The first client's bucket has refilled by the sweep, so it's dropped
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets after the sweep, expected 1", len(l.buckets))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// The limiter belongs to the handler, so the requests all go through the one
+	h := (&Server{RateLimit: 1, RateBurst: 2}).Handler()
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := search()
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := send("192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d of the burst has status %d, expected %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+	w := send("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d after the burst, expected %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After is %q, expected 60", got)
+	}
+	if w = send("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("status %d for another client, expected %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	s := &Server{TrustedProxies: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ff::/48"),
+	}}
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"[2001:db8::1]:443", nil, "2001:db8::1"},
+		{"[::ffff:192.0.2.1]:443", nil, "192.0.2.1"},
+
+		// X-Forwarded-For is only used from a trusted proxy
+		{"192.0.2.1:1234", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"10.0.0.5:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"[2001:db8:ff::1]:443", []string{"198.51.100.7"}, "198.51.100.7"},
+
+		// The client is the last address which isn't a trusted proxy, so it can't be spoofed by the client adding
+		// its own addresses in front
+		{"10.0.0.5:1234", []string{"203.0.113.9, 198.51.100.7, 10.0.0.6"}, "198.51.100.7"},
+		{"10.0.0.5:1234", []string{"203.0.113.9", "198.51.100.7, 10.0.0.6"}, "198.51.100.7"},
+
+		// A trusted proxy without a header is the client, as is the last trusted one before an invalid entry
+		{"10.0.0.5:1234", nil, "10.0.0.5"},
+		{"10.0.0.5:1234", []string{"198.51.100.7, bogus, 10.0.0.6"}, "10.0.0.6"},
+		{"10.0.0.5:1234", []string{"10.0.0.7, 10.0.0.6"}, "10.0.0.7"},
+	}
+	for _, test := range tests {
+		r := search()
+		r.RemoteAddr = test.remoteAddr
+		for _, v := range test.forwardedFor {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		addr, ok := s.clientAddr(r)
+		if !ok || addr != netip.MustParseAddr(test.want) {
+			t.Errorf("clientAddr for %v with X-Forwarded-For %q is %v, expected %v", test.remoteAddr,
+				test.forwardedFor, addr, test.want)
+		}
+	}
+
+	r := search()
+	r.RemoteAddr = "not an address"
+	if _, ok := s.clientAddr(r); ok {
+		t.Error("no error for an invalid remote address")
+	}
+}
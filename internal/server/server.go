@@ -0,0 +1,155 @@
+// Package server serves the saved stats over HTTP, for dashboards and other tooling
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/client"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// Server answers HTTP requests using the saved stats
+type Server struct {
+	DB store.Store
+
+	// The credentials allowed to use the endpoints, and the scopes open to everyone.  Without any credentials,
+	// everything is open to everyone
+	Credentials []Credential
+	Public      []string
+
+	// The origins of the web pages allowed to fetch from the endpoints, or "*" for any
+	CORSOrigins []string
+
+	// How long browsers and CDNs can cache the JSON responses for
+	CacheMaxAge time.Duration
+
+	// The requests per minute allowed from each client IP address (none when 0), and how many can be made at once
+	RateLimit int
+	RateBurst int
+
+	// The reverse proxies whose X-Forwarded-For headers give the client IP addresses
+	TrustedProxies []netip.Prefix
+
+	// How much logging output to give
+	Verbosity verbosity.Level
+}
+
+// operation is an endpoint, as both the route it's served on and its entry in the OpenAPI document
+type operation struct {
+	Method  string
+	Path    string
+	Summary string
+	Scope   string
+
+	// The query parameters it takes
+	Params []param
+
+	// Values of the types of its JSON request and response bodies, if it has them.  Responses which aren't JSON
+	// give their content type instead
+	Request     any
+	Response    any
+	ContentType string
+
+	Handler http.HandlerFunc
+}
+
+// param is a query parameter of an endpoint
+type param struct {
+	Name        string
+	Description string
+	Enum        []string
+}
+
+// operations returns all of the endpoints
+func (s *Server) operations() []operation {
+	return []operation{
+		// Grafana JSON datasource
+		{Method: http.MethodGet, Path: "/grafana/{$}", Summary: "Check the Grafana datasource is reachable",
+			Scope: ScopeTotals, Handler: s.grafanaHealth},
+		{Method: http.MethodPost, Path: "/grafana/search", Summary: "List the names of the query targets",
+			Scope: ScopeTotals, Response: []string{}, Handler: s.grafanaSearch},
+		{Method: http.MethodPost, Path: "/grafana/metrics", Summary: "List the query targets",
+			Scope: ScopeTotals, Response: []client.Metric{}, Handler: s.grafanaMetrics},
+		{Method: http.MethodPost, Path: "/grafana/query",
+			Summary: "Get the time series of query targets.  Targets for single releases or downloads also need " +
+				"the breakdowns scope",
+			Scope: ScopeTotals, Request: client.QueryRequest{}, Response: []client.Series{}, Handler: s.grafanaQuery},
+
+		// shields.io badges
+		{Method: http.MethodGet, Path: "/badge/{metric}", Summary: "Get the shields.io badge for a metric family",
+			Scope: ScopeBadges, Response: client.Badge{}, Handler: s.badgeHandler},
+
+		// Dashboard
+		{Method: http.MethodGet, Path: "/dashboard/{$}", Summary: "Show the stats dashboard",
+			Scope: ScopeBreakdowns, ContentType: "text/html", Handler: s.dashboard,
+			Params: []param{{Name: "period", Description: "The time periods to show, defaults to weekly",
+				Enum: []string{"daily", "weekly", "monthly"}}}},
+	}
+}
+
+// Handler returns the HTTP handler for all of the endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, op := range s.operations() {
+		mux.HandleFunc(op.Method+" "+op.Path, s.require(op.Scope, op.Handler))
+	}
+
+	// The API description is open to everyone, so clients can find out which credentials they need
+	mux.HandleFunc("GET /openapi.json", s.openAPI)
+	return s.cors(s.rateLimit(mux))
+}
+
+// writeJSON sends a JSON response, with caching headers.  The ETag is a hash of the response, so a client whose
+// cached copy is still current gets a 304 Not Modified response instead
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	body = append(body, '\n')
+	hash := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(hash[:8]) + `"`
+
+	// Responses to authenticated requests mustn't end up in shared caches, where they'd be served to anyone
+	cacheControl := "public"
+	if r.Header.Get("Authorization") != "" {
+		cacheControl = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%v, max-age=%d", cacheControl, int(s.CacheMaxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(body); err != nil {
+		log.Printf("Writing response failed: %v\n", err)
+	}
+}
+
+// etagMatches returns whether an If-None-Match header matches the ETag of a response
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// serverError logs an error, then sends a generic error response so the details aren't exposed
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Error handling %v %v: %v\n", r.Method, r.URL.Path, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	s := &Server{CacheMaxAge: 5 * time.Minute}
+	w := do(s, search())
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.Len() == 0 {
+		t.Fatalf("status %d with ETag %q, expected %d with an ETag", w.Code, etag, http.StatusOK)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=300" {
+		t.Errorf("Cache-Control is %q", got)
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"0123456789abcdef", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"0123456789abcdef"`, http.StatusOK},
+		{strings.Trim(etag, `"`), http.StatusOK},
+	}
+	for _, test := range tests {
+		r := search()
+		r.Header.Set("If-None-Match", test.ifNoneMatch)
+		w := do(s, r)
+		if w.Code != test.want {
+			t.Errorf("If-None-Match %v: status %d, expected %d", test.ifNoneMatch, w.Code, test.want)
+		}
+		if w.Code == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != etag) {
+			t.Errorf("If-None-Match %v: not modified response has a body, or the ETag %q", test.ifNoneMatch,
+				w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestPrivateCache(t *testing.T) {
+	// Responses to authenticated requests are kept out of shared caches
+	s := &Server{Credentials: testCredentials(), CacheMaxAge: time.Minute}
+	r := search()
+	r.Header.Set("Authorization", "Bearer t0ken")
+	w := do(s, r)
+	if got := w.Header().Get("Cache-Control"); got != "private, max-age=60" {
+		t.Errorf("Cache-Control is %q, expected private", got)
+	}
+}
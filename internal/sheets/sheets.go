@@ -0,0 +1,165 @@
+// Package sheets is a minimal client for writing values into a Google Sheet, authenticating as a service account
+package sheets
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the Google Sheets API address, when the config file doesn't give another
+const DefaultURL = "https://sheets.googleapis.com"
+
+// scope is the OAuth scope needed to write to a spreadsheet
+const scope = "https://www.googleapis.com/auth/spreadsheets"
+
+// ServiceAccount is the part of a Google service account key file we use
+type ServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// Client writes to the Google Sheets API as a service account.  The spreadsheet needs sharing with the service
+// account's email address, with edit access
+type Client struct {
+	URL     string
+	account ServiceAccount
+	key     *rsa.PrivateKey
+}
+
+// NewClient returns a client for the given Google Sheets API address (or the default one when it's empty), using the
+// service account key file at the given path
+func NewClient(api, keyFile string) (*Client, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var account ServiceAccount
+	if err = json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account key file %v: %w", keyFile, err)
+	}
+	if account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("the service account key file %v is missing its client_email or token_uri", keyFile)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("the service account key file %v has no PEM private key", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %v: %w", keyFile, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key in %v isn't an RSA key", keyFile)
+	}
+	if api == "" {
+		api = DefaultURL
+	}
+	return &Client{URL: strings.TrimSuffix(api, "/"), account: account, key: key}, nil
+}
+
+// Replace clears a sheet (tab) of a spreadsheet, then writes the rows into it starting at cell A1
+func (c *Client) Replace(ctx context.Context, spreadsheetID, sheet string, rows [][]any) error {
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+	base := c.URL + "/v4/spreadsheets/" + url.PathEscape(spreadsheetID) + "/values/" + url.PathEscape("'"+sheet+"'")
+	err = c.send(ctx, http.MethodPost, token, base+":clear", struct{}{})
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Range  string  `json:"range"`
+		Values [][]any `json:"values"`
+	}{"'" + sheet + "'", rows}
+	return c.send(ctx, http.MethodPut, token, base+"?valueInputOption=RAW", body)
+}
+
+// send sends a JSON request body to the API, authenticated with the access token
+func (c *Client) send(ctx context.Context, method, token, reqURL string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Google Sheets returned status %v for %v %v", resp.Status, method, req.URL.Path)
+	}
+	return nil
+}
+
+// token exchanges a signed JWT for an OAuth access token, as per
+// https://developers.google.com/identity/protocols/oauth2/service-account#httprest
+func (c *Client) token(ctx context.Context) (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   c.account.ClientEmail,
+		"scope": scope,
+		"aud":   c.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, c.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Google returned status %v for the service account token", resp.Status)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("Google didn't return a service account token")
+	}
+	return result.AccessToken, nil
+}
@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// declaredMetric is a metric declared in the config file, counting the download log rows matching its SQL filter,
+// optionally broken down by a group by expression.  The counts are saved in the metric's own table
+type declaredMetric struct {
+	passThrough
+	info config.MetricInfo
+}
+
+func (m declaredMetric) Name() string {
+	return m.info.Name
+}
+
+func (m declaredMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	counts, err := db.CountMatchingLogs(ctx, m.info.Filter, m.info.GroupBy, p.StartDate, p.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (m declaredMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	return 0, db.SaveMetricCounts(ctx, m.info.Table, p.Family.Name, p.StartDate, values.(map[string]int64))
+}
+
+// RegisterDeclaredMetrics checks the metrics declared in the config file, then adds them to those generated for their
+// kind of metric family
+func RegisterDeclaredMetrics(metrics []config.MetricInfo) error {
+	names := make(map[string]bool)
+	for _, info := range metrics {
+		if info.Name == "" {
+			return fmt.Errorf("declared metric for table '%v' has no name", info.Table)
+		}
+		if names[info.Name] {
+			return fmt.Errorf("metric '%v' is declared more than once", info.Name)
+		}
+		names[info.Name] = true
+		if info.Filter == "" {
+			return fmt.Errorf("declared metric '%v' has no filter", info.Name)
+		}
+		if err := store.ValidMetricTable(info.Table); err != nil {
+			return fmt.Errorf("declared metric '%v': %w", info.Name, err)
+		}
+		if info.Kind == "" {
+			info.Kind = KindDownloads
+		}
+		if info.Kind != KindDownloads && info.Kind != KindUsers {
+			return fmt.Errorf("unknown kind '%v' for declared metric '%v', it needs to be downloads or users", info.Kind,
+				info.Name)
+		}
+		RegisterMetric(info.Kind, declaredMetric{info: info})
+	}
+	return nil
+}
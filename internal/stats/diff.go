@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Delta is the change in a saved stats value between two time periods of a metric family, or between the saved and
+// freshly recomputed value of a single time period
+type Delta struct {
+	Family string
+	ID     int
+	Name   string // The release or download name, or "total" for the totals row
+	A      int64
+	B      int64
+}
+
+// Change returns the difference between the two values
+func (d Delta) Change() int64 {
+	return d.B - d.A
+}
+
+// Percent returns the change as a percentage of the first value, or nil when the first value is zero
+func (d Delta) Percent() *float64 {
+	if d.A == 0 {
+		return nil
+	}
+	pct := float64(d.B-d.A) * 100 / float64(d.A)
+	return &pct
+}
+
+// Diff compares the saved stats of each metric family for the time period containing date a against the one
+// containing date b, returning a Delta for the totals and every ID with a non zero value in either of them.  When b is zero, the saved stats for
+// the time period containing a are compared against freshly recomputed ones instead.  Missing rows are treated as zero
+func (g *Generator) Diff(ctx context.Context, a, b time.Time) (deltas []Delta, err error) {
+	for _, fam := range g.families() {
+		startA := fam.Granularity.Start(a)
+		var valuesA, valuesB map[int]int64
+		valuesA, err = g.DB.StoredStats(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, startA)
+		if err != nil {
+			return
+		}
+		if b.IsZero() {
+			valuesB, err = fam.recompute(ctx, g.DB, startA, fam.Granularity.Next(startA))
+		} else {
+			valuesB, err = g.DB.StoredStats(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, fam.Granularity.Start(b))
+		}
+		if err != nil {
+			return
+		}
+		var names map[int]string
+		names, err = fam.Names(ctx, g.DB)
+		if err != nil {
+			return
+		}
+
+		ids := make(map[int]struct{})
+		for id := range valuesA {
+			ids[id] = struct{}{}
+		}
+		for id := range valuesB {
+			ids[id] = struct{}{}
+		}
+		var found []Delta
+		for id := range ids {
+			if valuesA[id] == 0 && valuesB[id] == 0 && id != fam.TotalID {
+				continue
+			}
+			name := names[id]
+			if id == fam.TotalID {
+				name = "total"
+			}
+			found = append(found, Delta{Family: fam.Name, ID: id, Name: name, A: valuesA[id], B: valuesB[id]})
+		}
+
+		// The totals row goes first, followed by the releases or downloads in ID order
+		sort.Slice(found, func(i, j int) bool {
+			if (found[i].ID == fam.TotalID) != (found[j].ID == fam.TotalID) {
+				return found[i].ID == fam.TotalID
+			}
+			return found[i].ID < found[j].ID
+		})
+		deltas = append(deltas, found...)
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// versionCounts is a total along with its breakdown per release or download
+type versionCounts[T any] struct {
+	total      T
+	perVersion map[int]T
+}
+
+// downloadsMetric is the number of downloads, saved in the downloads stats table of the family
+type downloadsMetric struct{}
+
+func (downloadsMetric) Name() string {
+	return "downloads"
+}
+
+func (downloadsMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	DLs, DLsPerVersion, err := db.GetDownloads(ctx, p.StartDate, p.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	return versionCounts[int32]{DLs, DLsPerVersion}, nil
+}
+
+func (downloadsMetric) Aggregate(p *Period, data any) (any, error) {
+	p.Total = int64(data.(versionCounts[int32]).total)
+	return data, nil
+}
+
+func (downloadsMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	counts := values.(versionCounts[int32])
+	var err error
+	switch p.Family.Granularity {
+	case Daily:
+		err = db.SaveDailyDownloadsStats(ctx, p.StartDate, counts.total, counts.perVersion)
+	case Weekly:
+		err = db.SaveWeeklyDownloadsStats(ctx, p.StartDate, counts.total, counts.perVersion)
+	case Monthly:
+		err = db.SaveMonthlyDownloadsStats(ctx, p.StartDate, counts.total, counts.perVersion)
+	default:
+		err = fmt.Errorf("no downloads stats table for %v", p.Family.Name)
+	}
+	return 1 + len(counts.perVersion), err
+}
+
+// uniqueDownloadsMetric is the number of unique downloads, saved in their own column of the downloads stats rows
+type uniqueDownloadsMetric struct{ passThrough }
+
+func (uniqueDownloadsMetric) Name() string {
+	return "unique downloads"
+}
+
+func (uniqueDownloadsMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	DLs, DLsPerVersion, err := db.GetUniqueDownloads(ctx, p.StartDate, p.EndDate)
+	if err != nil || DLsPerVersion == nil {
+		return nil, err
+	}
+	return versionCounts[int32]{DLs, DLsPerVersion}, nil
+}
+
+func (uniqueDownloadsMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	counts := values.(versionCounts[int32])
+	return 0, db.SaveUniqueDownloads(ctx, p.Family.Table, p.StartDate, counts.total, counts.perVersion)
+}
+
+// bandwidthMetric is the number of bytes sent, saved in its own column of the downloads stats rows
+type bandwidthMetric struct{ passThrough }
+
+func (bandwidthMetric) Name() string {
+	return "bandwidth"
+}
+
+func (bandwidthMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	bytes, bytesPerVersion, err := db.GetBandwidth(ctx, p.StartDate, p.EndDate)
+	if err != nil || bytesPerVersion == nil {
+		return nil, err
+	}
+	return versionCounts[int64]{bytes, bytesPerVersion}, nil
+}
+
+func (bandwidthMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	counts := values.(versionCounts[int64])
+	return 0, db.SaveBandwidth(ctx, p.Family.Table, p.StartDate, counts.total, counts.perVersion)
+}
+
+// failedDownloadsMetric is the number of failed requests for each download artifact, saved in their own table
+type failedDownloadsMetric struct{ passThrough }
+
+func (failedDownloadsMetric) Name() string {
+	return "failed downloads"
+}
+
+func (failedDownloadsMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	failed, err := db.GetFailedDownloads(ctx, p.StartDate, p.EndDate)
+	if err != nil || failed == nil {
+		return nil, err
+	}
+	return failed, nil
+}
+
+func (failedDownloadsMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	failed := values.([]store.FailedDownload)
+	return 0, db.SaveFailedDownloads(ctx, p.Family.Name, p.StartDate, failed)
+}
+
+// referrersMetric is the top referrers of each download, saved in their own table
+type referrersMetric struct{ passThrough }
+
+func (referrersMetric) Name() string {
+	return "referrers"
+}
+
+func (referrersMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	referrers, err := db.GetReferrers(ctx, p.StartDate, p.EndDate)
+	if err != nil || referrers == nil {
+		return nil, err
+	}
+	return referrers, nil
+}
+
+func (referrersMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	referrers := values.([]store.Referrer)
+	return 0, db.SaveReferrers(ctx, p.Family.Name, p.StartDate, referrers)
+}
+
+// channelRollupMetric is the downloads from each distribution channel, with those from our mirrors being the total
+// of the downloads metric.  They're saved in their own table
+type channelRollupMetric struct{}
+
+func (channelRollupMetric) Name() string {
+	return "channel rollup"
+}
+
+func (channelRollupMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	perChannel, err := db.GetChannelDownloads(ctx, p.StartDate, p.EndDate)
+	if err != nil || perChannel == nil {
+		return nil, err
+	}
+	return perChannel, nil
+}
+
+func (channelRollupMetric) Aggregate(p *Period, data any) (any, error) {
+	perChannel := data.(map[string]int64)
+	perChannel[store.ChannelMirrors] = p.Total
+	return perChannel, nil
+}
+
+func (channelRollupMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	perChannel := values.(map[string]int64)
+	return 0, db.SaveChannelRollup(ctx, p.Family.Name, p.StartDate, perChannel)
+}
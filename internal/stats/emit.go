@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// Record is one aggregate value saved for a time period, as written out by the Generator's Emit option
+type Record struct {
+	Family    string    `json:"family"`
+	Period    time.Time `json:"period"` // Start of the time period
+	Metric    string    `json:"metric"`
+	Dimension string    `json:"dimension"` // eg a release or download ID, a user agent, or "total"
+	Value     int64     `json:"value"`
+}
+
+// emitRecords writes the records of a metric's values for a time period as JSON Lines
+func emitRecords(enc *json.Encoder, p *Period, m Metric, values any) error {
+	for _, r := range records(values) {
+		r.Family = p.Family.Name
+		r.Period = p.StartDate.UTC()
+		r.Metric = m.Name()
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("writing the %v records failed: %w", m.Name(), err)
+		}
+	}
+	return nil
+}
+
+// records returns the dimension and value records of a metric's values, in a stable order so the output of runs can be
+// diffed.  Unknown types of value give no records
+func records(values any) []Record {
+	switch v := values.(type) {
+	case versionCounts[int32]:
+		return append([]Record{{Dimension: "total", Value: int64(v.total)}}, sortedRecords(v.perVersion, strconv.Itoa)...)
+	case versionCounts[int64]:
+		return append([]Record{{Dimension: "total", Value: v.total}}, sortedRecords(v.perVersion, strconv.Itoa)...)
+	case userAgentCounts:
+		return append([]Record{{Dimension: "total", Value: int64(v.total)}}, sortedRecords(v.perUserAgent, identity)...)
+	case map[string]int64:
+		return sortedRecords(v, identity)
+	case map[string]int:
+		return sortedRecords(v, identity)
+	case int64:
+		return []Record{{Dimension: "total", Value: v}}
+	case heavyHitterCounts:
+		return []Record{{Dimension: "ips", Value: v.ips}, {Dimension: "requests", Value: v.requests}}
+	case []store.FailedDownload:
+		var recs []Record
+		for _, f := range v {
+			recs = append(recs, Record{Dimension: fmt.Sprintf("%v %d", f.Request, f.Status), Value: f.Failures})
+		}
+		return recs
+	case []store.Referrer:
+		var recs []Record
+		for _, r := range v {
+			recs = append(recs, Record{Dimension: fmt.Sprintf("%d %v", r.Download, r.Referrer), Value: r.Downloads})
+		}
+		return recs
+	}
+	return nil
+}
+
+// sortedRecords returns a record for each entry of a breakdown, ordered by key
+func sortedRecords[K cmp.Ordered, V int | int32 | int64](m map[K]V, dimension func(K) string) []Record {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var recs []Record
+	for _, k := range keys {
+		recs = append(recs, Record{Dimension: dimension(k), Value: int64(m[k])})
+	}
+	return recs
+}
+
+// identity returns the string as is, for breakdowns already keyed by their dimension
+func identity(s string) string {
+	return s
+}
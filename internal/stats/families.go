@@ -0,0 +1,250 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// Metric families, as recorded in the stats_processing_state table
+const (
+	FamilyDownloadsDaily   = "downloads-daily"
+	FamilyDownloadsMonthly = "downloads-monthly"
+	FamilyDownloadsWeekly  = "downloads-weekly"
+	FamilyUsersDaily       = "users-daily"
+	FamilyUsersMonthly     = "users-monthly"
+	FamilyUsersWeekly      = "users-weekly"
+)
+
+// Family describes where the stats for a metric family are stored, and how to generate them for one of its time periods
+type Family struct {
+	Name        string
+	Granularity Granularity
+
+	// Start of the first time period with data
+	FirstPeriod time.Time
+
+	// The stats table, plus the column and value identifying the row holding the totals for each time period.  The
+	// other rows for each time period use the same column for their release or download ID
+	Table       string
+	TotalColumn string
+	TotalID     int
+
+	// The stats table column holding the counts
+	ValueColumn string
+
+	// The kind of metric family, which decides the metrics generated for it (see Metrics)
+	Kind string
+
+	// What's being counted, for display in debug info
+	what string
+
+	// Generates the stats for a single time period without saving them, keyed by release or download ID
+	recompute func(ctx context.Context, db store.Store, startDate, endDate time.Time) (map[int]int64, error)
+
+	// Returns the name of each release or download ID
+	names func(ctx context.Context, db store.Store) (map[int]string, error)
+}
+
+// Families returns the details of each metric family, in the order they should be processed
+func Families() []Family {
+	// NOTE - The first weekly periods are the Mondays of the weeks containing the first day with data.  eg 2018-08-13
+	// (the first day with users data) is in week #33, and 2018-08-09 (the first day with downloads data) is in week #32
+	return []Family{
+		{
+			Name:        FamilyUsersDaily,
+			Granularity: Daily,
+			FirstPeriod: time.Date(2018, 8, 13, 0, 0, 0, 0, time.UTC),
+			Table:       "db4s_users_daily",
+			TotalColumn: "db4s_release",
+			TotalID:     1,
+			ValueColumn: "unique_ips",
+			what:        "Unique IP addresses",
+			Kind:        KindUsers,
+			recompute:   recomputeUsers,
+			names:       releaseNames,
+		},
+		{
+			Name:        FamilyUsersWeekly,
+			Granularity: Weekly,
+			FirstPeriod: time.Date(2018, 8, 13, 0, 0, 0, 0, time.UTC),
+			Table:       "db4s_users_weekly",
+			TotalColumn: "db4s_release",
+			TotalID:     1,
+			ValueColumn: "unique_ips",
+			what:        "Unique IP addresses",
+			Kind:        KindUsers,
+			recompute:   recomputeUsers,
+			names:       releaseNames,
+		},
+		{
+			Name:        FamilyUsersMonthly,
+			Granularity: Monthly,
+			FirstPeriod: time.Date(2018, 8, 1, 0, 0, 0, 0, time.UTC),
+			Table:       "db4s_users_monthly",
+			TotalColumn: "db4s_release",
+			TotalID:     1,
+			ValueColumn: "unique_ips",
+			what:        "Unique IP addresses",
+			Kind:        KindUsers,
+			recompute:   recomputeUsers,
+			names:       releaseNames,
+		},
+		{
+			Name:        FamilyDownloadsDaily,
+			Granularity: Daily,
+			FirstPeriod: time.Date(2018, 8, 9, 0, 0, 0, 0, time.UTC),
+			Table:       "db4s_downloads_daily",
+			TotalColumn: "db4s_download",
+			TotalID:     0,
+			ValueColumn: "num_downloads",
+			what:        "Downloads",
+			Kind:        KindDownloads,
+			recompute:   recomputeDownloads,
+			names:       downloadNames,
+		},
+		{
+			Name:        FamilyDownloadsWeekly,
+			Granularity: Weekly,
+			FirstPeriod: time.Date(2018, 8, 6, 0, 0, 0, 0, time.UTC),
+			Table:       "db4s_downloads_weekly",
+			TotalColumn: "db4s_download",
+			TotalID:     0,
+			ValueColumn: "num_downloads",
+			what:        "Downloads",
+			Kind:        KindDownloads,
+			recompute:   recomputeDownloads,
+			names:       downloadNames,
+		},
+		{
+			Name:        FamilyDownloadsMonthly,
+			Granularity: Monthly,
+			FirstPeriod: time.Date(2018, 8, 1, 0, 0, 0, 0, time.UTC),
+			Table:       "db4s_downloads_monthly",
+			TotalColumn: "db4s_download",
+			TotalID:     0,
+			ValueColumn: "num_downloads",
+			what:        "Downloads",
+			Kind:        KindDownloads,
+			recompute:   recomputeDownloads,
+			names:       downloadNames,
+		},
+	}
+}
+
+// FamilyByName returns the metric family with the given name
+func FamilyByName(name string) (Family, bool) {
+	for _, fam := range Families() {
+		if fam.Name == name {
+			return fam, true
+		}
+	}
+	return Family{}, false
+}
+
+// Names returns the name of each release or download ID of the metric family.  eg "3.11.0" or "3.11.0 Win64 MSI"
+func (fam Family) Names(ctx context.Context, db store.Store) (map[int]string, error) {
+	return fam.names(ctx, db)
+}
+
+// SelectFamilies returns the metric families matching any of the only terms (or all of them when there are none),
+// minus those matching any of the skip terms.  A term matches either a full family name (eg "users-daily"), or one
+// half of it (eg "users" or "weekly")
+func SelectFamilies(only, skip []string) ([]Family, error) {
+	for _, term := range append(append([]string{}, only...), skip...) {
+		if !matchesAny(term) {
+			return nil, fmt.Errorf("unknown metric family '%v'", term)
+		}
+	}
+	var selected []Family
+	for _, fam := range Families() {
+		if len(only) > 0 && !fam.matches(only) {
+			continue
+		}
+		if fam.matches(skip) {
+			continue
+		}
+		selected = append(selected, fam)
+	}
+	return selected, nil
+}
+
+// matches returns whether any of the terms matches the metric family
+func (fam Family) matches(terms []string) bool {
+	what, granularity, _ := strings.Cut(fam.Name, "-")
+	for _, term := range terms {
+		if term == fam.Name || term == what || term == granularity {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny returns whether the term matches at least one metric family
+func matchesAny(term string) bool {
+	for _, fam := range Families() {
+		if fam.matches([]string{term}) {
+			return true
+		}
+	}
+	return false
+}
+
+// recomputeDownloads generates the downloads stats for a time period without saving them.  The total is under ID 0, as
+// per the db4s_download_info table
+func recomputeDownloads(ctx context.Context, db store.Store, startDate, endDate time.Time) (map[int]int64, error) {
+	numDLs, DLsPerVersion, err := db.GetDownloads(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[int]int64{0: int64(numDLs)}
+	for version, DLCount := range DLsPerVersion {
+		counts[version] = int64(DLCount)
+	}
+	return counts, nil
+}
+
+// recomputeUsers generates the users stats for a time period without saving them.  The total is under ID 1, as per
+// the db4s_release_info table.  User agents without a release entry are left out, as they can't have been saved
+func recomputeUsers(ctx context.Context, db store.Store, startDate, endDate time.Time) (map[int]int64, error) {
+	numIPs, IPsPerUserAgent, err := db.GetIPs(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	releases, err := db.ReleaseIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[int]int64{1: int64(numIPs)}
+	for userAgent, verCount := range IPsPerUserAgent {
+		if id, ok := releases[db.Filters().UserAgentVersion(userAgent)]; ok {
+			counts[id] = int64(verCount)
+		}
+	}
+	return counts, nil
+}
+
+// downloadNames returns the name of each download ID, as per the db4s_download_info table
+func downloadNames(_ context.Context, db store.Store) (map[int]string, error) {
+	names := map[int]string{0: "Total downloads"}
+	for _, file := range db.Filters().DownloadFiles() {
+		names[file.ID] = file.Name
+	}
+	return names, nil
+}
+
+// releaseNames returns the version number of each release ID, as per the db4s_release_info table
+func releaseNames(ctx context.Context, db store.Store) (map[int]string, error) {
+	releases, err := db.ReleaseIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(releases))
+	for version, id := range releases {
+		names[id] = version
+	}
+	return names, nil
+}
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"math"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// Smoothing factors for the level, trend and seasonal parts of the forecasts
+const (
+	forecastAlpha = 0.4
+	forecastBeta  = 0.1
+	forecastGamma = 0.3
+)
+
+// Forecast methods, as recorded in the stats_forecasts table
+const (
+	MethodHoltWinters = "holt-winters"
+	MethodHolt        = "holt"
+	MethodNaive       = "naive"
+)
+
+// seasonLength returns the number of time periods in a year for the granularity, or 0 for daily (which isn't forecast)
+func seasonLength(g Granularity) int {
+	switch g {
+	case Weekly:
+		return 52
+	case Monthly:
+		return 12
+	default:
+		return 0
+	}
+}
+
+// Forecast projects the total of the current (still incomplete) time period of each weekly and monthly metric family
+// from the completed ones, saving the predictions so they can be compared against the actual totals later on
+func (g *Generator) Forecast(ctx context.Context) error {
+	for _, fam := range g.families() {
+		season := seasonLength(fam.Granularity)
+		if season == 0 {
+			continue
+		}
+		current := fam.Granularity.Start(g.now())
+		history, err := g.DB.StatsRange(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, fam.FirstPeriod, current)
+		if err != nil {
+			return err
+		}
+
+		// Walk the calendar, so time periods without a totals row count as zero instead of being skipped
+		var series []float64
+		for d := fam.FirstPeriod; d.Before(current); d = fam.Granularity.Next(d) {
+			series = append(series, float64(history[d][fam.TotalID]))
+		}
+		if len(series) == 0 {
+			continue
+		}
+
+		predicted, method := forecastNext(series, season)
+		err = g.DB.SaveForecast(ctx, fam.Name, current, predicted, method)
+		if err != nil {
+			return err
+		}
+		if g.Verbosity >= verbosity.Verbose {
+			log.Printf("Forecast %v for %v: %v (%v)\n", fam.what, fam.Granularity.Label(current), predicted, method)
+		}
+	}
+	return nil
+}
+
+// forecastNext predicts the value following the series.  Additive Holt-Winters is used when there are at least two
+// full seasons of history, falling back to Holt's linear trend method, then to the last value for a single point
+func forecastNext(series []float64, season int) (int64, string) {
+	n := len(series)
+	var next float64
+	var method string
+	switch {
+	case n >= 2*season:
+		next, method = holtWinters(series, season), MethodHoltWinters
+	case n >= 2:
+		next, method = holt(series), MethodHolt
+	default:
+		next, method = series[n-1], MethodNaive
+	}
+	return int64(math.Round(math.Max(next, 0))), method
+}
+
+// holt returns the one step ahead forecast of Holt's linear trend method
+func holt(series []float64) float64 {
+	level, trend := series[0], series[1]-series[0]
+	for _, y := range series[1:] {
+		prevLevel := level
+		level = forecastAlpha*y + (1-forecastAlpha)*(level+trend)
+		trend = forecastBeta*(level-prevLevel) + (1-forecastBeta)*trend
+	}
+	return level + trend
+}
+
+// holtWinters returns the one step ahead forecast of the additive Holt-Winters method.  The trend is initialised from
+// the first two seasons, and the level and seasonal parts from the first season with that trend taken out
+func holtWinters(series []float64, season int) float64 {
+	firstMean := mean(series[:season])
+	trend := (mean(series[season:2*season]) - firstMean) / float64(season)
+	seasonal := make([]float64, len(series))
+	for i := 0; i < season; i++ {
+		seasonal[i] = series[i] - (firstMean + trend*(float64(i)-float64(season-1)/2))
+	}
+
+	// The level as of the end of the first season
+	level := firstMean + trend*float64(season-1)/2
+	for t := season; t < len(series); t++ {
+		prevLevel := level
+		level = forecastAlpha*(series[t]-seasonal[t-season]) + (1-forecastAlpha)*(level+trend)
+		trend = forecastBeta*(level-prevLevel) + (1-forecastBeta)*trend
+		seasonal[t] = forecastGamma*(series[t]-level) + (1-forecastGamma)*seasonal[t-season]
+	}
+	return level + trend + seasonal[len(series)-season]
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
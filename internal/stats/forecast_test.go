@@ -0,0 +1,40 @@
+package stats
+
+import "testing"
+
+func TestForecastNext(t *testing.T) {
+	// repeat returns the values repeated the given number of times
+	repeat := func(times int, values ...float64) []float64 {
+		var series []float64
+		for i := 0; i < times; i++ {
+			series = append(series, values...)
+		}
+		return series
+	}
+	linear := make([]float64, 8)
+	for i := range linear {
+		linear[i] = float64(100 + 10*i)
+	}
+	tests := []struct {
+		name       string
+		series     []float64
+		season     int
+		want       int64
+		wantMethod string
+	}{
+		{"single point", []float64{42}, 12, 42, MethodNaive},
+		{"flat", []float64{50, 50, 50}, 12, 50, MethodHolt},
+		{"linear trend", linear, 12, 180, MethodHolt},
+		{"falling below zero", []float64{10, 4}, 12, 0, MethodHolt},
+		{"linear trend over two seasons", linear, 4, 180, MethodHoltWinters},
+		{"seasonal", repeat(3, 10, 20, 30, 40), 4, 10, MethodHoltWinters},
+		{"less than two seasons", repeat(7, 50), 4, 50, MethodHolt},
+	}
+	for _, test := range tests {
+		got, method := forecastNext(test.series, test.season)
+		if got != test.want || method != test.wantMethod {
+			t.Errorf("%v: forecastNext(%v, %d) = %d, %v, expected %d, %v", test.name, test.series, test.season, got,
+				method, test.want, test.wantMethod)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package stats_test
+
+// The golden file test runs the generator over a canned set of download log rows using the in-memory store, then
+// compares the resulting stats tables (as JSON) against the checked-in golden file.  After an intentional change to
+// the aggregation, regenerate the golden file with:
+//
+//   go test ./internal/stats/ -run TestGolden -update
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store/memstore"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// loadLogCSV reads download log rows from a CSV fixture
+func loadLogCSV(t *testing.T, path string) []memstore.LogEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []memstore.LogEntry
+	for _, r := range records[1:] { // Skip the header row
+		requestTime, err := time.Parse(time.RFC3339, r[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := strconv.Atoi(r[5])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, memstore.LogEntry{
+			RequestTime:     requestTime,
+			ClientIPv4:      r[1],
+			ClientIPv6:      r[2],
+			ClientIPStrange: r[3],
+			Request:         r[4],
+			Status:          status,
+			UserAgent:       r[6],
+		})
+	}
+	return entries
+}
+
+// aggregates converts the stats tables of the in-memory store into a stable structure for comparison, keyed by table
+// name then stats date then version number (users) or download ID (downloads).  Zero counts are left out, apart from
+// the totals
+func aggregates(s *memstore.Store) map[string]map[string]map[string]int64 {
+	versions := make(map[int]string)
+	for v, id := range s.Releases {
+		versions[id] = v
+	}
+	out := make(map[string]map[string]map[string]int64)
+	for _, fam := range stats.Families() {
+		table := make(map[string]map[string]int64)
+		for date, row := range s.Tables[fam.Table] {
+			r := make(map[string]int64)
+			for id, count := range row {
+				if count == 0 && id != fam.TotalID {
+					continue
+				}
+				key := strconv.Itoa(id)
+				if fam.TotalColumn == "db4s_release" {
+					key = versions[id]
+				}
+				r[key] = count
+			}
+			table[date.Format("2006-01-02")] = r
+		}
+		out[fam.Table] = table
+	}
+	return out
+}
+
+func TestGolden(t *testing.T) {
+	s := memstore.New()
+	s.Log = loadLogCSV(t, filepath.Join("testdata", "download_log.csv"))
+
+	// Run as if it's the start of September 2018, so only the fixture month is processed
+	now := time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC)
+	gen := stats.Generator{DB: s, Mode: stats.ModeFull, Now: func() time.Time { return now }}
+	if err := gen.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.MarshalIndent(aggregates(s), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	goldenFile := filepath.Join("testdata", "golden", "aggregates.json")
+	if *update {
+		if err = os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(goldenFile, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Aggregates don't match %v (rerun with -update if the change is intended)\ngot:\n%s", goldenFile, got)
+	}
+}
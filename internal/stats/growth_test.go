@@ -0,0 +1,58 @@
+package stats_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store/memstore"
+)
+
+func TestGrowth(t *testing.T) {
+	month := func(m time.Month) time.Time { return time.Date(2024, m, 1, 0, 0, 0, 0, time.UTC) }
+	s := memstore.New()
+	s.Releases["3.12.2"] = 2
+	for m, IPs := range map[time.Month]int{time.January: 2, time.February: 3, time.March: 3, time.May: 1} {
+		for i := 0; i < IPs; i++ {
+			s.Log = append(s.Log, memstore.LogEntry{
+				RequestTime: month(m).Add(time.Hour),
+				ClientIPv4:  fmt.Sprintf("10.0.0.%d", i+1),
+				Request:     "/currentrelease",
+				Status:      200,
+				UserAgent:   "sqlitebrowser 3.12.2",
+			})
+		}
+	}
+	fam, _ := stats.FamilyByName(stats.FamilyUsersMonthly)
+	gen := stats.Generator{DB: s}
+
+	// The months are processed out of order, as when backfilling, so the following months have to be updated too
+	for _, m := range []time.Month{time.March, time.January, time.February, time.April, time.May} {
+		if err := gen.ProcessPeriod(context.Background(), fam, month(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		month  time.Month
+		want   float64
+		wantOK bool
+	}{
+		{time.January, 0, false}, // Nothing to compare against
+		{time.February, 50, true},
+		{time.March, 0, true},
+		{time.April, -100, true},
+		{time.May, 0, false}, // Nor when the previous month has no users
+	}
+	for _, test := range tests {
+		got, ok := s.Growth[fam.Table][month(test.month)][fam.TotalID]
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("growth of the %v total = %v, %v, expected %v, %v", test.month, got, ok, test.want, test.wantOK)
+		}
+	}
+	if got := s.Growth[fam.Table][month(time.February)][2]; got != 50 {
+		t.Errorf("growth of the February 3.12.2 users = %v, expected 50", got)
+	}
+}
@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"context"
+	"log"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// ImpactDays is the number of days either side of a release date compared for the release impact
+const ImpactDays = 7
+
+// ReleaseImpact saves the totals of each daily metric family processed by this run in the days before and after each
+// release date in db4s_release_info, so the effect of each release announcement can be measured.  The days before
+// a release end the day before it, and the days after start with the release day.  Only the releases whose windows
+// include a day processed by this run are recalculated, and those without a full window after them yet are left out
+func (g *Generator) ReleaseImpact(ctx context.Context) error {
+	releases, err := g.DB.DatedReleases(ctx)
+	if err != nil {
+		return err
+	}
+	today := Daily.Start(g.now())
+	for _, fam := range g.families() {
+		from, ok := g.earliest[fam.Name]
+		if fam.Granularity != Daily || !ok {
+			continue
+		}
+		saved := 0
+		for id, released := range releases {
+			day := Daily.Start(released)
+			before := day.AddDate(0, 0, -ImpactDays)
+			after := day.AddDate(0, 0, ImpactDays)
+			if before.Before(fam.FirstPeriod) || after.After(today) || !after.After(from) {
+				continue
+			}
+			history, err := g.DB.StatsRange(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, before, after)
+			if err != nil {
+				return err
+			}
+			impact := store.ReleaseImpact{ReleaseID: id, Family: fam.Name, ReleaseDate: day, WindowDays: ImpactDays}
+			for d, counts := range history {
+				if d.Before(day) {
+					impact.Before += counts[fam.TotalID]
+				} else {
+					impact.After += counts[fam.TotalID]
+				}
+			}
+			err = g.DB.SaveReleaseImpact(ctx, impact)
+			if err != nil {
+				return err
+			}
+			saved++
+		}
+		if g.Verbosity >= verbosity.Verbose && saved > 0 {
+			log.Printf("Saved the release impact of %d release(s) for %v\n", saved, fam.what)
+		}
+	}
+	return nil
+}
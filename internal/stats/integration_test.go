@@ -0,0 +1,281 @@
+//go:build integration
+
+// The integration tests start a throwaway PostgreSQL container with dockertest, create the stats schema plus a fixture
+// download_log table, run the generator against it, then check the contents of the stats tables.  Run them with:
+//
+//   go test -tags integration ./internal/stats/
+
+package stats_test
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+const pgPassword = "integration"
+
+// pgContainer is a running throwaway PostgreSQL container
+type pgContainer struct {
+	port int
+}
+
+// startPG starts a PostgreSQL container with dockertest, waiting until it accepts connections.  The container is
+// removed when the test finishes
+func startPG(t *testing.T) *pgContainer {
+	t.Helper()
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("Docker not available, skipping integration test: %v", err)
+	}
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "16-alpine",
+		Env:        []string{"POSTGRES_PASSWORD=" + pgPassword, "POSTGRES_DB=db4s"},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("Starting PostgreSQL container failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Purge(resource); err != nil {
+			t.Logf("Removing PostgreSQL container failed: %v", err)
+		}
+	})
+	c := &pgContainer{}
+	c.port, err = strconv.Atoi(resource.GetPort("5432/tcp"))
+	if err != nil {
+		t.Fatalf("Unexpected PostgreSQL container port '%v': %v", resource.GetPort("5432/tcp"), err)
+	}
+
+	// The image only listens on TCP once its initialisation has finished, so wait for a successful connection
+	pool.MaxWait = time.Minute
+	err = pool.Retry(func() error {
+		conn, err := pgx.Connect(context.Background(), c.connString())
+		if err != nil {
+			return err
+		}
+		return conn.Close(context.Background())
+	})
+	if err != nil {
+		t.Fatalf("PostgreSQL container didn't become ready: %v", err)
+	}
+	return c
+}
+
+func (c *pgContainer) connString() string {
+	return fmt.Sprintf("host=127.0.0.1 port=%d user=postgres password=%s dbname=db4s sslmode=disable", c.port, pgPassword)
+}
+
+// statsRows returns the non-zero rows of a stats table, keyed by stats date then version number or download ID
+func statsRows(t *testing.T, conn *pgx.Conn, dbQuery string) map[string]map[string]int {
+	t.Helper()
+	rows, err := conn.Query(context.Background(), dbQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make(map[string]map[string]int)
+	for rows.Next() {
+		var date time.Time
+		var key string
+		var count int
+		if err = rows.Scan(&date, &key, &count); err != nil {
+			t.Fatal(err)
+		}
+		d := date.Format("2006-01-02")
+		if got[d] == nil {
+			got[d] = make(map[string]int)
+		}
+		got[d][key] = count
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func checkRows(t *testing.T, table string, got, want map[string]map[string]int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%v: got stats for %d dates, want %d: %v", table, len(got), len(want), got)
+	}
+	for date, wantRow := range want {
+		gotRow := got[date]
+		if len(gotRow) != len(wantRow) {
+			t.Errorf("%v %v: got %v, want %v", table, date, gotRow, wantRow)
+			continue
+		}
+		for key, count := range wantRow {
+			if gotRow[key] != count {
+				t.Errorf("%v %v %v: got %d, want %d", table, date, key, gotRow[key], count)
+			}
+		}
+	}
+}
+
+func TestGeneratorIntegration(t *testing.T) {
+	pg := startPG(t)
+	ctx := context.Background()
+	db, err := store.Open(ctx, config.PGInfo{
+		Database:       "db4s",
+		NumConnections: 2,
+		Password:       pgPassword,
+		Port:           pg.port,
+		Server:         "127.0.0.1",
+		Username:       "postgres",
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.InitSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := pgx.Connect(ctx, pg.connString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	fixture, err := os.ReadFile(filepath.Join("testdata", "download_log.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Exec(ctx, string(fixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run as if it's the start of September 2018, so only the fixture month is processed
+	now := time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC)
+	gen := stats.Generator{DB: db, Mode: stats.ModeFull, Now: func() time.Time { return now }}
+	if err = gen.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// * Users *
+	usersQuery := `
+		SELECT u.stats_date, r.version_number, u.unique_ips
+		FROM %s u, db4s_release_info r
+		WHERE u.db4s_release = r.release_id
+			AND u.unique_ips > 0`
+	checkRows(t, "db4s_users_daily", statsRows(t, conn, fmt.Sprintf(usersQuery, "db4s_users_daily")),
+		map[string]map[string]int{
+			"2018-08-13": {"Unique IPs": 3, "3.10.1": 2, "3.11.0": 1},
+			"2018-08-14": {"Unique IPs": 1, "3.11.0": 1},
+			"2018-08-20": {"Unique IPs": 1, "3.11.0": 1},
+			"2018-08-21": {"Unique IPs": 1, "3.11.0": 1},
+		})
+	checkRows(t, "db4s_users_weekly", statsRows(t, conn, fmt.Sprintf(usersQuery, "db4s_users_weekly")),
+		map[string]map[string]int{
+			"2018-08-13": {"Unique IPs": 3, "3.10.1": 2, "3.11.0": 2},
+			"2018-08-20": {"Unique IPs": 2, "3.11.0": 2},
+		})
+	checkRows(t, "db4s_users_monthly", statsRows(t, conn, fmt.Sprintf(usersQuery, "db4s_users_monthly")),
+		map[string]map[string]int{
+			"2018-08-01": {"Unique IPs": 4, "3.10.1": 2, "3.11.0": 4},
+		})
+
+	// * Downloads *
+	downloadsQuery := `
+		SELECT stats_date, db4s_download::text, num_downloads
+		FROM %s
+		WHERE num_downloads > 0`
+	checkRows(t, "db4s_downloads_daily", statsRows(t, conn, fmt.Sprintf(downloadsQuery, "db4s_downloads_daily")),
+		map[string]map[string]int{
+			"2018-08-09": {"0": 3, "1": 1, "3": 2},
+			"2018-08-13": {"0": 1, "4": 1},
+			"2018-08-31": {"0": 1, "1": 1},
+		})
+	checkRows(t, "db4s_downloads_weekly", statsRows(t, conn, fmt.Sprintf(downloadsQuery, "db4s_downloads_weekly")),
+		map[string]map[string]int{
+			"2018-08-06": {"0": 3, "1": 1, "3": 2},
+			"2018-08-13": {"0": 1, "4": 1},
+			"2018-08-27": {"0": 1, "1": 1},
+		})
+	checkRows(t, "db4s_downloads_monthly", statsRows(t, conn, fmt.Sprintf(downloadsQuery, "db4s_downloads_monthly")),
+		map[string]map[string]int{
+			"2018-08-01": {"0": 5, "1": 2, "3": 2, "4": 1},
+		})
+
+	// Run the users query directly too, estimating the users so both of its callers are covered
+	db.EstimateUsers(1)
+	start := time.Date(2018, 8, 1, 0, 0, 0, 0, time.UTC)
+	IPs, userAgentIPs, err := db.GetIPs(ctx, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IPs != 4 || len(userAgentIPs) != 2 {
+		t.Errorf("GetIPs: got %d IPs over %d user agents, want 4 over 2", IPs, len(userAgentIPs))
+	}
+	users, _, err := db.GetEstimatedUsers(ctx, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users < IPs {
+		t.Errorf("GetEstimatedUsers: got %d users, want at least the %d unique IPs", users, IPs)
+	}
+
+	// db4s_client_ip() should resolve the client IP addresses the same way as ResolveClientIP
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	for _, c := range [][2]string{
+		{"10.0.0.1", ""},
+		{"198.51.100.7", "203.0.113.5"},
+		{"2001:db8::1", "203.0.113.5"},
+		{"10.0.0.1", "192.0.2.1, 203.0.113.5, 10.0.0.2"},
+		{"10.0.0.1", "10.0.0.3, 10.0.0.2"},
+		{"10.0.0.1", "203.0.113.5, ,"},
+		{"10.0.0.1", "203.0.113.5, not-an-ip"},
+		{"garbage", "203.0.113.5"},
+	} {
+		var got string
+		err = conn.QueryRow(ctx, `SELECT db4s_client_ip($1, $2, $3)`, c[0], c[1], trusted).Scan(&got)
+		if err != nil {
+			t.Fatalf("db4s_client_ip(%q, %q): %v", c[0], c[1], err)
+		}
+		if want := store.ResolveClientIP(c[0], c[1], trusted); got != want {
+			t.Errorf("db4s_client_ip(%q, %q) = %q, want %q as per ResolveClientIP", c[0], c[1], got, want)
+		}
+	}
+
+	// Every completed time period should have a totals row, even when there was nothing to count
+	var numDays int
+	err = conn.QueryRow(ctx, `SELECT count(*) FROM db4s_users_daily WHERE db4s_release = 1`).Scan(&numDays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numDays != 19 {
+		t.Errorf("db4s_users_daily: got %d totals rows, want 19 (2018-08-13 to 2018-08-31)", numDays)
+	}
+
+	// The watermarks should record everything up to the fixed "now" as fully processed
+	for _, fam := range stats.Families() {
+		var lastProcessed time.Time
+		err = conn.QueryRow(ctx, `SELECT last_processed FROM stats_processing_state WHERE metric_family = $1`,
+			fam.Name).Scan(&lastProcessed)
+		if err != nil {
+			t.Fatalf("%v: %v", fam.Name, err)
+		}
+		if want := fam.Granularity.Start(now); !lastProcessed.Equal(want) {
+			t.Errorf("%v: got watermark %v, want %v", fam.Name, lastProcessed, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// Kinds of metric family, which decide the metrics generated for them
+const (
+	KindDownloads = "downloads"
+	KindUsers     = "users"
+)
+
+// Metric is one set of stats generated for each time period of a metric family.  New metrics (eg countries or
+// platforms) are added by implementing it in their own file, then adding it to the Metrics list (or calling
+// RegisterMetric from an init function)
+type Metric interface {
+	// Name identifies the metric in error messages.  eg "referrers"
+	Name() string
+
+	// Query reads the data for the time period, eg from the download logs.  A nil result means there's nothing to
+	// save, eg because the metric isn't enabled
+	Query(ctx context.Context, db store.Store, p *Period) (any, error)
+
+	// Aggregate turns the queried data into the values to save
+	Aggregate(p *Period, data any) (any, error)
+
+	// Save saves the values for the time period, returning the number of rows added to the family's stats table
+	Save(ctx context.Context, db store.Store, p *Period, values any) (rows int, err error)
+}
+
+// Period is the time period of a metric family the metrics are being generated for
+type Period struct {
+	Family    Family
+	StartDate time.Time
+	EndDate   time.Time
+
+	// The total of the time period (eg the unique IP addresses), set by the first metric of the family.  The other
+	// metrics can build on it
+	Total int64
+}
+
+// Metrics holds the metrics generated for each kind of metric family, in the order they're generated.  The first of
+// each is the main one, which saves the rows of the family's stats table and sets the total
+var Metrics = map[string][]Metric{
+	KindDownloads: {
+		downloadsMetric{},
+		uniqueDownloadsMetric{},
+		bandwidthMetric{},
+		failedDownloadsMetric{},
+		referrersMetric{},
+		excludedTrafficMetric{},
+		heavyHittersMetric{},
+		channelRollupMetric{},
+	},
+	KindUsers: {
+		usersMetric{},
+		estimatedUsersMetric{},
+		updatePendingMetric{},
+		osUsersMetric{},
+		checkInsMetric{},
+		excludedTrafficMetric{},
+		heavyHittersMetric{},
+	},
+}
+
+// RegisterMetric adds a metric to those generated for a kind of metric family, after the existing ones
+func RegisterMetric(kind string, m Metric) {
+	Metrics[kind] = append(Metrics[kind], m)
+}
+
+// generateMetrics generates and saves each metric of a metric family for a time period, returning the total and the
+// number of rows saved.  The values saved by each metric are passed to emit too
+func generateMetrics(ctx context.Context, db store.Store, fam Family, startDate, endDate time.Time, emit func(*Period, Metric, any) error) (total int64, rows int, err error) {
+	p := &Period{Family: fam, StartDate: startDate, EndDate: endDate}
+	for _, m := range Metrics[fam.Kind] {
+		var data any
+		data, err = m.Query(ctx, db, p)
+		if err != nil {
+			return
+		}
+		if data == nil {
+			continue
+		}
+		var values any
+		values, err = m.Aggregate(p, data)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%v metric: %w", m.Name(), err)
+		}
+		var n int
+		n, err = m.Save(ctx, db, p, values)
+		if err != nil {
+			return
+		}
+		rows += n
+		err = emit(p, m, values)
+		if err != nil {
+			return
+		}
+	}
+	return p.Total, rows, nil
+}
+
+// passThrough is embedded by the metrics saving exactly what they queried
+type passThrough struct{}
+
+func (passThrough) Aggregate(_ *Period, data any) (any, error) {
+	return data, nil
+}
+
+// excludedTrafficMetric is the number of requests from the excluded networks, which are version checks for the users
+// metric families and download requests for the downloads ones.  They're saved in their own table
+type excludedTrafficMetric struct{}
+
+func (excludedTrafficMetric) Name() string {
+	return "excluded traffic"
+}
+
+func (excludedTrafficMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	excluded, err := db.GetExcludedTraffic(ctx, p.StartDate, p.EndDate)
+	if err != nil || excluded == nil {
+		return nil, err
+	}
+	return excluded, nil
+}
+
+func (excludedTrafficMetric) Aggregate(p *Period, data any) (any, error) {
+	excluded := data.(*store.ExcludedTraffic)
+	if p.Family.Kind == KindUsers {
+		return excluded.VersionChecks, nil
+	}
+	return excluded.Downloads, nil
+}
+
+func (excludedTrafficMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	return 0, db.SaveExcludedTraffic(ctx, p.Family.Name, p.StartDate, values.(int64))
+}
+
+// heavyHitterCounts is the number of IP addresses left out for making too many requests, and the requests they made
+type heavyHitterCounts struct {
+	ips      int64
+	requests int64
+}
+
+// heavyHittersMetric is the number of IP addresses left out of the stats for making too many requests on a single day
+// (version checks for the users families, download requests for the downloads families), saved in its own table
+type heavyHittersMetric struct{}
+
+func (heavyHittersMetric) Name() string {
+	return "heavy hitters"
+}
+
+func (heavyHittersMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	heavy, err := db.GetHeavyHitters(ctx, p.StartDate, p.EndDate)
+	if err != nil || heavy == nil {
+		return nil, err
+	}
+	return heavy, nil
+}
+
+func (heavyHittersMetric) Aggregate(p *Period, data any) (any, error) {
+	heavy := data.(*store.HeavyHitters)
+	if p.Family.Kind == KindUsers {
+		return heavyHitterCounts{heavy.CheckIPs, heavy.Checks}, nil
+	}
+	return heavyHitterCounts{heavy.DownloadIPs, heavy.Downloads}, nil
+}
+
+func (heavyHittersMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	counts := values.(heavyHitterCounts)
+	return 0, db.SaveHeavyHitters(ctx, p.Family.Name, p.StartDate, counts.ips, counts.requests)
+}
@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+)
+
+// Granularity is the length of the time periods a metric family is generated for
+type Granularity int
+
+const (
+	Daily Granularity = iota
+	Weekly
+	Monthly
+)
+
+// String returns the name of the granularity.  eg "daily"
+func (g Granularity) String() string {
+	switch g {
+	case Weekly:
+		return "weekly"
+	case Monthly:
+		return "monthly"
+	default:
+		return "daily"
+	}
+}
+
+// Label returns a human readable description of the time period starting at the given date
+func (g Granularity) Label(startDate time.Time) string {
+	switch g {
+	case Weekly:
+		yr, wk := startDate.ISOWeek()
+		return fmt.Sprintf("week %v, %v", yr, wk)
+	case Monthly:
+		return "month " + startDate.Format("2006 Jan")
+	default:
+		return startDate.Format("2006 Jan 2")
+	}
+}
+
+// Next returns the start of the time period following the one starting at the given date
+func (g Granularity) Next(startDate time.Time) time.Time {
+	switch g {
+	case Weekly:
+		return startDate.AddDate(0, 0, 7)
+	case Monthly:
+		return startDate.AddDate(0, 1, 0)
+	default:
+		return startDate.AddDate(0, 0, 1)
+	}
+}
+
+// Previous returns the start of the time period preceding the one starting at the given date
+func (g Granularity) Previous(startDate time.Time) time.Time {
+	switch g {
+	case Weekly:
+		return startDate.AddDate(0, 0, -7)
+	case Monthly:
+		return startDate.AddDate(0, -1, 0)
+	default:
+		return startDate.AddDate(0, 0, -1)
+	}
+}
+
+// Start returns the start of the time period containing the given time.  Weeks start on Mondays, as per ISO 8601
+func (g Granularity) Start(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch g {
+	case Weekly:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progressBarWidth is the number of characters in the progress bar, between the brackets
+const progressBarWidth = 30
+
+// progress reports how far through a run the processing is, with an ETA based on the average time taken per time
+// period so far
+type progress struct {
+	w       io.Writer
+	bar     bool
+	total   int
+	done    int
+	started time.Time
+}
+
+// step reports a processed time period
+func (p *progress) step(fam Family, startDate time.Time) {
+	p.done++
+	label := fmt.Sprintf("%v %v", fam.Name, fam.Granularity.Label(startDate))
+
+	// Time periods outside of the planned ones (eg gaps being filled) can't be given an ETA
+	if p.done > p.total || p.total == 0 {
+		if !p.bar {
+			fmt.Fprintf(p.w, "Processed %v\n", label)
+		}
+		return
+	}
+
+	elapsed := time.Since(p.started)
+	eta := time.Duration(int64(elapsed) / int64(p.done) * int64(p.total-p.done)).Round(time.Second)
+	pct := float64(p.done) * 100 / float64(p.total)
+	if !p.bar {
+		fmt.Fprintf(p.w, "[%d/%d] Processed %v (%.1f%%, ETA %v)\n", p.done, p.total, label, pct, eta)
+		return
+	}
+
+	// Redraw the bar on the same line
+	filled := p.done * progressBarWidth / p.total
+	fmt.Fprintf(p.w, "\r\033[K[%v%v] %d/%d %.0f%% ETA %v  %v", strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled), p.done, p.total, pct, eta, label)
+	if p.done == p.total {
+		fmt.Fprintln(p.w)
+	}
+}
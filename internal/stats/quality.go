@@ -0,0 +1,29 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// CheckQuality counts the suspicious rows of the download logs from the given date onwards, logging each type found as
+// a warning.  The report is saved too when SaveQuality is set
+func (g *Generator) CheckQuality(ctx context.Context, from time.Time) error {
+	report, err := g.DB.CheckQuality(ctx, from, g.now())
+	if err != nil {
+		return err
+	}
+	for _, issue := range report.Issues() {
+		g.warn(fmt.Sprintf("Data quality: %v since %v", issue, from.Format(time.DateOnly)))
+	}
+	if g.Verbosity >= verbosity.Verbose && len(report.Issues()) == 0 {
+		log.Printf("Data quality: no suspicious rows since %v\n", from.Format(time.DateOnly))
+	}
+	if g.SaveQuality {
+		return g.DB.SaveQualityReport(ctx, report)
+	}
+	return nil
+}
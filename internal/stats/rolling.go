@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// RollingWindows are the number of days averaged over for the rolling averages of the daily metric families
+var RollingWindows = []int{7, 30}
+
+// RollingAverages saves the rolling averages of the daily totals for each daily metric family processed by this run.
+// Each day's averages cover the window of days ending with it, so they're recalculated from the earliest day processed
+// onwards.  The current (still incomplete) day is left out, as are the days without a full window of history before
+// them
+func (g *Generator) RollingAverages(ctx context.Context) error {
+	longest := 0
+	for _, days := range RollingWindows {
+		longest = max(longest, days)
+	}
+	for _, fam := range g.families() {
+		from, ok := g.earliest[fam.Name]
+		if fam.Granularity != Daily || !ok {
+			continue
+		}
+		today := Daily.Start(g.now())
+		historyStart := from.AddDate(0, 0, 1-longest)
+		if historyStart.Before(fam.FirstPeriod) {
+			historyStart = fam.FirstPeriod
+		}
+		history, err := g.DB.StatsRange(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, historyStart, today)
+		if err != nil {
+			return err
+		}
+
+		for d := from; d.Before(today); d = Daily.Next(d) {
+			averages := make(map[int]float64)
+			for _, days := range RollingWindows {
+				windowStart := d.AddDate(0, 0, 1-days)
+				if windowStart.Before(fam.FirstPeriod) {
+					continue
+				}
+
+				// Days without a totals row count as zero, as with the forecasts
+				var sum int64
+				for w := windowStart; !w.After(d); w = Daily.Next(w) {
+					sum += history[w][fam.TotalID]
+				}
+				averages[days] = float64(sum) / float64(days)
+			}
+			if len(averages) == 0 {
+				continue
+			}
+			err = g.DB.SaveRollingAverages(ctx, fam.Name, d, averages)
+			if err != nil {
+				return err
+			}
+		}
+		if g.Verbosity >= verbosity.Verbose {
+			log.Printf("Saved the rolling averages of %v from %v\n", fam.what, from.Format(time.DateOnly))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package stats_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store/memstore"
+)
+
+func TestRollingAverages(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	fam, _ := stats.FamilyByName(stats.FamilyUsersDaily)
+	fam.FirstPeriod = day(1)
+
+	// The totals saved by earlier runs are 10 times the day of the month, with nothing saved for the 10th.  This run
+	// then processes the 6th, with 2 users
+	s := memstore.New()
+	s.Releases["3.12.2"] = 2
+	s.Tables[fam.Table] = make(map[time.Time]map[int]int64)
+	for d := 1; d < 10; d++ {
+		s.Tables[fam.Table][day(d)] = map[int]int64{fam.TotalID: int64(10 * d)}
+	}
+	for i := 0; i < 2; i++ {
+		s.Log = append(s.Log, memstore.LogEntry{
+			RequestTime: day(6).Add(time.Hour),
+			ClientIPv4:  fmt.Sprintf("10.0.0.%d", i+1),
+			Request:     "/currentrelease",
+			Status:      200,
+			UserAgent:   "sqlitebrowser 3.12.2",
+		})
+	}
+	now := day(11).Add(time.Hour)
+	gen := stats.Generator{DB: s, Families: []stats.Family{fam}, Now: func() time.Time { return now }}
+	ctx := context.Background()
+	if err := gen.ProcessPeriod(ctx, fam, day(6)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.RollingAverages(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		day    int
+		want   float64
+		wantOK bool
+	}{
+		{5, 0, false}, // Before the earliest day processed
+		{6, 0, false}, // Without a full week of history
+		{7, 222.0 / 7, true},
+		{8, 292.0 / 7, true},
+		{9, 362.0 / 7, true},
+		{10, 332.0 / 7, true}, // The missing day counts as zero
+		{11, 0, false},        // The current day is still incomplete
+	}
+	for _, test := range tests {
+		averages := s.RollingAverages[fam.Name][day(test.day)]
+		got, ok := averages[7]
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("7 day average for the %dth = %v, %v, expected %v, %v", test.day, got, ok, test.want, test.wantOK)
+		}
+		if _, ok = averages[30]; ok {
+			t.Errorf("unexpected 30 day average for the %dth, without 30 days of history", test.day)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+// Package stats generates the daily, weekly and monthly DB4S users and downloads stats from the download logs
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// Mode selects which time periods a run processes
+type Mode int
+
+const (
+	// ModeResume processes each metric family from the end of its last fully processed time period onwards
+	ModeResume Mode = iota
+
+	// ModeDaily only processes the current time period and the one immediately preceding it
+	ModeDaily
+
+	// ModeFull ignores any saved progress, processing everything from the first day with data onwards
+	ModeFull
+)
+
+// String returns the name of the mode, as recorded in the stats_runs table
+func (m Mode) String() string {
+	switch m {
+	case ModeDaily:
+		return "daily"
+	case ModeFull:
+		return "full"
+	default:
+		return "resume"
+	}
+}
+
+// Generator generates and saves the stats for each metric family
+type Generator struct {
+	DB   store.Store
+	Mode Mode
+
+	// How much logging output to give
+	Verbosity verbosity.Level
+
+	// The metric families to process.  Defaults to all of them when nil
+	Families []Family
+
+	// Returns the current time.  Defaults to time.Now() when nil, but can be set to run against fixed dates
+	Now func() time.Time
+
+	// Whether to save the data quality report of each run, as well as logging its issues as warnings
+	SaveQuality bool
+
+	// Where to report the progress of each time period processed, with an ETA.  No progress is reported when nil.  If
+	// ProgressBar is set, a progress bar redrawn in place is shown instead (eg when attached to a terminal)
+	Progress    io.Writer
+	ProgressBar bool
+
+	// Whether to skip the completed time periods whose download logs haven't changed since they were last processed,
+	// going by their fingerprints.  This makes full runs much faster, but isn't suitable after changing what's
+	// generated (eg enabling a new metric)
+	SkipUnchanged bool
+
+	// Where to write every aggregate saved, as JSON Lines, in addition to saving them.  Nothing is written when nil
+	Emit io.Writer
+
+	earliest  map[string]time.Time // The earliest time period processed for each metric family
+	latest    time.Time            // The end of the latest time period processed
+	emitter   *json.Encoder
+	processed int
+	progress  *progress
+	rows      int
+	skipped   int
+	warnings  []string
+
+	// Guards the above, as the users and downloads metric families are processed concurrently
+	mu sync.Mutex
+}
+
+// Run adds any new user agents to the db4s_release_info table (and new artifacts to db4s_download_info), checks the
+// download logs for suspicious rows, processes the time periods selected by the mode for each metric family, fills in
+// any gaps left by earlier runs, then forecasts the current time periods, saves the rolling averages of the daily
+// totals and measures the impact of each release
+func (g *Generator) Run(ctx context.Context) error {
+	// Add any new user agents to the db4s_release_info table
+	err := g.DB.UpdateUserAgents(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Add any newly released artifacts to the downloads
+	err = g.DB.UpdateDownloads(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Work out where each metric family starts from, so the number of time periods to process is known up front
+	families := g.families()
+	starts := make([]time.Time, len(families))
+	total := 0
+	for i, fam := range families {
+		starts[i], err = g.startDate(ctx, fam)
+		if err != nil {
+			return err
+		}
+		for d := starts[i]; d.Before(g.now()); d = fam.Granularity.Next(d) {
+			total++
+		}
+	}
+	// Check the download logs about to be processed for suspicious rows
+	from := g.now()
+	for _, start := range starts {
+		if start.Before(from) {
+			from = start
+		}
+	}
+	err = g.CheckQuality(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	if g.Progress != nil {
+		g.progress = &progress{w: g.Progress, bar: g.ProgressBar, total: total, started: time.Now()}
+	}
+
+	// The users and downloads metric families use different tables, so their pipelines are run concurrently.  Each
+	// processes its metric families in order, sharing the connection pool (and its limits) with the other
+	group, groupCtx := errgroup.WithContext(ctx)
+	for _, kind := range []string{KindUsers, KindDownloads} {
+		group.Go(func() error {
+			for i, fam := range families {
+				if fam.Kind != kind {
+					continue
+				}
+				if err := g.processFamily(groupCtx, fam, starts[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	err = group.Wait()
+	if err != nil {
+		return err
+	}
+
+	// If earlier runs were missed (eg the cron job didn't run for a few days), some completed time periods won't have
+	// any stats saved for them.  Detect those, and process just the missing time periods
+	err = g.FillGaps(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Project the totals of the current weekly and monthly time periods
+	err = g.Forecast(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Smooth the daily totals into rolling averages, for the trend lines
+	err = g.RollingAverages(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Compare the daily totals either side of each release date
+	err = g.ReleaseImpact(ctx)
+	if err != nil {
+		return err
+	}
+	if g.progress != nil {
+		fmt.Fprintf(g.Progress, "Processed %d time period(s), saving %d row(s), in %v\n", g.processed, g.rows,
+			time.Since(g.progress.started).Round(time.Second))
+		if g.skipped > 0 {
+			fmt.Fprintf(g.Progress, "Skipped %d unchanged time period(s)\n", g.skipped)
+		}
+	}
+	return nil
+}
+
+// FillGaps compares the time periods which have stats saved against the expected calendar for each metric family,
+// then processes any completed time periods which are missing
+func (g *Generator) FillGaps(ctx context.Context) error {
+	for _, fam := range g.families() {
+		gaps, err := g.findGaps(ctx, fam)
+		if err != nil {
+			return err
+		}
+		if len(gaps) == 0 {
+			continue
+		}
+		g.warn(fmt.Sprintf("Found %d missing time period(s) for %v, reprocessing them", len(gaps), fam.Name))
+		for _, startDate := range gaps {
+			err = g.ProcessPeriod(ctx, fam, startDate)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ProcessPeriod generates and saves the stats of a metric family for the time period starting at the given date
+func (g *Generator) ProcessPeriod(ctx context.Context, fam Family, startDate time.Time) error {
+	total, rows, err := generateMetrics(ctx, g.DB, fam, startDate, fam.Granularity.Next(startDate), g.emit)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.processed++
+	g.rows += rows
+	if earliest, ok := g.earliest[fam.Name]; !ok || startDate.Before(earliest) {
+		if g.earliest == nil {
+			g.earliest = make(map[string]time.Time)
+		}
+		g.earliest[fam.Name] = startDate
+	}
+	if endDate := fam.Granularity.Next(startDate); endDate.After(g.latest) {
+		g.latest = endDate
+	}
+	g.mu.Unlock()
+
+	// Weekly and monthly stats also record the change from the previous time period.  The following time period is
+	// updated too, in case it was already saved (eg when backfilling)
+	if fam.Granularity != Daily {
+		prevDate, nextDate := fam.Granularity.Previous(startDate), fam.Granularity.Next(startDate)
+		err = g.DB.UpdateGrowth(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, startDate, prevDate)
+		if err == nil {
+			err = g.DB.UpdateGrowth(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, nextDate, startDate)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Display debug info if appropriate
+	if g.Verbosity >= verbosity.Verbose {
+		log.Printf("%v for %v: %v\n", fam.what, fam.Granularity.Label(startDate), total)
+	}
+	g.step(fam, startDate)
+	return nil
+}
+
+// emit writes the values saved by a metric as JSON Lines, when the aggregates are being emitted
+func (g *Generator) emit(p *Period, m Metric, values any) error {
+	if g.Emit == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.emitter == nil {
+		g.emitter = json.NewEncoder(g.Emit)
+	}
+	return emitRecords(g.emitter, p, m, values)
+}
+
+// step reports the progress of a time period being done, if progress is being reported
+func (g *Generator) step(fam Family, startDate time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.progress != nil {
+		g.progress.step(fam, startDate)
+	}
+}
+
+// findGaps returns the start dates of the completed time periods for a metric family which have no stats saved
+func (g *Generator) findGaps(ctx context.Context, fam Family) (gaps []time.Time, err error) {
+	existing, err := g.DB.StatsDates(ctx, fam.Table, fam.TotalColumn, fam.TotalID, fam.FirstPeriod)
+	if err != nil {
+		return
+	}
+
+	// Walk the expected calendar, only including time periods which have already finished
+	now := g.now()
+	for startDate := fam.FirstPeriod; !fam.Granularity.Next(startDate).After(now); startDate = fam.Granularity.Next(startDate) {
+		if _, ok := existing[startDate]; !ok {
+			gaps = append(gaps, startDate)
+		}
+	}
+	return
+}
+
+// Processed returns the number of time periods processed so far
+func (g *Generator) Processed() int {
+	return g.processed
+}
+
+// Range returns the start of the earliest time period processed so far, and the end of the latest one.  ok is false
+// when nothing has been processed
+func (g *Generator) Range() (from, to time.Time, ok bool) {
+	for _, earliest := range g.earliest {
+		if !ok || earliest.Before(from) {
+			from, ok = earliest, true
+		}
+	}
+	return from, g.latest, ok
+}
+
+// Rows returns the number of stats rows saved so far
+func (g *Generator) Rows() int {
+	return g.rows
+}
+
+// Warnings returns the warnings logged so far, for things which were handled but might need a look
+func (g *Generator) Warnings() []string {
+	return g.warnings
+}
+
+// warn logs a warning, keeping it for Warnings()
+func (g *Generator) warn(msg string) {
+	log.Println(msg)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.warnings = append(g.warnings, msg)
+}
+
+// families returns the metric families to process, as per the Families field
+func (g *Generator) families() []Family {
+	if g.Families == nil {
+		return Families()
+	}
+	return g.Families
+}
+
+// now returns the current time, as per the Now field
+func (g *Generator) now() time.Time {
+	if g.Now == nil {
+		return time.Now()
+	}
+	return g.Now()
+}
+
+// processFamily generates and saves the stats of a metric family for each time period from the given start date
+func (g *Generator) processFamily(ctx context.Context, fam Family, startDate time.Time) error {
+	for startDate.Before(g.now()) {
+		endDate := fam.Granularity.Next(startDate)
+		complete := !endDate.After(g.now())
+
+		// Skip the completed time periods whose download logs are the same as when they were last processed
+		var fp store.Fingerprint
+		var unchanged bool
+		if g.SkipUnchanged && complete {
+			var err error
+			fp, unchanged, err = g.unchanged(ctx, fam, startDate, endDate)
+			if err != nil {
+				return err
+			}
+		}
+		if unchanged {
+			g.mu.Lock()
+			g.skipped++
+			g.mu.Unlock()
+			if g.Verbosity >= verbosity.Verbose {
+				log.Printf("Skipping %v for %v, its download logs haven't changed\n", fam.what,
+					fam.Granularity.Label(startDate))
+			}
+			g.step(fam, startDate)
+		} else {
+			err := g.ProcessPeriod(ctx, fam, startDate)
+			if err != nil {
+				return err
+			}
+			if g.SkipUnchanged && complete {
+				err = g.DB.SaveFingerprint(ctx, fam.Name, startDate, fp)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Once the time period is entirely in the past it won't change, so record it as fully processed
+		if complete {
+			err := g.DB.SaveWatermark(ctx, fam.Name, endDate)
+			if err != nil {
+				return err
+			}
+		}
+		startDate = endDate
+	}
+	return nil
+}
+
+// unchanged returns the fingerprint of the download logs of a time period, and whether it matches the one saved when
+// the time period was last processed
+func (g *Generator) unchanged(ctx context.Context, fam Family, startDate, endDate time.Time) (store.Fingerprint, bool, error) {
+	fp, err := g.DB.LogsFingerprint(ctx, startDate, endDate)
+	if err != nil {
+		return fp, false, err
+	}
+	saved, ok, err := g.DB.PeriodFingerprint(ctx, fam.Name, startDate)
+	if err != nil {
+		return fp, false, err
+	}
+	return fp, ok && saved == fp, nil
+}
+
+// startDate returns the date processing of a metric family should start from
+func (g *Generator) startDate(ctx context.Context, fam Family) (time.Time, error) {
+	switch g.Mode {
+	case ModeDaily:
+		// We're running in daily mode, so we start with the time period before the current one
+		return fam.Granularity.Previous(fam.Granularity.Start(g.now())), nil
+	case ModeFull:
+		return fam.FirstPeriod, nil
+	}
+
+	// Resume from the end of the last fully processed time period, if that's later than the start of the data
+	lastProcessed, ok, err := g.DB.Watermark(ctx, fam.Name)
+	if err != nil {
+		return fam.FirstPeriod, err
+	}
+	if !ok || !lastProcessed.After(fam.FirstPeriod) {
+		return fam.FirstPeriod, nil
+	}
+	if g.Verbosity >= verbosity.Verbose {
+		log.Printf("Resuming %v from %v\n", fam.Name, lastProcessed.Format("2006 Jan 2"))
+	}
+	return lastProcessed, nil
+}
+
+// Backfill reprocesses the time periods of a single metric family between the from and to dates, leaving the saved
+// progress alone
+func (g *Generator) Backfill(ctx context.Context, fam Family, from, to time.Time) error {
+	startDate := fam.Granularity.Start(from)
+	if startDate.Before(fam.FirstPeriod) {
+		startDate = fam.FirstPeriod
+	}
+	for ; startDate.Before(to) && startDate.Before(g.now()); startDate = fam.Granularity.Next(startDate) {
+		err := g.ProcessPeriod(ctx, fam, startDate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+--
+-- Fixture download_log table for the integration tests, with a small set of August 2018 requests covering the
+-- filtering rules (duplicate IPs, excluded user agents, non-200 statuses, unknown requests)
+--
+
+CREATE TABLE public.download_log (
+    download_id bigserial PRIMARY KEY,
+    client_ipv4 text,
+    client_ipv6 text,
+    client_ip_strange text,
+    client_port integer,
+    remote_user text,
+    request_time timestamp with time zone,
+    request_type text,
+    request text,
+    protocol text,
+    status integer,
+    body_bytes_sent bigint,
+    http_referer text,
+    http_user_agent text
+);
+
+-- Version checks
+INSERT INTO public.download_log (request_time, client_ipv4, client_ipv6, client_ip_strange, request, status, http_user_agent) VALUES
+    ('2018-08-13 10:00:00+00', '1.1.1.1', NULL, NULL, '/currentrelease', 200, 'sqlitebrowser 3.10.1'),
+    ('2018-08-13 11:00:00+00', '1.1.1.1', NULL, NULL, '/currentrelease', 200, 'sqlitebrowser 3.10.1'),
+    ('2018-08-13 12:00:00+00', '2.2.2.2', NULL, NULL, '/currentrelease', 200, 'sqlitebrowser 3.10.1'),
+    ('2018-08-13 13:00:00+00', NULL, '2001:db8::1', NULL, '/currentrelease', 200, 'sqlitebrowser 3.11.0'),
+    ('2018-08-13 14:00:00+00', '3.3.3.3', NULL, NULL, '/currentrelease', 200, 'sqlitebrowser 3.10.1 AppEngine'),
+    ('2018-08-13 15:00:00+00', '4.4.4.4', NULL, NULL, '/currentrelease', 404, 'sqlitebrowser 3.10.1'),
+    ('2018-08-14 09:00:00+00', '1.1.1.1', NULL, NULL, '/currentrelease', 200, 'sqlitebrowser 3.11.0'),
+    ('2018-08-14 10:00:00+00', '5.5.5.5', NULL, NULL, '/currentrelease', 200, 'Mozilla/5.0'),
+    ('2018-08-20 08:00:00+00', '2.2.2.2', NULL, NULL, '/currentrelease', 200, 'sqlitebrowser 3.11.0'),
+    ('2018-08-21 08:00:00+00', NULL, NULL, 'unknown', '/currentrelease', 200, 'sqlitebrowser 3.11.0');
+
+-- Downloads
+INSERT INTO public.download_log (request_time, client_ipv4, request, status, http_user_agent) VALUES
+    ('2018-08-09 10:00:00+00', '6.6.6.6', '/DB.Browser.for.SQLite-3.10.1.dmg', 200, 'Mozilla/5.0'),
+    ('2018-08-09 11:00:00+00', '6.6.6.7', '/DB.Browser.for.SQLite-3.10.1-win64.exe', 200, 'Mozilla/5.0'),
+    ('2018-08-09 12:00:00+00', '6.6.6.8', '/DB.Browser.for.SQLite-3.10.1-win64.exe', 200, 'Mozilla/5.0'),
+    ('2018-08-09 13:00:00+00', '6.6.6.9', '/DB.Browser.for.SQLite-3.10.1-win32.exe', 404, 'Mozilla/5.0'),
+    ('2018-08-10 10:00:00+00', '6.6.6.6', '/index.html', 200, 'Mozilla/5.0'),
+    ('2018-08-13 10:00:00+00', '6.6.6.6', '/SQLiteDatabaseBrowserPortable_3.10.1_English.paf.exe', 200, 'Mozilla/5.0'),
+    ('2018-08-31 23:00:00+00', '6.6.6.6', '/DB.Browser.for.SQLite-3.10.1.dmg', 200, 'Mozilla/5.0');
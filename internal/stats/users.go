@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// userAgentCounts is a total along with its breakdown per user agent
+type userAgentCounts struct {
+	total        int
+	perUserAgent map[string]int
+}
+
+// usersMetric is the number of unique IP addresses doing a version check, saved in the users stats table of the family
+type usersMetric struct{}
+
+func (usersMetric) Name() string {
+	return "users"
+}
+
+func (usersMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	IPs, IPsPerUserAgent, err := db.GetIPs(ctx, p.StartDate, p.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	return userAgentCounts{IPs, IPsPerUserAgent}, nil
+}
+
+func (usersMetric) Aggregate(p *Period, data any) (any, error) {
+	p.Total = int64(data.(userAgentCounts).total)
+	return data, nil
+}
+
+func (usersMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	counts := values.(userAgentCounts)
+	var err error
+	switch p.Family.Granularity {
+	case Daily:
+		err = db.SaveDailyUsersStats(ctx, p.StartDate, counts.total, counts.perUserAgent)
+	case Weekly:
+		err = db.SaveWeeklyUsersStats(ctx, p.StartDate, counts.total, counts.perUserAgent)
+	case Monthly:
+		err = db.SaveMonthlyUsersStats(ctx, p.StartDate, counts.total, counts.perUserAgent)
+	default:
+		err = fmt.Errorf("no users stats table for %v", p.Family.Name)
+	}
+	return 1 + len(counts.perUserAgent), err
+}
+
+// estimatedUsersMetric is the estimated number of users, saved in their own column of the users stats rows
+type estimatedUsersMetric struct{ passThrough }
+
+func (estimatedUsersMetric) Name() string {
+	return "estimated users"
+}
+
+func (estimatedUsersMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	users, usersPerUserAgent, err := db.GetEstimatedUsers(ctx, p.StartDate, p.EndDate)
+	if err != nil || usersPerUserAgent == nil {
+		return nil, err
+	}
+	return userAgentCounts{users, usersPerUserAgent}, nil
+}
+
+func (estimatedUsersMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	counts := values.(userAgentCounts)
+	return 0, db.SaveEstimatedUsers(ctx, p.Family.Table, p.StartDate, counts.total, counts.perUserAgent)
+}
+
+// updatePendingMetric is the percentage of users on an out of date release, saved in its own column of the users
+// stats rows
+type updatePendingMetric struct{ passThrough }
+
+func (updatePendingMetric) Name() string {
+	return "update pending"
+}
+
+func (updatePendingMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	pending, pendingPerUserAgent, err := db.GetUpdatePending(ctx, p.StartDate, p.EndDate)
+	if err != nil || pendingPerUserAgent == nil {
+		return nil, err
+	}
+	return userAgentCounts{pending, pendingPerUserAgent}, nil
+}
+
+func (updatePendingMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	counts := values.(userAgentCounts)
+	return 0, db.SaveUpdatePending(ctx, p.Family.Table, p.StartDate, counts.total, counts.perUserAgent)
+}
+
+// osUsersMetric is the number of unique IP addresses per OS, saved in their own table
+type osUsersMetric struct{ passThrough }
+
+func (osUsersMetric) Name() string {
+	return "users per OS"
+}
+
+func (osUsersMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	perOS, err := db.GetOSUsers(ctx, p.StartDate, p.EndDate)
+	if err != nil || perOS == nil {
+		return nil, err
+	}
+	return perOS, nil
+}
+
+func (osUsersMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	return 0, db.SaveOSUsers(ctx, p.Family.Name, p.StartDate, values.(map[string]int))
+}
+
+// checkInsMetric is the distribution of the daily number of version checks per IP address, saved in its own table
+type checkInsMetric struct{ passThrough }
+
+func (checkInsMetric) Name() string {
+	return "check-ins"
+}
+
+func (checkInsMetric) Query(ctx context.Context, db store.Store, p *Period) (any, error) {
+	buckets, err := db.GetCheckIns(ctx, p.StartDate, p.EndDate)
+	if err != nil || buckets == nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+func (checkInsMetric) Save(ctx context.Context, db store.Store, p *Period, values any) (int, error) {
+	return 0, db.SaveCheckIns(ctx, p.Family.Name, p.StartDate, values.(map[string]int))
+}
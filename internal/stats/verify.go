@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// Mismatch is a saved stats value which differs from a freshly recomputed one
+type Mismatch struct {
+	Family     string
+	Date       time.Time
+	ID         int
+	Stored     int64
+	Recomputed int64
+}
+
+// Verify recomputes the stats of each metric family for the completed time periods between the from and to dates,
+// returning any which differ from the saved stats.  Missing rows are treated as zero.  If fix is set, the time
+// periods with mismatches are reprocessed, overwriting the saved stats
+func (g *Generator) Verify(ctx context.Context, from, to time.Time, fix bool) (mismatches []Mismatch, err error) {
+	for _, fam := range g.families() {
+		startDate := fam.Granularity.Start(from)
+		if startDate.Before(fam.FirstPeriod) {
+			startDate = fam.FirstPeriod
+		}
+		for ; startDate.Before(to); startDate = fam.Granularity.Next(startDate) {
+			// Time periods still in progress are expected to change, so only verify completed ones
+			endDate := fam.Granularity.Next(startDate)
+			if endDate.After(g.now()) {
+				break
+			}
+
+			var found []Mismatch
+			found, err = g.verifyPeriod(ctx, fam, startDate, endDate)
+			if err != nil {
+				return
+			}
+			mismatches = append(mismatches, found...)
+
+			if fix && len(found) > 0 {
+				log.Printf("Reprocessing %v for %v\n", fam.Name, fam.Granularity.Label(startDate))
+				err = g.ProcessPeriod(ctx, fam, startDate)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// verifyPeriod compares the saved stats of a metric family for a single time period against freshly recomputed ones
+func (g *Generator) verifyPeriod(ctx context.Context, fam Family, startDate, endDate time.Time) ([]Mismatch, error) {
+	stored, err := g.DB.StoredStats(ctx, fam.Table, fam.TotalColumn, fam.ValueColumn, startDate)
+	if err != nil {
+		return nil, err
+	}
+	recomputed, err := fam.recompute(ctx, g.DB, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every ID present on either side
+	ids := make(map[int]struct{})
+	for id := range stored {
+		ids[id] = struct{}{}
+	}
+	for id := range recomputed {
+		ids[id] = struct{}{}
+	}
+	var mismatches []Mismatch
+	for id := range ids {
+		if stored[id] != recomputed[id] {
+			mismatches = append(mismatches, Mismatch{
+				Family:     fam.Name,
+				Date:       startDate,
+				ID:         id,
+				Stored:     stored[id],
+				Recomputed: recomputed[id],
+			})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].ID < mismatches[j].ID })
+	return mismatches, nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// OldestLogTime returns the time of the oldest row in the download_log table.  ok is false when it's empty
+func (db *DB) OldestLogTime(ctx context.Context) (oldest time.Time, ok bool, err error) {
+	var t *time.Time
+	err = db.queryRow(ctx, `SELECT min(request_time) FROM download_log`).Scan(&t)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	if t == nil {
+		return
+	}
+	return t.UTC(), true, nil
+}
+
+// ReadLogs calls fn for each row of the download_log table in the given date range, in request time order.  The values
+// are in the order of the download_log columns (as per LogEntry), with nil for NULLs, so the rows can be archived
+// exactly.  The port and status are int32s, the body bytes sent an int64, the request time a time.Time and the rest
+// strings
+func (db *DB) ReadLogs(ctx context.Context, startDate, endDate time.Time, fn func(values []any) error) (n int64, err error) {
+	dbQuery := `
+		SELECT client_ipv4, client_ipv6, client_ip_strange, client_port, remote_user, request_time, request_type,
+			request, protocol, status, body_bytes_sent, http_referer, http_user_agent
+		FROM download_log
+		WHERE request_time >= $1
+			AND request_time < $2
+		ORDER BY request_time`
+	rows, cancel, err := db.query(ctx, dbQuery, &startDate, &endDate)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			clientIPv4, clientIPv6, clientIPStrange, remoteUser *string
+			requestType, request, protocol, referer, userAgent  *string
+			clientPort, status                                  *int32
+			bodyBytesSent                                       *int64
+			requestTime                                         time.Time
+		)
+		err = rows.Scan(&clientIPv4, &clientIPv6, &clientIPStrange, &clientPort, &remoteUser, &requestTime,
+			&requestType, &request, &protocol, &status, &bodyBytesSent, &referer, &userAgent)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		err = fn([]any{nullable(clientIPv4), nullable(clientIPv6), nullable(clientIPStrange), nullable(clientPort),
+			nullable(remoteUser), requestTime, nullable(requestType), nullable(request), nullable(protocol),
+			nullable(status), nullable(bodyBytesSent), nullable(referer), nullable(userAgent)})
+		if err != nil {
+			return
+		}
+		n++
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
+
+// nullable returns the value a scanned pointer points to, or nil for a NULL
+func nullable[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// CountLogs returns the number of rows of the download_log table in the given date range
+func (db *DB) CountLogs(ctx context.Context, startDate, endDate time.Time) (n int64, err error) {
+	dbQuery := `
+		SELECT count(*)
+		FROM download_log
+		WHERE request_time >= $1
+			AND request_time < $2`
+	err = db.queryRow(ctx, dbQuery, &startDate, &endDate).Scan(&n)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+	}
+	return
+}
+
+// DeleteLogs deletes the rows of the download_log table in the given date range, as long as there are exactly the
+// expected number of them.  Otherwise nothing is deleted.  It isn't bounded by the per query timeout, as deleting a
+// month of rows can take a while
+func (db *DB) DeleteLogs(ctx context.Context, startDate, endDate time.Time, expected int64) error {
+	tx, err := db.pool.Begin(ctx)
+	if db.retryAuth(err) {
+		tx, err = db.pool.Begin(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	dbQuery := `
+		DELETE FROM download_log
+		WHERE request_time >= $1
+			AND request_time < $2`
+	commandTag, err := tx.Exec(ctx, dbQuery, &startDate, &endDate)
+	if err != nil {
+		log.Printf("Deleting download log entries failed: %v\n", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != expected {
+		return fmt.Errorf("%d download log entries between %v and %v rather than the %d archived, so none were "+
+			"deleted", numRows, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), expected)
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TrackBandwidth enables the bandwidth stats, from the body_bytes_sent column of download_log
+func (db *DB) TrackBandwidth() {
+	db.bandwidth = true
+}
+
+// GetBandwidth returns the number of bytes sent for the DB4S downloads in the given date range, plus a breakdown per
+// download.  Partial (206) responses are included, as they use bandwidth too.  The breakdown is nil when bandwidth
+// tracking isn't enabled
+func (db *DB) GetBandwidth(ctx context.Context, startDate time.Time, endDate time.Time) (bytes int64, bytesPerVersion map[int]int64, err error) {
+	if !db.bandwidth {
+		return
+	}
+	if bounds := db.filters.mappingBounds(startDate, endDate); len(bounds) > 0 {
+		return countMappedParts(ctx, startDate, endDate, bounds, db.GetBandwidth)
+	}
+	var perRequest map[string]int64
+	if db.clickHouse != nil {
+		perRequest, err = db.clickHouseBandwidth(ctx, startDate, endDate)
+	} else {
+		perRequest, err = db.pgBandwidth(ctx, startDate, endDate)
+	}
+	if err != nil {
+		return
+	}
+
+	// Add up the requests for each download
+	bytesPerVersion = make(map[int]int64)
+	for _, file := range db.filters.downloads {
+		bytesPerVersion[file.ID] = 0
+	}
+	for request, n := range perRequest {
+		if id, ok := db.filters.DownloadOf(request, startDate); ok {
+			bytesPerVersion[id] += n
+			bytes += n
+		}
+	}
+	return
+}
+
+// pgBandwidth returns the number of bytes sent for each download request path in the given date range
+func (db *DB) pgBandwidth(ctx context.Context, startDate, endDate time.Time) (map[string]int64, error) {
+	networks, err := db.downloadExclusions(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	excluded, args := notInNetworks(requestIP, networks, []any{&startDate, &endDate, db.filters.DownloadRequests()})
+	dbQuery := `
+		SELECT request, sum(body_bytes_sent)
+		FROM {logs}
+		WHERE request = ANY($3)
+			AND request_time >= $1
+			AND request_time < $2
+			AND status IN (200, 206)
+			` + excluded + `
+		GROUP BY request`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	perRequest := make(map[string]int64)
+	for rows.Next() {
+		var request string
+		var sum pgtype.Numeric
+		err = rows.Scan(&request, &sum)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		if sum.Valid {
+			n, err := sum.Int64Value()
+			if err != nil {
+				return nil, err
+			}
+			perRequest[request] = n.Int64
+		}
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return perRequest, nil
+}
+
+// clickHouseBandwidth returns the number of bytes sent for each download request path in the given date range, as
+// per pgBandwidth
+func (db *DB) clickHouseBandwidth(ctx context.Context, startDate, endDate time.Time) (map[string]int64, error) {
+	query := `
+		SELECT request, sum(body_bytes_sent)
+		FROM {table}
+		WHERE request IN {requests:Array(String)}
+			AND request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})
+			AND status IN (200, 206)
+			AND NOT ` + clickHouseExcluded + `
+		GROUP BY request`
+	params := clickHouseRange(startDate, endDate)
+	params["requests"] = clickHouseArray(db.filters.DownloadRequests())
+	params["networks"] = db.clickHouseNetworks()
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return nil, err
+	}
+	perRequest := make(map[string]int64)
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		n, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		perRequest[row[0]] = n
+	}
+	return perRequest, nil
+}
+
+// SaveBandwidth sets the bytes sent of the rows of a downloads stats table for the given date.  The rows need to have
+// been saved already, with the download counts
+func (db *DB) SaveBandwidth(ctx context.Context, table string, date time.Time, bytes int64, bytesPerVersion map[int]int64) error {
+	dbQuery := fmt.Sprintf(`
+		UPDATE %s
+		SET bytes_sent = $3
+		WHERE stats_date = $1
+			AND db4s_download = $2`, table)
+
+	// NOTE - The hard coded 0 value for the db4s download corresponds to the manually added "Total downloads" entry in
+	// the DB4S download info table
+	_, err := db.exec(ctx, dbQuery, date, 0, bytes)
+	if err != nil {
+		log.Printf("Saving bandwidth failed: %v\n", err)
+		return err
+	}
+	for id, n := range bytesPerVersion {
+		_, err = db.exec(ctx, dbQuery, date, id, n)
+		if err != nil {
+			log.Printf("Saving bandwidth failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
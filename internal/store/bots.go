@@ -0,0 +1,108 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+// BotFilter excludes the version checks made by bots from the users stats, eg monitoring systems and scrapers faking
+// the DB4S user agent.  Version checks are excluded when their user agent or IP address matches, or when their IP
+// address made more than the allowed number of version checks on any day of the time period
+type BotFilter struct {
+	userAgents  []*regexp.Regexp
+	networks    []netip.Prefix
+	maxRequests int
+}
+
+// NewBotFilter returns a BotFilter for the given config, or nil if it doesn't exclude anything
+func NewBotFilter(conf config.BotsInfo) (*BotFilter, error) {
+	if len(conf.UserAgents) == 0 && len(conf.IPs) == 0 && conf.MaxRequests <= 0 {
+		return nil, nil
+	}
+	f := &BotFilter{maxRequests: conf.MaxRequests}
+	for _, pattern := range conf.UserAgents {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bot user agent pattern '%v': %w", pattern, err)
+		}
+		f.userAgents = append(f.userAgents, re)
+	}
+	for _, s := range conf.IPs {
+		network, err := ParseNetwork(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bot IP address or range '%v': %w", s, err)
+		}
+		f.networks = append(f.networks, network)
+	}
+	return f, nil
+}
+
+// ParseNetwork parses either a CIDR range or a single IP address, which is treated as a range of one
+func ParseNetwork(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		network, err := netip.ParsePrefix(s)
+		return network.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// InNetworks returns whether an IP address is in any of the given ranges.  Values which aren't valid IP addresses
+// (eg from the client_ip_strange field) are never in them
+func InNetworks(networks []netip.Prefix, IP string) bool {
+	if len(networks) == 0 {
+		return false
+	}
+	// IPv6 addresses aggregated by /64 are checked using the start of their range
+	if network, err := netip.ParsePrefix(IP); err == nil {
+		IP = network.Addr().String()
+	}
+	addr, err := netip.ParseAddr(IP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxRequests returns the number of version checks per IP address per day above which it's treated as a bot, or 0
+// when there's no limit
+func (f *BotFilter) MaxRequests() int {
+	if f == nil {
+		return 0
+	}
+	return f.maxRequests
+}
+
+// excludes returns whether a single version check is from a bot, going by its IP address and user agent
+func (f *BotFilter) excludes(IP, userAgent string) bool {
+	for _, re := range f.userAgents {
+		if re.MatchString(userAgent) {
+			return true
+		}
+	}
+	return InNetworks(f.networks, IP)
+}
+
+// FilterBots excludes the version checks made by bots from the users stats.  The version checks are filtered as they
+// stream in from PostgreSQL, so this isn't supported when reading the download logs from ClickHouse
+func (db *DB) FilterBots(filter *BotFilter) error {
+	if filter != nil && db.clickHouse != nil {
+		return errors.New("bot filtering isn't supported when reading the download logs from ClickHouse")
+	}
+	db.bots = filter
+	return nil
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+)
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"192.0.2.0/24", "192.0.2.0/24", false},
+		{"192.0.2.7/24", "192.0.2.0/24", false}, // Masked down to the start of the range
+		{"192.0.2.7", "192.0.2.7/32", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"192.0.2.300", "", true},
+		{"192.0.2.0/33", "", true},
+		{"example.org", "", true},
+	}
+	for _, test := range tests {
+		got, err := ParseNetwork(test.s)
+		if (err != nil) != test.wantErr || (err == nil && got.String() != test.want) {
+			t.Errorf("ParseNetwork(%q) = %v, %v, expected %v (error: %v)", test.s, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestInNetworks(t *testing.T) {
+	networks := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("2001:db8::/32")}
+	tests := []struct {
+		IP   string
+		want bool
+	}{
+		{"192.0.2.10", true},
+		{"198.51.100.10", false},
+		{"::ffff:192.0.2.10", true}, // IPv4 mapped
+		{"2001:db8:1::5", true},
+		{"2001:db8:1::/64", true}, // Aggregated by /64
+		{"2001:db9::1", false},
+		{"not an address", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := InNetworks(networks, test.IP); got != test.want {
+			t.Errorf("InNetworks(%q) = %v, expected %v", test.IP, got, test.want)
+		}
+	}
+	if InNetworks(nil, "192.0.2.10") {
+		t.Error("InNetworks() with no networks = true, expected false")
+	}
+}
+
+func TestNewBotFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    config.BotsInfo
+		wantNil bool
+		wantErr bool
+	}{
+		{"nothing to exclude", config.BotsInfo{}, true, false},
+		{"user agents", config.BotsInfo{UserAgents: []string{"(?i)bot"}}, false, false},
+		{"max requests", config.BotsInfo{MaxRequests: 100}, false, false},
+		{"bad pattern", config.BotsInfo{UserAgents: []string{"("}}, true, true},
+		{"bad IP address", config.BotsInfo{IPs: []string{"192.0.2"}}, true, true},
+	}
+	for _, test := range tests {
+		f, err := NewBotFilter(test.conf)
+		if (err != nil) != test.wantErr || (f == nil) != test.wantNil {
+			t.Errorf("%v: NewBotFilter() = %v, %v, expected nil: %v, error: %v", test.name, f, err, test.wantNil,
+				test.wantErr)
+		}
+	}
+}
+
+func TestUserCounterBots(t *testing.T) {
+	day := time.Date(2018, 8, 13, 9, 0, 0, 0, time.UTC)
+	filter, err := NewBotFilter(config.BotsInfo{
+		UserAgents:  []string{"Monitor"},
+		IPs:         []string{"192.0.2.0/24"},
+		MaxRequests: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		checks       []versionCheck
+		wantIPs      int
+		wantExcluded int
+	}{
+		{"regular", []versionCheck{{"10.0.0.1", "sqlitebrowser 3.12.2"}, {"10.0.0.2", "sqlitebrowser 3.12.2"}}, 2, 0},
+		{"bot user agent", []versionCheck{
+			{"10.0.0.1", "sqlitebrowser 3.12.2 Monitor"},
+			{"10.0.0.2", "sqlitebrowser 3.12.2"},
+		}, 1, 1},
+		{"bot IP address", []versionCheck{{"192.0.2.5", "sqlitebrowser 3.12.2"}, {"10.0.0.2", "sqlitebrowser 3.12.2"}},
+			1, 1},
+		{"at the limit", []versionCheck{
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+		}, 1, 0},
+		{"over the limit", []versionCheck{
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.13.0"},
+			{"10.0.0.1", "sqlitebrowser 3.13.0"},
+			{"10.0.0.2", "sqlitebrowser 3.12.2"},
+		}, 1, 4},
+	}
+	for _, test := range tests {
+		c := NewUserCounter(func(userAgent string) string { return userAgent }, filter, 0)
+		for _, check := range test.checks {
+			c.Add(check.IP, check.userAgent, day)
+		}
+		IPs, userAgentIPs, excluded := c.Counts()
+		if IPs != test.wantIPs || excluded != test.wantExcluded {
+			t.Errorf("%v: UserCounter.Counts() = %d, %v, %d, expected %d IPs and %d excluded", test.name, IPs,
+				userAgentIPs, excluded, test.wantIPs, test.wantExcluded)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UseBulkLoad saves the rows of the users and downloads stats tables by COPYing them into a temporary table, then
+// merging that into the stats table with a single INSERT ... ON CONFLICT, rather than upserting them one at a time.
+// This is much faster for full regenerations, which write tens of thousands of stats rows
+func (db *DB) UseBulkLoad() {
+	db.bulkLoad = true
+}
+
+// bulkMerge COPYs the rows into a temporary bulk_rows table with the given column definitions, then runs the merge
+// query to move them into their stats table.  This is done in a single transaction, which drops the temporary table
+// again at the end
+func (db *DB) bulkMerge(ctx context.Context, columnDefs string, columns []string, rows [][]any, mergeQuery string) (int64, error) {
+	tx, err := db.pool.Begin(ctx)
+	if db.retryAuth(err) {
+		tx, err = db.pool.Begin(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+	_, err = tx.Exec(queryCtx, fmt.Sprintf(`CREATE TEMPORARY TABLE bulk_rows (%s) ON COMMIT DROP`, columnDefs))
+	if err != nil {
+		return 0, db.checkTimeout(ctx, err)
+	}
+	_, err = tx.CopyFrom(queryCtx, pgx.Identifier{"bulk_rows"}, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, db.checkTimeout(ctx, err)
+	}
+	commandTag, err := tx.Exec(queryCtx, db.withTables(mergeQuery))
+	if err != nil {
+		return 0, db.checkTimeout(ctx, err)
+	}
+	return commandTag.RowsAffected(), db.checkTimeout(ctx, tx.Commit(queryCtx))
+}
+
+// bulkSaveDownloads saves the rows of a downloads stats table for the given date with bulkMerge
+func (db *DB) bulkSaveDownloads(ctx context.Context, table string, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	// Download 0 is the "Total downloads" entry in the DB4S download info table, as with the row by row saves
+	rows := [][]any{{date, 0, count}}
+	for version, DLCount := range DLsPerVersion {
+		rows = append(rows, []any{date, version, DLCount})
+	}
+	dbQuery := fmt.Sprintf(`
+		INSERT INTO %[1]s (stats_date, db4s_download, num_downloads)
+		SELECT stats_date, db4s_download, num_downloads
+		FROM bulk_rows
+		ON CONFLICT (stats_date, db4s_download)
+			DO UPDATE
+				SET num_downloads = excluded.num_downloads`, table)
+	numRows, err := db.bulkMerge(ctx, "stats_date timestamp without time zone, db4s_download integer, num_downloads integer",
+		[]string{"stats_date", "db4s_download", "num_downloads"}, rows, dbQuery)
+	if err != nil {
+		log.Printf("Bulk saving the %v rows failed: %v\n", table, err)
+		return err
+	}
+	if numRows != int64(len(rows)) {
+		log.Printf("Wrong number of rows (%v) affected when bulk saving the %v rows: %v\n", numRows, table, date)
+	}
+	return nil
+}
+
+// bulkSaveUsers saves the rows of a users stats table for the given date with bulkMerge.  The user agents are mapped
+// to their release in the query, going by the version number in them
+func (db *DB) bulkSaveUsers(ctx context.Context, table string, date time.Time, count int, IPsPerUserAgent map[string]int) error {
+	// Release 1 is the "Unique IPs" entry in the DB4S release info table, as with the row by row saves
+	rows := [][]any{{date, 1, nil, count}}
+	for userAgent, verCount := range IPsPerUserAgent {
+		rows = append(rows, []any{date, nil, db.filters.UserAgentVersion(userAgent), verCount})
+	}
+
+	// User agents differing only in their extra tokens (eg the OS) have the same version number, and there's only one
+	// row per release, so only the largest count of them is kept
+	dbQuery := fmt.Sprintf(`
+		INSERT INTO %[1]s (stats_date, db4s_release, unique_ips)
+		SELECT b.stats_date, coalesce(b.db4s_release, r.release_id), max(b.unique_ips)
+		FROM bulk_rows b
+			LEFT JOIN db4s_release_info r ON r.version_number = b.version_number
+		GROUP BY 1, 2
+		ON CONFLICT (stats_date, db4s_release)
+			DO UPDATE
+				SET unique_ips = excluded.unique_ips`, table)
+	_, err := db.bulkMerge(ctx,
+		"stats_date timestamp without time zone, db4s_release integer, version_number text, unique_ips integer",
+		[]string{"stats_date", "db4s_release", "version_number", "unique_ips"}, rows, dbQuery)
+	if err != nil {
+		log.Printf("Bulk saving the %v rows failed: %v\n", table, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The channel names used in the db4s_downloads_by_channel table for the downloads from our own mirrors (ie the
+// downloads stats tables), and the grand total across all the channels
+const (
+	ChannelMirrors = "mirrors"
+	ChannelTotal   = "total"
+)
+
+// TrackChannelRollup enables rolling the downloads from our mirrors up with those collected from the other
+// distribution channels, into the db4s_downloads_by_channel table
+func (db *DB) TrackChannelRollup() {
+	db.channelRollup = true
+}
+
+// GetChannelDownloads returns the downloads (installs plus updates) from each distribution channel in the
+// db4s_channel_downloads table, for the days in the given date range.  This is nil when the channel rollup isn't
+// enabled
+func (db *DB) GetChannelDownloads(ctx context.Context, startDate time.Time, endDate time.Time) (map[string]int64, error) {
+	if !db.channelRollup {
+		return nil, nil
+	}
+	dbQuery := `
+		SELECT channel, sum(installs + updates)
+		FROM db4s_channel_downloads
+		WHERE stats_date >= $1::date
+			AND stats_date < $2::date
+		GROUP BY channel`
+	rows, cancel, err := db.query(ctx, dbQuery, &startDate, &endDate)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	perChannel := make(map[string]int64)
+	for rows.Next() {
+		var channel string
+		var count pgtype.Int8
+		err = rows.Scan(&channel, &count)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		perChannel[channel] = count.Int64
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return perChannel, nil
+}
+
+// LastChannelDate returns the last day with install counts saved for a distribution channel, if there is one
+func (db *DB) LastChannelDate(ctx context.Context, channel string) (time.Time, bool, error) {
+	dbQuery := `
+		SELECT max(stats_date)
+		FROM db4s_channel_downloads
+		WHERE channel = $1`
+	var last pgtype.Date
+	err := db.queryRow(ctx, dbQuery, channel).Scan(&last)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return time.Time{}, false, err
+	}
+	return last.Time, last.Valid, nil
+}
+
+// SaveChannelDownloads saves the install counts of a distribution channel for the given day, replacing any saved
+// earlier for it
+func (db *DB) SaveChannelDownloads(ctx context.Context, channel string, date time.Time, installs, updates int64) error {
+	dbQuery := `
+		INSERT INTO db4s_channel_downloads (channel, stats_date, installs, updates, collected)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (channel, stats_date)
+			DO UPDATE
+				SET installs = $3, updates = $4, collected = now()
+				WHERE db4s_channel_downloads.channel = $1
+					AND db4s_channel_downloads.stats_date = $2`
+	commandTag, err := db.exec(ctx, dbQuery, channel, date, installs, updates)
+	if err != nil {
+		log.Printf("Saving channel downloads failed: %v\n", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when saving the downloads for channel: %v\n", numRows, channel)
+	}
+	return nil
+}
+
+// SaveChannelTotal saves the running total of installs of a distribution channel for the given day, along with the
+// increase since the previous total saved for it as the installs for the day.  The first total saved for a channel
+// has no installs, as there's nothing to compare it to
+func (db *DB) SaveChannelTotal(ctx context.Context, channel string, date time.Time, total int64) error {
+	dbQuery := `
+		INSERT INTO db4s_channel_downloads (channel, stats_date, installs, updates, total, collected)
+		SELECT $1, $2, coalesce(greatest($3 - (
+			SELECT total
+			FROM db4s_channel_downloads
+			WHERE channel = $1
+				AND stats_date < $2
+				AND total IS NOT NULL
+			ORDER BY stats_date DESC
+			LIMIT 1), 0), 0), 0, $3, now()
+		ON CONFLICT (channel, stats_date)
+			DO UPDATE
+				SET installs = excluded.installs, total = $3, collected = now()
+				WHERE db4s_channel_downloads.channel = $1
+					AND db4s_channel_downloads.stats_date = $2`
+	commandTag, err := db.exec(ctx, dbQuery, channel, date, total)
+	if err != nil {
+		log.Printf("Saving channel total failed: %v\n", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when saving the total for channel: %v\n", numRows, channel)
+	}
+	return nil
+}
+
+// SaveChannelRollup saves the downloads from each distribution channel for a metric family for the time period
+// starting at the given date, replacing any saved earlier for it.  The grand total across the channels is saved too.
+// This is done in a transaction, so a failure part way through doesn't leave the time period with only some channels
+func (db *DB) SaveChannelRollup(ctx context.Context, family string, date time.Time, perChannel map[string]int64) error {
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+	tx, err := db.pool.Begin(queryCtx)
+	if db.retryAuth(err) {
+		tx, err = db.pool.Begin(queryCtx)
+	}
+	if err != nil {
+		log.Printf("Saving downloads by channel failed: %v\n", err)
+		return err
+	}
+	defer tx.Rollback(queryCtx)
+
+	// Remove the earlier counts first, so channels no longer counted (eg the counts were reprocessed) don't linger
+	dbQuery := `
+		DELETE FROM db4s_downloads_by_channel
+		WHERE metric_family = $1
+			AND stats_date = $2`
+	_, err = tx.Exec(queryCtx, db.withTables(dbQuery), family, date)
+	if err != nil {
+		log.Printf("Saving downloads by channel failed: %v\n", err)
+		return err
+	}
+	dbQuery = db.withTables(`
+		INSERT INTO db4s_downloads_by_channel (metric_family, stats_date, channel, downloads)
+		VALUES ($1, $2, $3, $4)`)
+	var total int64
+	for channel, downloads := range perChannel {
+		_, err = tx.Exec(queryCtx, dbQuery, family, date, channel, downloads)
+		if err != nil {
+			log.Printf("Saving downloads by channel failed: %v\n", err)
+			return err
+		}
+		total += downloads
+	}
+	_, err = tx.Exec(queryCtx, dbQuery, family, date, ChannelTotal, total)
+	if err == nil {
+		err = tx.Commit(queryCtx)
+	}
+	if err != nil {
+		log.Printf("Saving downloads by channel failed: %v\n", err)
+	}
+	return err
+}
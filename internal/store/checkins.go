@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Most DB4S instances check for a new release once a day, so IP addresses making many more version checks than that
+// are either several users behind a NAT or something misbehaving (eg a monitoring system or a broken client).  The
+// distribution of the daily number of version checks per IP address shows how much of the traffic is from those
+
+// checkInBuckets are the buckets of the daily number of version checks per IP address, by their upper bound.  The last
+// one has no upper bound
+var checkInBuckets = []struct {
+	label string
+	max   int
+}{
+	{"1", 1},
+	{"2-5", 5},
+	{"6-20", 20},
+	{"21+", 0},
+}
+
+// CheckInBucket returns the label of the bucket for the given daily number of version checks from an IP address
+func CheckInBucket(checks int) string {
+	for _, b := range checkInBuckets {
+		if b.max == 0 || checks <= b.max {
+			return b.label
+		}
+	}
+	return ""
+}
+
+// TrackCheckIns enables the check-in frequency stats.  These aren't supported when reading the download logs from
+// ClickHouse
+func (db *DB) TrackCheckIns() error {
+	if db.clickHouse != nil {
+		return errors.New("the check-in frequency stats aren't supported when reading the download logs from ClickHouse")
+	}
+	db.checkIns = true
+	return nil
+}
+
+// GetCheckIns returns the number of IP address days in the given date range in each bucket of the daily number of
+// version checks.  This is nil when the check-in frequency stats aren't enabled
+func (db *DB) GetCheckIns(ctx context.Context, startDate time.Time, endDate time.Time) (map[string]int, error) {
+	if !db.checkIns {
+		return nil, nil
+	}
+
+	// Count the version checks of each IP address per day, then how many IP address days had each number of them.
+	// The bots aren't left out, as they're what this is for spotting
+	ip, ipArgs := db.userIP(3)
+	excluded, args := notInNetworks(ip, db.excluded, append([]any{&startDate, &endDate}, ipArgs...))
+	dbQuery := fmt.Sprintf(`
+		SELECT checks, count(*)
+		FROM (
+			SELECT %[2]s AS ip, date_trunc('day', request_time) AS day, count(*) AS checks
+			FROM {logs}
+			WHERE request = '/currentrelease'
+				AND %[3]s
+				AND request_time >= $1
+				AND request_time < $2
+				AND status = 200
+				%[4]s
+			GROUP BY 1, 2
+		) c
+		WHERE ip IS NOT NULL
+		GROUP BY checks`, ip, db.ipKey(ip), db.filters.pgUserAgents(), excluded)
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	buckets := make(map[string]int)
+	for rows.Next() {
+		var checks, n int
+		err = rows.Scan(&checks, &n)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		buckets[CheckInBucket(checks)] += n
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// SaveCheckIns saves the check-in frequency distribution of a metric family for the time period starting at the given
+// date, replacing any saved earlier for it
+func (db *DB) SaveCheckIns(ctx context.Context, family string, date time.Time, buckets map[string]int) error {
+	dbQuery := `
+		DELETE FROM db4s_users_check_ins
+		WHERE metric_family = $1
+			AND stats_date = $2`
+	_, err := db.exec(ctx, dbQuery, family, date)
+	if err != nil {
+		log.Printf("Saving check-ins failed: %v\n", err)
+		return err
+	}
+	dbQuery = `
+		INSERT INTO db4s_users_check_ins (metric_family, stats_date, bucket, ip_days)
+		VALUES ($1, $2, $3, $4)`
+	for bucket, n := range buckets {
+		_, err = db.exec(ctx, dbQuery, family, date, bucket, n)
+		if err != nil {
+			log.Printf("Saving check-ins failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// When a ClickHouse copy of the download logs is configured, the queries reading them run there instead, using its
+// HTTP interface.  The large distinct counts for the users stats are much faster in ClickHouse, and are done there
+// rather than by hashing every row in Go.  The results are still saved to PostgreSQL
+//
+// The ClickHouse table is expected to have the same columns as download_log, with the IP addresses as strings
+
+// clickHouse is the HTTP interface of a ClickHouse server holding a copy of the download logs
+type clickHouse struct {
+	conf   config.ClickHouseInfo
+	client *http.Client
+	table  string
+}
+
+// UseClickHouse reads the download logs from ClickHouse, instead of PostgreSQL
+func (db *DB) UseClickHouse(conf config.ClickHouseInfo) {
+	table := conf.Table
+	if table == "" {
+		table = "download_log"
+	}
+	db.clickHouse = &clickHouse{conf: conf, client: &http.Client{}, table: table}
+}
+
+// query runs a ClickHouse query with the given parameters, returning the rows of its TabSeparated output
+func (ch *clickHouse) query(ctx context.Context, query string, params map[string]string) (rows [][]string, err error) {
+	values := url.Values{}
+	if ch.conf.Database != "" {
+		values.Set("database", ch.conf.Database)
+	}
+	for name, value := range params {
+		values.Set("param_"+name, value)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(ch.conf.URL, "/")+"/?"+values.Encode(),
+		strings.NewReader(strings.ReplaceAll(query, "{table}", ch.table)+" FORMAT TabSeparated"))
+	if err != nil {
+		return
+	}
+	if ch.conf.Username != "" {
+		req.Header.Set("X-ClickHouse-User", ch.conf.Username)
+		req.Header.Set("X-ClickHouse-Key", ch.conf.Password)
+	}
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("ClickHouse query failed with status %v: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		for i, f := range fields {
+			fields[i] = unescapeTSV(f)
+		}
+		rows = append(rows, fields)
+	}
+	err = scanner.Err()
+	return
+}
+
+// unescapeTSV reverses the escaping of a value in the TabSeparated format
+func unescapeTSV(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case '0':
+			b.WriteByte(0)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// clickHouseQuery runs a ClickHouse query bounded by the per query timeout
+func (db *DB) clickHouseQuery(ctx context.Context, query string, params map[string]string) ([][]string, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+	started := time.Now()
+	rows, err := db.clickHouse.query(queryCtx, query, params)
+	if db.Verbosity >= verbosity.Trace {
+		log.Printf("ClickHouse query took %v (%d rows): %v\n", time.Since(started).Round(time.Millisecond), len(rows),
+			compactSQL(query))
+	}
+	return rows, db.checkTimeout(ctx, err)
+}
+
+// clickHouseRange returns the query parameters for a date range
+func clickHouseRange(startDate, endDate time.Time) map[string]string {
+	return map[string]string{
+		"start": strconv.FormatInt(startDate.Unix(), 10),
+		"end":   strconv.FormatInt(endDate.Unix(), 10),
+	}
+}
+
+// clickHouseArray returns a list of strings as an Array(String) query parameter
+func clickHouseArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = clickHouseString(v)
+	}
+	return "[" + strings.Join(quoted, ",") + "]"
+}
+
+// clickHouseDownloads returns the download counts for the given date range, as per GetDownloads
+func (db *DB) clickHouseDownloads(ctx context.Context, startDate, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	query := `
+		SELECT request, count()
+		FROM {table}
+		WHERE request IN {requests:Array(String)}
+			AND request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})
+			AND status IN {statuses:Array(Int32)}
+			AND NOT ` + clickHouseExcluded + `
+		GROUP BY request`
+	params := clickHouseRange(startDate, endDate)
+	params["requests"] = clickHouseArray(db.filters.DownloadRequests())
+	params["networks"] = db.clickHouseNetworks()
+	params["statuses"] = clickHouseStatuses(db.filters.statuses)
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	perRequest, err := clickHouseCounts(rows)
+	if err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	DLs, DLsPerVersion = db.filters.downloadCounts(perRequest, startDate)
+	return
+}
+
+// clickHouseUniqueDownloads returns the unique download counts for the given date range, as per GetUniqueDownloads
+func (db *DB) clickHouseUniqueDownloads(ctx context.Context, startDate, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	query := `
+		SELECT request, uniqExact(
+			coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, '')),
+			toDate(request_time, 'UTC'))
+		FROM {table}
+		WHERE request IN {requests:Array(String)}
+			AND request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})
+			AND status IN {statuses:Array(Int32)}
+			AND NOT ` + clickHouseExcluded + `
+		GROUP BY request`
+	params := clickHouseRange(startDate, endDate)
+	params["requests"] = clickHouseArray(db.filters.DownloadRequests())
+	params["networks"] = db.clickHouseNetworks()
+	params["statuses"] = clickHouseStatuses(db.downloadStatuses())
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	perRequest, err := clickHouseCounts(rows)
+	if err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	DLs, DLsPerVersion = db.filters.downloadCounts(perRequest, startDate)
+	return
+}
+
+// clickHouseCounts returns the counts from rows of request paths and their counts
+func clickHouseCounts(rows [][]string) (map[string]int32, error) {
+	perRequest := make(map[string]int32)
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		count, err := strconv.ParseInt(row[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		perRequest[row[0]] = int32(count)
+	}
+	return perRequest, nil
+}
+
+// clickHouseIPs returns the unique IP address counts for the given date range, as per GetIPs.  The distinct counting
+// is done by ClickHouse, using the same IP address field precedence as IPCounter
+func (db *DB) clickHouseIPs(ctx context.Context, startDate, endDate time.Time) (IPs int, userAgentIPs map[string]int, err error) {
+	// WITH TOTALS adds the number of unique IP addresses across all user agents, after an empty line
+	query := `
+		SELECT user_agent, uniqExact(ip)
+		FROM (
+			SELECT ` + db.filters.clickHouseUserAgent() + ` AS user_agent, ` + db.clickHouseIPKey("coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, ''))") + ` AS ip
+			FROM {table}
+			WHERE request = '/currentrelease'
+				AND ` + db.filters.clickHouseUserAgents() + `
+				AND request_time >= toDateTime({start:Int64})
+				AND request_time < toDateTime({end:Int64})
+				AND status = 200
+				AND NOT ` + clickHouseExcluded + `
+		)
+		GROUP BY user_agent
+		WITH TOTALS`
+	params := clickHouseRange(startDate, endDate)
+	params["networks"] = db.clickHouseNetworks()
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	userAgentIPs = make(map[string]int)
+	totals := false
+	for _, row := range rows {
+		if len(row) == 1 && row[0] == "" {
+			totals = true
+			continue
+		}
+		if len(row) != 2 {
+			err = fmt.Errorf("unexpected ClickHouse result row: %q", row)
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		var count int
+		count, err = strconv.Atoi(row[1])
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		if totals {
+			IPs = count
+		} else {
+			userAgentIPs[row[0]] = count
+		}
+	}
+	return
+}
+
+// clickHouseUserAgents returns the list of (valid) user agents in the download logs, as per UpdateUserAgents
+func (db *DB) clickHouseUserAgents(ctx context.Context) (userAgents []string, err error) {
+	query := `
+		SELECT DISTINCT http_user_agent
+		FROM {table}
+		WHERE request = '/currentrelease'
+			AND ` + db.filters.clickHouseUserAgents() + `
+		ORDER BY http_user_agent ASC`
+	rows, err := db.clickHouseQuery(ctx, query, nil)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	for _, row := range rows {
+		if row[0] != "" {
+			userAgents = append(userAgents, row[0])
+		}
+	}
+	return
+}
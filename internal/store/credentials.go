@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// CredentialsFunc returns the username and password to connect to the database with, for credentials held in a
+// secret store rather than the config file
+type CredentialsFunc func(ctx context.Context) (username, password string, err error)
+
+// credentialCache holds the credentials returned by a CredentialsFunc, until they're found to be no longer valid (eg
+// after the password has been rotated)
+type credentialCache struct {
+	fetch CredentialsFunc
+
+	mu       sync.Mutex
+	username string
+	password string
+	valid    bool
+}
+
+// get returns the cached credentials, fetching them first if needed
+func (c *credentialCache) get(ctx context.Context) (username, password string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.valid {
+		c.username, c.password, err = c.fetch(ctx)
+		if err != nil {
+			return
+		}
+		c.valid = true
+	}
+	return c.username, c.password, nil
+}
+
+// invalidate makes the next connection fetch the credentials again
+func (c *credentialCache) invalidate() {
+	c.mu.Lock()
+	c.valid = false
+	c.mu.Unlock()
+}
+
+// isAuthFailure returns whether the error is PostgreSQL rejecting the credentials
+func isAuthFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "28P01" || pgErr.Code == "28000" // invalid_password, invalid_authorization_specification
+}
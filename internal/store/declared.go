@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Simple metrics can be declared in the config file, rather than needing code.  Each is the number of download log
+// rows matching a SQL filter in each time period, optionally broken down by a column (or expression), saved in its own
+// table.  The filter and dimension come from the config file, so they're trusted the same as the rest of it
+
+// metricTablePattern matches the valid names for the tables of the declared metrics
+var metricTablePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ValidMetricTable returns an error when the name can't be used for the table of a declared metric
+func ValidMetricTable(table string) error {
+	if !metricTablePattern.MatchString(table) {
+		return fmt.Errorf("invalid metric table name '%v', it needs to be lower case letters, digits and underscores",
+			table)
+	}
+	return nil
+}
+
+// InitMetricTable creates the table of a declared metric, if it doesn't exist already
+func (db *DB) InitMetricTable(ctx context.Context, table string) error {
+	if err := ValidMetricTable(table); err != nil {
+		return err
+	}
+	dbQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			metric_family text NOT NULL,
+			stats_date timestamp without time zone NOT NULL,
+			dimension text NOT NULL,
+			value bigint NOT NULL,
+			CONSTRAINT %[2]s PRIMARY KEY (metric_family, stats_date, dimension)
+		)`, pgx.Identifier{table}.Sanitize(), pgx.Identifier{table + "_pk"}.Sanitize())
+	_, err := db.exec(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Creating metric table %v failed: %v\n", table, err)
+	}
+	return err
+}
+
+// CountMatchingLogs returns the number of download log rows matching the filter in the given date range, keyed by the
+// value of the groupBy expression.  Without a groupBy expression, the count is under the empty string.  The excluded
+// networks are left out, as for the downloads stats
+func (db *DB) CountMatchingLogs(ctx context.Context, filter, groupBy string, startDate time.Time, endDate time.Time) (map[string]int64, error) {
+	dimension := "''"
+	if groupBy != "" {
+		dimension = fmt.Sprintf("coalesce((%v)::text, '')", groupBy)
+	}
+	excluded, args := notInNetworks(requestIP, db.excluded, []any{&startDate, &endDate})
+	dbQuery := fmt.Sprintf(`
+		SELECT %[1]s, count(*)
+		FROM {logs}
+		WHERE request_time >= $1
+			AND request_time < $2
+			AND (%[2]s)
+			%[3]s
+		GROUP BY 1`, dimension, filter, excluded)
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var key string
+		var n int64
+		err = rows.Scan(&key, &n)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		counts[key] += n
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return counts, nil
+}
+
+// SaveMetricCounts saves the counts of a declared metric for a metric family for the time period starting at the
+// given date, replacing any saved earlier for it
+func (db *DB) SaveMetricCounts(ctx context.Context, table, family string, date time.Time, counts map[string]int64) error {
+	if err := ValidMetricTable(table); err != nil {
+		return err
+	}
+
+	// Remove the earlier counts first, so dimensions which no longer show up (eg the counts were reprocessed) don't
+	// linger
+	dbQuery := fmt.Sprintf(`
+		DELETE FROM %s
+		WHERE metric_family = $1
+			AND stats_date = $2`, pgx.Identifier{table}.Sanitize())
+	_, err := db.exec(ctx, dbQuery, family, date)
+	if err != nil {
+		log.Printf("Saving %v failed: %v\n", table, err)
+		return err
+	}
+	dbQuery = fmt.Sprintf(`
+		INSERT INTO %s (metric_family, stats_date, dimension, value)
+		VALUES ($1, $2, $3, $4)`, pgx.Identifier{table}.Sanitize())
+	for dimension, n := range counts {
+		_, err = db.exec(ctx, dbQuery, family, date, dimension, n)
+		if err != nil {
+			log.Printf("Saving %v failed: %v\n", table, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// DownloadFile is an entry in the db4s_download_info table, along with the request path(s) it is downloaded from
+type DownloadFile struct {
+	ID       int
+	Name     string
+	Requests []string
+}
+
+// defaultDownloadFiles holds the DB4S downloads we generate stats for by default.  The IDs and names are as per the
+// db4s_download_info table
+var defaultDownloadFiles = []DownloadFile{
+	// 3.10.1
+	{1, "3.10.1 macOS", []string{"/DB.Browser.for.SQLite-3.10.1.dmg"}},
+	{2, "3.10.1 win32", []string{"/DB.Browser.for.SQLite-3.10.1-win32.exe"}},
+	{3, "3.10.1 win64", []string{"/DB.Browser.for.SQLite-3.10.1-win64.exe"}},
+	{4, "3.10.1 Portable", []string{"/SQLiteDatabaseBrowserPortable_3.10.1_English.paf.exe"}},
+
+	// 3.11.0
+	{5, "3.11.0 Win32 MSI", []string{"/DB.Browser.for.SQLite-3.11.0-win32.msi"}},
+	{6, "3.11.0 Win32 .zip", []string{"/DB.Browser.for.SQLite-3.11.0-win32.zip"}},
+	{7, "3.11.0 Win64 MSI", []string{"/DB.Browser.for.SQLite-3.11.0-win64.msi"}},
+	{8, "3.11.0 Win64 .zip", []string{"/DB.Browser.for.SQLite-3.11.0-win64.zip"}},
+	{9, "3.11.0 macOS", []string{"/DB.Browser.for.SQLite-3.11.0.dmg"}},
+
+	// 3.11.1
+	{10, "3.11.1 Win32 MSI", []string{"/DB.Browser.for.SQLite-3.11.1-win32.msi"}},
+	{11, "3.11.1 Win32 .zip", []string{"/DB.Browser.for.SQLite-3.11.1-win32.zip"}},
+	{12, "3.11.1 Win64 MSI", []string{"/DB.Browser.for.SQLite-3.11.1-win64.msi"}},
+	{13, "3.11.1 Win64 .zip", []string{"/DB.Browser.for.SQLite-3.11.1-win64.zip"}},
+	{14, "3.11.1 macOS", []string{"/DB.Browser.for.SQLite-3.11.1.dmg", "/DB.Browser.for.SQLite-3.11.1v2.dmg"}},
+
+	// 3.11.2
+	{15, "3.11.2 Win32 MSI", []string{"/DB.Browser.for.SQLite-3.11.2-win32.msi"}},
+	{16, "3.11.2 Win32 .zip", []string{"/DB.Browser.for.SQLite-3.11.2-win32.zip"}},
+	{17, "3.11.2 Win64 MSI", []string{"/DB.Browser.for.SQLite-3.11.2-win64.msi"}},
+	{18, "3.11.2 Win64 .zip", []string{"/DB.Browser.for.SQLite-3.11.2-win64.zip"}},
+	{19, "3.11.2 macOS", []string{"/DB.Browser.for.SQLite-3.11.2.dmg"}},
+	{20, "3.11.2 Portable", []string{"/SQLiteDatabaseBrowserPortable_3.11.2_English.paf.exe"}},
+	{21, "3.11.2 Portable v2", []string{"/SQLiteDatabaseBrowserPortable_3.11.2_Rev_2_English.paf.exe"}},
+
+	// 3.12.0
+	{22, "DB4S 3.12.0 win32 msi", []string{"/DB.Browser.for.SQLite-3.12.0-win32.msi"}},
+	{23, "DB4S 3.12.0 win32 zip", []string{"/DB.Browser.for.SQLite-3.12.0-win32.zip"}},
+	{24, "DB4S 3.12.0 win64 msi", []string{"/DB.Browser.for.SQLite-3.12.0-win64.msi"}},
+	{25, "DB4S 3.12.0 win64 zip", []string{"/DB.Browser.for.SQLite-3.12.0-win64.zip"}},
+	{26, "DB4S 3.12.0 macOS", []string{"/DB.Browser.for.SQLite-3.12.0.dmg"}},
+	{27, "DB4S 3.12.0 Portable", []string{"/SQLiteDatabaseBrowserPortable_3.12.0_English.paf.exe"}},
+
+	// 3.12.2
+	{28, "DB4S 3.12.2 win32 msi", []string{"/DB.Browser.for.SQLite-3.12.2-win32.msi"}},
+	{29, "DB4S 3.12.2 win32 zip", []string{"/DB.Browser.for.SQLite-3.12.2-win32.zip"}},
+	{30, "DB4S 3.12.2 win64 msi", []string{"/DB.Browser.for.SQLite-3.12.2-win64.msi"}},
+	{31, "DB4S 3.12.2 win64 zip", []string{"/DB.Browser.for.SQLite-3.12.2-win64.zip"}},
+	{32, "DB4S 3.12.2 macOS", []string{"/DB.Browser.for.SQLite-3.12.2.dmg"}},
+	{33, "DB4S 3.12.2 Portable", []string{"/SQLiteDatabaseBrowserPortable_3.12.2_English.paf.exe"}},
+	{34, "DB.Browser.for.SQLite-arm64-3.12.2.dmg", []string{"/DB.Browser.for.SQLite-arm64-3.12.2.dmg"}},
+
+	// 3.13.0
+	{35, "DB.Browser.for.SQLite-v3.13.0.dmg", []string{"/DB.Browser.for.SQLite-v3.13.0.dmg"}},
+	{36, "DB.Browser.for.SQLite-v3.13.0-win32.msi", []string{"/DB.Browser.for.SQLite-v3.13.0-win32.msi"}},
+	{37, "DB.Browser.for.SQLite-v3.13.0-win32.zip", []string{"/DB.Browser.for.SQLite-v3.13.0-win32.zip"}},
+	{38, "DB.Browser.for.SQLite-v3.13.0-win64.msi", []string{"/DB.Browser.for.SQLite-v3.13.0-win64.msi"}},
+	{39, "DB.Browser.for.SQLite-v3.13.0-win64.zip", []string{"/DB.Browser.for.SQLite-v3.13.0-win64.zip"}},
+	{40, "DB.Browser.for.SQLite-v3.13.0-x86.64.AppImage", []string{"/DB.Browser.for.SQLite-v3.13.0-x86.64.AppImage"}},
+
+	// 3.13.1
+	{41, "DB.Browser.for.SQLite-v3.13.1.dmg", []string{"/DB.Browser.for.SQLite-v3.13.1.dmg"}},
+	{42, "DB.Browser.for.SQLite-v3.13.1-win32.msi", []string{"/DB.Browser.for.SQLite-v3.13.1-win32.msi"}},
+	{43, "DB.Browser.for.SQLite-v3.13.1-win32.zip", []string{"/DB.Browser.for.SQLite-v3.13.1-win32.zip"}},
+	{44, "DB.Browser.for.SQLite-v3.13.1-win64.msi", []string{"/DB.Browser.for.SQLite-v3.13.1-win64.msi"}},
+	{45, "DB.Browser.for.SQLite-v3.13.1-win64.zip", []string{"/DB.Browser.for.SQLite-v3.13.1-win64.zip"}},
+	{46, "DB.Browser.for.SQLite-v3.13.1-x86.64.AppImage", []string{"/DB.Browser.for.SQLite-v3.13.1-x86.64.AppImage"}},
+	{47, "DB.Browser.for.SQLite-v3.13.1-x86.64-v2.AppImage", []string{"/DB.Browser.for.SQLite-v3.13.1-x86.64-v2.AppImage"}},
+}
+
+// GetDownloads returns the total number of DB4S downloads in the given date range, plus a breakdown per DB4S version
+func (db *DB) GetDownloads(ctx context.Context, startDate time.Time, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	if bounds := db.filters.mappingBounds(startDate, endDate); len(bounds) > 0 {
+		return countMappedParts(ctx, startDate, endDate, bounds, db.GetDownloads)
+	}
+	if db.foldWindow > 0 {
+		return db.foldedDownloads(ctx, startDate, endDate)
+	}
+	if db.clickHouse != nil {
+		return db.clickHouseDownloads(ctx, startDate, endDate)
+	}
+	if db.timescale {
+		return db.timescaleDownloads(ctx, startDate, endDate)
+	}
+
+	// Retrieve the count of the valid download requests for each request path in the desired time range
+	networks, err := db.downloadExclusions(ctx, startDate, endDate)
+	if err != nil {
+		return
+	}
+	excluded, args := notInNetworks(requestIP, networks,
+		[]any{&startDate, &endDate, db.filters.DownloadRequests(), db.filters.statuses})
+	dbQuery := `
+		SELECT request, count(*)
+		FROM {logs}
+		WHERE request = ANY($3)
+			AND request_time >= $1
+			AND request_time < $2
+			AND status = ANY($4)
+			` + excluded + `
+		GROUP BY request`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	perRequest := make(map[string]int32)
+	for rows.Next() {
+		var request string
+		var count pgtype.Int8
+		err = rows.Scan(&request, &count)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		perRequest[request] = int32(count.Int64)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+
+	// * Counts specific downloads for the desired time range *
+	DLs, DLsPerVersion = db.filters.downloadCounts(perRequest, startDate)
+	return
+}
+
+// TrackUniqueDownloads enables the unique downloads stats.  These read the raw download log rows for each time period,
+// even with the TimescaleDB continuous aggregate, so they're opt in
+func (db *DB) TrackUniqueDownloads() {
+	db.uniqueDLs = true
+}
+
+// GetUniqueDownloads returns the number of unique DB4S downloads in the given date range, plus a breakdown per DB4S
+// version.  Each IP address is only counted once per request path per day, so download managers and retries (which
+// make many requests for the same file) don't inflate the numbers.  Over a week or month, this is the sum of the daily
+// counts.  Requests with status 206 are included when folding partial downloads.  The breakdown is nil when the unique
+// downloads stats aren't enabled
+func (db *DB) GetUniqueDownloads(ctx context.Context, startDate time.Time, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	if !db.uniqueDLs {
+		return
+	}
+	if bounds := db.filters.mappingBounds(startDate, endDate); len(bounds) > 0 {
+		return countMappedParts(ctx, startDate, endDate, bounds, db.GetUniqueDownloads)
+	}
+	if db.clickHouse != nil {
+		return db.clickHouseUniqueDownloads(ctx, startDate, endDate)
+	}
+
+	// The continuous aggregate doesn't have the IP addresses, so this always reads the raw log rows
+	networks, err := db.downloadExclusions(ctx, startDate, endDate)
+	if err != nil {
+		return
+	}
+	excluded, args := notInNetworks(requestIP, networks,
+		[]any{&startDate, &endDate, db.filters.DownloadRequests(), db.downloadStatuses()})
+	dbQuery := `
+		SELECT request, count(DISTINCT
+			coalesce(nullif(client_ip_strange, ''), nullif(client_ipv6, ''), nullif(client_ipv4, '')) || ' ' ||
+			date_trunc('day', request_time AT TIME ZONE 'UTC')::date)
+		FROM {logs}
+		WHERE request = ANY($3)
+			AND request_time >= $1
+			AND request_time < $2
+			AND status = ANY($4)
+			` + excluded + `
+		GROUP BY request`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	perRequest := make(map[string]int32)
+	for rows.Next() {
+		var request string
+		var count pgtype.Int8
+		err = rows.Scan(&request, &count)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		perRequest[request] = int32(count.Int64)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	DLs, DLsPerVersion = db.filters.downloadCounts(perRequest, startDate)
+	return
+}
+
+// SaveUniqueDownloads sets the unique downloads counts of the rows of a downloads stats table for the given date.  The
+// rows need to have been saved already, with the raw counts
+func (db *DB) SaveUniqueDownloads(ctx context.Context, table string, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	dbQuery := fmt.Sprintf(`
+		UPDATE %s
+		SET unique_downloads = $3
+		WHERE stats_date = $1
+			AND db4s_download = $2`, table)
+
+	// NOTE - The hard coded 0 value for the db4s download corresponds to the manually added "Total downloads" entry in
+	// the DB4S download info table
+	_, err := db.exec(ctx, dbQuery, date, 0, count)
+	if err != nil {
+		log.Printf("Saving unique downloads failed: %v\n", err)
+		return err
+	}
+	for id, n := range DLsPerVersion {
+		_, err = db.exec(ctx, dbQuery, date, id, n)
+		if err != nil {
+			log.Printf("Saving unique downloads failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadCounts adds up the number of requests for each request path into the totals for each download, plus the
+// overall total.  The requests are counted under the downloads their request paths count under at startDate, so the
+// date range counted mustn't span any of the mappings' date bounds (see countMappedParts)
+func (f *Filters) downloadCounts(perRequest map[string]int32, startDate time.Time) (DLs int32, DLsPerVersion map[int]int32) {
+	DLsPerVersion = make(map[int]int32)
+	for _, file := range f.downloads {
+		DLsPerVersion[file.ID] = 0
+	}
+	for request, n := range perRequest {
+		if id, ok := f.DownloadOf(request, startDate); ok {
+			DLsPerVersion[id] += n
+			DLs += n
+		}
+	}
+	return
+}
+
+// SaveDailyDownloadsStats inserts new or updated daily download stats counts into the db4s_downloads_daily table
+func (db *DB) SaveDailyDownloadsStats(ctx context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	if db.bulkLoad {
+		return db.bulkSaveDownloads(ctx, "db4s_downloads_daily", date, count, DLsPerVersion)
+	}
+
+	// Update the non-version-specific daily stats
+	// NOTE - The hard coded 0 value for the db4s download corresponds to the manually added "Total downloads" entry in
+	// the DB4S download info table
+	dbQuery := `
+		INSERT INTO db4s_downloads_daily (stats_date, db4s_download, num_downloads)
+		VALUES ($1, 0, $2)
+		ON CONFLICT (stats_date, db4s_download)
+			DO UPDATE
+				SET num_downloads = $2
+				WHERE db4s_downloads_daily.stats_date = $1
+					AND db4s_downloads_daily.db4s_download = 0`
+	commandTag, err := db.exec(ctx, dbQuery, date, count)
+	if err != nil {
+		// For now, don't bother logging a failure here.  This *might* need changing later on
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding a daily download stats row: %v\n", numRows, date)
+	}
+
+	// Update the version-specific daily download stats
+	for version, DLCount := range DLsPerVersion {
+		dbQuery = `
+		INSERT INTO db4s_downloads_daily (stats_date, db4s_download, num_downloads)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (stats_date, db4s_download)
+			DO UPDATE
+				SET num_downloads = $3
+				WHERE db4s_downloads_daily.stats_date = $1
+					AND db4s_downloads_daily.db4s_download = $2`
+		commandTag, err := db.exec(ctx, dbQuery, date, version, DLCount)
+		if err != nil {
+			// For now, don't bother logging a failure here.  This *might* need changing later on
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows > 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding a daily download stats row: %v\n", numRows, date)
+		}
+	}
+	return nil
+}
+
+// SaveMonthlyDownloadsStats inserts new or updated monthly download stats counts into the db4s_downloads_monthly table
+func (db *DB) SaveMonthlyDownloadsStats(ctx context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	if db.bulkLoad {
+		return db.bulkSaveDownloads(ctx, "db4s_downloads_monthly", date, count, DLsPerVersion)
+	}
+
+	// Update the non-version-specific monthly stats
+	// NOTE - The hard coded 0 value for the db4s download corresponds to the manually added "Total downloads" entry in
+	// the DB4S download info table
+	dbQuery := `
+		INSERT INTO db4s_downloads_monthly (stats_date, db4s_download, num_downloads)
+		VALUES ($1, 0, $2)
+		ON CONFLICT (stats_date, db4s_download)
+			DO UPDATE
+				SET num_downloads = $2
+				WHERE db4s_downloads_monthly.stats_date = $1
+					AND db4s_downloads_monthly.db4s_download = 0`
+	commandTag, err := db.exec(ctx, dbQuery, date, count)
+	if err != nil {
+		// For now, don't bother logging a failure here.  This *might* need changing later on
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding a monthly download stats row: %v\n", numRows, date)
+	}
+
+	// Update the version-specific monthly download stats
+	for version, DLCount := range DLsPerVersion {
+		dbQuery = `
+		INSERT INTO db4s_downloads_monthly (stats_date, db4s_download, num_downloads)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (stats_date, db4s_download)
+			DO UPDATE
+				SET num_downloads = $3
+				WHERE db4s_downloads_monthly.stats_date = $1
+					AND db4s_downloads_monthly.db4s_download = $2`
+		commandTag, err := db.exec(ctx, dbQuery, date, version, DLCount)
+		if err != nil {
+			// For now, don't bother logging a failure here.  This *might* need changing later on
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows > 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding a monthly download stats row: %v\n", numRows, date)
+		}
+	}
+	return nil
+}
+
+// SaveWeeklyDownloadsStats inserts new or updated weekly download stats counts into the db4s_downloads_weekly table
+func (db *DB) SaveWeeklyDownloadsStats(ctx context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	if db.bulkLoad {
+		return db.bulkSaveDownloads(ctx, "db4s_downloads_weekly", date, count, DLsPerVersion)
+	}
+
+	// Update the non-version-specific weekly stats
+	// NOTE - The hard coded 0 value for the db4s download corresponds to the manually added "Total downloads" entry in
+	// the DB4S download info table
+	dbQuery := `
+		INSERT INTO db4s_downloads_weekly (stats_date, db4s_download, num_downloads)
+		VALUES ($1, 0, $2)
+		ON CONFLICT (stats_date, db4s_download)
+			DO UPDATE
+				SET num_downloads = $2
+				WHERE db4s_downloads_weekly.stats_date = $1
+					AND db4s_downloads_weekly.db4s_download = 0`
+	commandTag, err := db.exec(ctx, dbQuery, date, count)
+	if err != nil {
+		// For now, don't bother logging a failure here.  This *might* need changing later on
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding a weekly download stats row: %v\n", numRows, date)
+	}
+
+	// Update the version-specific weekly download stats
+	for version, DLCount := range DLsPerVersion {
+		dbQuery = `
+		INSERT INTO db4s_downloads_weekly (stats_date, db4s_download, num_downloads)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (stats_date, db4s_download)
+			DO UPDATE
+				SET num_downloads = $3
+				WHERE db4s_downloads_weekly.stats_date = $1
+					AND db4s_downloads_weekly.db4s_download = $2`
+		commandTag, err := db.exec(ctx, dbQuery, date, version, DLCount)
+		if err != nil {
+			// For now, don't bother logging a failure here.  This *might* need changing later on
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows > 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding a weekly download stats row: %v\n", numRows, date)
+		}
+	}
+	return nil
+}
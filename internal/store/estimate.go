@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// EstimateUsers enables the estimated users stats.  checksPerUser is the typical number of version checks a single
+// user makes per day, above which an IP address is estimated to have several users behind it
+func (db *DB) EstimateUsers(checksPerUser int) {
+	db.checksPerUser = checksPerUser
+}
+
+// clickHouseEstimatedUsers returns the estimated users for the given date range, as per GetEstimatedUsers.  Bot
+// filtering isn't supported with ClickHouse, so this matches the PostgreSQL estimates
+func (db *DB) clickHouseEstimatedUsers(ctx context.Context, startDate, endDate time.Time) (users int, userAgentUsers map[string]int, err error) {
+	// WITH TOTALS adds the estimated number of users across all user agents, after an empty line
+	query := `
+		SELECT user_agent, sum(users)
+		FROM (
+			SELECT ip, user_agent, greatest(1, intDivOrZero(max(checks) + {checks:UInt32} - 1, {checks:UInt32})) AS users
+			FROM (
+				SELECT ` + db.clickHouseIPKey("coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, ''))") + ` AS ip,
+					` + db.filters.clickHouseUserAgent() + ` AS user_agent, toDate(request_time, 'UTC') AS day, count() AS checks
+				FROM {table}
+				WHERE request = '/currentrelease'
+					AND ` + db.filters.clickHouseUserAgents() + `
+					AND request_time >= toDateTime({start:Int64})
+					AND request_time < toDateTime({end:Int64})
+					AND status = 200
+					AND NOT ` + clickHouseExcluded + `
+				GROUP BY ip, user_agent, day
+			)
+			GROUP BY ip, user_agent
+		)
+		GROUP BY user_agent
+		WITH TOTALS`
+	params := clickHouseRange(startDate, endDate)
+	params["checks"] = strconv.Itoa(db.checksPerUser)
+	params["networks"] = db.clickHouseNetworks()
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	userAgentUsers = make(map[string]int)
+	totals := false
+	for _, row := range rows {
+		if len(row) == 1 && row[0] == "" {
+			totals = true
+			continue
+		}
+		if len(row) != 2 {
+			err = fmt.Errorf("unexpected ClickHouse result row: %q", row)
+			return
+		}
+		var n int
+		n, err = strconv.Atoi(row[1])
+		if err != nil {
+			err = fmt.Errorf("unexpected ClickHouse result row: %q", row)
+			return
+		}
+		if totals {
+			users = n
+		} else {
+			userAgentUsers[row[0]] = n
+		}
+	}
+	return
+}
+
+// SaveEstimatedUsers sets the estimated users of the rows of a users stats table for the given date.  The rows need to
+// have been saved already, with the unique IP counts
+func (db *DB) SaveEstimatedUsers(ctx context.Context, table string, date time.Time, users int, userAgentUsers map[string]int) error {
+	// NOTE - The hard coded 1 value for the release version corresponds to the manually added "Unique IPs" entry in
+	// the DB4S release info table
+	dbQuery := fmt.Sprintf(`
+		UPDATE %s
+		SET estimated_users = $3
+		WHERE stats_date = $1
+			AND db4s_release = $2`, table)
+	_, err := db.exec(ctx, dbQuery, date, 1, users)
+	if err != nil {
+		log.Printf("Saving estimated users failed: %v\n", err)
+		return err
+	}
+	dbQuery = fmt.Sprintf(`
+		UPDATE %s
+		SET estimated_users = $3
+		WHERE stats_date = $1
+			AND db4s_release = (
+				SELECT release_id
+				FROM db4s_release_info
+				WHERE version_number = $2)`, table)
+	for userAgent, n := range userAgentUsers {
+		_, err = db.exec(ctx, dbQuery, date, db.filters.UserAgentVersion(userAgent), n)
+		if err != nil {
+			log.Printf("Saving estimated users failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestUserCounterEstimates(t *testing.T) {
+	day := time.Date(2018, 8, 13, 9, 0, 0, 0, time.UTC)
+
+	// checks returns n version checks from an IP address on the given day
+	checks := func(IP, userAgent string, n int, day time.Time) []timedCheck {
+		out := make([]timedCheck, n)
+		for i := range out {
+			out[i] = timedCheck{versionCheck{IP, userAgent}, day.Add(time.Duration(i) * time.Minute)}
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		checks  [][]timedCheck
+		want    int
+		wantUAs map[string]int
+	}{
+		{"a user per IP address", [][]timedCheck{
+			checks("10.0.0.1", "sqlitebrowser 3.12.2", 1, day),
+			checks("10.0.0.2", "sqlitebrowser 3.12.2", 2, day),
+		}, 2, map[string]int{"sqlitebrowser 3.12.2": 2}},
+		{"several users behind an IP address", [][]timedCheck{
+			checks("10.0.0.1", "sqlitebrowser 3.12.2", 7, day),
+		}, 3, map[string]int{"sqlitebrowser 3.12.2": 3}},
+		{"busiest day", [][]timedCheck{
+			checks("10.0.0.1", "sqlitebrowser 3.12.2", 4, day),
+			checks("10.0.0.1", "sqlitebrowser 3.12.2", 2, day.AddDate(0, 0, 1)),
+		}, 2, map[string]int{"sqlitebrowser 3.12.2": 2}},
+		{"per user agent", [][]timedCheck{
+			checks("10.0.0.1", "sqlitebrowser 3.12.2", 4, day),
+			checks("10.0.0.1", "sqlitebrowser 3.13.0", 1, day),
+		}, 3, map[string]int{"sqlitebrowser 3.12.2": 2, "sqlitebrowser 3.13.0": 1}},
+	}
+	for _, test := range tests {
+		c := NewUserCounter(func(userAgent string) string { return userAgent }, nil, 3)
+		for _, group := range test.checks {
+			for _, check := range group {
+				c.Add(check.IP, check.userAgent, check.at)
+			}
+		}
+		c.Counts()
+		users, userAgentUsers := c.Estimates()
+		if users != test.want || !maps.Equal(userAgentUsers, test.wantUAs) {
+			t.Errorf("%v: UserCounter.Estimates() = %d, %v, expected %d, %v", test.name, users, userAgentUsers,
+				test.want, test.wantUAs)
+		}
+	}
+}
+
+// timedCheck is a version check made at a given time
+type timedCheck struct {
+	versionCheck
+	at time.Time
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// Requests from our own mirrors, known CI providers and package build farms aren't real users or downloads.  When
+// networks are excluded, the requests from them are left out of the users and downloads stats, with the number left
+// out being saved as the excluded traffic of each metric family.  The failed downloads are still counted, as broken
+// links matter whoever finds them
+
+// clickHouseExcluded is the ClickHouse condition matching the requests from the excluded networks, as per the
+// db4s_in_networks() PostgreSQL function.  It needs the networks query parameter, from clickHouseNetworks()
+const clickHouseExcluded = `(
+	(isIPv4String(ifNull(coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, '')), ''))
+		OR isIPv6String(ifNull(coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, '')), '')))
+	AND arrayExists(n -> isIPAddressInRange(
+		coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, '')), n), {networks:Array(String)}))`
+
+// ExcludedTraffic is the number of requests from the excluded networks in a time period
+type ExcludedTraffic struct {
+	VersionChecks int64
+	Downloads     int64
+}
+
+// ExcludeNetworks leaves the requests from the given IP address ranges out of the users and downloads stats.  The
+// TimescaleDB continuous aggregate doesn't have the IP addresses, so this isn't supported when using it
+func (db *DB) ExcludeNetworks(networks []string) error {
+	if len(networks) == 0 {
+		return nil
+	}
+	if db.timescale {
+		return errors.New("excluding networks isn't supported when using the TimescaleDB continuous aggregate")
+	}
+	db.excluded = nil
+	for _, s := range networks {
+		network, err := ParseNetwork(s)
+		if err != nil {
+			return fmt.Errorf("invalid excluded IP address or range '%v': %w", s, err)
+		}
+		db.excluded = append(db.excluded, network)
+	}
+	return nil
+}
+
+// requestIP is the SQL expression for the client IP address of a download_log row, with the same field precedence as
+// IPCounter
+const requestIP = `coalesce(nullif(client_ip_strange, ''), nullif(client_ipv6, ''), nullif(client_ipv4, ''))`
+
+// notInNetworks returns the condition leaving out the requests whose IP address expression is in one of the networks,
+// plus the query arguments with the networks added to the end.  There's no condition when there aren't any networks,
+// so the db4s_in_networks() function is only needed when some are excluded
+func notInNetworks(ip string, networks []netip.Prefix, args []any) (string, []any) {
+	if len(networks) == 0 {
+		return "", args
+	}
+	return fmt.Sprintf("AND NOT db4s_in_networks(%s, $%d)", ip, len(args)+1), append(args, networks)
+}
+
+// clickHouseNetworks returns the excluded networks as an Array(String) query parameter
+func (db *DB) clickHouseNetworks() string {
+	networks := make([]string, len(db.excluded))
+	for i, network := range db.excluded {
+		networks[i] = network.String()
+	}
+	return clickHouseArray(networks)
+}
+
+// GetExcludedTraffic returns the number of version checks and download requests from the excluded networks in the
+// given date range.  This is nil when no networks are excluded
+func (db *DB) GetExcludedTraffic(ctx context.Context, startDate time.Time, endDate time.Time) (*ExcludedTraffic, error) {
+	if len(db.excluded) == 0 {
+		return nil, nil
+	}
+	if db.clickHouse != nil {
+		return db.clickHouseExcludedTraffic(ctx, startDate, endDate)
+	}
+
+	// The requests are counted the same way as by GetIPs() and GetDownloads(), with the version checks going by the
+	// IP address of the user when X-Forwarded-For resolution is enabled
+	ip, ipArgs := db.userIP(6)
+	dbQuery := fmt.Sprintf(`
+		SELECT count(*) FILTER (WHERE request = '/currentrelease' AND status = 200
+				AND %[2]s
+				AND db4s_in_networks(%[1]s, $5)),
+			count(*) FILTER (WHERE request = ANY($3) AND status = ANY($4)
+				AND db4s_in_networks(%[3]s, $5))
+		FROM {logs}
+		WHERE (request = '/currentrelease' OR request = ANY($3))
+			AND request_time >= $1
+			AND request_time < $2`, ip, db.filters.pgUserAgents(), requestIP)
+	args := append([]any{&startDate, &endDate, db.filters.DownloadRequests(), db.downloadStatuses(), db.excluded},
+		ipArgs...)
+	var t ExcludedTraffic
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	err := db.queryLogsRow(ctx, dbQuery, args...).Scan(&t.VersionChecks, &t.Downloads)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	return &t, nil
+}
+
+// clickHouseExcludedTraffic returns the number of requests from the excluded networks, as per GetExcludedTraffic
+func (db *DB) clickHouseExcludedTraffic(ctx context.Context, startDate, endDate time.Time) (*ExcludedTraffic, error) {
+	query := `
+		SELECT countIf(request = '/currentrelease' AND status = 200
+				AND ` + db.filters.clickHouseUserAgents() + `),
+			countIf(request IN {requests:Array(String)} AND status IN {statuses:Array(Int32)})
+		FROM {table}
+		WHERE (request = '/currentrelease' OR request IN {requests:Array(String)})
+			AND request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})
+			AND ` + clickHouseExcluded
+	params := clickHouseRange(startDate, endDate)
+	params["requests"] = clickHouseArray(db.filters.DownloadRequests())
+	params["statuses"] = clickHouseStatuses(db.downloadStatuses())
+	params["networks"] = db.clickHouseNetworks()
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return nil, err
+	}
+	if len(rows) != 1 || len(rows[0]) != 2 {
+		return nil, fmt.Errorf("unexpected ClickHouse result: %q", rows)
+	}
+	var t ExcludedTraffic
+	t.VersionChecks, err = strconv.ParseInt(rows[0][0], 10, 64)
+	if err == nil {
+		t.Downloads, err = strconv.ParseInt(rows[0][1], 10, 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected ClickHouse result: %q", rows)
+	}
+	return &t, nil
+}
+
+// SaveExcludedTraffic saves the number of requests from the excluded networks for a metric family for the time period
+// starting at the given date
+func (db *DB) SaveExcludedTraffic(ctx context.Context, family string, date time.Time, requests int64) error {
+	dbQuery := `
+		INSERT INTO stats_excluded_traffic (metric_family, stats_date, requests)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (metric_family, stats_date)
+			DO UPDATE
+				SET requests = $3
+				WHERE stats_excluded_traffic.metric_family = $1
+					AND stats_excluded_traffic.stats_date = $2`
+	_, err := db.exec(ctx, dbQuery, family, date, requests)
+	if err != nil {
+		log.Printf("Saving excluded traffic failed: %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestNotInNetworks(t *testing.T) {
+	// Without any networks there's no condition, and no extra query argument
+	args := []any{1, 2}
+	cond, got := notInNetworks("ip", nil, args)
+	if cond != "" || len(got) != 2 {
+		t.Errorf("notInNetworks() = %q with %d arguments, expected no condition and 2 arguments", cond, len(got))
+	}
+
+	// The networks are added as the next query argument
+	networks := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	cond, got = notInNetworks("ip", networks, args)
+	if want := "AND NOT db4s_in_networks(ip, $3)"; cond != want || len(got) != 3 {
+		t.Errorf("notInNetworks() = %q with %d arguments, expected %q and 3 arguments", cond, len(got), want)
+	}
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// defaultArtifactPattern matches the request paths of the DB4S download artifacts.  Failed requests are counted for any
+// path matching it, rather than just the known downloads, as a broken link (eg to a renamed installer) won't be one of
+// those
+var defaultArtifactPattern = regexp.MustCompile(`\.(AppImage|dmg|exe|msi|zip)$`)
+
+// FailedDownload is the number of failed requests for a download artifact with a given HTTP status code
+type FailedDownload struct {
+	Request  string
+	Status   int
+	Failures int64
+}
+
+// IsFailedStatus returns whether an HTTP status code is counted as a failed download
+func IsFailedStatus(status int) bool {
+	return status == 403 || status == 404 || status >= 500
+}
+
+// TrackFailedDownloads enables the failed downloads stats.  These scan the download log rows of each time period for
+// the failed requests, so they're opt in
+func (db *DB) TrackFailedDownloads() {
+	db.failed = true
+}
+
+// GetFailedDownloads returns the number of failed (403, 404 and 5xx) requests for each download artifact and status
+// code in the given date range.  This is nil when the failed downloads stats aren't enabled
+func (db *DB) GetFailedDownloads(ctx context.Context, startDate time.Time, endDate time.Time) (failed []FailedDownload, err error) {
+	if !db.failed {
+		return
+	}
+	if db.clickHouse != nil {
+		return db.clickHouseFailedDownloads(ctx, startDate, endDate)
+	}
+
+	dbQuery := `
+		SELECT request, status, count(*)
+		FROM {logs}
+		WHERE request ~ $3
+			AND request_time >= $1
+			AND request_time < $2
+			AND (status IN (403, 404) OR status >= 500)
+		GROUP BY request, status
+		ORDER BY request, status`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, &startDate, &endDate, db.filters.artifacts.String())
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	failed = []FailedDownload{}
+	for rows.Next() {
+		var f FailedDownload
+		err = rows.Scan(&f.Request, &f.Status, &f.Failures)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		failed = append(failed, f)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
+
+// clickHouseFailedDownloads returns the failed download counts for the given date range, as per GetFailedDownloads
+func (db *DB) clickHouseFailedDownloads(ctx context.Context, startDate, endDate time.Time) ([]FailedDownload, error) {
+	query := `
+		SELECT request, status, count()
+		FROM {table}
+		WHERE match(request, {pattern:String})
+			AND request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})
+			AND (status IN (403, 404) OR status >= 500)
+		GROUP BY request, status
+		ORDER BY request, status`
+	params := clickHouseRange(startDate, endDate)
+	params["pattern"] = db.filters.artifacts.String()
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return nil, err
+	}
+	failed := []FailedDownload{}
+	for _, row := range rows {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		status, err := strconv.Atoi(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		n, err := strconv.ParseInt(row[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		failed = append(failed, FailedDownload{Request: row[0], Status: status, Failures: n})
+	}
+	return failed, nil
+}
+
+// SaveFailedDownloads saves the failed download counts of a metric family for the time period starting at the given
+// date, replacing any saved earlier for it
+func (db *DB) SaveFailedDownloads(ctx context.Context, family string, date time.Time, failed []FailedDownload) error {
+	// Remove the earlier counts first, so requests which no longer fail (eg the counts were reprocessed) don't linger
+	dbQuery := `
+		DELETE FROM db4s_failed_downloads
+		WHERE metric_family = $1
+			AND stats_date = $2`
+	_, err := db.exec(ctx, dbQuery, family, date)
+	if err != nil {
+		log.Printf("Saving failed downloads failed: %v\n", err)
+		return err
+	}
+	dbQuery = `
+		INSERT INTO db4s_failed_downloads (metric_family, stats_date, request, status, failures)
+		VALUES ($1, $2, $3, $4, $5)`
+	for _, f := range failed {
+		_, err = db.exec(ctx, dbQuery, family, date, f.Request, f.Status, f.Failures)
+		if err != nil {
+			log.Printf("Saving failed downloads failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
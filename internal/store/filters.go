@@ -0,0 +1,187 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Which download log rows count as downloads can be tweaked in the config file, rather than needing code changes.  eg
+// to leave out the request paths of a temporary mirror, or to count another status code as a successful download
+
+// Filters decide which download log rows are counted in the stats: the downloads and their request paths, the HTTP
+// status codes counted as downloads, and the user agents of the version checks.  Each DB (and memstore.Store) has its
+// own, so two of them in one process don't affect each other
+type Filters struct {
+	downloads  []DownloadFile   // The downloads we generate stats for
+	windows    []requestWindow  // When the request paths of the mapped downloads count, in priority order
+	artifacts  *regexp.Regexp   // Request paths of the download artifacts, for the failed downloads stats
+	statuses   []int32          // HTTP status codes of the requests counted as downloads
+	ignored    []*regexp.Regexp // Request paths left out of the downloads stats
+	uaPrefixes []string         // User agent prefixes of the version checks
+	uaExcludes []string         // Strings whose user agents aren't version checks, even with one of the prefixes
+}
+
+// NewFilters returns the default filters, counting the DB4S downloads and version checks
+func NewFilters() *Filters {
+	f := &Filters{
+		artifacts:  defaultArtifactPattern,
+		statuses:   []int32{200},
+		uaPrefixes: []string{"sqlitebrowser "},
+		uaExcludes: []string{"AppEngine"},
+	}
+	for _, file := range defaultDownloadFiles {
+		file.Requests = slices.Clone(file.Requests)
+		f.downloads = append(f.downloads, file)
+	}
+	return f
+}
+
+// SetDownloadFilters replaces the HTTP status codes counted as downloads, and sets the patterns of the request paths
+// left out of the downloads stats.  The default status codes are kept when statuses is nil
+func (f *Filters) SetDownloadFilters(statuses []int, ignore []string) error {
+	if statuses != nil {
+		var codes []int32
+		for _, s := range statuses {
+			if s < 100 || s > 599 {
+				return fmt.Errorf("invalid download status code %d", s)
+			}
+			codes = append(codes, int32(s))
+		}
+		if len(codes) == 0 {
+			return fmt.Errorf("the download status codes can't be empty")
+		}
+		f.statuses = codes
+	}
+	var ignored []*regexp.Regexp
+	for _, pattern := range ignore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid ignored request pattern '%v': %w", pattern, err)
+		}
+		ignored = append(ignored, re)
+	}
+	f.ignored = ignored
+
+	// Drop the ignored request paths from the downloads already known
+	files := f.downloads
+	f.downloads = nil
+	f.AddDownloadFiles(files...)
+	return nil
+}
+
+// SetUserAgentPatterns replaces the user agent prefixes and exclusions of the version checks.  The defaults are kept
+// for whichever is nil
+func (f *Filters) SetUserAgentPatterns(prefixes, excludes []string) error {
+	for _, p := range prefixes {
+		if strings.TrimSpace(p) == "" {
+			return errors.New("user agent prefixes can't be empty")
+		}
+	}
+	if prefixes != nil {
+		f.uaPrefixes = slices.Clone(prefixes)
+	}
+	if excludes != nil {
+		f.uaExcludes = slices.Clone(excludes)
+	}
+	return nil
+}
+
+// SetDownloadPattern replaces the downloads with the request paths matching the pattern, which are then added as they
+// show up.  The failed downloads stats are for the matching request paths too
+func (f *Filters) SetDownloadPattern(pattern *regexp.Regexp) {
+	f.downloads = nil
+	f.artifacts = pattern
+}
+
+// DownloadFiles returns the downloads we generate stats for.  The IDs and names are as per the db4s_download_info
+// table
+func (f *Filters) DownloadFiles() []DownloadFile {
+	return f.downloads
+}
+
+// DownloadRequests returns the request paths of all the downloads we generate stats for
+func (f *Filters) DownloadRequests() (requests []string) {
+	for _, file := range f.downloads {
+		requests = append(requests, file.Requests...)
+	}
+	return
+}
+
+// AddDownloadFiles adds downloads to the list we generate stats for, skipping any whose ID is already in it.  Ignored
+// request paths are left out, along with the downloads left without any
+func (f *Filters) AddDownloadFiles(files ...DownloadFile) {
+	for _, file := range files {
+		file.Requests = slices.DeleteFunc(slices.Clone(file.Requests), f.IsIgnoredRequest)
+		if len(file.Requests) == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(f.downloads, func(d DownloadFile) bool { return d.ID == file.ID }) {
+			f.downloads = append(f.downloads, file)
+		}
+	}
+}
+
+// mapDownloadRequests sets the request paths of a download, adding the download when it's not in the list yet.
+// Ignored request paths are left out
+func (f *Filters) mapDownloadRequests(id int, name string, requests []string) {
+	requests = slices.DeleteFunc(slices.Clone(requests), f.IsIgnoredRequest)
+	i := slices.IndexFunc(f.downloads, func(d DownloadFile) bool { return d.ID == id })
+	if i < 0 {
+		if len(requests) > 0 {
+			f.downloads = append(f.downloads, DownloadFile{ID: id, Name: name, Requests: requests})
+		}
+		return
+	}
+	f.downloads[i].Requests = requests
+}
+
+// ArtifactPattern returns the pattern matching the request paths of the download artifacts
+func (f *Filters) ArtifactPattern() *regexp.Regexp {
+	return f.artifacts
+}
+
+// DownloadStatuses returns the HTTP status codes of the requests counted as downloads.  The 206 (partial content)
+// requests are counted too when folding partial downloads
+func (f *Filters) DownloadStatuses() []int32 {
+	return f.statuses
+}
+
+// IsIgnoredRequest returns whether a request path is left out of the downloads stats
+func (f *Filters) IsIgnoredRequest(request string) bool {
+	return slices.ContainsFunc(f.ignored, func(re *regexp.Regexp) bool { return re.MatchString(request) })
+}
+
+// IsDownloadStatus returns whether an HTTP status code is counted as a download, as per DownloadStatuses
+func (f *Filters) IsDownloadStatus(status int) bool {
+	return slices.Contains(f.statuses, int32(status))
+}
+
+// UseDownloadFilters replaces the HTTP status codes counted as downloads, and sets the patterns of the request paths
+// left out of the downloads stats, as per Filters.SetDownloadFilters
+func (db *DB) UseDownloadFilters(statuses []int, ignore []string) error {
+	return db.filters.SetDownloadFilters(statuses, ignore)
+}
+
+// UseUserAgentPatterns replaces the user agent prefixes and exclusions of the version checks, as per
+// Filters.SetUserAgentPatterns
+func (db *DB) UseUserAgentPatterns(prefixes, excludes []string) error {
+	return db.filters.SetUserAgentPatterns(prefixes, excludes)
+}
+
+// Filters returns the filters deciding which download log rows are counted in the stats
+func (db *DB) Filters() *Filters {
+	return db.filters
+}
+
+// clickHouseStatuses returns a list of HTTP status codes as an Array(Int32) query parameter
+func clickHouseStatuses(statuses []int32) string {
+	s := make([]string, len(statuses))
+	for i, status := range statuses {
+		s[i] = strconv.Itoa(int(status))
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestFiltersIndependent(t *testing.T) {
+	// Changing the filters of one DB leaves those of another alone
+	a, b := NewFilters(), NewFilters()
+	if err := a.SetDownloadFilters([]int{200, 206}, []string{`-win32\.`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetUserAgentPatterns([]string{"dbhub-cli/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	a.SetDownloadPattern(regexp.MustCompile(`^/dbhub-cli-`))
+	a.AddDownloadFiles(DownloadFile{ID: 1, Name: "dbhub-cli 0.1.0", Requests: []string{"/dbhub-cli-0.1.0.zip"}})
+
+	if !a.IsDownloadStatus(206) || b.IsDownloadStatus(206) {
+		t.Error("the download statuses are shared")
+	}
+	win32 := "/DB.Browser.for.SQLite-3.12.2-win32.msi"
+	if !a.IsIgnoredRequest(win32) || b.IsIgnoredRequest(win32) {
+		t.Error("the ignored requests are shared")
+	}
+	if a.isVersionCheckUserAgent("sqlitebrowser 3.12.2") || !b.isVersionCheckUserAgent("sqlitebrowser 3.12.2") {
+		t.Error("the user agent prefixes are shared")
+	}
+	if len(a.DownloadFiles()) != 1 || len(b.DownloadFiles()) != len(defaultDownloadFiles) {
+		t.Errorf("the downloads are shared: %d and %d", len(a.DownloadFiles()), len(b.DownloadFiles()))
+	}
+	if a.ArtifactPattern() == b.ArtifactPattern() {
+		t.Error("the artifact pattern is shared")
+	}
+}
+
+func TestSetDownloadFilters(t *testing.T) {
+	f := NewFilters()
+	if err := f.SetDownloadFilters(nil, []string{`^/DB\.Browser\.for\.SQLite-3\.11\.1(v2)?\.dmg$`}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.IsDownloadStatus(200) {
+		t.Error("the default status is lost")
+	}
+
+	// The download with only ignored request paths is dropped
+	if slices.ContainsFunc(f.DownloadFiles(), func(d DownloadFile) bool { return d.ID == 14 }) {
+		t.Error("download with only ignored request paths is kept")
+	}
+	if slices.Contains(f.DownloadRequests(), "/DB.Browser.for.SQLite-3.11.1v2.dmg") {
+		t.Error("ignored request path is kept")
+	}
+
+	for _, statuses := range [][]int{{}, {99}, {600}} {
+		if err := f.SetDownloadFilters(statuses, nil); err == nil {
+			t.Errorf("no error for the statuses %v", statuses)
+		}
+	}
+	if err := f.SetDownloadFilters(nil, []string{"("}); err == nil {
+		t.Error("no error for an invalid ignored request pattern")
+	}
+}
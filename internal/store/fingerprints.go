@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Fingerprint summarises the download logs of a time period.  The download logs of a past time period only change by
+// rows being added (eg late ingestion) or removed (eg archiving), either of which changes its fingerprint
+type Fingerprint struct {
+	Rows           int64
+	MaxRequestTime time.Time // Zero when there are no rows
+}
+
+// LogsFingerprint returns the fingerprint of the download logs in the given date range
+func (db *DB) LogsFingerprint(ctx context.Context, startDate time.Time, endDate time.Time) (Fingerprint, error) {
+	dbQuery := `
+		SELECT count(*), max(request_time)
+		FROM {logs}
+		WHERE request_time >= $1
+			AND request_time < $2`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	var fp Fingerprint
+	var maxTime pgtype.Timestamp
+	err := db.queryLogsRow(ctx, dbQuery, &startDate, &endDate).Scan(&fp.Rows, &maxTime)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return Fingerprint{}, err
+	}
+	if maxTime.Valid {
+		fp.MaxRequestTime = maxTime.Time.UTC()
+	}
+	return fp, nil
+}
+
+// PeriodFingerprint returns the fingerprint of the download logs saved when a time period of a metric family was
+// last processed.  The returned bool is false when there isn't one
+func (db *DB) PeriodFingerprint(ctx context.Context, family string, date time.Time) (Fingerprint, bool, error) {
+	dbQuery := `
+		SELECT log_rows, max_request_time
+		FROM stats_period_fingerprints
+		WHERE metric_family = $1
+			AND stats_date = $2`
+	var fp Fingerprint
+	var maxTime pgtype.Timestamp
+	err := db.queryRow(ctx, dbQuery, family, date).Scan(&fp.Rows, &maxTime)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Fingerprint{}, false, nil
+	}
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return Fingerprint{}, false, err
+	}
+	if maxTime.Valid {
+		fp.MaxRequestTime = maxTime.Time.UTC()
+	}
+	return fp, true, nil
+}
+
+// SaveFingerprint records the fingerprint of the download logs a time period of a metric family was processed from
+func (db *DB) SaveFingerprint(ctx context.Context, family string, date time.Time, fp Fingerprint) error {
+	maxTime := pgtype.Timestamp{Time: fp.MaxRequestTime, Valid: !fp.MaxRequestTime.IsZero()}
+	dbQuery := `
+		INSERT INTO stats_period_fingerprints (metric_family, stats_date, log_rows, max_request_time)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (metric_family, stats_date)
+			DO UPDATE SET log_rows = $3, max_request_time = $4`
+	_, err := db.exec(ctx, dbQuery, family, date, fp.Rows, maxTime)
+	if err != nil {
+		log.Printf("Saving the fingerprint of %v for %v failed: %v\n", family, date.Format("2006-01-02"), err)
+	}
+	return err
+}
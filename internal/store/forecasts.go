@@ -0,0 +1,29 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SaveForecast saves the forecast total of a metric family for the time period starting at the given date, replacing
+// any earlier forecast for it
+func (db *DB) SaveForecast(ctx context.Context, family string, date time.Time, predicted int64, method string) error {
+	dbQuery := `
+		INSERT INTO stats_forecasts (metric_family, stats_date, predicted, method, created)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (metric_family, stats_date)
+			DO UPDATE
+				SET predicted = $3, method = $4, created = now()
+				WHERE stats_forecasts.metric_family = $1
+					AND stats_forecasts.stats_date = $2`
+	commandTag, err := db.exec(ctx, dbQuery, family, date, predicted, method)
+	if err != nil {
+		log.Printf("Saving forecast failed: %v\n", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when saving the forecast for: %v\n", numRows, family)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// A single broken client (eg one stuck in a retry loop) can make thousands of requests a day.  IP addresses making more
+// than the allowed number of version checks or download requests on any day of a time period are left out of its
+// users or downloads stats, with the number of them and the requests they made being saved as the heavy hitters of
+// each metric family.  The version checks limit is the max_requests of the bot filter
+
+// HeavyHitters is the number of IP addresses exceeding the daily limits in a time period, along with the number of
+// requests they made in it
+type HeavyHitters struct {
+	CheckIPs    int64
+	Checks      int64
+	DownloadIPs int64
+	Downloads   int64
+}
+
+// ExcludeHeavyHitters leaves the download requests from IP addresses making more than maxDownloads of them on any day
+// of a time period out of its downloads stats.  The TimescaleDB continuous aggregate doesn't have the IP addresses, and
+// the downloads are counted using PostgreSQL functions, so this isn't supported when using it or ClickHouse
+func (db *DB) ExcludeHeavyHitters(maxDownloads int) error {
+	if maxDownloads <= 0 {
+		return nil
+	}
+	if db.timescale {
+		return errors.New("excluding heavy hitters isn't supported when using the TimescaleDB continuous aggregate")
+	}
+	if db.clickHouse != nil {
+		return errors.New("excluding heavy hitters isn't supported when reading the download logs from ClickHouse")
+	}
+	db.maxDownloads = maxDownloads
+	return nil
+}
+
+// heavyHitters returns the IP addresses making more than a limit of the requests matching the condition on any day of
+// the given date range, along with the number of those requests each made in it.  The query arguments start with the
+// dates and the limit, as $1 to $3, followed by those of the condition
+func (db *DB) heavyHitters(ctx context.Context, ip, condition string, startDate, endDate time.Time, args []any) (map[string]int64, error) {
+	dbQuery := fmt.Sprintf(`
+		SELECT ip, sum(n)
+		FROM (
+			SELECT %[1]s AS ip, date_trunc('day', request_time) AS day, count(*) AS n
+			FROM {logs}
+			WHERE request_time >= $1
+				AND request_time < $2
+				AND %[2]s
+			GROUP BY 1, 2
+		) d
+		WHERE ip IS NOT NULL
+		GROUP BY ip
+		HAVING max(n) > $3`, ip, condition)
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	requests := make(map[string]int64)
+	for rows.Next() {
+		var IP string
+		var n int64
+		err = rows.Scan(&IP, &n)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		requests[IP] = n
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return requests, nil
+}
+
+// heavyDownloaders returns the IP addresses making more than the allowed number of download requests on any day of the
+// given date range, along with the number of download requests each made in it
+func (db *DB) heavyDownloaders(ctx context.Context, startDate, endDate time.Time) (map[string]int64, error) {
+	excluded, args := notInNetworks(requestIP, db.excluded, []any{&startDate, &endDate, db.maxDownloads,
+		db.filters.DownloadRequests(), db.downloadStatuses()})
+	return db.heavyHitters(ctx, requestIP, `
+				request = ANY($4)
+				AND status = ANY($5)
+				`+excluded, startDate, endDate, args)
+}
+
+// downloadExclusions returns the networks whose requests are left out of the downloads stats for the given date range,
+// for notInNetworks().  That's the excluded networks, plus the heavy hitters when they're excluded
+func (db *DB) downloadExclusions(ctx context.Context, startDate, endDate time.Time) ([]netip.Prefix, error) {
+	if db.maxDownloads <= 0 {
+		return db.excluded, nil
+	}
+	heavy, err := db.heavyDownloaders(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	networks := slices.Clone(db.excluded)
+	for IP := range heavy {
+		// Values which aren't IP addresses (eg from the client_ip_strange field) can't be matched by
+		// db4s_in_networks(), so are still counted
+		addr, err := netip.ParseAddr(IP)
+		if err != nil {
+			continue
+		}
+		networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return networks, nil
+}
+
+// GetHeavyHitters returns the number of IP addresses making more than the allowed number of version checks or download
+// requests on any day of the given date range, along with the number of them they made in it.  This is nil when
+// neither limit is set
+func (db *DB) GetHeavyHitters(ctx context.Context, startDate time.Time, endDate time.Time) (*HeavyHitters, error) {
+	maxChecks := db.bots.MaxRequests()
+	if maxChecks <= 0 && db.maxDownloads <= 0 {
+		return nil, nil
+	}
+	if db.clickHouse != nil {
+		return nil, errors.New("the heavy hitters stats aren't supported when reading the download logs from ClickHouse")
+	}
+	h := &HeavyHitters{}
+	if maxChecks > 0 {
+		// The version checks are counted per IP address the same way as by GetIPs()
+		ip, ipArgs := db.userIP(4)
+		excluded, args := notInNetworks(ip, db.excluded, append([]any{&startDate, &endDate, maxChecks}, ipArgs...))
+		checkers, err := db.heavyHitters(ctx, db.ipKey(ip), `
+				request = '/currentrelease'
+				AND `+db.filters.pgUserAgents()+`
+				AND status = 200
+				`+excluded, startDate, endDate, args)
+		if err != nil {
+			return nil, err
+		}
+		h.CheckIPs = int64(len(checkers))
+		for _, n := range checkers {
+			h.Checks += n
+		}
+	}
+	if db.maxDownloads > 0 {
+		downloaders, err := db.heavyDownloaders(ctx, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+		h.DownloadIPs = int64(len(downloaders))
+		for _, n := range downloaders {
+			h.Downloads += n
+		}
+	}
+	return h, nil
+}
+
+// SaveHeavyHitters saves the number of IP addresses left out of a metric family for the time period starting at the
+// given date for making too many requests, along with the number of requests they made
+func (db *DB) SaveHeavyHitters(ctx context.Context, family string, date time.Time, IPs, requests int64) error {
+	dbQuery := `
+		INSERT INTO stats_heavy_hitters (metric_family, stats_date, ips, requests)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (metric_family, stats_date)
+			DO UPDATE
+				SET ips = $3, requests = $4
+				WHERE stats_heavy_hitters.metric_family = $1
+					AND stats_heavy_hitters.stats_date = $2`
+	_, err := db.exec(ctx, dbQuery, family, date, IPs, requests)
+	if err != nil {
+		log.Printf("Saving heavy hitters failed: %v\n", err)
+	}
+	return err
+}
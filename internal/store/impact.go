@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ReleaseImpact is the total of a daily metric family in the days before and after a release date
+type ReleaseImpact struct {
+	ReleaseID   int
+	Family      string
+	ReleaseDate time.Time
+	WindowDays  int
+	Before      int64
+	After       int64
+}
+
+// Uplift returns the percentage change from the days before the release to the days after it.  This is NULL when
+// there's nothing before the release to compare against
+func (r ReleaseImpact) Uplift() *float64 {
+	if r.Before == 0 {
+		return nil
+	}
+	uplift := float64(r.After-r.Before) * 100 / float64(r.Before)
+	return &uplift
+}
+
+// DatedReleases returns the release date of each entry in the db4s_release_info table with one, keyed by release ID
+func (db *DB) DatedReleases(ctx context.Context) (map[int]time.Time, error) {
+	dbQuery := `
+		SELECT release_id, release_date
+		FROM db4s_release_info
+		WHERE release_date IS NOT NULL`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	releases := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var date time.Time
+		err = rows.Scan(&id, &date)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		releases[id] = date
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return releases, nil
+}
+
+// SaveReleaseImpact saves the totals of a daily metric family before and after a release date, replacing any saved
+// earlier for it
+func (db *DB) SaveReleaseImpact(ctx context.Context, impact ReleaseImpact) error {
+	dbQuery := `
+		INSERT INTO stats_release_impact (release_id, metric_family, release_date, window_days, total_before, total_after,
+			uplift_pct)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (release_id, metric_family)
+			DO UPDATE
+				SET release_date = $3, window_days = $4, total_before = $5, total_after = $6, uplift_pct = $7
+				WHERE stats_release_impact.release_id = $1
+					AND stats_release_impact.metric_family = $2`
+	commandTag, err := db.exec(ctx, dbQuery, impact.ReleaseID, impact.Family, impact.ReleaseDate, impact.WindowDays,
+		impact.Before, impact.After, impact.Uplift())
+	if err != nil {
+		log.Printf("Saving release impact failed: %v\n", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when saving the release impact for: %v\n", numRows,
+			impact.ReleaseID)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ImportStats saves stats from outside the download logs (eg the download numbers from the old hosting) into a users or
+// downloads stats table, keyed by time period start date then release or download ID.  Each row is flagged with the
+// source, and replaces any saved earlier for the same time period and ID.  Everything is saved in one transaction
+func (db *DB) ImportStats(ctx context.Context, table, idColumn, valueColumn, source string, counts map[time.Time]map[int]int64) error {
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+	tx, err := db.pool.Begin(queryCtx)
+	if db.retryAuth(err) {
+		tx, err = db.pool.Begin(queryCtx)
+	}
+	if err != nil {
+		log.Printf("Importing stats failed: %v\n", err)
+		return err
+	}
+	defer tx.Rollback(queryCtx)
+
+	// The table and column names come from the metric family definitions, not user input
+	dbQuery := fmt.Sprintf(`
+		INSERT INTO %[1]s (stats_date, %[2]s, %[3]s, source)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (stats_date, %[2]s)
+			DO UPDATE
+				SET %[3]s = $3, source = $4`, table, idColumn, valueColumn)
+	dbQuery = db.withTables(dbQuery)
+	for date, row := range counts {
+		for id, count := range row {
+			if _, err = tx.Exec(queryCtx, dbQuery, date, id, count, source); err != nil {
+				log.Printf("Importing stats failed: %v\n", err)
+				return err
+			}
+		}
+	}
+	if err = tx.Commit(queryCtx); err != nil {
+		log.Printf("Importing stats failed: %v\n", err)
+		return err
+	}
+	return nil
+}
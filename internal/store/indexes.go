@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RequiredIndex is an index the queries rely on.  Without it they still work, but a run can quietly take hours
+// scanning whole tables instead
+type RequiredIndex struct {
+	Table   string
+	Name    string   // The name used when creating it.  Existing indexes with other names are fine
+	Columns []string // Any index starting with these columns will do, unless it needs to be unique
+	Unique  bool     // Needed as the target of ON CONFLICT, so must be on exactly these columns
+}
+
+// RequiredIndexes are the indexes the queries rely on.  download_log isn't created by InitSchema, so its indexes
+// may well be missing on a freshly loaded copy of it
+var RequiredIndexes = []RequiredIndex{
+	{Table: "download_log", Name: "download_log_request_time_idx", Columns: []string{"request_time"}},
+	{Table: "download_log", Name: "download_log_request_request_time_idx", Columns: []string{"request", "request_time"}},
+	{Table: "download_log", Name: "download_log_http_user_agent_idx", Columns: []string{"http_user_agent"}},
+	{Table: "db4s_channel_downloads", Name: "db4s_channel_downloads_pk", Columns: []string{"channel", "stats_date"}, Unique: true},
+	{Table: "db4s_download_info", Name: "db4s_download_info_request_uindex", Columns: []string{"request"}, Unique: true},
+	{Table: "db4s_downloads_daily", Name: "db4s_downloads_daily_stats_date_db4s_download_uindex", Columns: []string{"stats_date", "db4s_download"}, Unique: true},
+	{Table: "db4s_downloads_monthly", Name: "db4s_downloads_monthly_stats_date_db4s_download_uindex", Columns: []string{"stats_date", "db4s_download"}, Unique: true},
+	{Table: "db4s_downloads_weekly", Name: "db4s_downloads_weekly_stats_date_db4s_download_uindex", Columns: []string{"stats_date", "db4s_download"}, Unique: true},
+	{Table: "db4s_release_info", Name: "db4s_release_info_version_number_uindex", Columns: []string{"version_number"}, Unique: true},
+	{Table: "db4s_users_daily", Name: "db4s_users_daily_stats_date_db4s_release_uindex", Columns: []string{"stats_date", "db4s_release"}, Unique: true},
+	{Table: "db4s_users_monthly", Name: "db4s_users_monthly_stats_date_db4s_release_uindex", Columns: []string{"stats_date", "db4s_release"}, Unique: true},
+	{Table: "db4s_users_weekly", Name: "db4s_users_weekly_stats_date_db4s_release_uindex", Columns: []string{"stats_date", "db4s_release"}, Unique: true},
+	{Table: "stats_excluded_traffic", Name: "stats_excluded_traffic_pk", Columns: []string{"metric_family", "stats_date"}, Unique: true},
+	{Table: "stats_forecasts", Name: "stats_forecasts_pk", Columns: []string{"metric_family", "stats_date"}, Unique: true},
+	{Table: "stats_processing_state", Name: "stats_processing_state_pk", Columns: []string{"metric_family"}, Unique: true},
+	{Table: "stats_rolling_averages", Name: "stats_rolling_averages_pk", Columns: []string{"metric_family", "stats_date", "window_days"}, Unique: true},
+}
+
+// String returns a description of the index, eg "download_log (request, request_time)"
+func (idx RequiredIndex) String() string {
+	desc := fmt.Sprintf("%v (%v)", idx.Table, strings.Join(idx.Columns, ", "))
+	if idx.Unique {
+		desc = "unique " + desc
+	}
+	return desc
+}
+
+// satisfiedBy returns whether an existing index, with the given columns, will do
+func (idx RequiredIndex) satisfiedBy(columns []string, unique bool) bool {
+	if idx.Unique {
+		return unique && slices.Equal(columns, idx.Columns)
+	}
+	return len(columns) >= len(idx.Columns) && slices.Equal(columns[:len(idx.Columns)], idx.Columns)
+}
+
+// MissingIndexes returns the required indexes which don't exist.  Tables which don't exist are logged and skipped
+func (db *DB) MissingIndexes(ctx context.Context) (missing []RequiredIndex, err error) {
+	tables := make(map[string][]indexColumns)
+	for _, idx := range RequiredIndexes {
+		idx.Table, idx.Name = db.withTables(idx.Table), db.withTables(idx.Name)
+		indexes, checked := tables[idx.Table]
+		if !checked {
+			indexes, err = db.tableIndexes(ctx, idx.Table)
+			if err != nil {
+				return
+			}
+			if indexes == nil {
+				log.Printf("Table %v doesn't exist, skipping its indexes\n", idx.Table)
+			}
+			tables[idx.Table] = indexes
+		}
+		if indexes == nil {
+			continue
+		}
+		if !slices.ContainsFunc(indexes, func(i indexColumns) bool { return idx.satisfiedBy(i.columns, i.unique) }) {
+			missing = append(missing, idx)
+		}
+	}
+	return
+}
+
+// indexColumns is the key columns of an existing index
+type indexColumns struct {
+	columns []string
+	unique  bool
+}
+
+// tableIndexes returns the key columns of each index on a table, or nil when the table doesn't exist.  Expression
+// indexes are left out
+func (db *DB) tableIndexes(ctx context.Context, table string) (indexes []indexColumns, err error) {
+	var found bool
+	err = db.queryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&found)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	dbQuery := `
+		SELECT i.indisunique, array_agg(a.attname::text ORDER BY k.ord)
+		FROM pg_index i
+			JOIN LATERAL unnest(i.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord) ON true
+			JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		WHERE i.indrelid = to_regclass($1)
+			AND k.ord <= i.indnkeyatts
+			AND i.indexprs IS NULL
+		GROUP BY i.indexrelid, i.indisunique`
+	rows, cancel, err := db.query(ctx, dbQuery, table)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+
+	// A table without any indexes still gets a (non nil) empty list, telling it apart from a missing table
+	indexes = []indexColumns{}
+	for rows.Next() {
+		var i indexColumns
+		err = rows.Scan(&i.unique, &i.columns)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		indexes = append(indexes, i)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
+
+// CreateIndex creates a required index.  This is done concurrently, so the table can still be written to (eg by an
+// ingest) while the index is built.  It isn't bounded by the per query timeout, as building an index on the download
+// logs can take a long time
+func (db *DB) CreateIndex(ctx context.Context, idx RequiredIndex) error {
+	columns := make([]string, len(idx.Columns))
+	for i, c := range idx.Columns {
+		columns[i] = pgx.Identifier{c}.Sanitize()
+	}
+	unique := ""
+	if idx.Unique {
+		unique = "UNIQUE "
+	}
+	dbQuery := fmt.Sprintf(`CREATE %vINDEX CONCURRENTLY IF NOT EXISTS %v ON %v (%v)`, unique,
+		pgx.Identifier{idx.Name}.Sanitize(), pgx.Identifier{idx.Table}.Sanitize(), strings.Join(columns, ", "))
+	_, err := db.pool.Exec(ctx, dbQuery)
+	if db.retryAuth(err) {
+		_, err = db.pool.Exec(ctx, dbQuery)
+	}
+	if err != nil {
+		log.Printf("Creating index %v failed: %v\n", idx.Name, err)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// AggregateIPv6 counts the IPv6 addresses in the same /64 as a single IP address in the users stats.  A single user
+// often appears under many IPv6 addresses in the same /64, due to privacy extensions
+func (db *DB) AggregateIPv6() {
+	db.ipv6Prefix = true
+}
+
+// IPv6Prefix returns the /64 network of an IPv6 address, as per the db4s_ipv6_prefix() PostgreSQL function.  Other
+// values are returned unchanged
+func IPv6Prefix(IP string) string {
+	addr, err := netip.ParseAddr(IP)
+	if err != nil || !addr.Is6() || addr.Is4In6() {
+		return IP
+	}
+	return netip.PrefixFrom(addr.WithZone(""), 64).Masked().String()
+}
+
+// ipKey returns the SQL expression counted as a unique IP address, for the given IP address expression
+func (db *DB) ipKey(ip string) string {
+	if !db.ipv6Prefix {
+		return ip
+	}
+	return "db4s_ipv6_prefix(" + ip + ")"
+}
+
+// clickHouseIPKey returns the ClickHouse expression counted as a unique IP address, as per ipKey
+func (db *DB) clickHouseIPKey(ip string) string {
+	if !db.ipv6Prefix {
+		return ip
+	}
+	return fmt.Sprintf("if(isIPv6String(%[1]s) AND NOT startsWith(lower(%[1]s), '::ffff:'), "+
+		"concat(IPv6NumToString(cutIPv6(toIPv6(%[1]s), 8, 0)), '/64'), %[1]s)", ip)
+}
+
+// logIPv6Aggregation logs the number of unique IP addresses in the given date range with and without the IPv6 /64
+// aggregation, so its effect can be seen.  Bots aren't left out of these counts, so they can be slightly higher than
+// the users stats
+func (db *DB) logIPv6Aggregation(ctx context.Context, startDate, endDate time.Time) error {
+	var raw, aggregated int64
+	if db.clickHouse != nil {
+		query := `
+			SELECT uniqExact(ip), uniqExact(` + db.clickHouseIPKey("ip") + `)
+			FROM (
+				SELECT coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, '')) AS ip
+				FROM {table}
+				WHERE request = '/currentrelease'
+					AND ` + db.filters.clickHouseUserAgents() + `
+					AND request_time >= toDateTime({start:Int64})
+					AND request_time < toDateTime({end:Int64})
+					AND status = 200
+					AND NOT ` + clickHouseExcluded + `
+			)`
+		params := clickHouseRange(startDate, endDate)
+		params["networks"] = db.clickHouseNetworks()
+		rows, err := db.clickHouseQuery(ctx, query, params)
+		if err != nil {
+			log.Printf("ClickHouse query failed: %v\n", err)
+			return err
+		}
+		if len(rows) != 1 || len(rows[0]) != 2 {
+			return fmt.Errorf("unexpected ClickHouse result: %q", rows)
+		}
+		raw, err = strconv.ParseInt(rows[0][0], 10, 64)
+		if err == nil {
+			aggregated, err = strconv.ParseInt(rows[0][1], 10, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("unexpected ClickHouse result: %q", rows)
+		}
+	} else {
+		ip, ipArgs := db.userIP(3)
+		excluded, args := notInNetworks(ip, db.excluded, append([]any{&startDate, &endDate}, ipArgs...))
+		dbQuery := fmt.Sprintf(`
+			SELECT count(DISTINCT %[1]s), count(DISTINCT %[2]s)
+			FROM {logs}
+			WHERE request = '/currentrelease'
+				AND %[3]s
+				AND request_time >= $1
+				AND request_time < $2
+				AND status = 200
+				%[4]s`, ip, db.ipKey(ip), db.filters.pgUserAgents(), excluded)
+		dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+		err := db.queryLogsRow(ctx, dbQuery, args...).Scan(&raw, &aggregated)
+		if err != nil {
+			log.Printf("Database query failed: %v\n", err)
+			return err
+		}
+	}
+	log.Printf("Unique IP addresses between %v and %v: %d with IPv6 /64 aggregation, %d without\n",
+		startDate.Format("2006 Jan 2"), endDate.Format("2006 Jan 2"), aggregated, raw)
+	return nil
+}
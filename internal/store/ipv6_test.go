@@ -0,0 +1,36 @@
+package store
+
+import "testing"
+
+func TestIPv6Prefix(t *testing.T) {
+	tests := []struct {
+		IP   string
+		want string
+	}{
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2::1", "2001:db8:1:2::/64"},
+		{"2001:DB8:1:2:ffff:ffff:ffff:ffff", "2001:db8:1:2::/64"},
+		{"fe80::1%eth0", "fe80::/64"}, // The zone is dropped
+		{"::1", "::/64"},
+		{"192.0.2.10", "192.0.2.10"},               // IPv4 addresses are unchanged
+		{"::ffff:192.0.2.10", "::ffff:192.0.2.10"}, // As are IPv4 mapped ones
+		{"unknown", "unknown"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := IPv6Prefix(test.IP); got != test.want {
+			t.Errorf("IPv6Prefix(%q) = %v, expected %v", test.IP, got, test.want)
+		}
+	}
+}
+
+func TestIPKey(t *testing.T) {
+	db := &DB{}
+	if got := db.ipKey("ip"); got != "ip" {
+		t.Errorf("ipKey() = %v without aggregation, expected ip", got)
+	}
+	db.AggregateIPv6()
+	if got := db.ipKey("ip"); got != "db4s_ipv6_prefix(ip)" {
+		t.Errorf("ipKey() = %v with aggregation, expected db4s_ipv6_prefix(ip)", got)
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	pgpool "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Listener receives the notifications sent on a PostgreSQL channel, eg by the log ingestion process after each batch
+// of download log entries.  It holds a connection kept out of the pool until closed
+type Listener struct {
+	conn *pgpool.Conn
+}
+
+// Listen starts listening for notifications on the given channel
+func (db *DB) Listen(ctx context.Context, channel string) (*Listener, error) {
+	conn, err := db.pool.Acquire(ctx)
+	if db.retryAuth(err) {
+		conn, err = db.pool.Acquire(ctx)
+	}
+	if err != nil {
+		log.Printf("Database connection failed: %v\n", err)
+		return nil, err
+	}
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	if err != nil {
+		log.Printf("Listening on channel %v failed: %v\n", channel, err)
+		conn.Release()
+		return nil, err
+	}
+	return &Listener{conn: conn}, nil
+}
+
+// Wait blocks until the next notification arrives, returning its payload
+func (l *Listener) Wait(ctx context.Context) (string, error) {
+	n, err := l.conn.Conn().WaitForNotification(ctx)
+	if err != nil {
+		return "", err
+	}
+	return n.Payload, nil
+}
+
+// Close stops listening, returning the connection to the pool.  If the connection is no longer usable (eg a Wait was
+// interrupted), it's closed rather than going back into the pool
+func (l *Listener) Close() {
+	ctx := context.Background()
+	if _, err := l.conn.Exec(ctx, "UNLISTEN *"); err != nil {
+		l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+}
+
+// Notify sends a notification on the given channel, eg to tell the listen mode new download log entries are in
+func (db *DB) Notify(ctx context.Context, channel, payload string) error {
+	_, err := db.exec(ctx, `SELECT pg_notify($1, $2)`, channel, payload)
+	if err != nil {
+		log.Printf("Sending a notification on channel %v failed: %v\n", channel, err)
+	}
+	return err
+}
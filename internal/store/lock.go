@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"log"
+)
+
+// runLockID is the PostgreSQL advisory lock key held while generating the stats.  Other products (with a table
+// prefix) have the CRC-32 of their prefix in the upper half, so their runs don't block each other
+const runLockID = 0x44423453 // "DB4S"
+
+// ErrRunLocked is returned by LockRun when another run is already generating the stats
+var ErrRunLocked = errors.New("another run is already generating the stats")
+
+// LockRun takes the advisory lock for generating the stats, so overlapping runs (eg a slow run still going when cron
+// starts the next one) don't process the same time periods at once.  The lock is held by a connection kept out of the
+// pool until the returned function releases it, which needs doing before Close()
+func (db *DB) LockRun(ctx context.Context) (unlock func(), err error) {
+	conn, err := db.pool.Acquire(ctx)
+	if db.retryAuth(err) {
+		conn, err = db.pool.Acquire(ctx)
+	}
+	if err != nil {
+		log.Printf("Database connection failed: %v\n", err)
+		return nil, err
+	}
+	lockID := int64(runLockID)
+	if db.tablePrefix != "" {
+		lockID |= int64(crc32.ChecksumIEEE([]byte(db.tablePrefix))) << 32
+	}
+	var locked bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, lockID).Scan(&locked)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		conn.Release()
+		return nil, err
+	}
+	if !locked {
+		conn.Release()
+		return nil, ErrRunLocked
+	}
+	return func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+		if err != nil {
+			log.Printf("Releasing the run lock failed: %v\n", err)
+		}
+		conn.Release()
+	}, nil
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LogEntry is a single row of the download_log table.  Empty strings (and zero numbers) are saved as NULL
+type LogEntry struct {
+	ClientIPv4      string
+	ClientIPv6      string
+	ClientIPStrange string
+	ClientPort      int
+	RemoteUser      string
+	RequestTime     time.Time
+	RequestType     string // eg GET
+	Request         string
+	Protocol        string
+	Status          int
+	BodyBytesSent   int64
+	Referer         string
+	UserAgent       string
+}
+
+// logColumns are the download_log columns filled in from a LogEntry, in the order returned by logRow
+var logColumns = []string{"client_ipv4", "client_ipv6", "client_ip_strange", "client_port", "remote_user",
+	"request_time", "request_type", "request", "protocol", "status", "body_bytes_sent", "http_referer", "http_user_agent"}
+
+// logRows returns log entries as the source for a COPY into the download_log table
+func logRows(entries []LogEntry) pgx.CopyFromSource {
+	return pgx.CopyFromSlice(len(entries), func(i int) ([]any, error) {
+		e := entries[i]
+		return []any{nullString(e.ClientIPv4), nullString(e.ClientIPv6), nullString(e.ClientIPStrange),
+			nullInt(int64(e.ClientPort)), nullString(e.RemoteUser), e.RequestTime, nullString(e.RequestType), e.Request,
+			nullString(e.Protocol), e.Status, nullInt(e.BodyBytesSent), nullString(e.Referer), nullString(e.UserAgent)}, nil
+	})
+}
+
+// InsertLogEntries adds entries to the download_log table, returning the number of rows added
+func (db *DB) InsertLogEntries(ctx context.Context, entries []LogEntry) (int64, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+	n, err := db.pool.CopyFrom(queryCtx, pgx.Identifier{"download_log"}, logColumns, logRows(entries))
+	if db.retryAuth(err) {
+		n, err = db.pool.CopyFrom(queryCtx, pgx.Identifier{"download_log"}, logColumns, logRows(entries))
+	}
+	if err = db.checkTimeout(ctx, err); err != nil {
+		log.Printf("Adding download log entries failed: %v\n", err)
+	}
+	return n, err
+}
+
+// LogIngested returns whether a log file has already been loaded, either under the same name or (when the file covers
+// a known time range) as another file covering exactly the same time range
+func (db *DB) LogIngested(ctx context.Context, source, name string, rangeStart, rangeEnd time.Time) (ingested bool, err error) {
+	dbQuery := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM ingested_logs
+			WHERE source = $1
+				AND (name = $2 OR (range_start = $3 AND range_end = $4))
+		)`
+	err = db.queryRow(ctx, dbQuery, source, name, nullTime(rangeStart), nullTime(rangeEnd)).Scan(&ingested)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+	}
+	return
+}
+
+// LogTx loads a log file into the download_log table in a single transaction, which also records the file in the
+// ingested_logs table.  So a file is either loaded completely and recorded, or not at all
+type LogTx struct {
+	db *DB
+	tx pgx.Tx
+}
+
+// BeginLogs starts a transaction for loading a log file.  It isn't bounded by the per query timeout, as loading a
+// large file can take a while
+func (db *DB) BeginLogs(ctx context.Context) (*LogTx, error) {
+	tx, err := db.pool.Begin(ctx)
+	if db.retryAuth(err) {
+		tx, err = db.pool.Begin(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &LogTx{db: db, tx: tx}, nil
+}
+
+// InsertLogEntries adds entries to the download_log table as part of the transaction
+func (t *LogTx) InsertLogEntries(ctx context.Context, entries []LogEntry) (int64, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, t.db.queryTimeout)
+	defer cancel()
+	n, err := t.tx.CopyFrom(queryCtx, pgx.Identifier{"download_log"}, logColumns, logRows(entries))
+	if err = t.db.checkTimeout(ctx, err); err != nil {
+		log.Printf("Adding download log entries failed: %v\n", err)
+	}
+	return n, err
+}
+
+// Commit records the log file in the ingested_logs table, then commits the transaction.  The time range is optional
+func (t *LogTx) Commit(ctx context.Context, source, name string, rangeStart, rangeEnd time.Time, entries int64) error {
+	dbQuery := `
+		INSERT INTO ingested_logs (source, name, range_start, range_end, entries)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err := t.tx.Exec(ctx, dbQuery, source, name, nullTime(rangeStart), nullTime(rangeEnd), entries)
+	if err != nil {
+		log.Printf("Recording ingested log file failed: %v\n", err)
+		return err
+	}
+	return t.tx.Commit(ctx)
+}
+
+// Rollback abandons the transaction.  It's safe to call after Commit
+func (t *LogTx) Rollback(ctx context.Context) {
+	_ = t.tx.Rollback(ctx)
+}
+
+// nullString returns nil for an empty string, so it's saved as NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullInt returns nil for zero, so it's saved as NULL
+func nullInt(i int64) any {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
+
+// nullTime returns nil for the zero time, so it's saved as NULL
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
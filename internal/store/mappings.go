@@ -0,0 +1,354 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Which request paths count as the downloads of each db4s_download_info entry comes from the db4s_download_mappings
+// table, seeded by init-schema from the downloads list.  A mapping is either an exact request path or a regular
+// expression, optionally limited to the time the request path was in use.  So when an artifact gets renamed (eg the
+// "v" prefix added to the file names from 3.13.0) it's a new mapping row rather than a code change.  The mappings are
+// read at the start of each run, and replace the request paths of the downloads they're for.  The patterns are
+// matched in Go against the request paths in the download logs, so they mean the same whichever database holds them
+
+// DownloadMapping maps the request paths matching a pattern to a download.  A zero ActiveFrom or ActiveUntil leaves
+// that end of the active date range open
+type DownloadMapping struct {
+	Download    int
+	Pattern     string
+	Regex       bool
+	ActiveFrom  time.Time
+	ActiveUntil time.Time
+}
+
+// Compile checks the mapping's pattern, returning the regular expression matching the request paths it maps
+func (m DownloadMapping) Compile() (*regexp.Regexp, error) {
+	if !m.Regex {
+		return regexp.MustCompile("^" + regexp.QuoteMeta(m.Pattern) + "$"), nil
+	}
+	re, err := regexp.Compile(m.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid download mapping pattern '%v': %w", m.Pattern, err)
+	}
+	return re, nil
+}
+
+// Active returns whether the mapping applies to a request made at the given time
+func (m DownloadMapping) Active(t time.Time) bool {
+	return (m.ActiveFrom.IsZero() || !t.Before(m.ActiveFrom)) && (m.ActiveUntil.IsZero() || t.Before(m.ActiveUntil))
+}
+
+// requestWindow is a time range a request path counts as the downloads of a mapped download.  A zero from or until
+// leaves that end of the range open
+type requestWindow struct {
+	download    int
+	request     string
+	from, until time.Time
+}
+
+// active returns whether the window includes a request made at the given time
+func (w requestWindow) active(t time.Time) bool {
+	return (w.from.IsZero() || !t.Before(w.from)) && (w.until.IsZero() || t.Before(w.until))
+}
+
+// resolveMappings works out when each request path counts as the downloads of each mapping's download, from the
+// mappings in priority order and the request paths seen in the download logs.  A request path matched by several
+// mappings counts under the first one active at the time of each request, so it's never counted twice
+func resolveMappings(mappings []DownloadMapping, requests []string) (windows []requestWindow, err error) {
+	claimed := make(map[string][]requestWindow)
+	for _, m := range mappings {
+		re, err := m.Compile()
+		if err != nil {
+			return nil, err
+		}
+		matched := []string{m.Pattern}
+		if m.Regex {
+			matched = slices.DeleteFunc(slices.Clone(requests), func(r string) bool { return !re.MatchString(r) })
+		}
+		for _, r := range matched {
+			w := requestWindow{download: m.Download, request: r, from: m.ActiveFrom, until: m.ActiveUntil}
+			for _, part := range subtractWindows(w, claimed[r]) {
+				windows = append(windows, part)
+				claimed[r] = append(claimed[r], part)
+			}
+		}
+	}
+	return windows, nil
+}
+
+// subtractWindows returns the parts of a window not covered by any of the others
+func subtractWindows(w requestWindow, others []requestWindow) []requestWindow {
+	parts := []requestWindow{w}
+	for _, o := range others {
+		var remaining []requestWindow
+		for _, p := range parts {
+			// The part before the other window starts
+			if !o.from.IsZero() && (p.from.IsZero() || p.from.Before(o.from)) {
+				before := p
+				if p.until.IsZero() || o.from.Before(p.until) {
+					before.until = o.from
+				}
+				remaining = append(remaining, before)
+			}
+
+			// The part after the other window ends
+			if !o.until.IsZero() && (p.until.IsZero() || o.until.Before(p.until)) {
+				after := p
+				if p.from.IsZero() || p.from.Before(o.until) {
+					after.from = o.until
+				}
+				remaining = append(remaining, after)
+			}
+		}
+		parts = remaining
+	}
+	return parts
+}
+
+// SetMappings replaces the request paths of the mapped downloads in the downloads list with the ones their mappings
+// match, out of the exact request paths of the mappings plus the given request paths from the download logs.  The
+// mappings are in priority order.  names has the names of the downloads, with those missing coming from their first
+// request path.  The downloads without any mappings are left as is
+func (f *Filters) SetMappings(mappings []DownloadMapping, names map[int]string, requests []string) error {
+	windows, err := resolveMappings(mappings, requests)
+	if err != nil {
+		return err
+	}
+	f.windows = windows
+
+	var ids []int
+	mapped := make(map[int][]string)
+	for _, m := range mappings {
+		if !slices.Contains(ids, m.Download) {
+			ids = append(ids, m.Download)
+		}
+	}
+	for _, w := range windows {
+		if !slices.Contains(mapped[w.download], w.request) {
+			mapped[w.download] = append(mapped[w.download], w.request)
+		}
+	}
+	for _, id := range ids {
+		name := names[id]
+		if name == "" && len(mapped[id]) > 0 {
+			name = DownloadLabel(mapped[id][0])
+		}
+		f.mapDownloadRequests(id, name, mapped[id])
+	}
+	return nil
+}
+
+// DownloadOf returns the ID of the download a request made at the given time counts under.  The request paths of the
+// mapped downloads only count while their mappings are active, and a request path of several downloads counts under
+// the first of them
+func (f *Filters) DownloadOf(request string, t time.Time) (id int, ok bool) {
+	for _, file := range f.downloads {
+		if slices.Contains(file.Requests, request) && f.countsAt(file.ID, request, t) {
+			return file.ID, true
+		}
+	}
+	return 0, false
+}
+
+// countsAt returns whether a request path of a download counts under it at the given time.  This is always the case
+// for the request paths which aren't mapped
+func (f *Filters) countsAt(id int, request string, t time.Time) bool {
+	mapped := false
+	for _, w := range f.windows {
+		if w.download == id && w.request == request {
+			if w.active(t) {
+				return true
+			}
+			mapped = true
+		}
+	}
+	return !mapped
+}
+
+// mappingBounds returns the start and end dates of the mappings' active date ranges falling inside the given date
+// range, in order.  Between them, each request path counts under the same download throughout
+func (f *Filters) mappingBounds(startDate, endDate time.Time) (bounds []time.Time) {
+	for _, w := range f.windows {
+		for _, t := range []time.Time{w.from, w.until} {
+			if !t.IsZero() && t.After(startDate) && t.Before(endDate) && !slices.ContainsFunc(bounds, t.Equal) {
+				bounds = append(bounds, t)
+			}
+		}
+	}
+	slices.SortFunc(bounds, time.Time.Compare)
+	return
+}
+
+// countMappedParts counts the downloads in a date range by the parts of it between the given mapping bounds (as per
+// mappingBounds), adding up the results.  This way each part counts its request paths under the right downloads
+func countMappedParts[T int32 | int64](ctx context.Context, startDate, endDate time.Time, bounds []time.Time,
+	count func(context.Context, time.Time, time.Time) (T, map[int]T, error)) (total T, perVersion map[int]T, err error) {
+	perVersion = make(map[int]T)
+	for i, start := range append([]time.Time{startDate}, bounds...) {
+		end := endDate
+		if i < len(bounds) {
+			end = bounds[i]
+		}
+		n, counts, err := count(ctx, start, end)
+		if err != nil {
+			return 0, nil, err
+		}
+		total += n
+		for id, c := range counts {
+			perVersion[id] += c
+		}
+	}
+	return
+}
+
+// loadDownloadMappings replaces the request paths of the mapped downloads in the downloads list with the ones
+// their mappings match, as per Filters.SetMappings.  The earlier mappings take priority
+func (db *DB) loadDownloadMappings(ctx context.Context) error {
+	dbQuery := `
+		SELECT m.db4s_download, i.friendly_name, m.pattern, m.is_regex, m.active_from, m.active_until
+		FROM db4s_download_mappings m, db4s_download_info i
+		WHERE i.download_id = m.db4s_download
+		ORDER BY m.mapping_id`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return err
+	}
+	defer cancel()
+	defer rows.Close()
+	var mappings []DownloadMapping
+	names := make(map[int]string)
+	for rows.Next() {
+		var m DownloadMapping
+		var id int32
+		var name pgtype.Text
+		var from, until pgtype.Timestamp
+		err = rows.Scan(&id, &name, &m.Pattern, &m.Regex, &from, &until)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return err
+		}
+		m.Download = int(id)
+		m.ActiveFrom, m.ActiveUntil = from.Time, until.Time
+		mappings = append(mappings, m)
+		names[m.Download] = name.String
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return err
+	}
+
+	// Only the request paths in the logs can be known, so look them up for the regular expressions to match against
+	var requests []string
+	if slices.ContainsFunc(mappings, func(m DownloadMapping) bool { return m.Regex }) {
+		requests, err = db.successfulRequests(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return db.filters.SetMappings(mappings, names, requests)
+}
+
+// successfulRequests returns the distinct request paths in the download logs with at least one successful request
+func (db *DB) successfulRequests(ctx context.Context) (requests []string, err error) {
+	if db.clickHouse != nil {
+		query := `
+			SELECT DISTINCT request
+			FROM {table}
+			WHERE status = 200
+			ORDER BY request`
+		var rows [][]string
+		rows, err = db.clickHouseQuery(ctx, query, nil)
+		if err != nil {
+			log.Printf("ClickHouse query failed: %v\n", err)
+			return
+		}
+		for _, row := range rows {
+			requests = append(requests, row[0])
+		}
+		return
+	}
+
+	dbQuery := `
+		SELECT DISTINCT request
+		FROM download_log
+		WHERE status = 200
+		ORDER BY request`
+	rows, cancel, err := db.queryLogs(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var request string
+		err = rows.Scan(&request)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		requests = append(requests, request)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
+
+// DownloadInfo is an entry in the db4s_download_info table, along with its request path mappings
+type DownloadInfo struct {
+	ID       int
+	Name     string
+	Request  string // The request path of the entries added automatically, otherwise empty
+	Mappings []DownloadMapping
+}
+
+// ListDownloads returns the entries of the db4s_download_info table with their mappings, in ID order
+func (db *DB) ListDownloads(ctx context.Context) (downloads []DownloadInfo, err error) {
+	dbQuery := `
+		SELECT i.download_id, coalesce(i.friendly_name, ''), coalesce(i.request, ''), m.pattern, m.is_regex,
+			m.active_from, m.active_until
+		FROM db4s_download_info i
+			LEFT JOIN db4s_download_mappings m ON m.db4s_download = i.download_id
+		ORDER BY i.download_id, m.mapping_id`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var d DownloadInfo
+		var id int32
+		var pattern pgtype.Text
+		var regex pgtype.Bool
+		var from, until pgtype.Timestamp
+		err = rows.Scan(&id, &d.Name, &d.Request, &pattern, &regex, &from, &until)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		d.ID = int(id)
+		if n := len(downloads); n == 0 || downloads[n-1].ID != d.ID {
+			downloads = append(downloads, d)
+		}
+		if pattern.Valid {
+			last := &downloads[len(downloads)-1]
+			last.Mappings = append(last.Mappings, DownloadMapping{Download: d.ID, Pattern: pattern.String,
+				Regex: regex.Bool, ActiveFrom: from.Time, ActiveUntil: until.Time})
+		}
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDownloadMappings(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 10, d, 0, 0, 0, 0, time.UTC) }
+	mappings := []DownloadMapping{
+		// A date bounded exact path, reused for another artifact from the 10th
+		{Download: 100, Pattern: "/db4s.AppImage", ActiveUntil: day(10)},
+		{Download: 101, Pattern: "/db4s.AppImage", ActiveFrom: day(10)},
+
+		// Overlapping regular expressions, with the first one taking priority while it's active
+		{Download: 102, Pattern: `^/DB\.Browser-.*\.dmg$`, Regex: true, ActiveFrom: day(5), ActiveUntil: day(15)},
+		{Download: 103, Pattern: `\.dmg$`, Regex: true},
+	}
+	requests := []string{"/db4s.AppImage", "/DB.Browser-3.13.1.dmg", "/other.dmg", "/unmapped.zip"}
+	f := NewFilters()
+	if err := f.SetMappings(mappings, map[int]string{100: "AppImage (old)"}, requests); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		request string
+		at      time.Time
+		id      int
+		ok      bool
+	}{
+		{"/db4s.AppImage", day(1), 100, true},
+		{"/db4s.AppImage", day(10).Add(-time.Second), 100, true},
+		{"/db4s.AppImage", day(10), 101, true},
+		{"/db4s.AppImage", day(20), 101, true},
+		{"/DB.Browser-3.13.1.dmg", day(1), 103, true},
+		{"/DB.Browser-3.13.1.dmg", day(5), 102, true},
+		{"/DB.Browser-3.13.1.dmg", day(14), 102, true},
+		{"/DB.Browser-3.13.1.dmg", day(15), 103, true},
+		{"/other.dmg", day(7), 103, true},
+		{"/unmapped.zip", day(7), 0, false},
+		{"/DB.Browser.for.SQLite-3.10.1.dmg", day(7), 1, true}, // Downloads without mappings are left as is
+	}
+	for _, test := range tests {
+		id, ok := f.DownloadOf(test.request, test.at)
+		if id != test.id || ok != test.ok {
+			t.Errorf("DownloadOf(%q, %v) = %d, %v, expected %d, %v", test.request, test.at.Format(time.DateTime),
+				id, ok, test.id, test.ok)
+		}
+	}
+
+	// The mapped downloads are added with their names, or one from their request path when not given
+	names := make(map[int]string)
+	for _, file := range f.DownloadFiles() {
+		names[file.ID] = file.Name
+	}
+	if names[100] != "AppImage (old)" || names[103] != "3.13.1 macOS" {
+		t.Errorf("unexpected mapped download names: %q and %q", names[100], names[103])
+	}
+
+	// Each request is counted once, under the download its request path counts under at the time
+	DLs, perVersion := f.downloadCounts(map[string]int32{"/DB.Browser-3.13.1.dmg": 4, "/other.dmg": 2}, day(6))
+	if DLs != 6 || perVersion[102] != 4 || perVersion[103] != 2 {
+		t.Errorf("downloadCounts() = %d, %v, expected 6 with 4 for download 102 and 2 for 103", DLs, perVersion)
+	}
+
+	wantBounds := []time.Time{day(5), day(10), day(15)}
+	if got := f.mappingBounds(day(1), day(31)); !slices.EqualFunc(got, wantBounds, time.Time.Equal) {
+		t.Errorf("mappingBounds() = %v, expected %v", got, wantBounds)
+	}
+	if got := f.mappingBounds(day(10), day(15)); len(got) != 0 {
+		t.Errorf("mappingBounds() = %v for a range between the bounds, expected none", got)
+	}
+
+	// Counting a month adds up the parts between the bounds
+	var parts [][2]time.Time
+	count := func(_ context.Context, start, end time.Time) (int32, map[int]int32, error) {
+		parts = append(parts, [2]time.Time{start, end})
+		return 1, map[int]int32{100: 1}, nil
+	}
+	DLs, perVersion, err := countMappedParts(context.Background(), day(1), day(31), wantBounds, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantParts := [][2]time.Time{{day(1), day(5)}, {day(5), day(10)}, {day(10), day(15)}, {day(15), day(31)}}
+	if DLs != 4 || !maps.Equal(perVersion, map[int]int32{100: 4}) || !slices.Equal(parts, wantParts) {
+		t.Errorf("countMappedParts() = %d, %v over %v, expected 4 over %v", DLs, perVersion, parts, wantParts)
+	}
+
+	if err := f.SetMappings([]DownloadMapping{{Download: 1, Pattern: "(", Regex: true}}, nil, nil); err == nil {
+		t.Error("no error for an invalid mapping pattern")
+	}
+}
+
+func TestSubtractWindows(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 10, d, 0, 0, 0, 0, time.UTC) }
+	var open time.Time
+	tests := []struct {
+		name   string
+		w      requestWindow
+		others []requestWindow
+		want   []requestWindow
+	}{
+		{"no others", requestWindow{from: day(1), until: day(9)}, nil,
+			[]requestWindow{{from: day(1), until: day(9)}}},
+		{"disjoint", requestWindow{from: day(1), until: day(5)}, []requestWindow{{from: day(6), until: day(9)}},
+			[]requestWindow{{from: day(1), until: day(5)}}},
+		{"inside", requestWindow{from: day(1), until: day(9)}, []requestWindow{{from: day(3), until: day(5)}},
+			[]requestWindow{{from: day(1), until: day(3)}, {from: day(5), until: day(9)}}},
+		{"overlapping the start", requestWindow{from: day(3), until: day(9)},
+			[]requestWindow{{from: day(1), until: day(5)}}, []requestWindow{{from: day(5), until: day(9)}}},
+		{"open ended", requestWindow{}, []requestWindow{{from: day(3), until: day(5)}},
+			[]requestWindow{{until: day(3)}, {from: day(5)}}},
+		{"open ended other", requestWindow{from: day(3), until: day(9)}, []requestWindow{{from: day(5), until: open}},
+			[]requestWindow{{from: day(3), until: day(5)}}},
+		{"covered", requestWindow{from: day(3), until: day(5)}, []requestWindow{{}}, nil},
+	}
+	for _, test := range tests {
+		if got := subtractWindows(test.w, test.others); !slices.Equal(got, test.want) {
+			t.Errorf("%v: subtractWindows() = %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
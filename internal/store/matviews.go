@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// With the materialized views backend, the users and downloads aggregates are defined as PostgreSQL materialized views
+// over download_log, and each run just refreshes them.  Mapping the user agents to releases (and the request paths to
+// downloads) stays in Go, which fills in the lookup tables the views join against before they're refreshed.
+//
+// NOTE: The views count the raw log rows, so the excluded networks, bots, partial download folding, X-Forwarded-For
+//       resolution and IPv6 /64 aggregation aren't applied to them
+
+// matview is one of the materialized views of the materialized views backend
+type matview struct {
+	name     string
+	idColumn string // The release or download ID column, which with stats_date is unique
+	query    string
+}
+
+// matviewIP is the client IP address of a download_log row, as per IPCounter
+const matviewIP = `coalesce(nullif(l.client_ip_strange, ''), nullif(l.client_ipv6, ''), nullif(l.client_ipv4, ''))`
+
+// matviewUsers returns the query of a users materialized view, counting the unique IP addresses per release for each
+// time period of the given date_trunc() unit.  Release 1 is the "Unique IPs" entry for the totals, as per the users
+// stats tables
+func matviewUsers(unit string) string {
+	return fmt.Sprintf(`
+		SELECT date_trunc('%[1]s', l.request_time) AS stats_date, m.db4s_release, count(DISTINCT %[2]s) AS unique_ips
+		FROM download_log l
+			JOIN stats_user_agent_releases m ON m.http_user_agent = l.http_user_agent
+		WHERE l.request = '/currentrelease'
+			AND l.status = 200
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT date_trunc('%[1]s', l.request_time), 1, count(DISTINCT %[2]s)
+		FROM download_log l
+			JOIN stats_user_agent_releases m ON m.http_user_agent = l.http_user_agent
+		WHERE l.request = '/currentrelease'
+			AND l.status = 200
+		GROUP BY 1`, unit, matviewIP)
+}
+
+// matviewDownloads returns the query of a weekly or monthly downloads materialized view, which adds up the daily one
+func matviewDownloads(unit string) string {
+	return fmt.Sprintf(`
+		SELECT date_trunc('%s', stats_date) AS stats_date, db4s_download, sum(num_downloads)::bigint AS num_downloads
+		FROM stats_mv_downloads_daily
+		GROUP BY 1, 2`, unit)
+}
+
+// matviews are the materialized views of the materialized views backend, in dependency order.  Each comes after any
+// views it reads from, so refreshing them in order never reads stale data.  The unique IP addresses can't be added
+// together across days, so the weekly and monthly users views read the download logs too
+var matviews = []matview{
+	{"stats_mv_users_daily", "db4s_release", matviewUsers("day")},
+	{"stats_mv_users_weekly", "db4s_release", matviewUsers("week")},
+	{"stats_mv_users_monthly", "db4s_release", matviewUsers("month")},
+	{"stats_mv_downloads_daily", "db4s_download", `
+		SELECT date_trunc('day', l.request_time) AS stats_date, d.db4s_download, count(*) AS num_downloads
+		FROM download_log l
+			JOIN stats_download_requests d ON d.request = l.request
+		WHERE l.status = 200
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT date_trunc('day', l.request_time), 0, count(*)
+		FROM download_log l
+			JOIN stats_download_requests d ON d.request = l.request
+		WHERE l.status = 200
+		GROUP BY 1`},
+	{"stats_mv_downloads_weekly", "db4s_download", matviewDownloads("week")},
+	{"stats_mv_downloads_monthly", "db4s_download", matviewDownloads("month")},
+}
+
+// InitMatviews creates the lookup tables and materialized views of the materialized views backend, if they don't
+// exist already.  The views are created empty, and filled in by the first refresh
+func (db *DB) InitMatviews(ctx context.Context) error {
+	queries := []string{`
+		CREATE TABLE IF NOT EXISTS stats_user_agent_releases (
+			http_user_agent text PRIMARY KEY,
+			db4s_release integer NOT NULL
+		)`, `
+		CREATE TABLE IF NOT EXISTS stats_download_requests (
+			request text PRIMARY KEY,
+			db4s_download integer NOT NULL
+		)`}
+	for _, mv := range matviews {
+		// REFRESH ... CONCURRENTLY needs a unique index on the view
+		queries = append(queries,
+			fmt.Sprintf(`CREATE MATERIALIZED VIEW IF NOT EXISTS %s AS %s WITH NO DATA`, mv.name, mv.query),
+			fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %[1]s_idx ON %[1]s (stats_date, %[2]s)`, mv.name, mv.idColumn))
+	}
+	for _, dbQuery := range queries {
+		_, err := db.exec(ctx, dbQuery)
+		if err != nil {
+			log.Printf("Creating the materialized views failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// RefreshMatviews updates the lookup tables of the materialized views backend, then refreshes each materialized view
+// in dependency order.  The views are refreshed concurrently (so they can still be read from meanwhile), apart from
+// the first time, which needs a plain refresh to populate them
+func (db *DB) RefreshMatviews(ctx context.Context) error {
+	err := db.updateMatviewLookups(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mv := range matviews {
+		var populated bool
+		err = db.queryRow(ctx, `SELECT ispopulated FROM pg_matviews WHERE matviewname = $1`, mv.name).Scan(&populated)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("materialized view %v doesn't exist, run init-schema to create it", mv.name)
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v\n", err)
+			return err
+		}
+		dbQuery := "REFRESH MATERIALIZED VIEW CONCURRENTLY " + mv.name
+		if !populated {
+			dbQuery = "REFRESH MATERIALIZED VIEW " + mv.name
+		}
+		started := time.Now()
+		_, err = db.exec(ctx, dbQuery)
+		if err != nil {
+			log.Printf("Refreshing materialized view %v failed: %v\n", mv.name, err)
+			return err
+		}
+		if db.Verbosity >= verbosity.Verbose {
+			log.Printf("Refreshed %v in %v\n", mv.name, time.Since(started).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+// updateMatviewLookups fills in the release of each new (valid) version check user agent in the download logs, and
+// replaces the download of each request path with the current downloads list
+func (db *DB) updateMatviewLookups(ctx context.Context) error {
+	releases, err := db.ReleaseIDs(ctx)
+	if err != nil {
+		return err
+	}
+	dbQuery := `
+		SELECT DISTINCT l.http_user_agent
+		FROM download_log l
+		WHERE l.request = '/currentrelease'
+			AND ` + db.filters.pgUserAgents() + `
+			AND NOT EXISTS (
+				SELECT 1
+				FROM stats_user_agent_releases m
+				WHERE m.http_user_agent = l.http_user_agent)`
+	rows, cancel, err := db.queryLogs(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return err
+	}
+	defer cancel()
+	defer rows.Close()
+	var userAgents []string
+	for rows.Next() {
+		var userAgent pgtype.Text
+		err = rows.Scan(&userAgent)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return err
+		}
+		if userAgent.String != "" {
+			userAgents = append(userAgents, userAgent.String)
+		}
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return err
+	}
+	for _, userAgent := range userAgents {
+		// UpdateUserAgents adds the releases first, so a missing one means the user agent has no usable version
+		release, ok := releases[db.filters.UserAgentVersion(userAgent)]
+		if !ok {
+			continue
+		}
+		dbQuery = `
+			INSERT INTO stats_user_agent_releases (http_user_agent, db4s_release)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`
+		_, err = db.exec(ctx, dbQuery, userAgent, release)
+		if err != nil {
+			log.Printf("Adding user agent '%v' to the lookup table failed: %v\n", userAgent, err)
+			return err
+		}
+	}
+	if db.Verbosity >= verbosity.Verbose && len(userAgents) > 0 {
+		log.Printf("Looked up the releases of %d new user agent(s)\n", len(userAgents))
+	}
+
+	// The downloads are a short list, so are just replaced each time
+	_, err = db.exec(ctx, `DELETE FROM stats_download_requests`)
+	if err != nil {
+		log.Printf("Updating the download requests lookup table failed: %v\n", err)
+		return err
+	}
+	for _, file := range db.filters.downloads {
+		for _, request := range file.Requests {
+			dbQuery = `
+				INSERT INTO stats_download_requests (request, db4s_download)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING`
+			_, err = db.exec(ctx, dbQuery, request, file.ID)
+			if err != nil {
+				log.Printf("Updating the download requests lookup table failed: %v\n", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
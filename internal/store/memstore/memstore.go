@@ -0,0 +1,1209 @@
+// Package memstore is an in-memory implementation of store.Store, for testing the stats generation without a
+// PostgreSQL server
+package memstore
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+var _ store.Store = (*Store)(nil)
+
+// LogEntry is a single row of the download_log table.  Empty strings are used for NULL IP address fields
+type LogEntry struct {
+	RequestTime     time.Time
+	Request         string
+	Status          int
+	UserAgent       string
+	ClientIPv4      string
+	ClientIPv6      string
+	ClientIPStrange string
+	BodyBytesSent   int64
+	Referer         string
+	ForwardedFor    string
+}
+
+// Store holds the download log entries to generate stats from, and the stats tables they're saved into
+type Store struct {
+	// The download log entries to generate stats from
+	Log []LogEntry
+
+	// Whether to generate the bandwidth stats, as per store.DB.TrackBandwidth
+	TrackBandwidth bool
+
+	// Whether to generate the unique downloads stats, as per store.DB.TrackUniqueDownloads
+	TrackUniqueDownloads bool
+
+	// Whether to generate the failed downloads stats, as per store.DB.TrackFailedDownloads
+	TrackFailedDownloads bool
+
+	// Whether to roll the downloads up with those from the other distribution channels, as per
+	// store.DB.TrackChannelRollup
+	TrackChannelRollup bool
+
+	// Networks whose requests are left out of the users and downloads stats, as per store.DB.ExcludeNetworks
+	ExcludedNetworks []netip.Prefix
+
+	// Whether to count the IPv6 addresses in the same /64 as a single IP address in the users stats, as per
+	// store.DB.AggregateIPv6
+	AggregateIPv6 bool
+
+	// The typical number of version checks a single user makes per day, as per store.DB.EstimateUsers.  No estimated
+	// users stats are generated when zero
+	ChecksPerUser int
+
+	// The reverse proxies whose X-Forwarded-For headers are used when counting the unique IP addresses, as per
+	// store.DB.UseForwardedFor
+	TrustedProxies []netip.Prefix
+
+	// Excludes the version checks made by bots from the users stats, as per store.DB.FilterBots
+	Bots *store.BotFilter
+
+	// The number of top referrers kept for each download, as per store.DB.TrackReferrers.  No referrer stats are
+	// generated when zero
+	TopReferrers int
+
+	// When not zero, the 200 and 206 requests from a client for a file are counted as a single download, as per
+	// store.DB.FoldPartialDownloads
+	FoldWindow time.Duration
+
+	// The db4s_channel_downloads table as installs plus updates, keyed by distribution channel then stats date
+	Channels map[string]map[time.Time]int64
+
+	// The request paths of new release artifacts to add downloads for, as per store.DB.AutoAddDownloads
+	NewDownloads *regexp.Regexp
+
+	// The db4s_download_mappings table in mapping ID order, applied to the filters' download list by UpdateDownloads
+	Mappings []store.DownloadMapping
+
+	// Whether to generate the per OS users stats, as per store.DB.TrackOS
+	PerOS bool
+
+	// Whether to generate the check-in frequency stats, as per store.DB.TrackCheckIns
+	TrackCheckIns bool
+
+	// The download requests per IP address per day above which its downloads are left out, as per
+	// store.DB.ExcludeHeavyHitters.  Nothing is left out when zero
+	MaxDownloads int
+
+	// The release dates of the (non pre-release) releases, keyed by version number.  The update pending stats are
+	// generated when set, as per store.DB.TrackUpdatePending
+	ReleaseDates map[string]time.Time
+
+	// The db4s_release_info table, mapping version numbers to their release ID
+	Releases map[string]int
+
+	// The stats tables, keyed by table name then stats date then release or download ID
+	Tables map[string]map[time.Time]map[int]int64
+
+	// The stats_processing_state table
+	Watermarks map[string]time.Time
+
+	// The pct_change column of the stats tables, keyed by table name then stats date then release or download ID
+	Growth map[string]map[time.Time]map[int]float64
+
+	// The stats_forecasts table, keyed by metric family then stats date
+	Forecasts map[string]map[time.Time]Forecast
+
+	// The stats_period_fingerprints table, keyed by metric family then stats date
+	Fingerprints map[string]map[time.Time]store.Fingerprint
+
+	// The bytes_sent column of the downloads stats tables, keyed by table name then stats date then download ID
+	Bandwidth map[string]map[time.Time]map[int]int64
+
+	// The estimated_users column of the users stats tables, keyed by table name then stats date then release ID
+	EstimatedUsers map[string]map[time.Time]map[int]int64
+
+	// The db4s_downloads_by_channel table, keyed by metric family then stats date then distribution channel
+	ChannelRollups map[string]map[time.Time]map[string]int64
+
+	// The stats_excluded_traffic table, keyed by metric family then stats date
+	ExcludedTraffic map[string]map[time.Time]int64
+
+	// The db4s_failed_downloads table, keyed by metric family then stats date
+	FailedDownloads map[string]map[time.Time][]store.FailedDownload
+
+	// The tables of the declared metrics, keyed by table name then metric family then stats date then dimension
+	MetricCounts map[string]map[string]map[time.Time]map[string]int64
+
+	// The db4s_users_by_os table, keyed by metric family then stats date then OS
+	OSUsers map[string]map[time.Time]map[string]int
+
+	// The db4s_users_check_ins table, keyed by metric family then stats date then bucket
+	CheckIns map[string]map[time.Time]map[string]int
+
+	// The stats_heavy_hitters table as the number of IP addresses then requests, keyed by metric family then stats date
+	HeavyHitters map[string]map[time.Time][2]int64
+
+	// The db4s_download_referrers table, keyed by metric family then stats date
+	Referrers map[string]map[time.Time][]store.Referrer
+
+	// The stats_quality_reports table
+	QualityReports []store.QualityReport
+
+	// The stats_release_impact table, keyed by metric family then release ID
+	ReleaseImpacts map[string]map[int]store.ReleaseImpact
+
+	// The stats_rolling_averages table, keyed by metric family then stats date then window days
+	RollingAverages map[string]map[time.Time]map[int]float64
+
+	// The update_pending_pct column of the users stats tables, keyed by table name then stats date then release ID
+	UpdatePending map[string]map[time.Time]map[int]float64
+
+	// The unique_downloads column of the downloads stats tables, keyed by table name then stats date then download ID
+	UniqueDownloads map[string]map[time.Time]map[int]int64
+
+	filters *store.Filters
+	mu      sync.Mutex
+}
+
+// New returns an empty Store.  As with the PostgreSQL database, release ID 1 is the "Unique IPs" entry
+func New() *Store {
+	return &Store{
+		Releases:   map[string]int{"Unique IPs": 1},
+		Tables:     make(map[string]map[time.Time]map[int]int64),
+		Watermarks: make(map[string]time.Time),
+		Forecasts:  make(map[string]map[time.Time]Forecast),
+		Growth:     make(map[string]map[time.Time]map[int]float64),
+
+		Fingerprints: make(map[string]map[time.Time]store.Fingerprint),
+
+		Bandwidth:       make(map[string]map[time.Time]map[int]int64),
+		ChannelRollups:  make(map[string]map[time.Time]map[string]int64),
+		CheckIns:        make(map[string]map[time.Time]map[string]int),
+		EstimatedUsers:  make(map[string]map[time.Time]map[int]int64),
+		ExcludedTraffic: make(map[string]map[time.Time]int64),
+		FailedDownloads: make(map[string]map[time.Time][]store.FailedDownload),
+		HeavyHitters:    make(map[string]map[time.Time][2]int64),
+		MetricCounts:    make(map[string]map[string]map[time.Time]map[string]int64),
+		OSUsers:         make(map[string]map[time.Time]map[string]int),
+		Referrers:       make(map[string]map[time.Time][]store.Referrer),
+		ReleaseImpacts:  make(map[string]map[int]store.ReleaseImpact),
+		RollingAverages: make(map[string]map[time.Time]map[int]float64),
+		UniqueDownloads: make(map[string]map[time.Time]map[int]int64),
+		UpdatePending:   make(map[string]map[time.Time]map[int]float64),
+
+		filters: store.NewFilters(),
+	}
+}
+
+// Filters returns the filters deciding which download log entries are counted in the stats, as per store.DB.Filters
+func (s *Store) Filters() *store.Filters {
+	return s.filters
+}
+
+// Forecast is an entry in the stats_forecasts table
+type Forecast struct {
+	Predicted int64
+	Method    string
+}
+
+// CheckQuality counts the suspicious rows of the download log in the given date range
+func (s *Store) CheckQuality(_ context.Context, startDate time.Time, endDate time.Time) (store.QualityReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := store.QualityReport{From: startDate, To: endDate}
+	copies := make(map[LogEntry]int)
+	for _, e := range s.Log {
+		if !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		e.RequestTime = e.RequestTime.UTC()
+		copies[e]++
+		if copies[e] > 1 {
+			report.Duplicates++
+		}
+		if s.filters.IsVersionCheck(e.Request, e.UserAgent, e.Status) {
+			if clientIP(e) == "" {
+				report.NoClientIP++
+			}
+			if _, ok := store.ParseVersion(s.filters.UserAgentVersion(e.UserAgent)); !ok {
+				report.MalformedVersions++
+			}
+		}
+		if (e.Status == 200 || e.Status == 206) && s.filters.ArtifactPattern().MatchString(e.Request) &&
+			!slices.Contains(s.filters.DownloadRequests(), e.Request) {
+			report.UnknownDownloads++
+		}
+	}
+	return report, nil
+}
+
+// CountMatchingLogs isn't supported, as the filters of the declared metrics are SQL
+func (s *Store) CountMatchingLogs(_ context.Context, _, _ string, _ time.Time, _ time.Time) (map[string]int64, error) {
+	return nil, errors.New("declared metrics need the PostgreSQL database")
+}
+
+// DatedReleases returns the release dates in ReleaseDates, keyed by the release ID of their version
+func (s *Store) DatedReleases(_ context.Context) (map[int]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	releases := make(map[int]time.Time)
+	for v, date := range s.ReleaseDates {
+		if id, ok := s.Releases[v]; ok {
+			releases[id] = date
+		}
+	}
+	return releases, nil
+}
+
+// GetBandwidth returns the number of bytes sent for the DB4S downloads in the given date range, plus a breakdown per
+// download.  The breakdown is nil when TrackBandwidth isn't set
+func (s *Store) GetBandwidth(_ context.Context, startDate time.Time, endDate time.Time) (bytes int64, bytesPerVersion map[int]int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.TrackBandwidth {
+		return
+	}
+	bytesPerVersion = make(map[int]int64)
+	heavy := s.heavyDownloaders(startDate, endDate)
+	for _, file := range s.filters.DownloadFiles() {
+		bytesPerVersion[file.ID] = 0
+	}
+	for _, e := range s.Log {
+		if s.excluded(e) || heavy[clientIP(e)] > 0 {
+			continue
+		}
+		if (e.Status != 200 && e.Status != 206) || !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		if id, ok := s.filters.DownloadOf(e.Request, e.RequestTime); ok {
+			bytes += e.BodyBytesSent
+			bytesPerVersion[id] += e.BodyBytesSent
+		}
+	}
+	return
+}
+
+// GetChannelDownloads returns the downloads (installs plus updates) from each distribution channel in the Channels
+// table, for the days in the given date range.  This is nil when TrackChannelRollup isn't set
+func (s *Store) GetChannelDownloads(_ context.Context, startDate time.Time, endDate time.Time) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.TrackChannelRollup {
+		return nil, nil
+	}
+	perChannel := make(map[string]int64)
+	for channel, days := range s.Channels {
+		for date, downloads := range days {
+			if !date.Before(startDate) && date.Before(endDate) {
+				perChannel[channel] += downloads
+			}
+		}
+	}
+	return perChannel, nil
+}
+
+// GetDownloads returns the total number of DB4S downloads in the given date range, plus a breakdown per download
+func (s *Store) GetDownloads(_ context.Context, startDate time.Time, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	DLsPerVersion = make(map[int]int32)
+	for _, file := range s.filters.DownloadFiles() {
+		DLsPerVersion[file.ID] = 0
+	}
+	if s.FoldWindow > 0 {
+		return s.foldedDownloads(startDate, endDate, DLsPerVersion)
+	}
+	heavy := s.heavyDownloaders(startDate, endDate)
+	for _, e := range s.Log {
+		if s.excluded(e) || heavy[clientIP(e)] > 0 {
+			continue
+		}
+		if !s.filters.IsDownloadStatus(e.Status) || !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		if id, ok := s.filters.DownloadOf(e.Request, e.RequestTime); ok {
+			DLs++
+			DLsPerVersion[id]++
+		}
+	}
+	return
+}
+
+// foldedDownloads counts the 200 and 206 requests from each client for each file as downloads, starting a new one
+// after a gap of more than the fold window
+func (s *Store) foldedDownloads(startDate, endDate time.Time, DLsPerVersion map[int]int32) (DLs int32, _ map[int]int32, err error) {
+	type key struct {
+		ip, request string
+		download    int
+	}
+	times := make(map[key][]time.Time)
+	heavy := s.heavyDownloaders(startDate, endDate)
+	for _, e := range s.Log {
+		if s.excluded(e) || heavy[clientIP(e)] > 0 {
+			continue
+		}
+		if !s.downloadStatus(e.Status) || !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		id, ok := s.filters.DownloadOf(e.Request, e.RequestTime)
+		if !ok {
+			continue
+		}
+		k := key{clientIP(e), e.Request, id}
+		times[k] = append(times[k], e.RequestTime)
+	}
+	for k, t := range times {
+		slices.SortFunc(t, func(a, b time.Time) int { return a.Compare(b) })
+		n := int32(1)
+		for i := 1; i < len(t); i++ {
+			if t[i].Sub(t[i-1]) > s.FoldWindow {
+				n++
+			}
+		}
+		DLs += n
+		DLsPerVersion[k.download] += n
+	}
+	return DLs, DLsPerVersion, nil
+}
+
+// clientIP returns the IP address of a log entry, with the same precedence as store.IPCounter
+func clientIP(e LogEntry) string {
+	if e.ClientIPStrange != "" {
+		return e.ClientIPStrange
+	}
+	if e.ClientIPv6 != "" {
+		return e.ClientIPv6
+	}
+	return e.ClientIPv4
+}
+
+// GetEstimatedUsers returns the estimated number of DB4S users in the given date range, plus a breakdown per user
+// agent.  The breakdown is nil when ChecksPerUser isn't set
+func (s *Store) GetEstimatedUsers(_ context.Context, startDate time.Time, endDate time.Time) (users int, userAgentUsers map[string]int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ChecksPerUser <= 0 {
+		return
+	}
+	counter := store.NewUserCounter(s.filters.NormalizeUserAgent, s.Bots, s.ChecksPerUser)
+	counter = s.countUsers(startDate, endDate, counter)
+	counter.Counts()
+	users, userAgentUsers = counter.Estimates()
+	return
+}
+
+// GetExcludedTraffic returns the number of version checks and download requests from the excluded networks in the
+// given date range.  This is nil when no networks are excluded
+func (s *Store) GetExcludedTraffic(_ context.Context, startDate time.Time, endDate time.Time) (*store.ExcludedTraffic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ExcludedNetworks) == 0 {
+		return nil, nil
+	}
+	var t store.ExcludedTraffic
+	requests := s.filters.DownloadRequests()
+	for _, e := range s.Log {
+		if !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		if s.filters.IsVersionCheck(e.Request, e.UserAgent, e.Status) && store.InNetworks(s.ExcludedNetworks, s.userIP(e)) {
+			t.VersionChecks++
+		}
+		if slices.Contains(requests, e.Request) && s.downloadStatus(e.Status) && s.excluded(e) {
+			t.Downloads++
+		}
+	}
+	return &t, nil
+}
+
+// userIP returns the IP address of the user making a request, as per clientIP but resolving the X-Forwarded-For header
+// for requests through the trusted proxies
+func (s *Store) userIP(e LogEntry) string {
+	return store.ResolveClientIP(clientIP(e), e.ForwardedFor, s.TrustedProxies)
+}
+
+// ipKey returns what's counted as a unique IP address for a request, as per userIP but with the IPv6 addresses
+// aggregated by /64 when AggregateIPv6 is set
+func (s *Store) ipKey(e LogEntry) string {
+	if s.AggregateIPv6 {
+		return store.IPv6Prefix(s.userIP(e))
+	}
+	return s.userIP(e)
+}
+
+// downloadStatus returns whether a log entry with the given status is counted as a download, as per
+// store.DownloadStatuses plus the 206 requests when folding partial downloads
+func (s *Store) downloadStatus(status int) bool {
+	return s.filters.IsDownloadStatus(status) || (status == 206 && s.FoldWindow > 0)
+}
+
+// excluded returns whether a log entry is from one of the excluded networks
+func (s *Store) excluded(e LogEntry) bool {
+	return store.InNetworks(s.ExcludedNetworks, clientIP(e))
+}
+
+// GetFailedDownloads returns the number of failed (403, 404 and 5xx) requests for each download artifact and status
+// code in the given date range, ordered by request path then status code.  This is nil when TrackFailedDownloads isn't
+// set
+func (s *Store) GetFailedDownloads(_ context.Context, startDate time.Time, endDate time.Time) ([]store.FailedDownload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.TrackFailedDownloads {
+		return nil, nil
+	}
+	type key struct {
+		request string
+		status  int
+	}
+	counts := make(map[key]int64)
+	for _, e := range s.Log {
+		if store.IsFailedStatus(e.Status) && s.filters.ArtifactPattern().MatchString(e.Request) && inRange(e.RequestTime, startDate, endDate) {
+			counts[key{e.Request, e.Status}]++
+		}
+	}
+	failed := []store.FailedDownload{}
+	for k, n := range counts {
+		failed = append(failed, store.FailedDownload{Request: k.request, Status: k.status, Failures: n})
+	}
+	slices.SortFunc(failed, func(a, b store.FailedDownload) int {
+		if c := strings.Compare(a.Request, b.Request); c != 0 {
+			return c
+		}
+		return a.Status - b.Status
+	})
+	return failed, nil
+}
+
+// GetHeavyHitters returns the number of IP addresses making more than the allowed number of version checks or download
+// requests on any day of the given date range, along with the number they made.  This is nil when neither Bots has a
+// max requests limit nor MaxDownloads is set
+func (s *Store) GetHeavyHitters(_ context.Context, startDate time.Time, endDate time.Time) (*store.HeavyHitters, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maxChecks := s.Bots.MaxRequests()
+	if maxChecks <= 0 && s.MaxDownloads <= 0 {
+		return nil, nil
+	}
+	h := &store.HeavyHitters{}
+	if maxChecks > 0 {
+		checkers := s.heavyHitters(startDate, endDate, maxChecks, s.ipKey, func(e LogEntry) bool {
+			return s.filters.IsVersionCheck(e.Request, e.UserAgent, e.Status) &&
+				!store.InNetworks(s.ExcludedNetworks, s.userIP(e))
+		})
+		h.CheckIPs = int64(len(checkers))
+		for _, n := range checkers {
+			h.Checks += n
+		}
+	}
+	downloaders := s.heavyDownloaders(startDate, endDate)
+	h.DownloadIPs = int64(len(downloaders))
+	for _, n := range downloaders {
+		h.Downloads += n
+	}
+	return h, nil
+}
+
+// heavyDownloaders returns the IP addresses making more than MaxDownloads download requests on any day of the given
+// date range, along with the number they made in it.  This is empty when MaxDownloads isn't set
+func (s *Store) heavyDownloaders(startDate, endDate time.Time) map[string]int64 {
+	if s.MaxDownloads <= 0 {
+		return nil
+	}
+	requests := s.filters.DownloadRequests()
+	return s.heavyHitters(startDate, endDate, s.MaxDownloads, clientIP, func(e LogEntry) bool {
+		return slices.Contains(requests, e.Request) && s.downloadStatus(e.Status) &&
+			!s.excluded(e)
+	})
+}
+
+// heavyHitters returns the IP addresses making more than limit of the matching requests on any day of the given date
+// range, along with the number of them each made in it
+func (s *Store) heavyHitters(startDate, endDate time.Time, limit int, ip func(LogEntry) string, match func(LogEntry) bool) map[string]int64 {
+	perDay := make(map[string]map[time.Time]int64)
+	for _, e := range s.Log {
+		if !inRange(e.RequestTime, startDate, endDate) || !match(e) || ip(e) == "" {
+			continue
+		}
+		if perDay[ip(e)] == nil {
+			perDay[ip(e)] = make(map[time.Time]int64)
+		}
+		perDay[ip(e)][e.RequestTime.UTC().Truncate(24*time.Hour)]++
+	}
+	requests := make(map[string]int64)
+	for IP, days := range perDay {
+		var total, peak int64
+		for _, n := range days {
+			total += n
+			peak = max(peak, n)
+		}
+		if peak > int64(limit) {
+			requests[IP] = total
+		}
+	}
+	return requests
+}
+
+// GetIPs returns the number of unique IP addresses doing a version check in the given date range, plus a breakdown
+// per user agent
+func (s *Store) GetIPs(_ context.Context, startDate time.Time, endDate time.Time) (IPs int, userAgentIPs map[string]int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Bots != nil || len(s.TrustedProxies) > 0 || s.AggregateIPv6 {
+		IPs, userAgentIPs = s.sortedIPs(startDate, endDate)
+		return
+	}
+	counter := store.NewIPCounter()
+	for _, e := range s.Log {
+		if s.excluded(e) {
+			continue
+		}
+		if !s.filters.IsVersionCheck(e.Request, e.UserAgent, e.Status) || !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		err = counter.Add(s.filters.NormalizeUserAgent(e.UserAgent), e.ClientIPv4, e.ClientIPv6, e.ClientIPStrange)
+		if errors.Is(err, store.ErrNoClientIP) {
+			// As with the PostgreSQL query, these are left out and reported by CheckQuality instead
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+	IPs, userAgentIPs = counter.Counts()
+	return
+}
+
+// sortedIPs counts the unique IP addresses doing version checks as per GetIPs, resolving the X-Forwarded-For headers,
+// aggregating the IPv6 addresses and leaving out the bots
+func (s *Store) sortedIPs(startDate, endDate time.Time) (IPs int, userAgentIPs map[string]int) {
+	counter := store.NewUserCounter(s.filters.NormalizeUserAgent, s.Bots, 0)
+	IPs, userAgentIPs, _ = s.countUsers(startDate, endDate, counter).Counts()
+	return
+}
+
+// countUsers adds the version checks in the given date range to a store.UserCounter, returning it.  The version checks
+// are grouped by IP address first, as they are by the PostgreSQL query
+func (s *Store) countUsers(startDate, endDate time.Time, counter *store.UserCounter) *store.UserCounter {
+	var checks []LogEntry
+	for _, e := range s.Log {
+		if store.InNetworks(s.ExcludedNetworks, s.userIP(e)) {
+			continue
+		}
+		if s.filters.IsVersionCheck(e.Request, e.UserAgent, e.Status) && inRange(e.RequestTime, startDate, endDate) {
+			checks = append(checks, e)
+		}
+	}
+	slices.SortStableFunc(checks, func(a, b LogEntry) int {
+		return strings.Compare(s.ipKey(a), s.ipKey(b))
+	})
+	for _, e := range checks {
+		if s.ipKey(e) == "" {
+			continue
+		}
+		counter.Add(s.ipKey(e), e.UserAgent, e.RequestTime)
+	}
+	return counter
+}
+
+// GetCheckIns returns the number of IP address days in the given date range in each bucket of the daily number of
+// version checks.  This is nil when TrackCheckIns isn't set
+func (s *Store) GetCheckIns(_ context.Context, startDate time.Time, endDate time.Time) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.TrackCheckIns {
+		return nil, nil
+	}
+	type ipDay struct {
+		ip  string
+		day time.Time
+	}
+	checks := make(map[ipDay]int)
+	for _, e := range s.Log {
+		if store.InNetworks(s.ExcludedNetworks, s.userIP(e)) || s.ipKey(e) == "" {
+			continue
+		}
+		if s.filters.IsVersionCheck(e.Request, e.UserAgent, e.Status) && inRange(e.RequestTime, startDate, endDate) {
+			checks[ipDay{s.ipKey(e), e.RequestTime.UTC().Truncate(24 * time.Hour)}]++
+		}
+	}
+	buckets := make(map[string]int)
+	for _, n := range checks {
+		buckets[store.CheckInBucket(n)]++
+	}
+	return buckets, nil
+}
+
+// GetOSUsers returns the number of unique IP addresses doing a version check in the given date range for each OS.
+// This is nil when PerOS isn't set
+func (s *Store) GetOSUsers(_ context.Context, startDate time.Time, endDate time.Time) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.PerOS {
+		return nil, nil
+	}
+	counter := store.NewUserCounter(s.filters.UserAgentOS, s.Bots, 0)
+	_, perOS, _ := s.countUsers(startDate, endDate, counter).Counts()
+	return perOS, nil
+}
+
+// GetReferrers returns the top referrers for each DB4S download in the given date range, plus the top referrers across
+// all downloads under download ID 0.  This is nil when TopReferrers isn't set
+func (s *Store) GetReferrers(_ context.Context, startDate time.Time, endDate time.Time) ([]store.Referrer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.TopReferrers <= 0 {
+		return nil, nil
+	}
+	perRequest := make(map[string]map[string]int64)
+	heavy := s.heavyDownloaders(startDate, endDate)
+	for _, e := range s.Log {
+		if s.excluded(e) || heavy[clientIP(e)] > 0 {
+			continue
+		}
+		if !s.filters.IsDownloadStatus(e.Status) || !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		referrer := e.Referer
+		if referrer == "" || referrer == "-" {
+			referrer = store.DirectReferrer
+		}
+		if perRequest[e.Request] == nil {
+			perRequest[e.Request] = make(map[string]int64)
+		}
+		perRequest[e.Request][referrer]++
+	}
+	return s.filters.TopReferrers(perRequest, s.TopReferrers), nil
+}
+
+// GetUniqueDownloads returns the number of unique DB4S downloads in the given date range, plus a breakdown per
+// download.  Each IP address is only counted once per request path per day.  The breakdown is nil when
+// TrackUniqueDownloads isn't set
+func (s *Store) GetUniqueDownloads(_ context.Context, startDate time.Time, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.TrackUniqueDownloads {
+		return
+	}
+	type key struct {
+		ip, request string
+		day         time.Time
+	}
+	seen := make(map[key]struct{})
+	heavy := s.heavyDownloaders(startDate, endDate)
+	DLsPerVersion = make(map[int]int32)
+	for _, file := range s.filters.DownloadFiles() {
+		DLsPerVersion[file.ID] = 0
+	}
+	for _, e := range s.Log {
+		if s.excluded(e) || heavy[clientIP(e)] > 0 {
+			continue
+		}
+		if !s.downloadStatus(e.Status) || !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		k := key{clientIP(e), e.Request, e.RequestTime.UTC().Truncate(24 * time.Hour)}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		if id, ok := s.filters.DownloadOf(e.Request, e.RequestTime); ok {
+			seen[k] = struct{}{}
+			DLs++
+			DLsPerVersion[id]++
+		}
+	}
+	return
+}
+
+// GetUpdatePending returns the number of unique IP addresses doing a version check from an out of date release in the
+// given date range, plus a breakdown per user agent.  The breakdown is nil when ReleaseDates isn't set
+func (s *Store) GetUpdatePending(_ context.Context, startDate time.Time, endDate time.Time) (pending int, userAgentPending map[string]int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ReleaseDates == nil {
+		return
+	}
+	counter := store.NewUserCounter(s.filters.NormalizeUserAgent, s.Bots, 0)
+	timeline := store.NewReleaseTimeline(s.ReleaseDates)
+	counter.Include = func(userAgent string, requestTime time.Time) bool {
+		return timeline.Outdated(s.filters.UserAgentVersion(userAgent), requestTime)
+	}
+	pending, userAgentPending, _ = s.countUsers(startDate, endDate, counter).Counts()
+	return
+}
+
+// LogsFingerprint returns the fingerprint of the download log entries in the given date range
+func (s *Store) LogsFingerprint(_ context.Context, startDate time.Time, endDate time.Time) (store.Fingerprint, error) {
+	var fp store.Fingerprint
+	for _, e := range s.Log {
+		if !inRange(e.RequestTime, startDate, endDate) {
+			continue
+		}
+		fp.Rows++
+		if e.RequestTime.After(fp.MaxRequestTime) {
+			fp.MaxRequestTime = e.RequestTime.UTC()
+		}
+	}
+	return fp, nil
+}
+
+// PeriodFingerprint returns the fingerprint recorded for a time period of a metric family, if there is one
+func (s *Store) PeriodFingerprint(_ context.Context, family string, date time.Time) (store.Fingerprint, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fp, ok := s.Fingerprints[family][date.UTC()]
+	return fp, ok, nil
+}
+
+// ReleaseIDs returns the release ID for each version number
+func (s *Store) ReleaseIDs(_ context.Context) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	releases := make(map[string]int, len(s.Releases))
+	for v, id := range s.Releases {
+		releases[v] = id
+	}
+	return releases, nil
+}
+
+func (s *Store) SaveDailyDownloadsStats(_ context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	s.saveDownloads("db4s_downloads_daily", date, count, DLsPerVersion)
+	return nil
+}
+
+func (s *Store) SaveDailyUsersStats(_ context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error {
+	s.saveUsers("db4s_users_daily", date, count, IPsPerUserAgent)
+	return nil
+}
+
+func (s *Store) SaveMonthlyDownloadsStats(_ context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	s.saveDownloads("db4s_downloads_monthly", date, count, DLsPerVersion)
+	return nil
+}
+
+func (s *Store) SaveMonthlyUsersStats(_ context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error {
+	s.saveUsers("db4s_users_monthly", date, count, IPsPerUserAgent)
+	return nil
+}
+
+func (s *Store) SaveWeeklyDownloadsStats(_ context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	s.saveDownloads("db4s_downloads_weekly", date, count, DLsPerVersion)
+	return nil
+}
+
+func (s *Store) SaveWeeklyUsersStats(_ context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error {
+	s.saveUsers("db4s_users_weekly", date, count, IPsPerUserAgent)
+	return nil
+}
+
+// SaveUpdatePending records the update pending percentage for the rows of a users stats table for the given date
+func (s *Store) SaveUpdatePending(_ context.Context, table string, date time.Time, pending int, userAgentPending map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.UpdatePending[table] == nil {
+		s.UpdatePending[table] = make(map[time.Time]map[int]float64)
+	}
+	saved := s.row(table, date)
+	r := make(map[int]float64, len(saved))
+	for id := range saved {
+		r[id] = 0
+	}
+	pct := func(id, n int) {
+		if saved[id] > 0 {
+			r[id] = 100 * float64(n) / float64(saved[id])
+		}
+	}
+	pct(1, pending)
+	for userAgent, n := range userAgentPending {
+		if id, ok := s.Releases[s.filters.UserAgentVersion(userAgent)]; ok {
+			pct(id, n)
+		}
+	}
+	s.UpdatePending[table][date.UTC()] = r
+	return nil
+}
+
+// SaveWatermark records the end of the last fully processed time period for a metric family.  The watermark is never
+// moved backwards
+func (s *Store) SaveWatermark(_ context.Context, family string, processedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if processedUntil.After(s.Watermarks[family]) {
+		s.Watermarks[family] = processedUntil
+	}
+	return nil
+}
+
+// SaveChannelRollup saves the downloads from each distribution channel for a metric family for the time period
+// starting at the given date, replacing any saved earlier for it.  The grand total across the channels is saved too
+func (s *Store) SaveChannelRollup(_ context.Context, family string, date time.Time, perChannel map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ChannelRollups[family] == nil {
+		s.ChannelRollups[family] = make(map[time.Time]map[string]int64)
+	}
+	r := make(map[string]int64, len(perChannel)+1)
+	for channel, downloads := range perChannel {
+		r[channel] = downloads
+		r[store.ChannelTotal] += downloads
+	}
+	s.ChannelRollups[family][date.UTC()] = r
+	return nil
+}
+
+// SaveEstimatedUsers records the estimated users for the rows of a users stats table for the given date
+func (s *Store) SaveEstimatedUsers(_ context.Context, table string, date time.Time, users int, userAgentUsers map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.EstimatedUsers[table] == nil {
+		s.EstimatedUsers[table] = make(map[time.Time]map[int]int64)
+	}
+	r := map[int]int64{1: int64(users)}
+	for userAgent, n := range userAgentUsers {
+		if id, ok := s.Releases[s.filters.UserAgentVersion(userAgent)]; ok {
+			r[id] = int64(n)
+		}
+	}
+	s.EstimatedUsers[table][date.UTC()] = r
+	return nil
+}
+
+// SaveExcludedTraffic saves the number of requests from the excluded networks for a metric family for the time period
+// starting at the given date
+func (s *Store) SaveExcludedTraffic(_ context.Context, family string, date time.Time, requests int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ExcludedTraffic[family] == nil {
+		s.ExcludedTraffic[family] = make(map[time.Time]int64)
+	}
+	s.ExcludedTraffic[family][date.UTC()] = requests
+	return nil
+}
+
+// SaveFailedDownloads saves the failed download counts of a metric family for the time period starting at the given
+// date, replacing any saved earlier for it
+func (s *Store) SaveFailedDownloads(_ context.Context, family string, date time.Time, failed []store.FailedDownload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.FailedDownloads[family] == nil {
+		s.FailedDownloads[family] = make(map[time.Time][]store.FailedDownload)
+	}
+	s.FailedDownloads[family][date.UTC()] = slices.Clone(failed)
+	return nil
+}
+
+// SaveFingerprint records the fingerprint of the download log entries a time period of a metric family was processed
+// from
+func (s *Store) SaveFingerprint(_ context.Context, family string, date time.Time, fp store.Fingerprint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Fingerprints[family] == nil {
+		s.Fingerprints[family] = make(map[time.Time]store.Fingerprint)
+	}
+	s.Fingerprints[family][date.UTC()] = fp
+	return nil
+}
+
+// SaveForecast saves the forecast total of a metric family for the time period starting at the given date
+func (s *Store) SaveForecast(_ context.Context, family string, date time.Time, predicted int64, method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Forecasts[family] == nil {
+		s.Forecasts[family] = make(map[time.Time]Forecast)
+	}
+	s.Forecasts[family][date.UTC()] = Forecast{Predicted: predicted, Method: method}
+	return nil
+}
+
+// StatsDates returns the dates in a stats table which have a totals row saved for them, from the given date onwards
+func (s *Store) StatsDates(_ context.Context, table, _ string, totalID int, from time.Time) (map[time.Time]struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dates := make(map[time.Time]struct{})
+	for date, row := range s.Tables[table] {
+		if _, ok := row[totalID]; ok && !date.Before(from) {
+			dates[date] = struct{}{}
+		}
+	}
+	return dates, nil
+}
+
+// StatsRange returns the saved stats of a stats table for the dates from the start date up to (but not including) the
+// end date, keyed by stats date then release or download ID
+func (s *Store) StatsRange(_ context.Context, table, _, _ string, startDate, endDate time.Time) (map[time.Time]map[int]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[time.Time]map[int]int64)
+	for date, row := range s.Tables[table] {
+		if date.Before(startDate) || !date.Before(endDate) {
+			continue
+		}
+		counts[date] = make(map[int]int64, len(row))
+		for id, count := range row {
+			counts[date][id] = count
+		}
+	}
+	return counts, nil
+}
+
+// StoredStats returns the saved stats of a stats table for the given date, keyed by release or download ID
+func (s *Store) StoredStats(_ context.Context, table, _, _ string, date time.Time) (map[int]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[int]int64)
+	for id, count := range s.Tables[table][date.UTC()] {
+		counts[id] = count
+	}
+	return counts, nil
+}
+
+// SaveBandwidth records the bytes sent for the rows of a stats table for the given date
+func (s *Store) SaveBandwidth(_ context.Context, table string, date time.Time, bytes int64, bytesPerVersion map[int]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Bandwidth[table] == nil {
+		s.Bandwidth[table] = make(map[time.Time]map[int]int64)
+	}
+	r := map[int]int64{0: bytes}
+	for id, n := range bytesPerVersion {
+		r[id] = n
+	}
+	s.Bandwidth[table][date.UTC()] = r
+	return nil
+}
+
+// SaveHeavyHitters records the number of IP addresses left out of a metric family for the given date for making too
+// many requests, along with the number of requests they made
+func (s *Store) SaveHeavyHitters(_ context.Context, family string, date time.Time, IPs, requests int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.HeavyHitters[family] == nil {
+		s.HeavyHitters[family] = make(map[time.Time][2]int64)
+	}
+	s.HeavyHitters[family][date.UTC()] = [2]int64{IPs, requests}
+	return nil
+}
+
+// SaveMetricCounts records the counts of a declared metric for a metric family for the given date, replacing any
+// recorded earlier for it
+func (s *Store) SaveMetricCounts(_ context.Context, table, family string, date time.Time, counts map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MetricCounts[table] == nil {
+		s.MetricCounts[table] = make(map[string]map[time.Time]map[string]int64)
+	}
+	if s.MetricCounts[table][family] == nil {
+		s.MetricCounts[table][family] = make(map[time.Time]map[string]int64)
+	}
+	s.MetricCounts[table][family][date.UTC()] = maps.Clone(counts)
+	return nil
+}
+
+// SaveCheckIns saves the check-in frequency distribution of a metric family for the time period starting at the given
+// date, replacing any saved earlier for it
+func (s *Store) SaveCheckIns(_ context.Context, family string, date time.Time, buckets map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.CheckIns[family] == nil {
+		s.CheckIns[family] = make(map[time.Time]map[string]int)
+	}
+	s.CheckIns[family][date.UTC()] = maps.Clone(buckets)
+	return nil
+}
+
+// SaveOSUsers saves the unique IP addresses per OS of a metric family for the time period starting at the given date,
+// replacing any saved earlier for it
+func (s *Store) SaveOSUsers(_ context.Context, family string, date time.Time, perOS map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.OSUsers[family] == nil {
+		s.OSUsers[family] = make(map[time.Time]map[string]int)
+	}
+	s.OSUsers[family][date.UTC()] = maps.Clone(perOS)
+	return nil
+}
+
+// SaveQualityReport saves a data quality report in the QualityReports table
+func (s *Store) SaveQualityReport(_ context.Context, report store.QualityReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.QualityReports = append(s.QualityReports, report)
+	return nil
+}
+
+// SaveReferrers saves the top referrers of a metric family for the time period starting at the given date, replacing
+// any saved earlier for it
+func (s *Store) SaveReferrers(_ context.Context, family string, date time.Time, referrers []store.Referrer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Referrers[family] == nil {
+		s.Referrers[family] = make(map[time.Time][]store.Referrer)
+	}
+	s.Referrers[family][date.UTC()] = slices.Clone(referrers)
+	return nil
+}
+
+// SaveReleaseImpact saves the totals of a daily metric family before and after a release date
+func (s *Store) SaveReleaseImpact(_ context.Context, impact store.ReleaseImpact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ReleaseImpacts[impact.Family] == nil {
+		s.ReleaseImpacts[impact.Family] = make(map[int]store.ReleaseImpact)
+	}
+	s.ReleaseImpacts[impact.Family][impact.ReleaseID] = impact
+	return nil
+}
+
+// SaveRollingAverages saves the rolling averages of a daily metric family for the given date, keyed by window days
+func (s *Store) SaveRollingAverages(_ context.Context, family string, date time.Time, averages map[int]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.RollingAverages[family] == nil {
+		s.RollingAverages[family] = make(map[time.Time]map[int]float64)
+	}
+	s.RollingAverages[family][date.UTC()] = maps.Clone(averages)
+	return nil
+}
+
+// SaveUniqueDownloads records the unique downloads for the rows of a stats table for the given date
+func (s *Store) SaveUniqueDownloads(_ context.Context, table string, date time.Time, count int32, DLsPerVersion map[int]int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.UniqueDownloads[table] == nil {
+		s.UniqueDownloads[table] = make(map[time.Time]map[int]int64)
+	}
+	r := map[int]int64{0: int64(count)}
+	for id, n := range DLsPerVersion {
+		r[id] = int64(n)
+	}
+	s.UniqueDownloads[table][date.UTC()] = r
+	return nil
+}
+
+// UpdateGrowth sets the percentage change of each row of a stats table for the given date, compared to the previous
+// date.  Rows without a previous value to compare against are left out
+func (s *Store) UpdateGrowth(_ context.Context, table, _, _ string, date, previous time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.Tables[table][date.UTC()]
+	if !ok {
+		return nil
+	}
+	if s.Growth[table] == nil {
+		s.Growth[table] = make(map[time.Time]map[int]float64)
+	}
+	growth := make(map[int]float64)
+	for id, count := range cur {
+		if prev := s.Tables[table][previous.UTC()][id]; prev > 0 {
+			growth[id] = math.Round(float64(count-prev)*10000/float64(prev)) / 100
+		}
+	}
+	s.Growth[table][date.UTC()] = growth
+	return nil
+}
+
+// UpdateDownloads adds a download to the filters' list for each new request path in the download log
+// matching NewDownloads, with the next free download ID.  Then the request paths of the downloads in Mappings are
+// replaced with the ones their mappings match
+func (s *Store) UpdateDownloads(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.Log {
+		if s.NewDownloads == nil {
+			break
+		}
+		if e.Status != 200 || !s.NewDownloads.MatchString(e.Request) ||
+			slices.Contains(s.filters.DownloadRequests(), e.Request) || s.filters.IsIgnoredRequest(e.Request) {
+			continue
+		}
+		id := 0
+		for _, file := range s.filters.DownloadFiles() {
+			id = max(id, file.ID)
+		}
+		s.filters.AddDownloadFiles(store.DownloadFile{ID: id + 1, Name: store.DownloadLabel(e.Request),
+			Requests: []string{e.Request}})
+	}
+
+	var requests []string
+	for _, e := range s.Log {
+		if e.Status == 200 && !slices.Contains(requests, e.Request) {
+			requests = append(requests, e.Request)
+		}
+	}
+	return s.filters.SetMappings(s.Mappings, nil, requests)
+}
+
+// UpdateUserAgents ensures there's a release entry for each user agent present in the download log
+func (s *Store) UpdateUserAgents(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var versions []string
+	for _, e := range s.Log {
+		if s.filters.IsVersionCheck(e.Request, e.UserAgent, e.Status) {
+			versions = append(versions, s.filters.UserAgentVersion(e.UserAgent))
+		}
+	}
+	slices.SortFunc(versions, store.CompareVersions)
+	for _, v := range versions {
+		if _, ok := s.Releases[v]; !ok {
+			s.Releases[v] = len(s.Releases) + 1
+		}
+	}
+	return nil
+}
+
+// Watermark returns the end of the last fully processed time period for a metric family, if there is one
+func (s *Store) Watermark(_ context.Context, family string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.Watermarks[family]
+	return t, ok, nil
+}
+
+// inRange reports whether t is from the start date up to (but not including) the end date, as per the download_log
+// queries
+func inRange(t, startDate, endDate time.Time) bool {
+	return !t.Before(startDate) && t.Before(endDate)
+}
+
+// row returns the row of a stats table for the given date, creating it if needed.  The caller must hold the lock
+func (s *Store) row(table string, date time.Time) map[int]int64 {
+	t, ok := s.Tables[table]
+	if !ok {
+		t = make(map[time.Time]map[int]int64)
+		s.Tables[table] = t
+	}
+	r, ok := t[date.UTC()]
+	if !ok {
+		r = make(map[int]int64)
+		t[date.UTC()] = r
+	}
+	return r
+}
+
+// saveDownloads saves the downloads stats for a date, with ID 0 holding the total as per the db4s_download_info table
+func (s *Store) saveDownloads(table string, date time.Time, count int32, DLsPerVersion map[int]int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.row(table, date)
+	r[0] = int64(count)
+	for version, DLCount := range DLsPerVersion {
+		r[version] = int64(DLCount)
+	}
+}
+
+// saveUsers saves the users stats for a date, with ID 1 holding the total as per the db4s_release_info table.  Counts
+// for user agents without a release entry are dropped
+func (s *Store) saveUsers(table string, date time.Time, count int, IPsPerUserAgent map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.row(table, date)
+	r[1] = int64(count)
+	for userAgent, verCount := range IPsPerUserAgent {
+		if id, ok := s.Releases[s.filters.UserAgentVersion(userAgent)]; ok {
+			r[id] = int64(verCount)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package memstore
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// versionCheck returns a version check log entry
+func versionCheck(at time.Time, ip, userAgent string, status int) LogEntry {
+	return LogEntry{RequestTime: at, ClientIPv4: ip, Request: "/currentrelease", Status: status, UserAgent: userAgent}
+}
+
+func TestGetIPs(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	tests := []struct {
+		name    string
+		log     []LogEntry
+		wantIPs int
+		wantUAs map[string]int
+	}{
+		{"none", nil, 0, map[string]int{}},
+		{"unique IP addresses", []LogEntry{
+			versionCheck(start, "10.0.0.1", "sqlitebrowser 3.12.2", 200),
+			versionCheck(start.Add(time.Hour), "10.0.0.1", "sqlitebrowser 3.12.2", 200),
+			versionCheck(start, "10.0.0.2", "sqlitebrowser 3.13.0", 200),
+		}, 2, map[string]int{"sqlitebrowser 3.12.2": 1, "sqlitebrowser 3.13.0": 1}},
+		{"one IP address with two releases", []LogEntry{
+			versionCheck(start, "10.0.0.1", "sqlitebrowser 3.12.2", 200),
+			versionCheck(start, "10.0.0.1", "sqlitebrowser 3.13.0", 200),
+		}, 1, map[string]int{"sqlitebrowser 3.12.2": 1, "sqlitebrowser 3.13.0": 1}},
+		{"left out", []LogEntry{
+			versionCheck(start, "10.0.0.1", "sqlitebrowser 3.12.2", 404),                   // Failed
+			versionCheck(start, "10.0.0.2", "Mozilla/5.0", 200),                            // Not DB4S
+			versionCheck(end, "10.0.0.3", "sqlitebrowser 3.12.2", 200),                     // After the date range
+			versionCheck(start.Add(-time.Second), "10.0.0.4", "sqlitebrowser 3.12.2", 200), // Before it
+			{RequestTime: start, ClientIPv4: "10.0.0.5", Request: "/other", Status: 200,
+				UserAgent: "sqlitebrowser 3.12.2"}, // Not a version check request
+		}, 0, map[string]int{}},
+	}
+	for _, test := range tests {
+		s := New()
+		s.Log = test.log
+		IPs, userAgentIPs, err := s.GetIPs(context.Background(), start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if IPs != test.wantIPs || !maps.Equal(userAgentIPs, test.wantUAs) {
+			t.Errorf("%v: GetIPs() = %d, %v, expected %d, %v", test.name, IPs, userAgentIPs, test.wantIPs, test.wantUAs)
+		}
+	}
+}
+
+func TestGetDownloads(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	download := func(d int, request string, status int) LogEntry {
+		return LogEntry{RequestTime: day(d).Add(time.Hour), ClientIPv4: "10.0.0.1", Request: request, Status: status}
+	}
+	s := New()
+	s.Log = []LogEntry{
+		download(1, "/DB.Browser.for.SQLite-3.10.1.dmg", 200),
+		download(1, "/DB.Browser.for.SQLite-3.10.1.dmg", 200),
+		download(1, "/DB.Browser.for.SQLite-3.10.1.dmg", 404),
+		download(2, "/db4s-nightly.AppImage", 200),
+		download(5, "/db4s-nightly.AppImage", 200),
+		download(5, "/unknown.zip", 200),
+	}
+
+	// The nightly AppImage counts under one download until the 4th, then under another
+	s.Mappings = []store.DownloadMapping{
+		{Download: 100, Pattern: "/db4s-nightly.AppImage", ActiveUntil: day(4)},
+		{Download: 101, Pattern: "/db4s-nightly.AppImage", ActiveFrom: day(4)},
+	}
+	if err := s.UpdateDownloads(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       int32
+		wantPer    map[int]int32
+	}{
+		{"whole range", day(1), day(8), 4, map[int]int32{1: 2, 100: 1, 101: 1}},
+		{"first day", day(1), day(2), 2, map[int]int32{1: 2}},
+		{"before the mapping ends", day(2), day(4), 1, map[int]int32{100: 1}},
+		{"after the mapping ends", day(4), day(8), 1, map[int]int32{101: 1}},
+		{"nothing", day(8), day(9), 0, map[int]int32{}},
+	}
+	for _, test := range tests {
+		DLs, perVersion, err := s.GetDownloads(context.Background(), test.start, test.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		maps.DeleteFunc(perVersion, func(_ int, n int32) bool { return n == 0 })
+		if DLs != test.want || !maps.Equal(perVersion, test.wantPer) {
+			t.Errorf("%v: GetDownloads() = %d, %v, expected %d, %v", test.name, DLs, perVersion, test.want,
+				test.wantPer)
+		}
+	}
+
+	// The unique downloads count each IP address once per file per day
+	s.TrackUniqueDownloads = true
+	if DLs, _, err := s.GetUniqueDownloads(context.Background(), day(1), day(8)); err != nil || DLs != 3 {
+		t.Errorf("GetUniqueDownloads() = %d, %v, expected 3", DLs, err)
+	}
+}
+
+func TestSavedStats(t *testing.T) {
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	s := New()
+	s.Log = []LogEntry{
+		versionCheck(day(1), "10.0.0.1", "sqlitebrowser 3.13.0", 200),
+		versionCheck(day(1), "10.0.0.1", "sqlitebrowser 3.12.2", 200),
+	}
+	if err := s.UpdateUserAgents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	releases, err := s.ReleaseIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"Unique IPs": 1, "3.12.2": 2, "3.13.0": 3}; !maps.Equal(releases, want) {
+		t.Fatalf("ReleaseIDs() = %v after UpdateUserAgents(), expected the releases in version order %v", releases,
+			want)
+	}
+
+	// User agents are saved under their release ID, with the total as release 1.  Unknown releases are left out
+	err = s.SaveDailyUsersStats(ctx, day(1), 5, map[string]int{"sqlitebrowser 3.12.2": 3, "sqlitebrowser 3.13.0": 2,
+		"sqlitebrowser 9.9.9": 1})
+	if err == nil {
+		err = s.SaveDailyUsersStats(ctx, day(2), 4, map[string]int{"sqlitebrowser 3.13.0": 4})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.StoredStats(ctx, "db4s_users_daily", "", "", day(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int64{1: 5, 2: 3, 3: 2}; !maps.Equal(stored, want) {
+		t.Errorf("StoredStats() = %v, expected %v", stored, want)
+	}
+	ranged, err := s.StatsRange(ctx, "db4s_users_daily", "", "", day(2), day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranged) != 1 || !maps.Equal(ranged[day(2)], map[int]int64{1: 4, 3: 4}) {
+		t.Errorf("StatsRange() = %v, expected just the 2nd", ranged)
+	}
+	dates, err := s.StatsDates(ctx, "db4s_users_daily", "", 1, day(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dates[day(2)]; !ok || len(dates) != 1 {
+		t.Errorf("StatsDates() = %v, expected just the 2nd", dates)
+	}
+
+	// Watermarks
+	if _, ok, _ := s.Watermark(ctx, "users-daily"); ok {
+		t.Error("Watermark() found one before any were saved")
+	}
+	if err = s.SaveWatermark(ctx, "users-daily", day(3)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, _ := s.Watermark(ctx, "users-daily"); !ok || !got.Equal(day(3)) {
+		t.Errorf("Watermark() = %v, %v, expected %v", got, ok, day(3))
+	}
+}
+
+func TestFoldedDownloads(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	const dmg = "/DB.Browser.for.SQLite-3.10.1.dmg"
+
+	// request returns a request for the dmg download, the given number of minutes into the day
+	request := func(ip string, minutes, status int) LogEntry {
+		return LogEntry{
+			RequestTime: start.Add(time.Duration(minutes) * time.Minute),
+			ClientIPv4:  ip,
+			Request:     dmg,
+			Status:      status,
+		}
+	}
+	tests := []struct {
+		name string
+		fold time.Duration
+		log  []LogEntry
+		want int32
+	}{
+		{"single request", time.Hour, []LogEntry{request("10.0.0.1", 0, 200)}, 1},
+		{"range requests", time.Hour, []LogEntry{
+			request("10.0.0.1", 0, 200),
+			request("10.0.0.1", 1, 206),
+			request("10.0.0.1", 50, 206),
+			request("10.0.0.1", 100, 206),
+		}, 1},
+		{"gap longer than the window", time.Hour, []LogEntry{
+			request("10.0.0.1", 0, 206),
+			request("10.0.0.1", 61, 206),
+		}, 2},
+		{"separate clients", time.Hour, []LogEntry{
+			request("10.0.0.1", 0, 206),
+			request("10.0.0.2", 1, 206),
+		}, 2},
+		{"failed requests", time.Hour, []LogEntry{
+			request("10.0.0.1", 0, 404),
+			request("10.0.0.1", 1, 206),
+		}, 1},
+		{"not folding", 0, []LogEntry{
+			request("10.0.0.1", 0, 200),
+			request("10.0.0.1", 1, 206),
+			request("10.0.0.1", 2, 200),
+		}, 2},
+	}
+	for _, test := range tests {
+		s := New()
+		s.FoldWindow = test.fold
+		s.Log = test.log
+		DLs, perVersion, err := s.GetDownloads(context.Background(), start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if DLs != test.want || perVersion[1] != test.want {
+			t.Errorf("%v: GetDownloads() = %d with %d for download 1, expected %d", test.name, DLs, perVersion[1],
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// New release artifacts show up in the download logs before anyone adds them to the downloads list.  When a
+// pattern for them is given, each request path matching it with a successful (200) request gets its own
+// db4s_download_info entry, with its request path in the request column.  These entries are then added to the
+// DownloadFiles list at the start of each run, so their counts flow like the others
+
+// versionPattern matches the version number in the file name of a release artifact
+var versionPattern = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+// AutoAddDownloads adds a db4s_download_info entry for each new request path in the download logs matching the
+// pattern
+func (db *DB) AutoAddDownloads(pattern *regexp.Regexp) {
+	db.newDownloads = pattern
+}
+
+// DownloadLabel returns a friendly name for a release artifact from its request path, with its version and platform.
+// eg "3.13.1 Win64 MSI" for /DB.Browser.for.SQLite-v3.13.1-win64.msi
+func DownloadLabel(request string) string {
+	name := path.Base(request)
+	version := versionPattern.FindString(name)
+	if version == "" {
+		return name
+	}
+	lower := strings.ToLower(name)
+	label := []string{version}
+	switch {
+	case strings.Contains(lower, "win32"):
+		label = append(label, "Win32")
+	case strings.Contains(lower, "win64"):
+		label = append(label, "Win64")
+	case strings.Contains(lower, "arm64"):
+		label = append(label, "ARM64")
+	case strings.Contains(lower, "x86.64"), strings.Contains(lower, "x86_64"):
+		label = append(label, "x86_64")
+	}
+	switch path.Ext(lower) {
+	case ".appimage":
+		label = append(label, "AppImage")
+	case ".dmg":
+		label = append(label, "macOS")
+	case ".exe":
+		if strings.Contains(lower, "portable") {
+			label = append(label, "Portable")
+		} else {
+			label = append(label, "installer")
+		}
+	case ".msi":
+		label = append(label, "MSI")
+	case ".zip":
+		label = append(label, ".zip")
+	}
+	return strings.Join(label, " ")
+}
+
+// UpdateDownloads ensures there's a db4s_download_info entry for each new request path in the download logs matching
+// the auto add pattern, then adds all the automatically added entries to the downloads list.  Lastly the request
+// paths of the downloads with entries in the db4s_download_mappings table are replaced with the ones they map
+func (db *DB) UpdateDownloads(ctx context.Context) error {
+	if db.newDownloads != nil {
+		requests, err := db.artifactRequests(ctx)
+		if err != nil {
+			return err
+		}
+		known := db.filters.DownloadRequests()
+		dbQuery := `
+			INSERT INTO db4s_download_info (friendly_name, request)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`
+		for _, request := range requests {
+			if slices.Contains(known, request) || db.filters.IsIgnoredRequest(request) {
+				continue
+			}
+			commandTag, err := db.exec(ctx, dbQuery, DownloadLabel(request), request)
+			if err != nil {
+				log.Printf("Adding download failed: %v\n", err)
+				return err
+			}
+			if commandTag.RowsAffected() == 1 {
+				log.Printf("Added new download '%v' (%v)\n", DownloadLabel(request), request)
+			}
+		}
+	}
+
+	// Add the automatically added entries to the downloads list
+	dbQuery := `
+		SELECT download_id, friendly_name, request
+		FROM db4s_download_info
+		WHERE request IS NOT NULL
+		ORDER BY download_id`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return err
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var id int32
+		var name pgtype.Text
+		var request string
+		err = rows.Scan(&id, &name, &request)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return err
+		}
+		db.filters.AddDownloadFiles(DownloadFile{ID: int(id), Name: name.String, Requests: []string{request}})
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return err
+	}
+	return db.loadDownloadMappings(ctx)
+}
+
+// artifactRequests returns the request paths in the download logs matching the auto add pattern, with at least one
+// successful request
+func (db *DB) artifactRequests(ctx context.Context) (requests []string, err error) {
+	if db.clickHouse != nil {
+		query := `
+			SELECT DISTINCT request
+			FROM {table}
+			WHERE match(request, {pattern:String})
+				AND status = 200
+			ORDER BY request`
+		var rows [][]string
+		rows, err = db.clickHouseQuery(ctx, query, map[string]string{"pattern": db.newDownloads.String()})
+		if err != nil {
+			log.Printf("ClickHouse query failed: %v\n", err)
+			return
+		}
+		for _, row := range rows {
+			requests = append(requests, row[0])
+		}
+		return
+	}
+
+	dbQuery := `
+		SELECT DISTINCT request
+		FROM download_log
+		WHERE request ~ $1
+			AND status = 200
+		ORDER BY request`
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, db.newDownloads.String())
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var request string
+		err = rows.Scan(&request)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		requests = append(requests, request)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
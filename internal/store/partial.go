@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Download managers fetch files using range requests, logged with status 206 (Partial Content), and a single client
+// can make dozens of 200 and 206 requests for the same file.  When folding is enabled, the 200 and 206 requests from
+// the same IP address for the same file are counted as one download, until the client has made no requests for that
+// file for the fold window.  Otherwise only the requests with one of the DownloadStatuses (200 by default) are
+// counted, each as a download
+
+// FoldPartialDownloads counts the 200 and 206 requests from a client for a file as a single download, as long as the
+// gap between them is no longer than the given window
+func (db *DB) FoldPartialDownloads(window time.Duration) {
+	db.foldWindow = window
+}
+
+// downloadStatuses returns the HTTP status codes of the requests counted as downloads
+func (db *DB) downloadStatuses() []int32 {
+	if db.foldWindow > 0 && !slices.Contains(db.filters.statuses, 206) {
+		return append(slices.Clone(db.filters.statuses), 206)
+	}
+	return db.filters.statuses
+}
+
+// foldedDownloads returns the download counts for the given date range, as per GetDownloads but with the requests
+// folded into logical downloads.  A download in progress at the start of the date range is counted at its first
+// request in the range
+func (db *DB) foldedDownloads(ctx context.Context, startDate, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	if db.clickHouse != nil {
+		return db.clickHouseFoldedDownloads(ctx, startDate, endDate)
+	}
+
+	networks, err := db.downloadExclusions(ctx, startDate, endDate)
+	if err != nil {
+		return
+	}
+	excluded, args := notInNetworks(requestIP, networks,
+		[]any{&startDate, &endDate, db.filters.DownloadRequests(), db.foldWindow.Seconds(), db.downloadStatuses()})
+	dbQuery := `
+		SELECT request, count(*) FILTER (WHERE previous IS NULL OR request_time - previous > make_interval(secs => $4))
+		FROM (
+			SELECT request, request_time, lag(request_time) OVER (
+				PARTITION BY coalesce(nullif(client_ip_strange, ''), nullif(client_ipv6, ''), nullif(client_ipv4, '')), request
+				ORDER BY request_time) AS previous
+			FROM {logs}
+			WHERE request = ANY($3)
+				AND request_time >= $1
+				AND request_time < $2
+				AND status = ANY($5)
+				` + excluded + `
+		) requests
+		GROUP BY request`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	perRequest := make(map[string]int32)
+	for rows.Next() {
+		var request string
+		var count pgtype.Int8
+		err = rows.Scan(&request, &count)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		perRequest[request] = int32(count.Int64)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	DLs, DLsPerVersion = db.filters.downloadCounts(perRequest, startDate)
+	return
+}
+
+// clickHouseFoldedDownloads returns the folded download counts for the given date range, as per foldedDownloads
+func (db *DB) clickHouseFoldedDownloads(ctx context.Context, startDate, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	// lagInFrame returns the epoch for the first request of each client, so it always starts a new download
+	query := `
+		SELECT request, countIf(request_time - previous > {window:Int64})
+		FROM (
+			SELECT request, request_time, lagInFrame(request_time) OVER (
+				PARTITION BY coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, '')), request
+				ORDER BY request_time ROWS BETWEEN 1 PRECEDING AND 1 PRECEDING) AS previous
+			FROM {table}
+			WHERE request IN {requests:Array(String)}
+				AND request_time >= toDateTime({start:Int64})
+				AND request_time < toDateTime({end:Int64})
+				AND status IN {statuses:Array(Int32)}
+				AND NOT ` + clickHouseExcluded + `
+		)
+		GROUP BY request`
+	params := clickHouseRange(startDate, endDate)
+	params["requests"] = clickHouseArray(db.filters.DownloadRequests())
+	params["networks"] = db.clickHouseNetworks()
+	params["window"] = strconv.FormatInt(int64(db.foldWindow/time.Second), 10)
+	params["statuses"] = clickHouseStatuses(db.downloadStatuses())
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	perRequest, err := clickHouseCounts(rows)
+	if err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	DLs, DLsPerVersion = db.filters.downloadCounts(perRequest, startDate)
+	return
+}
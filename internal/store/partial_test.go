@@ -0,0 +1,37 @@
+package store
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDownloadStatuses(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		fold     time.Duration
+		want     []int32
+	}{
+		{"default", nil, 0, []int32{200}},
+		{"folding", nil, time.Hour, []int32{200, 206}},
+		{"configured", []int{200, 304}, 0, []int32{200, 304}},
+		{"configured with folding", []int{200, 304}, time.Hour, []int32{200, 304, 206}},
+		{"206 already counted", []int{200, 206}, time.Hour, []int32{200, 206}},
+	}
+	for _, test := range tests {
+		db := &DB{filters: NewFilters()}
+		if err := db.filters.SetDownloadFilters(test.statuses, nil); err != nil {
+			t.Fatal(err)
+		}
+		db.FoldPartialDownloads(test.fold)
+		if got := db.downloadStatuses(); !slices.Equal(got, test.want) {
+			t.Errorf("%v: downloadStatuses() = %v, expected %v", test.name, got, test.want)
+		}
+
+		// The configured status codes are left as they were
+		if test.statuses == nil && !slices.Equal(db.filters.statuses, []int32{200}) {
+			t.Errorf("%v: download statuses changed to %v", test.name, db.filters.statuses)
+		}
+	}
+}
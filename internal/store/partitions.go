@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// partitions describes how download_log is partitioned by request_time, when it's partitioned
+type partitions struct {
+	layout   string          // Go time layout of the partition names, eg download_log_2006_01
+	interval string          // month, week or day
+	existing map[string]bool // The partitions of download_log found at startup
+}
+
+// UsePartitions reads the download logs for each time period straight from the download_log partitions covering it,
+// rather than leaving the planner to prune them.  The partition names are given by a Go time layout applied to the
+// start of each partition, eg download_log_2006_01 for monthly partitions.  The interval is month (the default), week
+// (starting on Mondays) or day
+func (db *DB) UsePartitions(ctx context.Context, layout, interval string) error {
+	switch interval {
+	case "":
+		interval = "month"
+	case "month", "week", "day":
+	default:
+		return fmt.Errorf("unknown partition interval '%v', it should be month, week or day", interval)
+	}
+	if layout == time.Now().Format(layout) {
+		return fmt.Errorf("partition name '%v' doesn't contain any date fields", layout)
+	}
+
+	dbQuery := `
+		SELECT c.relname
+		FROM pg_inherits i, pg_class c
+		WHERE i.inhparent = 'download_log'::regclass
+			AND c.oid = i.inhrelid`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return err
+	}
+	defer cancel()
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return err
+		}
+		existing[name] = true
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return err
+	}
+	if len(existing) == 0 {
+		return fmt.Errorf("download_log doesn't have any partitions")
+	}
+	db.partitions = &partitions{layout: layout, interval: interval, existing: existing}
+	return nil
+}
+
+// logsQuery fills in the {logs} placeholder of a query reading the download logs in the given date range.  This is
+// download_log, unless partitions are in use and every partition covering the range exists, in which case it's just
+// those partitions
+func (db *DB) logsQuery(dbQuery string, startDate, endDate time.Time) string {
+	return strings.ReplaceAll(dbQuery, "{logs}", db.logsTable(startDate, endDate))
+}
+
+// logsTable returns the table (or subquery) to read the download logs in the given date range from, as per logsQuery
+func (db *DB) logsTable(startDate, endDate time.Time) string {
+	p := db.partitions
+	if p == nil || !startDate.Before(endDate) {
+		return "download_log"
+	}
+	var names []string
+	for start := p.start(startDate.UTC()); start.Before(endDate); start = p.next(start) {
+		name := start.Format(p.layout)
+		if !p.existing[name] {
+			if db.Verbosity >= verbosity.Trace {
+				log.Printf("No partition '%v', reading from download_log instead\n", name)
+			}
+			return "download_log"
+		}
+		names = append(names, pgx.Identifier{name}.Sanitize())
+	}
+	if len(names) == 1 {
+		return names[0] + " AS download_log"
+	}
+	for i := range names {
+		names[i] = "SELECT * FROM " + names[i]
+	}
+	return "(" + strings.Join(names, " UNION ALL ") + ") AS download_log"
+}
+
+// start returns the start of the partition containing the given time
+func (p *partitions) start(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch p.interval {
+	case "day":
+		return day
+	case "week":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the start of the partition after the one starting at the given time
+func (p *partitions) next(start time.Time) time.Time {
+	switch p.interval {
+	case "day":
+		return start.AddDate(0, 0, 1)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 1, 0)
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// A user has an update pending when their version check is from a release older than the one /currentrelease was
+// serving at the time.  That's taken to be the latest release (leaving out pre-releases) published by then, going by
+// the release dates synced from GitHub into db4s_release_info
+
+// ReleaseTimeline holds the release dates of the DB4S releases, to work out which was the current release at a given
+// time
+type ReleaseTimeline struct {
+	dates    []time.Time
+	versions []Version
+}
+
+// NewReleaseTimeline returns a ReleaseTimeline for the given release dates, keyed by version number.  Malformed version
+// numbers are skipped
+func NewReleaseTimeline(releases map[string]time.Time) *ReleaseTimeline {
+	t := &ReleaseTimeline{}
+	type release struct {
+		date    time.Time
+		version Version
+	}
+	var list []release
+	for s, date := range releases {
+		if v, ok := ParseVersion(s); ok {
+			list = append(list, release{date, v})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].date.Before(list[j].date) })
+	for _, r := range list {
+		t.dates = append(t.dates, r.date)
+		t.versions = append(t.versions, r.version)
+	}
+	return t
+}
+
+// Outdated returns whether a version check from the given version number was from a release older than the current one
+// at the time.  Version checks from before the first known release, or with a malformed version number, aren't counted
+// as outdated
+func (t *ReleaseTimeline) Outdated(version string, requestTime time.Time) bool {
+	v, ok := ParseVersion(version)
+	if !ok {
+		return false
+	}
+
+	// The current release is the newest one released by then, rather than the last one released, in case of a bug fix
+	// release for an older series
+	i := sort.Search(len(t.dates), func(i int) bool { return t.dates[i].After(requestTime) })
+	current := ""
+	for _, r := range t.versions[:i] {
+		current = max(current, r.SortKey())
+	}
+	return current != "" && v.SortKey() < current
+}
+
+// TrackUpdatePending enables the update pending stats.  The version checks are compared against the release dates as
+// they stream in from PostgreSQL, so this isn't supported when reading the download logs from ClickHouse
+func (db *DB) TrackUpdatePending() error {
+	if db.clickHouse != nil {
+		return errors.New("the update pending stats aren't supported when reading the download logs from ClickHouse")
+	}
+	db.updatePending = true
+	return nil
+}
+
+// releaseTimeline returns the timeline of the releases with a release date in db4s_release_info, leaving out the
+// pre-releases
+func (db *DB) releaseTimeline(ctx context.Context) (*ReleaseTimeline, error) {
+	dbQuery := `
+		SELECT version_number, release_date
+		FROM db4s_release_info
+		WHERE release_date IS NOT NULL
+			AND NOT coalesce(prerelease, false)`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	releases := make(map[string]time.Time)
+	for rows.Next() {
+		var version string
+		var date time.Time
+		err = rows.Scan(&version, &date)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		releases[version] = date
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return NewReleaseTimeline(releases), nil
+}
+
+// GetUpdatePending returns the number of unique IP addresses doing a version check from an out of date release in the
+// given date range, plus a breakdown per user agent.  The breakdown is nil when the update pending stats aren't
+// enabled
+func (db *DB) GetUpdatePending(ctx context.Context, startDate time.Time, endDate time.Time) (pending int, userAgentPending map[string]int, err error) {
+	if !db.updatePending {
+		return
+	}
+	timeline, err := db.releaseTimeline(ctx)
+	if err != nil {
+		return
+	}
+	counter := NewUserCounter(db.filters.NormalizeUserAgent, db.bots, 0)
+	counter.Include = func(userAgent string, requestTime time.Time) bool {
+		return timeline.Outdated(db.filters.UserAgentVersion(userAgent), requestTime)
+	}
+	counter, err = db.countUsers(ctx, startDate, endDate, counter)
+	if err != nil {
+		return
+	}
+	pending, userAgentPending, _ = counter.Counts()
+	return
+}
+
+// SaveUpdatePending sets the update pending percentage of the rows of a users stats table for the given date, from the
+// number of unique IP addresses with an update pending.  The rows need to have been saved already, with the unique IP
+// counts
+func (db *DB) SaveUpdatePending(ctx context.Context, table string, date time.Time, pending int, userAgentPending map[string]int) error {
+	// Releases without any pending updates are at 0%, rather than NULL
+	dbQuery := fmt.Sprintf(`
+		UPDATE %s
+		SET update_pending_pct = 0
+		WHERE stats_date = $1`, table)
+	_, err := db.exec(ctx, dbQuery, date)
+	if err != nil {
+		log.Printf("Saving update pending failed: %v\n", err)
+		return err
+	}
+
+	// NOTE - The hard coded 1 value for the release version corresponds to the manually added "Unique IPs" entry in
+	// the DB4S release info table
+	dbQuery = fmt.Sprintf(`
+		UPDATE %s
+		SET update_pending_pct = 100.0 * $3 / nullif(unique_ips, 0)
+		WHERE stats_date = $1
+			AND db4s_release = $2`, table)
+	_, err = db.exec(ctx, dbQuery, date, 1, pending)
+	if err != nil {
+		log.Printf("Saving update pending failed: %v\n", err)
+		return err
+	}
+	dbQuery = fmt.Sprintf(`
+		UPDATE %s
+		SET update_pending_pct = 100.0 * $3 / nullif(unique_ips, 0)
+		WHERE stats_date = $1
+			AND db4s_release = (
+				SELECT release_id
+				FROM db4s_release_info
+				WHERE version_number = $2)`, table)
+	for userAgent, n := range userAgentPending {
+		_, err = db.exec(ctx, dbQuery, date, db.filters.UserAgentVersion(userAgent), n)
+		if err != nil {
+			log.Printf("Saving update pending failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
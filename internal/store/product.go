@@ -0,0 +1,75 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The same download logs can hold the requests of other sqlitebrowser products (eg the dbhub.io clients), whose stats
+// are generated by a run with their own config file.  Each product has its own version check user agents, downloads
+// and stats tables, while the download_log and ingested_logs tables are shared
+
+// productTables are the tables holding the stats of a single product, renamed when a table prefix is in use
+var productTables = []string{
+	"db4s_channel_downloads",
+	"db4s_download_info",
+	"db4s_download_mappings",
+	"db4s_download_referrers",
+	"db4s_downloads_by_channel",
+	"db4s_downloads_daily",
+	"db4s_downloads_monthly",
+	"db4s_downloads_weekly",
+	"db4s_failed_downloads",
+	"db4s_release_info",
+	"db4s_users_by_os",
+	"db4s_users_check_ins",
+	"db4s_users_daily",
+	"db4s_users_monthly",
+	"db4s_users_weekly",
+	"stats_excluded_traffic",
+	"stats_forecasts",
+	"stats_heavy_hitters",
+	"stats_period_fingerprints",
+	"stats_processing_state",
+	"stats_quality_reports",
+	"stats_release_impact",
+	"stats_rolling_averages",
+	"stats_runs",
+}
+
+// tablePrefixPattern matches the valid table prefixes, which are used unquoted in the queries
+var tablePrefixPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*_$`)
+
+// UseTablePrefix saves the stats in their own set of tables for another product.  The prefix replaces the db4s_ one
+// of the stats tables, and is added to the stats_ ones.  eg with "dbhub_", the daily users stats go in
+// dbhub_users_daily and the saved progress in dbhub_stats_processing_state.  Run init-schema to create them
+func (db *DB) UseTablePrefix(prefix string) error {
+	if !tablePrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid table prefix '%v', it needs to be lower case letters, digits and underscores, "+
+			"ending with an underscore", prefix)
+	}
+	var renames []string
+	for _, t := range productTables {
+		renames = append(renames, t, prefix+strings.TrimPrefix(t, "db4s_"))
+	}
+	db.tablePrefix = prefix
+	db.tables = strings.NewReplacer(renames...)
+	return nil
+}
+
+// UseDownloadPattern replaces the built in DB4S downloads with the request paths matching the pattern, for another
+// product.  Each matching request path is added as a download when it first shows up, as per AutoAddDownloads, and
+// the failed downloads stats are for the matching request paths too
+func (db *DB) UseDownloadPattern(pattern *regexp.Regexp) {
+	db.filters.SetDownloadPattern(pattern)
+	db.newDownloads = pattern
+}
+
+// withTables renames the stats tables in a query as per the table prefix, if there is one
+func (db *DB) withTables(dbQuery string) string {
+	if db.tables == nil {
+		return dbQuery
+	}
+	return db.tables.Replace(dbQuery)
+}
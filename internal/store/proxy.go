@@ -0,0 +1,67 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UseForwardedFor counts the unique IP addresses using the X-Forwarded-For header in the given download_log column,
+// for requests which came through one of the trusted proxies.  The header is resolved as the rows are read from
+// PostgreSQL, so this isn't supported when reading the download logs from ClickHouse
+func (db *DB) UseForwardedFor(column string, trusted []string) error {
+	if column == "" {
+		return nil
+	}
+	if db.clickHouse != nil {
+		return errors.New("X-Forwarded-For resolution isn't supported when reading the download logs from ClickHouse")
+	}
+	if len(trusted) == 0 {
+		return errors.New("X-Forwarded-For resolution needs the trusted proxies, as the header can't be relied on otherwise")
+	}
+	db.forwardedFor = column
+	db.trustedProxies = nil
+	for _, s := range trusted {
+		network, err := ParseNetwork(s)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy IP address or range '%v': %w", s, err)
+		}
+		db.trustedProxies = append(db.trustedProxies, network)
+	}
+	return nil
+}
+
+// userIP returns the SQL expression for the IP address of the user making a request, plus any query arguments it
+// needs.  The arguments are numbered from the given one
+func (db *DB) userIP(arg int) (expr string, args []any) {
+	expr = `coalesce(nullif(client_ip_strange, ''), nullif(client_ipv6, ''), nullif(client_ipv4, ''))`
+	if db.forwardedFor == "" {
+		return expr, nil
+	}
+	return fmt.Sprintf("db4s_client_ip(%s, %s, $%d)", expr, pgx.Identifier{db.forwardedFor}.Sanitize(), arg),
+		[]any{db.trustedProxies}
+}
+
+// ResolveClientIP returns the IP address of a client as per the db4s_client_ip() PostgreSQL function.  When the
+// request came through one of the trusted proxies, the X-Forwarded-For header is read from right to left, skipping
+// the trusted proxies
+func ResolveClientIP(IP, forwardedFor string, trusted []netip.Prefix) string {
+	if forwardedFor == "" || !InNetworks(trusted, IP) {
+		return IP
+	}
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		IP = hop
+		if !InNetworks(trusted, hop) {
+			break
+		}
+	}
+	return IP
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	tests := []struct {
+		name, IP, forwardedFor, want string
+	}{
+		{"no header", "10.0.0.1", "", "10.0.0.1"},
+		{"untrusted peer", "198.51.100.7", "203.0.113.5", "198.51.100.7"},
+		{"trusted peer", "10.0.0.1", "203.0.113.5", "203.0.113.5"},
+		{"trusted IPv6 peer", "2001:db8::1", "203.0.113.5", "203.0.113.5"},
+		{"several hops", "10.0.0.1", "192.0.2.1, 203.0.113.5, 10.0.0.2", "203.0.113.5"},
+		{"spoofed leftmost hop", "10.0.0.1", "1.2.3.4, 203.0.113.5", "203.0.113.5"},
+		{"only trusted hops", "10.0.0.1", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"empty hops", "10.0.0.1", "203.0.113.5, ,", "203.0.113.5"},
+		{"malformed hop", "10.0.0.1", "203.0.113.5, not-an-ip", "not-an-ip"},
+		{"malformed peer", "garbage", "203.0.113.5", "garbage"},
+	}
+	for _, test := range tests {
+		if got := ResolveClientIP(test.IP, test.forwardedFor, trusted); got != test.want {
+			t.Errorf("%v: ResolveClientIP(%q, %q) = %q, expected %q", test.name, test.IP, test.forwardedFor, got,
+				test.want)
+		}
+	}
+}
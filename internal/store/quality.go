@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// QualityReport counts the suspicious rows of the download logs in a date range.  These are handled (or left out) by
+// the stats generation, but large numbers of them point at a problem with the logs
+type QualityReport struct {
+	From time.Time
+	To   time.Time
+
+	NoClientIP        int64 // Version checks without any client IP address, which are left out of the users stats
+	MalformedVersions int64 // Version checks whose user agent doesn't give a valid version number
+	UnknownDownloads  int64 // Successful requests for download artifacts which don't match any known download
+	Duplicates        int64 // Extra copies of log entries which are otherwise identical
+}
+
+// Issues returns a description of each type of suspicious row found, with the number of them
+func (r QualityReport) Issues() (issues []string) {
+	if r.NoClientIP > 0 {
+		issues = append(issues, fmt.Sprintf("%d version check(s) without a client IP address", r.NoClientIP))
+	}
+	if r.MalformedVersions > 0 {
+		issues = append(issues, fmt.Sprintf("%d version check(s) with a malformed version number", r.MalformedVersions))
+	}
+	if r.UnknownDownloads > 0 {
+		issues = append(issues, fmt.Sprintf("%d download(s) not matching any known artifact", r.UnknownDownloads))
+	}
+	if r.Duplicates > 0 {
+		issues = append(issues, fmt.Sprintf("%d duplicate log entries", r.Duplicates))
+	}
+	return
+}
+
+// CheckQuality counts the suspicious rows of the download logs in the given date range.  The excluded networks aren't
+// left out, as this is about the logs themselves
+func (db *DB) CheckQuality(ctx context.Context, startDate time.Time, endDate time.Time) (report QualityReport, err error) {
+	if db.clickHouse != nil {
+		return db.clickHouseCheckQuality(ctx, startDate, endDate)
+	}
+	report.From, report.To = startDate, endDate
+
+	dbQuery := fmt.Sprintf(`
+		SELECT count(*) FILTER (WHERE request = '/currentrelease' AND status = 200
+				AND %[1]s
+				AND coalesce(nullif(client_ip_strange, ''), nullif(client_ipv6, ''), nullif(client_ipv4, '')) IS NULL),
+			count(*) FILTER (WHERE request ~ $3 AND NOT request = ANY($4) AND status IN (200, 206))
+		FROM {logs}
+		WHERE request_time >= $1
+			AND request_time < $2`, db.filters.pgUserAgents())
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	err = db.queryLogsRow(ctx, dbQuery, &startDate, &endDate, db.filters.artifacts.String(),
+		db.filters.DownloadRequests()).
+		Scan(&report.NoClientIP, &report.UnknownDownloads)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+
+	// Log entries are duplicates when every field matches, which happens when a log file is loaded twice under
+	// different names
+	dbQuery = `
+		SELECT coalesce(sum(copies - 1), 0)
+		FROM (
+			SELECT count(*) AS copies
+			FROM {logs}
+			WHERE request_time >= $1
+				AND request_time < $2
+			GROUP BY client_ipv4, client_ipv6, client_ip_strange, client_port, request_time, request, status,
+				body_bytes_sent, http_user_agent
+			HAVING count(*) > 1
+		) d`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	err = db.queryLogsRow(ctx, dbQuery, &startDate, &endDate).Scan(&report.Duplicates)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+
+	// The version numbers are checked here rather than in SQL, so they're parsed the same way as everywhere else
+	dbQuery = fmt.Sprintf(`
+		SELECT http_user_agent, count(*)
+		FROM {logs}
+		WHERE request = '/currentrelease'
+			AND %[1]s
+			AND request_time >= $1
+			AND request_time < $2
+			AND status = 200
+		GROUP BY http_user_agent`, db.filters.pgUserAgents())
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, &startDate, &endDate)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var userAgent string
+		var n int64
+		err = rows.Scan(&userAgent, &n)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		if _, ok := ParseVersion(db.filters.UserAgentVersion(userAgent)); !ok {
+			report.MalformedVersions += n
+		}
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
+
+// clickHouseCheckQuality counts the suspicious rows of the download logs in the given date range, as per CheckQuality
+func (db *DB) clickHouseCheckQuality(ctx context.Context, startDate, endDate time.Time) (report QualityReport, err error) {
+	report.From, report.To = startDate, endDate
+	query := `
+		SELECT countIf(request = '/currentrelease' AND status = 200
+				AND ` + db.filters.clickHouseUserAgents() + `
+				AND coalesce(nullIf(client_ip_strange, ''), nullIf(client_ipv6, ''), nullIf(client_ipv4, '')) IS NULL),
+			countIf(match(request, {pattern:String}) AND request NOT IN {requests:Array(String)} AND status IN (200, 206)),
+			count() - uniqExact(client_ipv4, client_ipv6, client_ip_strange, client_port, request_time, request, status,
+				body_bytes_sent, http_user_agent)
+		FROM {table}
+		WHERE request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})`
+	params := clickHouseRange(startDate, endDate)
+	params["pattern"] = db.filters.artifacts.String()
+	params["requests"] = clickHouseArray(db.filters.DownloadRequests())
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	if len(rows) != 1 || len(rows[0]) != 3 {
+		err = fmt.Errorf("unexpected ClickHouse result: %q", rows)
+		return
+	}
+	for i, n := range []*int64{&report.NoClientIP, &report.UnknownDownloads, &report.Duplicates} {
+		*n, err = strconv.ParseInt(rows[0][i], 10, 64)
+		if err != nil {
+			err = fmt.Errorf("unexpected ClickHouse result: %q", rows)
+			return
+		}
+	}
+
+	query = `
+		SELECT http_user_agent, count()
+		FROM {table}
+		WHERE request = '/currentrelease'
+			AND ` + db.filters.clickHouseUserAgents() + `
+			AND request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})
+			AND status = 200
+		GROUP BY http_user_agent`
+	rows, err = db.clickHouseQuery(ctx, query, clickHouseRange(startDate, endDate))
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return
+	}
+	for _, row := range rows {
+		if len(row) != 2 {
+			err = fmt.Errorf("unexpected ClickHouse result row: %q", row)
+			return
+		}
+		var n int64
+		n, err = strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			err = fmt.Errorf("unexpected ClickHouse result row: %q", row)
+			return
+		}
+		if _, ok := ParseVersion(db.filters.UserAgentVersion(row[0])); !ok {
+			report.MalformedVersions += n
+		}
+	}
+	return
+}
+
+// SaveQualityReport saves a data quality report in the stats_quality_reports table
+func (db *DB) SaveQualityReport(ctx context.Context, report QualityReport) error {
+	dbQuery := `
+		INSERT INTO stats_quality_reports (range_start, range_end, no_client_ip, malformed_versions, unknown_downloads,
+			duplicates)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := db.exec(ctx, dbQuery, report.From, report.To, report.NoClientIP, report.MalformedVersions,
+		report.UnknownDownloads, report.Duplicates)
+	if err != nil {
+		log.Printf("Saving data quality report failed: %v\n", err)
+	}
+	return err
+}
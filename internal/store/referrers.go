@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DirectReferrer is recorded for downloads without a referrer, eg from a download manager or a link typed in
+const DirectReferrer = "(direct)"
+
+// Referrer is the number of downloads of a file coming from a given referrer
+type Referrer struct {
+	Download  int
+	Referrer  string
+	Downloads int64
+}
+
+// TrackReferrers enables the referrer stats, keeping the given number of top referrers for each download
+func (db *DB) TrackReferrers(top int) {
+	db.topReferrers = top
+}
+
+// GetReferrers returns the top referrers for each DB4S download in the given date range, plus the top referrers
+// across all downloads under download ID 0.  This is nil when referrer tracking isn't enabled
+func (db *DB) GetReferrers(ctx context.Context, startDate time.Time, endDate time.Time) ([]Referrer, error) {
+	if db.topReferrers <= 0 {
+		return nil, nil
+	}
+	if db.clickHouse != nil {
+		return db.clickHouseReferrers(ctx, startDate, endDate)
+	}
+
+	networks, err := db.downloadExclusions(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	excluded, args := notInNetworks(requestIP, networks,
+		[]any{&startDate, &endDate, db.filters.DownloadRequests(), DirectReferrer, db.filters.statuses})
+	dbQuery := `
+		SELECT request, coalesce(nullif(nullif(http_referer, ''), '-'), $4), count(*)
+		FROM {logs}
+		WHERE request = ANY($3)
+			AND request_time >= $1
+			AND request_time < $2
+			AND status = ANY($5)
+			` + excluded + `
+		GROUP BY 1, 2`
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	perRequest := make(map[string]map[string]int64)
+	for rows.Next() {
+		var request, referrer string
+		var n int64
+		err = rows.Scan(&request, &referrer, &n)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		if perRequest[request] == nil {
+			perRequest[request] = make(map[string]int64)
+		}
+		perRequest[request][referrer] = n
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return db.filters.TopReferrers(perRequest, db.topReferrers), nil
+}
+
+// clickHouseReferrers returns the top referrers for the given date range, as per GetReferrers
+func (db *DB) clickHouseReferrers(ctx context.Context, startDate, endDate time.Time) ([]Referrer, error) {
+	query := `
+		SELECT request, if(http_referer IN ('', '-'), {direct:String}, http_referer) AS referrer, count()
+		FROM {table}
+		WHERE request IN {requests:Array(String)}
+			AND request_time >= toDateTime({start:Int64})
+			AND request_time < toDateTime({end:Int64})
+			AND status IN {statuses:Array(Int32)}
+			AND NOT ` + clickHouseExcluded + `
+		GROUP BY request, referrer`
+	params := clickHouseRange(startDate, endDate)
+	params["networks"] = db.clickHouseNetworks()
+	params["requests"] = clickHouseArray(db.filters.DownloadRequests())
+	params["direct"] = DirectReferrer
+	params["statuses"] = clickHouseStatuses(db.filters.statuses)
+	rows, err := db.clickHouseQuery(ctx, query, params)
+	if err != nil {
+		log.Printf("ClickHouse query failed: %v\n", err)
+		return nil, err
+	}
+	perRequest := make(map[string]map[string]int64)
+	for _, row := range rows {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		n, err := strconv.ParseInt(row[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected ClickHouse result row: %q", row)
+		}
+		if perRequest[row[0]] == nil {
+			perRequest[row[0]] = make(map[string]int64)
+		}
+		perRequest[row[0]][row[1]] = n
+	}
+	return db.filters.TopReferrers(perRequest, db.topReferrers), nil
+}
+
+// TopReferrers adds up the number of downloads from each referrer for each request path into the totals for each
+// download, plus the totals across all downloads (download ID 0), keeping the top ones of each.  Ties are broken by
+// referrer, so the results don't change between runs
+func (f *Filters) TopReferrers(perRequest map[string]map[string]int64, top int) (referrers []Referrer) {
+	perDownload := make(map[int]map[string]int64)
+	add := func(id int, referrer string, n int64) {
+		if perDownload[id] == nil {
+			perDownload[id] = make(map[string]int64)
+		}
+		perDownload[id][referrer] += n
+	}
+	for _, file := range f.downloads {
+		for _, request := range file.Requests {
+			for referrer, n := range perRequest[request] {
+				add(file.ID, referrer, n)
+				add(0, referrer, n)
+			}
+		}
+	}
+	for id, counts := range perDownload {
+		var r []Referrer
+		for referrer, n := range counts {
+			r = append(r, Referrer{Download: id, Referrer: referrer, Downloads: n})
+		}
+		sort.Slice(r, func(i, j int) bool {
+			if r[i].Downloads != r[j].Downloads {
+				return r[i].Downloads > r[j].Downloads
+			}
+			return r[i].Referrer < r[j].Referrer
+		})
+		if len(r) > top {
+			r = r[:top]
+		}
+		referrers = append(referrers, r...)
+	}
+	sort.SliceStable(referrers, func(i, j int) bool {
+		return referrers[i].Download < referrers[j].Download
+	})
+	return
+}
+
+// SaveReferrers saves the top referrers of a metric family for the time period starting at the given date, replacing
+// any saved earlier for it
+func (db *DB) SaveReferrers(ctx context.Context, family string, date time.Time, referrers []Referrer) error {
+	// Remove the earlier referrers first, so ones which dropped out of the top don't linger
+	dbQuery := `
+		DELETE FROM db4s_download_referrers
+		WHERE metric_family = $1
+			AND stats_date = $2`
+	_, err := db.exec(ctx, dbQuery, family, date)
+	if err != nil {
+		log.Printf("Saving referrers failed: %v\n", err)
+		return err
+	}
+	dbQuery = `
+		INSERT INTO db4s_download_referrers (metric_family, stats_date, db4s_download, referrer, num_downloads)
+		VALUES ($1, $2, $3, $4, $5)`
+	for _, r := range referrers {
+		_, err = db.exec(ctx, dbQuery, family, date, r.Download, r.Referrer, r.Downloads)
+		if err != nil {
+			log.Printf("Saving referrers failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// SaveReleaseInfo saves the GitHub release details of a version into the db4s_release_info table, adding an entry for
+// the version if there isn't one yet.  This way releases nobody has done a version check from yet still get an ID
+func (db *DB) SaveReleaseInfo(ctx context.Context, version, name string, released time.Time, prerelease bool) error {
+	dbQuery := `
+		INSERT INTO db4s_release_info (version_number, release_name, release_date, prerelease)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (version_number)
+			DO UPDATE
+				SET release_name = $2, release_date = $3, prerelease = $4`
+	commandTag, err := db.exec(ctx, dbQuery, version, name, released, prerelease)
+	if err != nil {
+		log.Printf("Saving release info failed: %v\n", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when saving release info: %v\n", numRows, version)
+	}
+	return nil
+}
+
+// Artifact is a release file to add a download for
+type Artifact struct {
+	Name    string // The friendly name of its db4s_download_info entry.  eg "3.13.1 Win64 MSI"
+	Request string // The request path it's downloaded from
+}
+
+// AddRelease adds the db4s_release_info entry for a version if there isn't one yet, plus a db4s_download_info entry
+// and an exact db4s_download_mappings entry for each of its artifacts.  Nothing is added when any of the request paths
+// is already mapped, or was added automatically.  A zero release date leaves the saved one (if any) as is.  It returns the new download IDs
+func (db *DB) AddRelease(ctx context.Context, version string, released time.Time, artifacts []Artifact) (ids []int, err error) {
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+	tx, err := db.pool.Begin(queryCtx)
+	if db.retryAuth(err) {
+		tx, err = db.pool.Begin(queryCtx)
+	}
+	if err != nil {
+		log.Printf("Adding release failed: %v\n", err)
+		return nil, err
+	}
+	defer tx.Rollback(queryCtx)
+
+	var date *time.Time
+	if !released.IsZero() {
+		date = &released
+	}
+	dbQuery := `
+		INSERT INTO db4s_release_info (version_number, friendly_name, release_date)
+		VALUES ($1, $1, $2)
+		ON CONFLICT (version_number)
+			DO UPDATE
+				SET release_date = coalesce($2, db4s_release_info.release_date)`
+	if _, err = tx.Exec(queryCtx, db.withTables(dbQuery), version, date); err != nil {
+		log.Printf("Adding release failed: %v\n", err)
+		return nil, err
+	}
+	for _, a := range artifacts {
+		var existing int32
+		dbQuery = `
+			SELECT db4s_download
+			FROM db4s_download_mappings
+			WHERE pattern = $1
+				AND NOT is_regex
+			UNION ALL
+			SELECT download_id
+			FROM db4s_download_info
+			WHERE request = $1
+			LIMIT 1`
+		err = tx.QueryRow(queryCtx, db.withTables(dbQuery), a.Request).Scan(&existing)
+		if err == nil {
+			return nil, fmt.Errorf("the request path '%v' is already mapped to download %d", a.Request, existing)
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Adding release failed: %v\n", err)
+			return nil, err
+		}
+		var id int32
+		dbQuery = `
+			INSERT INTO db4s_download_info (friendly_name)
+			VALUES ($1)
+			RETURNING download_id`
+		if err = tx.QueryRow(queryCtx, db.withTables(dbQuery), a.Name).Scan(&id); err != nil {
+			log.Printf("Adding release failed: %v\n", err)
+			return nil, err
+		}
+		dbQuery = `
+			INSERT INTO db4s_download_mappings (db4s_download, pattern)
+			VALUES ($1, $2)`
+		if _, err = tx.Exec(queryCtx, db.withTables(dbQuery), id, a.Request); err != nil {
+			log.Printf("Adding release failed: %v\n", err)
+			return nil, err
+		}
+		ids = append(ids, int(id))
+	}
+	if err = tx.Commit(queryCtx); err != nil {
+		log.Printf("Adding release failed: %v\n", err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ReleaseInfo is an entry in the db4s_release_info table
+type ReleaseInfo struct {
+	ID         int
+	Version    string
+	Name       string    // The friendly name, or the GitHub release name when there isn't one
+	Released   time.Time // Zero when the release date isn't known
+	Prerelease bool
+}
+
+// ListReleases returns the entries of the db4s_release_info table, in ID order
+func (db *DB) ListReleases(ctx context.Context) (releases []ReleaseInfo, err error) {
+	dbQuery := `
+		SELECT release_id, coalesce(version_number, ''), coalesce(friendly_name, release_name, ''), release_date,
+			coalesce(prerelease, false)
+		FROM db4s_release_info
+		ORDER BY release_id`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var r ReleaseInfo
+		var id int32
+		var released pgtype.Timestamptz
+		err = rows.Scan(&id, &r.Version, &r.Name, &released, &r.Prerelease)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		r.ID, r.Released = int(id), released.Time
+		releases = append(releases, r)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+	}
+	return
+}
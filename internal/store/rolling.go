@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SaveRollingAverages saves the rolling averages of a daily metric family for the given date, keyed by the number of
+// days in the window, replacing any saved earlier for it
+func (db *DB) SaveRollingAverages(ctx context.Context, family string, date time.Time, averages map[int]float64) error {
+	dbQuery := `
+		INSERT INTO stats_rolling_averages (metric_family, stats_date, window_days, average)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (metric_family, stats_date, window_days)
+			DO UPDATE
+				SET average = $4
+				WHERE stats_rolling_averages.metric_family = $1
+					AND stats_rolling_averages.stats_date = $2
+					AND stats_rolling_averages.window_days = $3`
+	for days, average := range averages {
+		commandTag, err := db.exec(ctx, dbQuery, family, date, days, average)
+		if err != nil {
+			log.Printf("Saving rolling average failed: %v\n", err)
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows != 1 {
+			log.Printf("Wrong number of rows (%v) affected when saving the %v day rolling average for: %v\n", numRows,
+				days, family)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Run is an entry in the stats_runs table.  RangeStart and RangeEnd are zero when no time periods were processed,
+// and Error is empty for successful runs
+type Run struct {
+	Started    time.Time
+	Finished   time.Time
+	Mode       string
+	RangeStart time.Time
+	RangeEnd   time.Time
+	Processed  int
+	Rows       int
+	Warnings   []string
+	Version    string
+	ExitStatus int
+	Error      string
+}
+
+// SaveRun records a stats generation run in the stats_runs table
+func (db *DB) SaveRun(ctx context.Context, run Run) error {
+	warnings := run.Warnings
+	if warnings == nil {
+		warnings = []string{}
+	}
+	dbQuery := `
+		INSERT INTO stats_runs (started, finished, mode, range_start, range_end, periods_processed, rows_saved,
+			warnings, tool_version, exit_status, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err := db.exec(ctx, dbQuery, run.Started, run.Finished, run.Mode, nullTime(run.RangeStart),
+		nullTime(run.RangeEnd), run.Processed, run.Rows, warnings, run.Version, run.ExitStatus, nullString(run.Error))
+	if err != nil {
+		log.Printf("Saving the run details failed: %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// The schema files are applied in file name order, and must be safe to run against an existing database
+//
+//go:embed schema/*.sql
+var schemaFiles embed.FS
+
+// InitSchema creates any missing stats tables, along with their unique constraints and indexes, then adds the
+// reference rows the stats depend on.  It's safe to run against an existing database
+func (db *DB) InitSchema(ctx context.Context) error {
+	names, err := fs.Glob(schemaFiles, "schema/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	tx, err := db.pool.Begin(ctx)
+	if db.retryAuth(err) {
+		tx, err = db.pool.Begin(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, name := range names {
+		ddl, err := schemaFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if db.Verbosity >= verbosity.Verbose {
+			log.Printf("Applying %v\n", name)
+		}
+		if _, err = tx.Exec(ctx, db.withTables(string(ddl))); err != nil {
+			return fmt.Errorf("applying %v: %w", name, err)
+		}
+	}
+
+	// The totals rows, plus the downloads we generate stats for
+	dbQuery := `
+		INSERT INTO db4s_release_info (release_id, version_number, friendly_name)
+		VALUES (1, 'Unique IPs', 'Unique IPs')
+		ON CONFLICT DO NOTHING`
+	if _, err = tx.Exec(ctx, db.withTables(dbQuery)); err != nil {
+		return err
+	}
+	dbQuery = `
+		INSERT INTO db4s_download_info (download_id, friendly_name)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+	if _, err = tx.Exec(ctx, db.withTables(dbQuery), 0, "Total downloads"); err != nil {
+		return err
+	}
+	for _, file := range db.filters.downloads {
+		if _, err = tx.Exec(ctx, db.withTables(dbQuery), file.ID, file.Name); err != nil {
+			return err
+		}
+	}
+	dbQuery = `
+		INSERT INTO db4s_download_mappings (db4s_download, pattern)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+	for _, file := range db.filters.downloads {
+		for _, request := range file.Requests {
+			if _, err = tx.Exec(ctx, db.withTables(dbQuery), file.ID, request); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Move the ID sequences past the explicitly inserted IDs
+	dbQuery = `
+		SELECT setval('db4s_release_info_release_id_seq', (SELECT max(release_id) FROM db4s_release_info));
+		SELECT setval('db4s_download_info_download_id_seq', (SELECT max(download_id) FROM db4s_download_info));`
+	if _, err = tx.Exec(ctx, db.withTables(dbQuery)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// schemaObject is a database object needed by some of the stats, along with a query returning whether it exists
+type schemaObject struct {
+	name  string
+	query string
+}
+
+// CheckSchema checks the database has the functions, tables and columns the enabled stats need.  These are added by
+// init-schema, so after upgrading to a release needing new ones, it returns an error saying to run that rather than
+// the run failing part way through
+func (db *DB) CheckSchema(ctx context.Context) error {
+	var objects []schemaObject
+	if db.clickHouse == nil && (len(db.excluded) > 0 || db.maxDownloads > 0) {
+		objects = append(objects, schemaObject{"db4s_in_networks() function",
+			`SELECT to_regprocedure('public.db4s_in_networks(text, cidr[])') IS NOT NULL`})
+	}
+	if db.forwardedFor != "" {
+		objects = append(objects, schemaObject{"db4s_client_ip() function",
+			`SELECT to_regprocedure('public.db4s_client_ip(text, text, cidr[])') IS NOT NULL`})
+	}
+	if len(db.excluded) > 0 {
+		objects = append(objects, schemaObject{"stats_excluded_traffic table",
+			`SELECT to_regclass('public.stats_excluded_traffic') IS NOT NULL`})
+	}
+	if db.uniqueDLs {
+		objects = append(objects, schemaObject{"unique_downloads column of db4s_downloads_daily", `
+			SELECT EXISTS (
+				SELECT 1
+				FROM information_schema.columns
+				WHERE table_schema = 'public'
+					AND table_name = 'db4s_downloads_daily'
+					AND column_name = 'unique_downloads')`})
+	}
+	if db.failed {
+		objects = append(objects, schemaObject{"db4s_failed_downloads table",
+			`SELECT to_regclass('public.db4s_failed_downloads') IS NOT NULL`})
+	}
+	if db.channelRollup {
+		objects = append(objects, schemaObject{"db4s_downloads_by_channel table",
+			`SELECT to_regclass('public.db4s_downloads_by_channel') IS NOT NULL`})
+	}
+	for _, o := range objects {
+		var exists bool
+		if err := db.queryRow(ctx, o.query).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("the %v is missing from the database, run init-schema to add it",
+				db.withTables(o.name))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+--
+-- Reference tables.  Release ID 1 ("Unique IPs") and download ID 0 ("Total downloads") hold the totals rows in the
+-- stats tables
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_release_info (
+    release_id serial NOT NULL,
+    version_number text,
+    friendly_name text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_release_info_release_id_uindex ON public.db4s_release_info USING btree (release_id);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_release_info_version_number_uindex ON public.db4s_release_info USING btree (version_number);
+
+CREATE TABLE IF NOT EXISTS public.db4s_download_info (
+    download_id serial NOT NULL,
+    friendly_name text,
+    CONSTRAINT db4s_download_info_pk PRIMARY KEY (download_id)
+);
+
+--
+-- Users stats
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_users_daily (
+    daily_id serial NOT NULL,
+    stats_date timestamp without time zone,
+    db4s_release integer,
+    unique_ips integer,
+    CONSTRAINT db4s_users_daily_pk PRIMARY KEY (daily_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_users_daily_stats_date_db4s_release_uindex ON public.db4s_users_daily USING btree (stats_date, db4s_release);
+
+CREATE TABLE IF NOT EXISTS public.db4s_users_weekly (
+    weekly_id serial NOT NULL,
+    stats_date timestamp without time zone,
+    db4s_release integer,
+    unique_ips integer,
+    CONSTRAINT db4s_users_weekly_pk PRIMARY KEY (weekly_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_users_weekly_stats_date_db4s_release_uindex ON public.db4s_users_weekly USING btree (stats_date, db4s_release);
+
+CREATE TABLE IF NOT EXISTS public.db4s_users_monthly (
+    monthly_id serial NOT NULL,
+    stats_date timestamp without time zone,
+    db4s_release integer,
+    unique_ips integer,
+    CONSTRAINT db4s_users_monthly_pk PRIMARY KEY (monthly_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_users_monthly_stats_date_db4s_release_uindex ON public.db4s_users_monthly USING btree (stats_date, db4s_release);
+
+--
+-- Downloads stats
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_downloads_daily (
+    daily_id serial NOT NULL,
+    stats_date timestamp without time zone,
+    db4s_download integer,
+    num_downloads integer,
+    CONSTRAINT db4s_downloads_daily_pk PRIMARY KEY (daily_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_downloads_daily_stats_date_db4s_download_uindex ON public.db4s_downloads_daily USING btree (stats_date, db4s_download);
+
+CREATE TABLE IF NOT EXISTS public.db4s_downloads_weekly (
+    weekly_id serial NOT NULL,
+    stats_date timestamp without time zone,
+    db4s_download integer,
+    num_downloads integer,
+    CONSTRAINT db4s_downloads_weekly_pk PRIMARY KEY (weekly_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_downloads_weekly_stats_date_db4s_download_uindex ON public.db4s_downloads_weekly USING btree (stats_date, db4s_download);
+
+CREATE TABLE IF NOT EXISTS public.db4s_downloads_monthly (
+    monthly_id serial NOT NULL,
+    stats_date timestamp without time zone,
+    db4s_download integer,
+    num_downloads integer,
+    CONSTRAINT db4s_downloads_monthly_pk PRIMARY KEY (monthly_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_downloads_monthly_stats_date_db4s_download_uindex ON public.db4s_downloads_monthly USING btree (stats_date, db4s_download);
@@ -0,0 +1,10 @@
+--
+-- Tracks the end of the last fully processed time period for each metric family, so default runs can resume from
+-- there instead of reprocessing everything from 2018-08-13 onwards
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_processing_state (
+    metric_family text NOT NULL,
+    last_processed timestamp without time zone NOT NULL,
+    CONSTRAINT stats_processing_state_pk PRIMARY KEY (metric_family)
+);
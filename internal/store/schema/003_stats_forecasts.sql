@@ -0,0 +1,13 @@
+--
+-- Holds the forecast total of each metric family for upcoming time periods, so forecasts can be compared against the
+-- actual totals once the time period has finished
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_forecasts (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    predicted bigint NOT NULL,
+    method text NOT NULL,
+    created timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT stats_forecasts_pk PRIMARY KEY (metric_family, stats_date)
+);
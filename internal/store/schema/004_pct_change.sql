@@ -0,0 +1,9 @@
+--
+-- Adds the percentage change from the previous time period (week on week, month on month) to the weekly and monthly
+-- stats tables.  NULL when there's no previous value to compare against
+--
+
+ALTER TABLE public.db4s_downloads_weekly ADD COLUMN IF NOT EXISTS pct_change numeric(10,2);
+ALTER TABLE public.db4s_downloads_monthly ADD COLUMN IF NOT EXISTS pct_change numeric(10,2);
+ALTER TABLE public.db4s_users_weekly ADD COLUMN IF NOT EXISTS pct_change numeric(10,2);
+ALTER TABLE public.db4s_users_monthly ADD COLUMN IF NOT EXISTS pct_change numeric(10,2);
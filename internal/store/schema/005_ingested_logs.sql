@@ -0,0 +1,16 @@
+--
+-- Records the log files loaded into the download_log table, so files which have already been loaded (eg Cloudflare
+-- Logpush files, which cover a known time range) are skipped instead of being added twice
+--
+
+CREATE TABLE IF NOT EXISTS public.ingested_logs (
+    source text NOT NULL,
+    name text NOT NULL,
+    range_start timestamp with time zone,
+    range_end timestamp with time zone,
+    entries bigint NOT NULL,
+    ingested timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT ingested_logs_pk PRIMARY KEY (source, name)
+);
+
+CREATE INDEX IF NOT EXISTS ingested_logs_range_index ON public.ingested_logs USING btree (source, range_start, range_end);
@@ -0,0 +1,8 @@
+--
+-- Adds the unique downloads count to the downloads stats tables.  This counts each IP address downloading a file at
+-- most once per day, so download managers and retries don't inflate it the way they do the raw counts
+--
+
+ALTER TABLE public.db4s_downloads_daily ADD COLUMN IF NOT EXISTS unique_downloads bigint;
+ALTER TABLE public.db4s_downloads_weekly ADD COLUMN IF NOT EXISTS unique_downloads bigint;
+ALTER TABLE public.db4s_downloads_monthly ADD COLUMN IF NOT EXISTS unique_downloads bigint;
@@ -0,0 +1,8 @@
+--
+-- Adds the bandwidth used to the downloads stats tables, for planning mirror and CDN capacity, and attributing the
+-- hosting costs to specific release files
+--
+
+ALTER TABLE public.db4s_downloads_daily ADD COLUMN IF NOT EXISTS bytes_sent bigint;
+ALTER TABLE public.db4s_downloads_weekly ADD COLUMN IF NOT EXISTS bytes_sent bigint;
+ALTER TABLE public.db4s_downloads_monthly ADD COLUMN IF NOT EXISTS bytes_sent bigint;
@@ -0,0 +1,13 @@
+--
+-- Holds the number of failed (403, 404 and 5xx) requests for each download artifact in each time period of the
+-- downloads metric families, so broken links on the downloads page (eg a renamed installer) show up in the stats
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_failed_downloads (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    request text NOT NULL,
+    status integer NOT NULL,
+    failures bigint NOT NULL,
+    CONSTRAINT db4s_failed_downloads_pk PRIMARY KEY (metric_family, stats_date, request, status)
+);
@@ -0,0 +1,14 @@
+--
+-- Holds the top referrers of each download in each time period of the downloads metric families, so we can see
+-- whether downloads come from our site, GitHub or third party listings, and spot hotlinking.  Download 0 is the
+-- total across all downloads, as per the db4s_download_info table
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_download_referrers (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    db4s_download integer NOT NULL,
+    referrer text NOT NULL,
+    num_downloads bigint NOT NULL,
+    CONSTRAINT db4s_download_referrers_pk PRIMARY KEY (metric_family, stats_date, db4s_download, referrer)
+);
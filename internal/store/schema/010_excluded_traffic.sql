@@ -0,0 +1,32 @@
+--
+-- Requests from our own mirrors, CI providers and package build farms are left out of the users and downloads stats.
+-- db4s_in_networks() returns whether a download_log IP address is in any of the excluded networks, treating values
+-- which aren't valid IP addresses (eg from the client_ip_strange field) as not being in them
+--
+
+CREATE OR REPLACE FUNCTION public.db4s_in_networks(ip text, networks cidr[]) RETURNS boolean
+    LANGUAGE plpgsql IMMUTABLE
+    AS $$
+BEGIN
+    IF ip IS NULL OR coalesce(cardinality(networks), 0) = 0 OR ip !~ '^[0-9A-Fa-f:.]+$' THEN
+        RETURN false;
+    END IF;
+    BEGIN
+        RETURN ip::inet <<= ANY(networks);
+    EXCEPTION WHEN invalid_text_representation THEN
+        RETURN false;
+    END;
+END
+$$;
+
+--
+-- Holds the number of requests from the excluded networks in each time period of each metric family (version checks
+-- for the users families, download requests for the downloads families), so we can see how much was filtered
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_excluded_traffic (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    requests bigint NOT NULL,
+    CONSTRAINT stats_excluded_traffic_pk PRIMARY KEY (metric_family, stats_date)
+);
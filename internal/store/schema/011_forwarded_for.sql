@@ -0,0 +1,31 @@
+--
+-- When the download logs come from behind a reverse proxy, the client IP address fields can hold the proxy's address
+-- instead of the user's.  db4s_client_ip() returns the address of the client, going by the X-Forwarded-For header
+-- when the request came through one of the trusted proxies.  The header is read from right to left, skipping the
+-- trusted proxies, as only the entries added by them can be relied on
+--
+
+CREATE OR REPLACE FUNCTION public.db4s_client_ip(ip text, forwarded_for text, trusted cidr[]) RETURNS text
+    LANGUAGE plpgsql IMMUTABLE
+    AS $$
+DECLARE
+    hops text[];
+    hop text;
+BEGIN
+    IF coalesce(forwarded_for, '') = '' OR NOT public.db4s_in_networks(ip, trusted) THEN
+        RETURN ip;
+    END IF;
+    hops := string_to_array(forwarded_for, ',');
+    FOR i IN REVERSE cardinality(hops)..1 LOOP
+        hop := btrim(hops[i]);
+        IF hop = '' THEN
+            CONTINUE;
+        END IF;
+        ip := hop;
+        IF NOT public.db4s_in_networks(hop, trusted) THEN
+            EXIT;
+        END IF;
+    END LOOP;
+    RETURN ip;
+END
+$$;
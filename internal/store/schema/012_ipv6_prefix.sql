@@ -0,0 +1,26 @@
+--
+-- A single IPv6 user often appears under many addresses in the same /64, due to privacy extensions.  When counting the
+-- unique IP addresses by /64 prefix, db4s_ipv6_prefix() returns the /64 network of an IPv6 address.  Other values
+-- (IPv4 addresses, IPv4-mapped IPv6 addresses, and anything which isn't a valid IP address) are returned unchanged
+--
+
+CREATE OR REPLACE FUNCTION public.db4s_ipv6_prefix(ip text) RETURNS text
+    LANGUAGE plpgsql IMMUTABLE
+    AS $$
+DECLARE
+    addr inet;
+BEGIN
+    IF ip IS NULL OR position(':' in ip) = 0 OR ip !~ '^[0-9A-Fa-f:.]+$' THEN
+        RETURN ip;
+    END IF;
+    BEGIN
+        addr := ip::inet;
+    EXCEPTION WHEN invalid_text_representation THEN
+        RETURN ip;
+    END;
+    IF family(addr) <> 6 OR addr <<= '::ffff:0.0.0.0/96'::inet THEN
+        RETURN ip;
+    END IF;
+    RETURN text(network(set_masklen(addr, 64)));
+END
+$$;
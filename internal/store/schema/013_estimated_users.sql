@@ -0,0 +1,9 @@
+--
+-- Adds the estimated number of users to the users stats tables.  Unique IP addresses undercount the users behind
+-- carrier grade NAT, so this estimates the users behind each IP address from the user agents seen from it and its
+-- daily number of version checks
+--
+
+ALTER TABLE public.db4s_users_daily ADD COLUMN IF NOT EXISTS estimated_users integer;
+ALTER TABLE public.db4s_users_weekly ADD COLUMN IF NOT EXISTS estimated_users integer;
+ALTER TABLE public.db4s_users_monthly ADD COLUMN IF NOT EXISTS estimated_users integer;
@@ -0,0 +1,13 @@
+--
+-- Holds the daily install counts of DB4S from distribution channels other than our download mirrors (eg Flathub), so
+-- adoption through them is visible too
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_channel_downloads (
+    channel text NOT NULL,
+    stats_date date NOT NULL,
+    installs bigint NOT NULL,
+    updates bigint NOT NULL,
+    collected timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT db4s_channel_downloads_pk PRIMARY KEY (channel, stats_date)
+);
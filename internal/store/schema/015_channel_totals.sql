@@ -0,0 +1,6 @@
+--
+-- Some distribution channels (eg Chocolatey) only publish a running total of installs, so it's saved for each day it's
+-- collected, with the installs for the day being the increase since the previous total
+--
+
+ALTER TABLE public.db4s_channel_downloads ADD COLUMN IF NOT EXISTS total bigint;
@@ -0,0 +1,13 @@
+--
+-- Holds the downloads from each distribution channel (our download mirrors plus those in db4s_channel_downloads) in
+-- each time period of the downloads metric families, along with their grand total, so there's a single number to
+-- quote for the downloads in a time period
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_downloads_by_channel (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    channel text NOT NULL,
+    downloads bigint NOT NULL,
+    CONSTRAINT db4s_downloads_by_channel_pk PRIMARY KEY (metric_family, stats_date, channel)
+);
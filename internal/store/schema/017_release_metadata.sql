@@ -0,0 +1,8 @@
+--
+-- The release details from GitHub, for the db4s_release_info entries matching a GitHub release.  These are NULL for
+-- the other entries (eg nightly builds)
+--
+
+ALTER TABLE public.db4s_release_info ADD COLUMN IF NOT EXISTS release_name text;
+ALTER TABLE public.db4s_release_info ADD COLUMN IF NOT EXISTS release_date timestamp with time zone;
+ALTER TABLE public.db4s_release_info ADD COLUMN IF NOT EXISTS prerelease boolean;
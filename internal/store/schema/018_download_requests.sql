@@ -0,0 +1,7 @@
+--
+-- The request path of the db4s_download_info entries added automatically for newly released artifacts.  It's NULL for
+-- the entries whose request paths are in the DownloadFiles list
+--
+
+ALTER TABLE public.db4s_download_info ADD COLUMN IF NOT EXISTS request text;
+CREATE UNIQUE INDEX IF NOT EXISTS db4s_download_info_request_uindex ON public.db4s_download_info USING btree (request);
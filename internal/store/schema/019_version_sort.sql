@@ -0,0 +1,7 @@
+--
+-- A sortable key for the version number of each release, as the version numbers themselves sort alphabetically (eg
+-- "3.9.1" after "3.13.0").  Version numbers which can't be parsed are flagged as malformed for review, with no key
+--
+
+ALTER TABLE public.db4s_release_info ADD COLUMN IF NOT EXISTS sort_key text;
+ALTER TABLE public.db4s_release_info ADD COLUMN IF NOT EXISTS malformed boolean;
@@ -0,0 +1,12 @@
+--
+-- Holds the number of unique IP addresses doing version checks from each OS in each time period of the users metric
+-- families, going by the extra tokens some DB4S builds add to their user agent
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_users_by_os (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    os text NOT NULL,
+    unique_ips bigint NOT NULL,
+    CONSTRAINT db4s_users_by_os_pk PRIMARY KEY (metric_family, stats_date, os)
+);
@@ -0,0 +1,8 @@
+--
+-- The percentage of the unique IP addresses of each row of the users stats tables which did a version check from an
+-- out of date release, ie one older than the latest (non pre-release) release at the time of the version check
+--
+
+ALTER TABLE public.db4s_users_daily ADD COLUMN IF NOT EXISTS update_pending_pct double precision;
+ALTER TABLE public.db4s_users_weekly ADD COLUMN IF NOT EXISTS update_pending_pct double precision;
+ALTER TABLE public.db4s_users_monthly ADD COLUMN IF NOT EXISTS update_pending_pct double precision;
@@ -0,0 +1,12 @@
+--
+-- Holds the rolling averages of the daily totals of each daily metric family, so dashboards can show smooth trends
+-- without each of them reimplementing the window math
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_rolling_averages (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    window_days integer NOT NULL,
+    average double precision NOT NULL,
+    CONSTRAINT stats_rolling_averages_pk PRIMARY KEY (metric_family, stats_date, window_days)
+);
@@ -0,0 +1,15 @@
+--
+-- Holds the data quality report of each run, counting the suspicious rows of the download logs it processed
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_quality_reports (
+    checked timestamp without time zone DEFAULT now() NOT NULL,
+    range_start timestamp without time zone NOT NULL,
+    range_end timestamp without time zone NOT NULL,
+    no_client_ip bigint NOT NULL,
+    malformed_versions bigint NOT NULL,
+    unknown_downloads bigint NOT NULL,
+    duplicates bigint NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stats_quality_reports_checked_idx ON public.stats_quality_reports (checked);
@@ -0,0 +1,20 @@
+--
+-- Holds the details of each stats generation run, as an audit trail for when the saved stats change
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_runs (
+    run_id bigserial PRIMARY KEY,
+    started timestamp without time zone NOT NULL,
+    finished timestamp without time zone NOT NULL,
+    mode text NOT NULL,
+    range_start timestamp without time zone,
+    range_end timestamp without time zone,
+    periods_processed integer NOT NULL,
+    rows_saved integer NOT NULL,
+    warnings text[] NOT NULL,
+    tool_version text NOT NULL,
+    exit_status integer NOT NULL,
+    error text
+);
+
+CREATE INDEX IF NOT EXISTS stats_runs_started_idx ON public.stats_runs (started);
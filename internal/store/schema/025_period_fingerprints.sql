@@ -0,0 +1,12 @@
+--
+-- Holds a fingerprint of the download logs of each fully processed time period, so runs can skip the time periods
+-- whose download logs haven't changed since their stats were generated
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_period_fingerprints (
+    metric_family text NOT NULL,
+    stats_date timestamp without time zone NOT NULL,
+    log_rows bigint NOT NULL,
+    max_request_time timestamp without time zone,
+    CONSTRAINT stats_period_fingerprints_pk PRIMARY KEY (metric_family, stats_date)
+);
@@ -0,0 +1,15 @@
+--
+-- Holds the downloads and version checks in the days before and after each release date in db4s_release_info, for
+-- the daily metric families, to quantify the effect of each release announcement
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_release_impact (
+    release_id integer NOT NULL,
+    metric_family text NOT NULL,
+    release_date date NOT NULL,
+    window_days integer NOT NULL,
+    total_before bigint NOT NULL,
+    total_after bigint NOT NULL,
+    uplift_pct double precision,
+    CONSTRAINT stats_release_impact_pk PRIMARY KEY (release_id, metric_family)
+);
@@ -0,0 +1,12 @@
+--
+-- Holds the distribution of the daily number of version checks per IP address in each time period of the users metric
+-- families.  Each IP address is counted once per day, in the bucket of its number of version checks that day
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_users_check_ins (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    bucket text NOT NULL,
+    ip_days bigint NOT NULL,
+    CONSTRAINT db4s_users_check_ins_pk PRIMARY KEY (metric_family, stats_date, bucket)
+);
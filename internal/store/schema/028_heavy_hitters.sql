@@ -0,0 +1,13 @@
+--
+-- Holds the IP addresses left out of each time period of each metric family for making too many requests on a single
+-- day (version checks for the users families, download requests for the downloads families), along with the number
+-- of requests they made, so a single broken client skewing the stats shows up
+--
+
+CREATE TABLE IF NOT EXISTS public.stats_heavy_hitters (
+    metric_family text NOT NULL,
+    stats_date date NOT NULL,
+    ips bigint NOT NULL,
+    requests bigint NOT NULL,
+    CONSTRAINT stats_heavy_hitters_pk PRIMARY KEY (metric_family, stats_date)
+);
@@ -0,0 +1,16 @@
+--
+-- Maps the request paths in the download logs to their db4s_download_info entries.  A pattern is either an exact
+-- request path or a regular expression, and only the request paths requested while the mapping is active (from
+-- active_from, until before active_until) are mapped.  A NULL bound leaves that end of the range open
+--
+
+CREATE TABLE IF NOT EXISTS public.db4s_download_mappings (
+    mapping_id serial NOT NULL,
+    db4s_download integer NOT NULL,
+    pattern text NOT NULL,
+    is_regex boolean DEFAULT false NOT NULL,
+    active_from timestamp without time zone,
+    active_until timestamp without time zone,
+    CONSTRAINT db4s_download_mappings_pk PRIMARY KEY (mapping_id),
+    CONSTRAINT db4s_download_mappings_uindex UNIQUE (db4s_download, pattern)
+);
@@ -0,0 +1,11 @@
+--
+-- Where the rows of the users and downloads stats tables came from.  NULL for the rows generated from the download
+-- logs, otherwise the source given to the import command (eg the old hosting's download numbers)
+--
+
+ALTER TABLE public.db4s_users_daily ADD COLUMN IF NOT EXISTS source text;
+ALTER TABLE public.db4s_users_weekly ADD COLUMN IF NOT EXISTS source text;
+ALTER TABLE public.db4s_users_monthly ADD COLUMN IF NOT EXISTS source text;
+ALTER TABLE public.db4s_downloads_daily ADD COLUMN IF NOT EXISTS source text;
+ALTER TABLE public.db4s_downloads_weekly ADD COLUMN IF NOT EXISTS source text;
+ALTER TABLE public.db4s_downloads_monthly ADD COLUMN IF NOT EXISTS source text;
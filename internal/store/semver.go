@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed DB4S version number.  eg 3.13.0-rc1
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// versionFormat matches the version numbers given in the user agents, with an optional leading "v", an optional patch
+// number, and an optional pre-release suffix.  eg "3.12.2", "v3.13.0", "3.13" or "3.13.0-rc1"
+var versionFormat = regexp.MustCompile(`^[vV]?(\d+)\.(\d+)(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?$`)
+
+// ParseVersion parses a version number, returning false when it's malformed
+func ParseVersion(s string) (v Version, ok bool) {
+	m := versionFormat.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Version{}, false
+	}
+	var err error
+	if v.Major, err = strconv.Atoi(m[1]); err != nil {
+		return Version{}, false
+	}
+	if v.Minor, err = strconv.Atoi(m[2]); err != nil {
+		return Version{}, false
+	}
+	if m[3] != "" {
+		if v.Patch, err = strconv.Atoi(m[3]); err != nil {
+			return Version{}, false
+		}
+	}
+	v.Prerelease = strings.ToLower(m[4])
+	return v, true
+}
+
+// String returns the normalised version number.  eg "3.13.0" for "v3.13"
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// SortKey returns a key for the version which sorts alphabetically in version order.  Pre-releases sort before the
+// release itself, as "-" sorts before "~"
+func (v Version) SortKey() string {
+	key := fmt.Sprintf("%05d.%05d.%05d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		return key + "-" + v.Prerelease
+	}
+	return key + "~"
+}
+
+// CompareVersions orders two version numbers, returning -1, 0 or 1 like strings.Compare.  Malformed version numbers
+// sort after the others, alphabetically
+func CompareVersions(a, b string) int {
+	va, okA := ParseVersion(a)
+	vb, okB := ParseVersion(b)
+	switch {
+	case okA && okB:
+		if c := strings.Compare(va.SortKey(), vb.SortKey()); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	case okA:
+		return -1
+	case okB:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// updateVersionKeys fills in the sort key of the db4s_release_info entries not checked yet, flagging those whose
+// version number is malformed.  The "Unique IPs" entry isn't a version, so is left alone
+func (db *DB) updateVersionKeys(ctx context.Context) error {
+	dbQuery := `
+		SELECT release_id, version_number
+		FROM db4s_release_info
+		WHERE malformed IS NULL
+			AND release_id != 1`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return err
+	}
+	defer cancel()
+	defer rows.Close()
+	versions := make(map[int32]string)
+	for rows.Next() {
+		var id int32
+		var version string
+		err = rows.Scan(&id, &version)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return err
+		}
+		versions[id] = version
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return err
+	}
+
+	dbQuery = `
+		UPDATE db4s_release_info
+		SET sort_key = $2, malformed = $3
+		WHERE release_id = $1`
+	for id, version := range versions {
+		var key *string
+		v, ok := ParseVersion(version)
+		if ok {
+			k := v.SortKey()
+			key = &k
+		} else {
+			log.Printf("Malformed version number in the user agents: '%v'\n", version)
+		}
+		_, err = db.exec(ctx, dbQuery, id, key, !ok)
+		if err != nil {
+			log.Printf("Updating the version sort key failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
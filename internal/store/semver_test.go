@@ -0,0 +1,111 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+		ok   bool
+	}{
+		{"3.12.2", Version{3, 12, 2, ""}, true},
+		{"v3.13.0", Version{3, 13, 0, ""}, true},
+		{"V3.13.0", Version{3, 13, 0, ""}, true},
+		{"3.13", Version{3, 13, 0, ""}, true},
+		{" 3.12.2\n", Version{3, 12, 2, ""}, true},
+		{"3.13.0-rc1", Version{3, 13, 0, "rc1"}, true},
+		{"3.13.0-RC.2", Version{3, 13, 0, "rc.2"}, true},
+		{"3.13-beta", Version{3, 13, 0, "beta"}, true},
+		{"0.0.0", Version{0, 0, 0, ""}, true},
+		{"", Version{}, false},
+		{"3", Version{}, false},
+		{"3.x", Version{}, false},
+		{"3.12.2.1", Version{}, false},
+		{"3.12.2-", Version{}, false},
+		{"3.12.2-rc 1", Version{}, false},
+		{"3.12.2+build5", Version{}, false},
+		{"-3.12.2", Version{}, false},
+		{"vv3.12.2", Version{}, false},
+		{"99999999999999999999.1", Version{}, false},
+		{"nightly", Version{}, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseVersion(test.in)
+		if got != test.want || ok != test.ok {
+			t.Errorf("ParseVersion(%q) = %+v, %v, expected %+v, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"3.12.2", "3.12.2"},
+		{"v3.13", "3.13.0"},
+		{"3.13.0-RC1", "3.13.0-rc1"},
+	}
+	for _, test := range tests {
+		v, _ := ParseVersion(test.in)
+		if got := v.String(); got != test.want {
+			t.Errorf("ParseVersion(%q).String() = %v, expected %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.12.2", "3.12.2", 0},
+		{"3.9.1", "3.13.0", -1},
+		{"3.13.0", "3.9.1", 1},
+		{"3.12.10", "3.12.9", 1},
+		{"2.99.99", "3.0.0", -1},
+		{"3.13", "3.13.1", -1},
+
+		// Pre-releases come before the release
+		{"3.13.0-rc1", "3.13.0", -1},
+		{"3.13.0", "3.13.0-rc1", 1},
+		{"3.13.0-beta1", "3.13.0-rc1", -1},
+		{"3.13.0-rc1", "3.12.2", 1},
+
+		// The same version written differently is ordered by how it's written, so the order is stable
+		{"3.13.0", "v3.13", -1},
+		{"v3.13", "3.13.0", 1},
+
+		// Malformed versions come after the others, alphabetically
+		{"3.12.2", "nightly", -1},
+		{"nightly", "3.12.2", 1},
+		{"abc", "nightly", -1},
+		{"nightly", "nightly", 0},
+	}
+	for _, test := range tests {
+		if got := CompareVersions(test.a, test.b); got != test.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := []string{"3.9.1", "nightly", "3.13.0", "3.12.2", "3.13.0-rc1", "3.10.0", "", "3.12.0", "3.13.0-beta"}
+	expected := []string{"3.9.1", "3.10.0", "3.12.0", "3.12.2", "3.13.0-beta", "3.13.0-rc1", "3.13.0", "", "nightly"}
+	slices.SortFunc(versions, CompareVersions)
+	if !slices.Equal(versions, expected) {
+		t.Errorf("sorted versions are %q, expected %q", versions, expected)
+	}
+
+	// The sort keys give the same order, for sorting in the database
+	var keys []string
+	for _, v := range expected[:7] {
+		parsed, _ := ParseVersion(v)
+		keys = append(keys, parsed.SortKey())
+	}
+	if !slices.IsSorted(keys) {
+		t.Errorf("sort keys %q aren't in version order", keys)
+	}
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// SaveWatermark records the end of the last fully processed time period for a metric family in the
+// stats_processing_state table.  The watermark is never moved backwards
+func (db *DB) SaveWatermark(ctx context.Context, family string, processedUntil time.Time) error {
+	dbQuery := `
+		INSERT INTO stats_processing_state (metric_family, last_processed)
+		VALUES ($1, $2)
+		ON CONFLICT (metric_family)
+			DO UPDATE
+				SET last_processed = greatest(stats_processing_state.last_processed, $2)
+				WHERE stats_processing_state.metric_family = $1`
+	commandTag, err := db.exec(ctx, dbQuery, family, processedUntil)
+	if err != nil {
+		// For now, don't bother logging a failure here.  This *might* need changing later on
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when saving the processing watermark for: %v\n", numRows, family)
+	}
+	return nil
+}
+
+// StatsDates returns the dates in a stats table which have a totals row (identified by the given column and value)
+// saved for them, from the given date onwards
+func (db *DB) StatsDates(ctx context.Context, table, totalColumn string, totalID int, from time.Time) (dates map[time.Time]struct{}, err error) {
+	dbQuery := fmt.Sprintf(`
+		SELECT stats_date
+		FROM %s
+		WHERE %s = $1
+			AND stats_date >= $2`, table, totalColumn)
+	rows, cancel, err := db.query(ctx, dbQuery, totalID, from)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	dates = make(map[time.Time]struct{})
+	for rows.Next() {
+		var statsDate time.Time
+		err = rows.Scan(&statsDate)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		dates[statsDate.UTC()] = struct{}{}
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	return
+}
+
+// StatsRange returns the saved stats of a stats table for the dates from the start date up to (but not including) the
+// end date, keyed by stats date then release or download ID
+func (db *DB) StatsRange(ctx context.Context, table, idColumn, valueColumn string, startDate, endDate time.Time) (counts map[time.Time]map[int]int64, err error) {
+	dbQuery := fmt.Sprintf(`
+		SELECT stats_date, %s, %s
+		FROM %s
+		WHERE stats_date >= $1
+			AND stats_date < $2
+			AND %s IS NOT NULL`, idColumn, valueColumn, table, idColumn)
+	rows, cancel, err := db.query(ctx, dbQuery, startDate, endDate)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	counts = make(map[time.Time]map[int]int64)
+	for rows.Next() {
+		var date time.Time
+		var id int
+		var count pgtype.Int8
+		err = rows.Scan(&date, &id, &count)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		date = date.UTC()
+		if counts[date] == nil {
+			counts[date] = make(map[int]int64)
+		}
+		counts[date][id] = count.Int64
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	return
+}
+
+// StoredStats returns the saved stats of a stats table for the given date, keyed by release or download ID
+func (db *DB) StoredStats(ctx context.Context, table, idColumn, valueColumn string, date time.Time) (counts map[int]int64, err error) {
+	dbQuery := fmt.Sprintf(`
+		SELECT %s, %s
+		FROM %s
+		WHERE stats_date = $1
+			AND %s IS NOT NULL`, idColumn, valueColumn, table, idColumn)
+	rows, cancel, err := db.query(ctx, dbQuery, date)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	counts = make(map[int]int64)
+	for rows.Next() {
+		var id int
+		var count pgtype.Int8
+		err = rows.Scan(&id, &count)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		counts[id] = count.Int64
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	return
+}
+
+// UpdateGrowth sets the pct_change column of each row of a stats table for the given date, to the percentage change
+// from the matching row of the previous date.  Rows without a previous value to compare against are set to NULL
+func (db *DB) UpdateGrowth(ctx context.Context, table, idColumn, valueColumn string, date, previous time.Time) error {
+	dbQuery := fmt.Sprintf(`
+		UPDATE %[1]s cur
+		SET pct_change = (
+			SELECT round((cur.%[3]s - prev.%[3]s) * 100.0 / prev.%[3]s, 2)
+			FROM %[1]s prev
+			WHERE prev.stats_date = $2
+				AND prev.%[2]s = cur.%[2]s
+				AND prev.%[3]s > 0)
+		WHERE cur.stats_date = $1`, table, idColumn, valueColumn)
+	_, err := db.exec(ctx, dbQuery, date, previous)
+	if err != nil {
+		log.Printf("Updating growth failed: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// Watermark returns the end of the last fully processed time period for a metric family.  The returned bool is false
+// when nothing has been recorded for the family yet
+func (db *DB) Watermark(ctx context.Context, family string) (time.Time, bool, error) {
+	dbQuery := `
+		SELECT last_processed
+		FROM stats_processing_state
+		WHERE metric_family = $1`
+	var lastProcessed pgtype.Timestamp
+	err := db.queryRow(ctx, dbQuery, family).Scan(&lastProcessed)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Database query failed: %v\n", err)
+		return time.Time{}, false, err
+	}
+	return lastProcessed.Time, lastProcessed.Valid, nil
+}
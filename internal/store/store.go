@@ -0,0 +1,519 @@
+// Package store holds the PostgreSQL queries used for reading the download logs, and for saving the generated stats
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	pgpool "github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// Store is the storage the stats are generated from and saved to.  DB is the PostgreSQL implementation, with
+// memstore.Store being an in-memory one for tests
+type Store interface {
+	// CheckQuality counts the suspicious rows of the download logs in the given date range
+	CheckQuality(ctx context.Context, startDate time.Time, endDate time.Time) (QualityReport, error)
+
+	// CountMatchingLogs returns the number of download log rows matching a declared metric's SQL filter in the given
+	// date range, keyed by the value of its group by expression
+	CountMatchingLogs(ctx context.Context, filter, groupBy string, startDate time.Time, endDate time.Time) (map[string]int64, error)
+
+	// Filters returns the filters deciding which download log rows are counted in the stats
+	Filters() *Filters
+
+	// DatedReleases returns the release date of each entry in the db4s_release_info table with one, keyed by release ID
+	DatedReleases(ctx context.Context) (map[int]time.Time, error)
+
+	// GetBandwidth returns the number of bytes sent for the DB4S downloads in the given date range, plus a breakdown
+	// per download.  The breakdown is nil when bandwidth tracking isn't enabled
+	GetBandwidth(ctx context.Context, startDate time.Time, endDate time.Time) (bytes int64, bytesPerVersion map[int]int64, err error)
+
+	// GetCheckIns returns the number of IP address days in the given date range in each bucket of the daily number of
+	// version checks.  This is nil when the check-in frequency stats aren't enabled
+	GetCheckIns(ctx context.Context, startDate time.Time, endDate time.Time) (map[string]int, error)
+
+	// GetChannelDownloads returns the downloads (installs plus updates) from each distribution channel in the
+	// db4s_channel_downloads table, for the days in the given date range.  This is nil when the channel rollup isn't
+	// enabled
+	GetChannelDownloads(ctx context.Context, startDate time.Time, endDate time.Time) (map[string]int64, error)
+
+	// GetDownloads returns the total number of DB4S downloads in the given date range, plus a breakdown per download
+	GetDownloads(ctx context.Context, startDate time.Time, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error)
+
+	// GetEstimatedUsers returns the estimated number of DB4S users in the given date range, plus a breakdown per user
+	// agent.  The breakdown is nil when the users aren't estimated
+	GetEstimatedUsers(ctx context.Context, startDate time.Time, endDate time.Time) (users int, userAgentUsers map[string]int, err error)
+
+	// GetExcludedTraffic returns the number of version checks and download requests from the excluded networks in the
+	// given date range.  This is nil when no networks are excluded
+	GetExcludedTraffic(ctx context.Context, startDate time.Time, endDate time.Time) (*ExcludedTraffic, error)
+
+	// GetFailedDownloads returns the number of failed (403, 404 and 5xx) requests for each download artifact and status
+	// code in the given date range.  This is nil when the failed downloads stats aren't enabled
+	GetFailedDownloads(ctx context.Context, startDate time.Time, endDate time.Time) ([]FailedDownload, error)
+
+	// GetHeavyHitters returns the number of IP addresses making more than the allowed number of version checks or
+	// download requests on any day of the given date range, along with the number they made.  This is nil when
+	// neither limit is set
+	GetHeavyHitters(ctx context.Context, startDate time.Time, endDate time.Time) (*HeavyHitters, error)
+
+	// GetIPs returns the number of unique IP addresses doing a version check in the given date range, plus a
+	// breakdown per user agent
+	GetIPs(ctx context.Context, startDate time.Time, endDate time.Time) (IPs int, userAgentIPs map[string]int, err error)
+
+	// GetOSUsers returns the number of unique IP addresses doing a version check in the given date range for each OS.
+	// This is nil when the per OS users stats aren't enabled
+	GetOSUsers(ctx context.Context, startDate time.Time, endDate time.Time) (map[string]int, error)
+
+	// GetReferrers returns the top referrers for each DB4S download in the given date range, plus the top referrers
+	// across all downloads under download ID 0.  This is nil when referrer tracking isn't enabled
+	GetReferrers(ctx context.Context, startDate time.Time, endDate time.Time) ([]Referrer, error)
+
+	// GetUniqueDownloads returns the number of unique DB4S downloads in the given date range, plus a breakdown per
+	// download.  Each IP address downloading a file is only counted once per day.  The breakdown is nil when the unique
+	// downloads stats aren't enabled
+	GetUniqueDownloads(ctx context.Context, startDate time.Time, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error)
+
+	// GetUpdatePending returns the number of unique IP addresses doing a version check from an out of date release in
+	// the given date range, plus a breakdown per user agent.  The breakdown is nil when the update pending stats aren't
+	// enabled
+	GetUpdatePending(ctx context.Context, startDate time.Time, endDate time.Time) (pending int, userAgentPending map[string]int, err error)
+
+	// LogsFingerprint returns the fingerprint of the download logs in the given date range
+	LogsFingerprint(ctx context.Context, startDate time.Time, endDate time.Time) (Fingerprint, error)
+
+	// PeriodFingerprint returns the fingerprint of the download logs saved when a time period of a metric family was
+	// last processed, if there is one
+	PeriodFingerprint(ctx context.Context, family string, date time.Time) (Fingerprint, bool, error)
+
+	// ReleaseIDs returns the release ID for each version number in the db4s_release_info table
+	ReleaseIDs(ctx context.Context) (map[string]int, error)
+
+	// SaveBandwidth sets the bytes sent of the already saved rows of a downloads stats table
+	SaveBandwidth(ctx context.Context, table string, date time.Time, bytes int64, bytesPerVersion map[int]int64) error
+
+	SaveDailyDownloadsStats(ctx context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error
+	SaveDailyUsersStats(ctx context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error
+	SaveMonthlyDownloadsStats(ctx context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error
+	SaveMonthlyUsersStats(ctx context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error
+	SaveWeeklyDownloadsStats(ctx context.Context, date time.Time, count int32, DLsPerVersion map[int]int32) error
+	SaveWeeklyUsersStats(ctx context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error
+
+	// SaveChannelRollup saves the downloads from each distribution channel for a metric family for the time period
+	// starting at the given date, replacing any saved earlier for it.  The grand total across the channels is saved too
+	SaveChannelRollup(ctx context.Context, family string, date time.Time, perChannel map[string]int64) error
+
+	// SaveCheckIns saves the check-in frequency distribution of a metric family for the time period starting at the
+	// given date, replacing any saved earlier for it
+	SaveCheckIns(ctx context.Context, family string, date time.Time, buckets map[string]int) error
+
+	// SaveEstimatedUsers sets the estimated users of the already saved rows of a users stats table
+	SaveEstimatedUsers(ctx context.Context, table string, date time.Time, users int, userAgentUsers map[string]int) error
+
+	// SaveExcludedTraffic saves the number of requests from the excluded networks for a metric family for the time
+	// period starting at the given date
+	SaveExcludedTraffic(ctx context.Context, family string, date time.Time, requests int64) error
+
+	// SaveFailedDownloads saves the failed download counts of a metric family for the time period starting at the
+	// given date, replacing any saved earlier for it
+	SaveFailedDownloads(ctx context.Context, family string, date time.Time, failed []FailedDownload) error
+
+	// SaveFingerprint records the fingerprint of the download logs a time period of a metric family was processed from
+	SaveFingerprint(ctx context.Context, family string, date time.Time, fp Fingerprint) error
+
+	// SaveForecast saves the forecast total of a metric family for the time period starting at the given date
+	SaveForecast(ctx context.Context, family string, date time.Time, predicted int64, method string) error
+
+	// SaveHeavyHitters saves the number of IP addresses left out of a metric family for the time period starting at
+	// the given date for making too many requests, along with the number of requests they made
+	SaveHeavyHitters(ctx context.Context, family string, date time.Time, IPs, requests int64) error
+
+	// SaveMetricCounts saves the counts of a declared metric for a metric family for the time period starting at the
+	// given date, replacing any saved earlier for it
+	SaveMetricCounts(ctx context.Context, table, family string, date time.Time, counts map[string]int64) error
+
+	// SaveOSUsers saves the unique IP addresses per OS of a metric family for the time period starting at the given
+	// date, replacing any saved earlier for it
+	SaveOSUsers(ctx context.Context, family string, date time.Time, perOS map[string]int) error
+
+	// SaveQualityReport saves a data quality report in the stats_quality_reports table
+	SaveQualityReport(ctx context.Context, report QualityReport) error
+
+	// SaveReferrers saves the top referrers of a metric family for the time period starting at the given date,
+	// replacing any saved earlier for it
+	SaveReferrers(ctx context.Context, family string, date time.Time, referrers []Referrer) error
+
+	// SaveReleaseImpact saves the totals of a daily metric family before and after a release date, replacing any
+	// saved earlier for it
+	SaveReleaseImpact(ctx context.Context, impact ReleaseImpact) error
+
+	// SaveRollingAverages saves the rolling averages of a daily metric family for the given date, keyed by the number
+	// of days in the window, replacing any saved earlier for it
+	SaveRollingAverages(ctx context.Context, family string, date time.Time, averages map[int]float64) error
+
+	// SaveUniqueDownloads sets the unique downloads counts of the already saved rows of a downloads stats table
+	SaveUniqueDownloads(ctx context.Context, table string, date time.Time, count int32, DLsPerVersion map[int]int32) error
+
+	// SaveUpdatePending sets the update pending percentage of the already saved rows of a users stats table
+	SaveUpdatePending(ctx context.Context, table string, date time.Time, pending int, userAgentPending map[string]int) error
+
+	// SaveWatermark records the end of the last fully processed time period for a metric family
+	SaveWatermark(ctx context.Context, family string, processedUntil time.Time) error
+
+	// StatsDates returns the dates in a stats table which have a totals row saved for them, from the given date onwards
+	StatsDates(ctx context.Context, table, totalColumn string, totalID int, from time.Time) (map[time.Time]struct{}, error)
+
+	// StatsRange returns the saved stats of a stats table for the dates from the start date up to (but not including)
+	// the end date, keyed by stats date then release or download ID
+	StatsRange(ctx context.Context, table, idColumn, valueColumn string, startDate, endDate time.Time) (map[time.Time]map[int]int64, error)
+
+	// StoredStats returns the saved stats of a stats table for the given date, keyed by release or download ID
+	StoredStats(ctx context.Context, table, idColumn, valueColumn string, date time.Time) (map[int]int64, error)
+
+	// UpdateDownloads ensures there's a db4s_download_info entry for each new request path in the download logs
+	// matching the auto add pattern, then adds all the automatically added entries to the downloads list.  Lastly
+	// the request paths of the mapped downloads are replaced with the ones their mappings match
+	UpdateDownloads(ctx context.Context) error
+
+	// UpdateGrowth sets the percentage change of each row of a stats table for the given date, compared to the
+	// previous date
+	UpdateGrowth(ctx context.Context, table, idColumn, valueColumn string, date, previous time.Time) error
+
+	// UpdateUserAgents ensures there's a db4s_release_info entry for each user agent present in the download logs
+	UpdateUserAgents(ctx context.Context) error
+
+	// Watermark returns the end of the last fully processed time period for a metric family, if there is one
+	Watermark(ctx context.Context, family string) (time.Time, bool, error)
+}
+
+var _ Store = (*DB)(nil)
+
+// DB is a connection pool to the PostgreSQL database holding the download logs and stats tables
+type DB struct {
+	// How much logging output to give
+	Verbosity verbosity.Level
+
+	clickHouse     *clickHouse // ClickHouse copy of the download logs, if there is one
+	bandwidth      bool        // Generate the bandwidth stats
+	bulkLoad       bool        // Save the users and downloads stats rows with COPY, rather than one at a time
+	bots           *BotFilter  // Excludes the version checks made by bots, if set
+	channelRollup  bool        // Generate the downloads by channel rollup
+	checkIns       bool        // Generate the check-in frequency stats
+	checksPerUser  int         // Estimate the users behind each IP address, when not zero
+	creds          *credentialCache
+	excluded       []netip.Prefix // Networks whose requests are left out of the users and downloads stats
+	failed         bool           // Generate the failed downloads stats
+	filters        *Filters       // Which download log rows are counted
+	forwardedFor   string         // The download_log column holding the X-Forwarded-For header, if it's used
+	foldWindow     time.Duration  // Fold the 200 and 206 requests for a file into downloads, when not zero
+	ipv6Prefix     bool           // Count the IPv6 addresses in the same /64 as one in the users stats
+	maxDownloads   int            // Leave out the IP addresses making more download requests a day, when not zero
+	newDownloads   *regexp.Regexp // Request paths of new release artifacts to add downloads for, if set
+	partitions     *partitions    // The download_log partitions read from directly, if set
+	perOS          bool           // Generate the per OS users stats
+	pool           *pgpool.Pool
+	readPool       *pgpool.Pool // Read replica for the download logs, if there is one
+	queryTimeout   time.Duration
+	tablePrefix    string            // Prefix of the stats tables of another product, if set
+	tables         *strings.Replacer // Renames the stats tables as per the table prefix, if set
+	timescale      bool
+	trustedProxies []netip.Prefix // The reverse proxies whose X-Forwarded-For headers are used
+	topReferrers   int            // The number of top referrers kept for each download, no referrer stats when zero
+	uniqueDLs      bool           // Generate the unique downloads stats
+	updatePending  bool           // Generate the update pending stats
+}
+
+// Open connects to the PostgreSQL database.  Every query run through the returned DB is bounded by queryTimeout, in
+// addition to any deadline on the context passed to it
+func Open(ctx context.Context, pg config.PGInfo, queryTimeout time.Duration) (*DB, error) {
+	return OpenWithCredentials(ctx, pg, queryTimeout, nil)
+}
+
+// OpenWithCredentials connects to the PostgreSQL database as per Open, but with the username and password returned by
+// creds (when not nil) instead of the ones in the config.  If the database rejects them, they're fetched again and the
+// query retried, so credentials rotated during a run are picked up
+func OpenWithCredentials(ctx context.Context, pg config.PGInfo, queryTimeout time.Duration, creds CredentialsFunc) (*DB, error) {
+	db := &DB{filters: NewFilters(), queryTimeout: queryTimeout}
+	if creds != nil {
+		db.creds = &credentialCache{fetch: creds}
+	}
+	pgConfig, err := db.poolConfig(pg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect to database
+	db.pool, err = pgpool.NewWithConfig(ctx, pgConfig)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// UseReplica connects to a read replica (eg a streaming replica), which is then used for the heavy queries reading the
+// download logs.  Everything else, including reading back the saved stats, still goes to the primary so it's never
+// affected by replication lag.  Credentials from a secret store are shared with the primary
+func (db *DB) UseReplica(ctx context.Context, pg config.PGInfo) error {
+	pgConfig, err := db.poolConfig(pg)
+	if err != nil {
+		return err
+	}
+	db.readPool, err = pgpool.NewWithConfig(ctx, pgConfig)
+	return err
+}
+
+// poolConfig returns the connection pool configuration for the given config values
+func (db *DB) poolConfig(pg config.PGInfo) (*pgpool.Config, error) {
+	// Prepare TLS configuration
+	tlsConfig := tls.Config{}
+	if pg.SSL {
+		tlsConfig.ServerName = pg.Server
+		tlsConfig.InsecureSkipVerify = false
+
+		// Verify the server against a custom CA bundle, and authenticate with a client certificate, when given
+		if pg.SSLRootCert != "" {
+			caCerts, err := os.ReadFile(pg.SSLRootCert)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA bundle failed: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCerts) {
+				return nil, fmt.Errorf("no certificates found in CA bundle '%v'", pg.SSLRootCert)
+			}
+		}
+		if pg.SSLCert != "" || pg.SSLKey != "" {
+			clientCert, err := tls.LoadX509KeyPair(pg.SSLCert, pg.SSLKey)
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate failed: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{clientCert}
+		}
+	} else {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	// Set the main PostgreSQL database configuration values
+	pgConfig, err := pgpool.ParseConfig(connString(pg))
+	if err != nil {
+		return nil, err
+	}
+
+	// Identify our connections to the DBAs, and have the server bound our queries too when asked
+	appName := pg.ApplicationName
+	if appName == "" {
+		appName = "db4s_stats_gen"
+	}
+	pgConfig.ConnConfig.RuntimeParams["application_name"] = appName
+	if pg.StatementTimeout > 0 {
+		pgConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.Itoa(pg.StatementTimeout * 1000)
+	}
+
+	// PgBouncer in transaction pooling mode can give each statement a different server connection, so prepared
+	// statements (including the ones pgx caches automatically) can't be used
+	if pg.PgBouncer {
+		pgConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	}
+
+	// Pool tuning, where given.  Otherwise the pgxpool defaults are used
+	if pg.MinConnections > 0 {
+		pgConfig.MinConns = int32(pg.MinConnections)
+	}
+	if pg.MaxConnLifetime > 0 {
+		pgConfig.MaxConnLifetime = time.Duration(pg.MaxConnLifetime) * time.Second
+	}
+	if pg.MaxConnIdleTime > 0 {
+		pgConfig.MaxConnIdleTime = time.Duration(pg.MaxConnIdleTime) * time.Second
+	}
+	if pg.HealthCheckPeriod > 0 {
+		pgConfig.HealthCheckPeriod = time.Duration(pg.HealthCheckPeriod) * time.Second
+	}
+
+	// Enable encrypted connections where needed.  The default sslmode ("prefer") would otherwise fall back to an
+	// unencrypted connection if the TLS one fails
+	if pg.SSL {
+		if tlsConfig.ServerName == "" {
+			// The server was given by a service file entry
+			tlsConfig.ServerName = pgConfig.ConnConfig.Host
+		}
+		pgConfig.ConnConfig.TLSConfig = &tlsConfig
+		pgConfig.ConnConfig.Fallbacks = nil
+	}
+
+	// Log the queries with their durations, when asked to or when tracing
+	pgConfig.ConnConfig.Tracer = queryTracer{db: db, all: pg.LogQueries}
+
+	// Fill in the credentials for each new connection, when they come from elsewhere
+	if db.creds != nil {
+		pgConfig.BeforeConnect = func(ctx context.Context, connConfig *pgx.ConnConfig) error {
+			username, password, err := db.creds.get(ctx)
+			if err != nil {
+				return err
+			}
+			connConfig.User, connConfig.Password = username, password
+			return nil
+		}
+	}
+	return pgConfig, nil
+}
+
+// connString returns the connection string for the config values which are set.  Unset ones are left out, so they
+// fall back to the service file entry (when one is given), then the libpq defaults.  eg the password is then looked up
+// in ~/.pgpass, or isn't needed when using a client certificate
+func connString(pg config.PGInfo) string {
+	values := []struct {
+		key   string
+		value string
+	}{
+		{"host", pg.Server},
+		{"port", strconv.Itoa(int(uint16(pg.Port)))},
+		{"user", pg.Username},
+		{"password", pg.Password},
+		{"dbname", pg.Database},
+		{"pool_max_conns", strconv.Itoa(pg.NumConnections)},
+		{"service", pg.Service},
+		{"servicefile", pg.ServiceFile},
+		{"passfile", pg.PassFile},
+	}
+	var parts []string
+	for _, v := range values {
+		if v.value == "" || v.value == "0" {
+			continue
+		}
+		quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v.value)
+		parts = append(parts, fmt.Sprintf("%s='%s'", v.key, quoted))
+	}
+	return strings.Join(append(parts, "connect_timeout=10"), " ")
+}
+
+// Close closes the database connection pools
+func (db *DB) Close() {
+	db.pool.Close()
+	if db.readPool != nil {
+		db.readPool.Close()
+	}
+}
+
+// checkTimeout logs a clear message when a database operation failed due to the per query timeout or the deadline of
+// the surrounding context (eg the overall run deadline).  The error is returned unchanged
+func (db *DB) checkTimeout(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if ctx.Err() != nil {
+		log.Println("Run deadline exceeded, aborting")
+	} else {
+		log.Printf("Database query timed out after %v\n", db.queryTimeout)
+	}
+	return err
+}
+
+// retryAuth returns whether a failed database operation should be retried, as the credentials were rejected and will
+// be fetched again for the next connection
+func (db *DB) retryAuth(err error) bool {
+	if db.creds == nil || !isAuthFailure(err) {
+		return false
+	}
+	log.Println("Database credentials rejected, fetching them again")
+	db.creds.invalidate()
+	return true
+}
+
+// exec runs a database statement, bounded by the per query timeout
+func (db *DB) exec(ctx context.Context, dbQuery string, args ...any) (pgconn.CommandTag, error) {
+	dbQuery = db.withTables(dbQuery)
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+	commandTag, err := db.pool.Exec(queryCtx, dbQuery, args...)
+	if db.retryAuth(err) {
+		commandTag, err = db.pool.Exec(queryCtx, dbQuery, args...)
+	}
+	return commandTag, db.checkTimeout(ctx, err)
+}
+
+// query runs a database query returning rows, bounded by the per query timeout.  The returned cancel function must be
+// called once the rows are no longer needed
+func (db *DB) query(ctx context.Context, dbQuery string, args ...any) (pgx.Rows, context.CancelFunc, error) {
+	return db.queryPool(ctx, db.pool, dbQuery, args...)
+}
+
+// queryLogs runs a database query reading the download logs as per query, using the read replica if there is one
+func (db *DB) queryLogs(ctx context.Context, dbQuery string, args ...any) (pgx.Rows, context.CancelFunc, error) {
+	return db.queryPool(ctx, db.logsPool(), dbQuery, args...)
+}
+
+func (db *DB) queryPool(ctx context.Context, pool *pgpool.Pool, dbQuery string, args ...any) (pgx.Rows, context.CancelFunc, error) {
+	dbQuery = db.withTables(dbQuery)
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	rows, err := pool.Query(queryCtx, dbQuery, args...)
+	if db.retryAuth(err) {
+		rows, err = pool.Query(queryCtx, dbQuery, args...)
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, db.checkTimeout(ctx, err)
+	}
+	return rows, cancel, nil
+}
+
+// queryRow runs a database query returning a single row, bounded by the per query timeout
+func (db *DB) queryRow(ctx context.Context, dbQuery string, args ...any) pgx.Row {
+	return db.queryRowPool(ctx, db.pool, dbQuery, args...)
+}
+
+// queryLogsRow runs a database query reading the download logs as per queryRow, using the read replica if there is
+// one
+func (db *DB) queryLogsRow(ctx context.Context, dbQuery string, args ...any) pgx.Row {
+	return db.queryRowPool(ctx, db.logsPool(), dbQuery, args...)
+}
+
+// queryRowPool returns a row whose query is run when it's scanned, as that's when any error (eg rejected credentials)
+// comes back
+func (db *DB) queryRowPool(ctx context.Context, pool *pgpool.Pool, dbQuery string, args ...any) pgx.Row {
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	return timedRow{db: db, pool: pool, ctx: ctx, queryCtx: queryCtx, cancel: cancel, query: db.withTables(dbQuery),
+		args: args}
+}
+
+// logsPool returns the connection pool for reading the download logs
+func (db *DB) logsPool() *pgpool.Pool {
+	if db.readPool != nil {
+		return db.readPool
+	}
+	return db.pool
+}
+
+// timedRow is a database row whose query timeout is released once the row has been scanned.  As with exec and
+// queryPool, the query is run again if the credentials were rejected
+type timedRow struct {
+	db       *DB
+	pool     *pgpool.Pool
+	ctx      context.Context
+	queryCtx context.Context
+	cancel   context.CancelFunc
+	query    string
+	args     []any
+}
+
+func (r timedRow) Scan(dest ...any) error {
+	defer r.cancel()
+	err := r.pool.QueryRow(r.queryCtx, r.query, r.args...).Scan(dest...)
+	if r.db.retryAuth(err) {
+		err = r.pool.QueryRow(r.queryCtx, r.query, r.args...).Scan(dest...)
+	}
+	return r.db.checkTimeout(r.ctx, err)
+}
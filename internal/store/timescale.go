@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// When download_log is a TimescaleDB hypertable, the download counts are taken from a continuous aggregate holding
+// the number of successful requests for each path per day, instead of counting the raw log rows each time.  The
+// unique IP counts still need the raw rows, as distinct counts can't be added together across days.
+//
+// NOTE: The aggregate's daily buckets include requests made at exactly midnight, which the raw log queries (with
+//       their exclusive start times) leave out.  So the counts can differ very slightly between the two modes
+
+// UseTimescale switches the download counts over to the download_log_daily continuous aggregate
+func (db *DB) UseTimescale() {
+	db.timescale = true
+}
+
+// InitTimescale creates the download_log_daily continuous aggregate, if it doesn't exist already.  The download_log
+// table must already be a TimescaleDB hypertable
+func (db *DB) InitTimescale(ctx context.Context) error {
+	dbQuery := `
+		CREATE MATERIALIZED VIEW IF NOT EXISTS download_log_daily
+		WITH (timescaledb.continuous) AS
+			SELECT time_bucket('1 day', request_time) AS bucket, request, count(*) AS downloads
+			FROM download_log
+			WHERE status = 200
+			GROUP BY bucket, request
+		WITH NO DATA`
+	_, err := db.exec(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Creating continuous aggregate failed: %v\n", err)
+	}
+	return err
+}
+
+// timescaleDownloads returns the download counts for the given date range from the continuous aggregate, refreshing
+// the range first so it includes the latest log rows.  The dates must be on day boundaries, so a download mapping
+// active from part way through a day only applies to that day's aggregated requests from the following day
+func (db *DB) timescaleDownloads(ctx context.Context, startDate, endDate time.Time) (DLs int32, DLsPerVersion map[int]int32, err error) {
+	// Refreshing only recalculates the buckets which have changed since the last refresh, so it's cheap for ranges
+	// already processed
+	_, err = db.exec(ctx, `CALL refresh_continuous_aggregate('download_log_daily', $1::timestamptz, $2::timestamptz)`,
+		startDate, endDate)
+	if err != nil {
+		log.Printf("Refreshing continuous aggregate failed: %v\n", err)
+		return
+	}
+
+	dbQuery := `
+		SELECT request, sum(downloads)
+		FROM download_log_daily
+		WHERE bucket >= $1
+			AND bucket < $2
+			AND request = ANY($3)
+		GROUP BY request`
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, startDate, endDate, db.filters.DownloadRequests())
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	perRequest := make(map[string]int32)
+	for rows.Next() {
+		var request string
+		var count pgtype.Int8
+		err = rows.Scan(&request, &count)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		perRequest[request] = int32(count.Int64)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+
+	DLs, DLsPerVersion = db.filters.downloadCounts(perRequest, startDate)
+	return
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// maxLoggedArg is the length logged queries' string parameters are cut down to
+const maxLoggedArg = 64
+
+// queryTracer logs the SQL queries run, with their duration and the number of rows returned or affected.  This is done
+// for every query when log_queries is set for the connection pool, or the verbosity is Trace.  It's added to every
+// connection, checking the verbosity as each query starts, as the verbosity is set after connecting
+type queryTracer struct {
+	db  *DB
+	all bool // Log every query, regardless of the verbosity
+}
+
+// traceStartKey is the context key for the details of a traced query
+type traceStartKey struct{}
+
+// traceStart holds the details of a traced query, from when it started
+type traceStart struct {
+	sql     string
+	args    []any
+	started time.Time
+}
+
+// enabled returns whether queries are being logged
+func (t queryTracer) enabled() bool {
+	return t.all || t.db.Verbosity >= verbosity.Trace
+}
+
+// TraceQueryStart notes the start time of a query, when it's being traced
+func (t queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !t.enabled() {
+		return ctx
+	}
+	return context.WithValue(ctx, traceStartKey{}, traceStart{sql: data.SQL, args: data.Args, started: time.Now()})
+}
+
+// TraceQueryEnd logs a traced query.  For queries returning rows, this is once the rows are closed
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(traceStartKey{}).(traceStart)
+	if !ok {
+		return
+	}
+	logQuery(start, data.CommandTag.RowsAffected(), data.Err)
+}
+
+// TraceCopyFromStart notes the start time of a COPY into a table, when it's being traced
+func (t queryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	if !t.enabled() {
+		return ctx
+	}
+	sql := fmt.Sprintf("COPY %v (%v) FROM STDIN", data.TableName.Sanitize(), strings.Join(data.ColumnNames, ", "))
+	return context.WithValue(ctx, traceStartKey{}, traceStart{sql: sql, started: time.Now()})
+}
+
+// TraceCopyFromEnd logs a traced COPY into a table
+func (t queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	start, ok := ctx.Value(traceStartKey{}).(traceStart)
+	if !ok {
+		return
+	}
+	logQuery(start, data.CommandTag.RowsAffected(), data.Err)
+}
+
+// logQuery logs a traced query with its duration, rows returned or affected, and (redacted) parameters
+func logQuery(start traceStart, rows int64, err error) {
+	status := fmt.Sprintf("%d rows", rows)
+	if err != nil {
+		status = err.Error()
+	}
+	msg := fmt.Sprintf("Query took %v (%v): %v", time.Since(start.started).Round(time.Millisecond), status,
+		compactSQL(start.sql))
+	if len(start.args) > 0 {
+		args := make([]string, len(start.args))
+		for i, arg := range start.args {
+			args[i] = fmt.Sprintf("$%d=%v", i+1, redactArg(arg))
+		}
+		msg += " [" + strings.Join(args, " ") + "]"
+	}
+	log.Println(msg)
+}
+
+// redactArg returns how a query parameter is logged.  IP addresses are masked, as they're personal data, lists are
+// given as the number of values (eg the known download paths, which would swamp the log), and long strings are cut
+// short
+func redactArg(arg any) string {
+	switch a := arg.(type) {
+	case nil:
+		return "NULL"
+	case *time.Time:
+		if a == nil {
+			return "NULL"
+		}
+		return a.UTC().Format(time.RFC3339)
+	case time.Time:
+		return a.UTC().Format(time.RFC3339)
+	case string:
+		if _, err := netip.ParseAddr(a); err == nil {
+			return "<ip>"
+		}
+		if _, err := netip.ParsePrefix(a); err == nil {
+			return "<network>"
+		}
+		if len(a) > maxLoggedArg {
+			a = a[:maxLoggedArg] + "..."
+		}
+		return fmt.Sprintf("%q", a)
+	case []byte:
+		return fmt.Sprintf("<%d bytes>", len(a))
+	}
+	if v := reflect.ValueOf(arg); v.Kind() == reflect.Slice {
+		return fmt.Sprintf("[%d values]", v.Len())
+	}
+	return fmt.Sprintf("%v", arg)
+}
+
+// compactSQL returns a query with its whitespace collapsed, so it's logged on a single line
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
@@ -0,0 +1,236 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The DB4S user agent is "sqlitebrowser <version>", but some builds add extra tokens after the version.  eg
+// "sqlitebrowser 3.13.0 (Windows 10; x86_64; nightly; de_DE)".  The stats are kept per version, so these are
+// normalised to just "sqlitebrowser <version>" when counting, with the extra tokens used for the per OS breakdowns
+
+// userAgentPrefix returns the prefix a user agent starts with, if it's a version check one
+func (f *Filters) userAgentPrefix(userAgent string) (string, bool) {
+	for _, p := range f.uaPrefixes {
+		if strings.HasPrefix(userAgent, p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// isVersionCheckUserAgent returns whether a user agent is one of a version check, as per the user agent prefixes and
+// exclusions
+func (f *Filters) isVersionCheckUserAgent(userAgent string) bool {
+	if _, ok := f.userAgentPrefix(userAgent); !ok {
+		return false
+	}
+	for _, e := range f.uaExcludes {
+		if strings.Contains(userAgent, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// pgUserAgents returns the PostgreSQL condition matching the user agents of version checks, as per
+// isVersionCheckUserAgent
+func (f *Filters) pgUserAgents() string {
+	quote := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `'`, `''`)
+	var prefixes []string
+	for _, p := range f.uaPrefixes {
+		prefixes = append(prefixes, "http_user_agent LIKE '"+quote.Replace(p)+"%'")
+	}
+	cond := prefixes[0]
+	if len(prefixes) > 1 {
+		cond = "(" + strings.Join(prefixes, " OR ") + ")"
+	}
+	for _, e := range f.uaExcludes {
+		cond += " AND http_user_agent NOT LIKE '%" + quote.Replace(e) + "%'"
+	}
+	return cond
+}
+
+// clickHouseString returns a string as a ClickHouse string literal
+func clickHouseString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// clickHouseUserAgents returns the ClickHouse condition matching the user agents of version checks, as per
+// isVersionCheckUserAgent
+func (f *Filters) clickHouseUserAgents() string {
+	var prefixes []string
+	for _, p := range f.uaPrefixes {
+		prefixes = append(prefixes, "startsWith(http_user_agent, "+clickHouseString(p)+")")
+	}
+	cond := prefixes[0]
+	if len(prefixes) > 1 {
+		cond = "(" + strings.Join(prefixes, " OR ") + ")"
+	}
+	for _, e := range f.uaExcludes {
+		cond += " AND position(http_user_agent, " + clickHouseString(e) + ") = 0"
+	}
+	return cond
+}
+
+// clickHouseUserAgent returns the ClickHouse expression normalising a user agent, as per NormalizeUserAgent
+func (f *Filters) clickHouseUserAgent() string {
+	expr := "http_user_agent"
+	for i := len(f.uaPrefixes) - 1; i >= 0; i-- {
+		p := clickHouseString(f.uaPrefixes[i])
+		expr = fmt.Sprintf("if(startsWith(http_user_agent, %[1]s), concat(%[1]s, splitByWhitespace(substring(http_user_agent, length(%[1]s) + 1))[1]), %[2]s)", p, expr)
+	}
+	return expr
+}
+
+// UserAgent is a parsed DB4S user agent.  The fields other than the version are empty when not given
+type UserAgent struct {
+	Version string
+	OS      string
+	Arch    string
+	Channel string
+	Locale  string
+}
+
+// OSUnknown is the OS the version checks from user agents without one are counted under
+const OSUnknown = "unknown"
+
+// localeFormat matches a locale token.  eg "en", "de_DE" or "pt-BR"
+var localeFormat = regexp.MustCompile(`^[a-z]{2,3}([_-][A-Za-z]{2})?$`)
+
+// ParseUserAgent parses a DB4S user agent.  The version is the first token after the prefix (eg "sqlitebrowser "),
+// and the rest are either in brackets separated by semicolons, or separated by spaces
+func (f *Filters) ParseUserAgent(userAgent string) (ua UserAgent) {
+	prefix, _ := f.userAgentPrefix(userAgent)
+	rest := strings.TrimSpace(strings.TrimPrefix(userAgent, prefix))
+	ua.Version, rest, _ = strings.Cut(rest, " ")
+	var tokens []string
+	if strings.HasPrefix(strings.TrimSpace(rest), "(") {
+		tokens = strings.Split(strings.Trim(strings.TrimSpace(rest), "()"), ";")
+	} else {
+		tokens = strings.Fields(rest)
+	}
+	for _, t := range tokens {
+		t = strings.TrimSpace(t)
+		lower := strings.ToLower(t)
+		switch {
+		case t == "":
+		case ua.OS == "" && osName(lower) != "":
+			ua.OS = osName(lower)
+		case ua.Arch == "" && archName(lower) != "":
+			ua.Arch = archName(lower)
+		case ua.Channel == "" && slices.Contains([]string{"alpha", "beta", "nightly", "rc", "release"}, lower):
+			ua.Channel = lower
+		case ua.Locale == "" && localeFormat.MatchString(t):
+			ua.Locale = t
+		}
+	}
+	return
+}
+
+// osName returns the OS named by a (lower case) user agent token, or an empty string if it doesn't name one
+func osName(token string) string {
+	switch {
+	case strings.Contains(token, "windows"):
+		return "Windows"
+	case strings.Contains(token, "macos"), strings.Contains(token, "mac os"), strings.Contains(token, "darwin"),
+		strings.Contains(token, "osx"):
+		return "macOS"
+	case strings.Contains(token, "linux"), strings.Contains(token, "ubuntu"), strings.Contains(token, "debian"),
+		strings.Contains(token, "fedora"):
+		return "Linux"
+	case strings.Contains(token, "bsd"):
+		return "BSD"
+	}
+	return ""
+}
+
+// archName returns the architecture named by a (lower case) user agent token, or an empty string if it doesn't name
+// one
+func archName(token string) string {
+	switch token {
+	case "x86_64", "x86.64", "amd64", "x64", "win64":
+		return "x86_64"
+	case "arm64", "aarch64":
+		return "arm64"
+	case "x86", "i386", "i686", "win32":
+		return "x86"
+	}
+	return ""
+}
+
+// NormalizeUserAgent returns the user agent with any extra tokens after the version removed.  eg "sqlitebrowser 3.13.0"
+// for "sqlitebrowser 3.13.0 (Windows 10; x86_64)"
+func (f *Filters) NormalizeUserAgent(userAgent string) string {
+	prefix, _ := f.userAgentPrefix(userAgent)
+	return prefix + f.ParseUserAgent(userAgent).Version
+}
+
+// UserAgentVersion returns the version number of a user agent, as per the db4s_release_info table
+func (f *Filters) UserAgentVersion(userAgent string) string {
+	return f.ParseUserAgent(userAgent).Version
+}
+
+// UserAgentOS returns the OS of a user agent, or OSUnknown when it doesn't give one
+func (f *Filters) UserAgentOS(userAgent string) string {
+	if os := f.ParseUserAgent(userAgent).OS; os != "" {
+		return os
+	}
+	return OSUnknown
+}
+
+// TrackOS enables the per OS users stats.  The version checks are counted per OS as they stream in from PostgreSQL, so
+// this isn't supported when reading the download logs from ClickHouse
+func (db *DB) TrackOS() error {
+	if db.clickHouse != nil {
+		return errors.New("the per OS users stats aren't supported when reading the download logs from ClickHouse")
+	}
+	db.perOS = true
+	return nil
+}
+
+// GetOSUsers returns the number of unique IP addresses doing a version check in the given date range for each OS.
+// This is nil when the per OS users stats aren't enabled
+func (db *DB) GetOSUsers(ctx context.Context, startDate time.Time, endDate time.Time) (map[string]int, error) {
+	if !db.perOS {
+		return nil, nil
+	}
+	counter := NewUserCounter(db.filters.UserAgentOS, db.bots, 0)
+	counter, err := db.countUsers(ctx, startDate, endDate, counter)
+	if err != nil {
+		return nil, err
+	}
+	_, perOS, _ := counter.Counts()
+	return perOS, nil
+}
+
+// SaveOSUsers saves the unique IP addresses per OS of a metric family for the time period starting at the given date,
+// replacing any saved earlier for it
+func (db *DB) SaveOSUsers(ctx context.Context, family string, date time.Time, perOS map[string]int) error {
+	dbQuery := `
+		DELETE FROM db4s_users_by_os
+		WHERE metric_family = $1
+			AND stats_date = $2`
+	_, err := db.exec(ctx, dbQuery, family, date)
+	if err != nil {
+		log.Printf("Saving users by OS failed: %v\n", err)
+		return err
+	}
+	dbQuery = `
+		INSERT INTO db4s_users_by_os (metric_family, stats_date, os, unique_ips)
+		VALUES ($1, $2, $3, $4)`
+	for os, n := range perOS {
+		_, err = db.exec(ctx, dbQuery, family, date, os, n)
+		if err != nil {
+			log.Printf("Saving users by OS failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want UserAgent
+	}{
+		{"sqlitebrowser 3.12.2", UserAgent{Version: "3.12.2"}},
+		{"sqlitebrowser 3.13.0 (Windows 10; x86_64; nightly; de_DE)",
+			UserAgent{Version: "3.13.0", OS: "Windows", Arch: "x86_64", Channel: "nightly", Locale: "de_DE"}},
+		{"sqlitebrowser 3.13.0 (macOS 14.2; arm64)", UserAgent{Version: "3.13.0", OS: "macOS", Arch: "arm64"}},
+		{"sqlitebrowser 3.13.0 (Mac OS X; aarch64; pt-BR)",
+			UserAgent{Version: "3.13.0", OS: "macOS", Arch: "arm64", Locale: "pt-BR"}},
+		{"sqlitebrowser 3.12.2 Ubuntu amd64 release en",
+			UserAgent{Version: "3.12.2", OS: "Linux", Arch: "x86_64", Channel: "release", Locale: "en"}},
+		{"sqlitebrowser 3.12.2 (FreeBSD; i386)", UserAgent{Version: "3.12.2", OS: "BSD", Arch: "x86"}},
+		{"sqlitebrowser 3.12.2 (Win32; BETA)", UserAgent{Version: "3.12.2", Arch: "x86", Channel: "beta"}},
+
+		// Empty and unknown tokens are skipped, and only the first of each kind is used
+		{"sqlitebrowser 3.13.0 (; Haiku; Linux; Windows; x64; arm64; rc; beta; fr; de)",
+			UserAgent{Version: "3.13.0", OS: "Linux", Arch: "x86_64", Channel: "rc", Locale: "fr"}},
+		{"sqlitebrowser 3.13.0 ()", UserAgent{Version: "3.13.0"}},
+		{"sqlitebrowser  3.12.2", UserAgent{Version: "3.12.2"}},
+		{"sqlitebrowser ", UserAgent{}},
+		{"sqlitebrowser 3.13.0 (English; 64bit)", UserAgent{Version: "3.13.0"}},
+	}
+	f := NewFilters()
+	for _, test := range tests {
+		if got := f.ParseUserAgent(test.in); got != test.want {
+			t.Errorf("ParseUserAgent(%q) = %+v, expected %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeUserAgent(t *testing.T) {
+	tests := []struct {
+		in, want, os string
+	}{
+		{"sqlitebrowser 3.12.2", "sqlitebrowser 3.12.2", OSUnknown},
+		{"sqlitebrowser 3.13.0 (Windows 10; x86_64)", "sqlitebrowser 3.13.0", "Windows"},
+		{"sqlitebrowser 3.13.0-rc1 Debian", "sqlitebrowser 3.13.0-rc1", "Linux"},
+	}
+	f := NewFilters()
+	for _, test := range tests {
+		if got := f.NormalizeUserAgent(test.in); got != test.want {
+			t.Errorf("NormalizeUserAgent(%q) = %q, expected %q", test.in, got, test.want)
+		}
+		if got := f.UserAgentOS(test.in); got != test.os {
+			t.Errorf("UserAgentOS(%q) = %q, expected %q", test.in, got, test.os)
+		}
+	}
+}
+
+func TestVersionCheckUserAgent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"sqlitebrowser 3.12.2", true},
+		{"sqlitebrowser 3.13.0 (Windows 10; x86_64)", true},
+		{"sqlitebrowser 3.12.2 AppEngine-Google", false},
+		{"Sqlitebrowser 3.12.2", false},
+		{"Mozilla/5.0 sqlitebrowser 3.12.2", false},
+		{"", false},
+	}
+	f := NewFilters()
+	for _, test := range tests {
+		if got := f.isVersionCheckUserAgent(test.in); got != test.want {
+			t.Errorf("isVersionCheckUserAgent(%q) = %v, expected %v", test.in, got, test.want)
+		}
+	}
+
+	// The defaults are kept for the patterns which aren't given
+	if err := f.SetUserAgentPatterns([]string{"sqlitebrowser ", "dbhub-cli/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !f.isVersionCheckUserAgent("dbhub-cli/0.1.0 (Linux)") {
+		t.Error("extra prefix isn't matched")
+	}
+	if f.isVersionCheckUserAgent("sqlitebrowser 3.12.2 AppEngine") {
+		t.Error("default exclusion is lost")
+	}
+	if got := f.NormalizeUserAgent("dbhub-cli/0.1.0 (Linux)"); got != "dbhub-cli/0.1.0" {
+		t.Errorf("NormalizeUserAgent for the extra prefix is %q", got)
+	}
+	if err := f.SetUserAgentPatterns([]string{" "}, nil); err == nil {
+		t.Error("no error for an empty prefix")
+	}
+}
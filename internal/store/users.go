@@ -0,0 +1,618 @@
+package store
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// ErrNoClientIP is returned by IPCounter for a version check without any client IP address
+var ErrNoClientIP = errors.New("doesn't seem to be any non-NULL client IP field for one of the rows")
+
+// GetIPs returns the number of DB4S instances doing a version check in the given date range, plus a count of the
+// quantity per DB4S version
+func (db *DB) GetIPs(ctx context.Context, startDate time.Time, endDate time.Time) (IPs int, userAgentIPs map[string]int, err error) {
+	if db.ipv6Prefix {
+		err = db.logIPv6Aggregation(ctx, startDate, endDate)
+		if err != nil {
+			return
+		}
+	}
+	if db.clickHouse != nil {
+		return db.clickHouseIPs(ctx, startDate, endDate)
+	}
+
+	counter, err := db.countUsers(ctx, startDate, endDate, NewUserCounter(db.filters.NormalizeUserAgent, db.bots, 0))
+	if err != nil {
+		return
+	}
+	var excluded int
+	IPs, userAgentIPs, excluded = counter.Counts()
+	if db.bots != nil && db.Verbosity >= verbosity.Verbose {
+		log.Printf("Excluded %d version check(s) from bots between %v and %v\n", excluded,
+			startDate.Format("2006 Jan 2"), endDate.Format("2006 Jan 2"))
+	}
+	return
+}
+
+// GetEstimatedUsers returns the estimated number of DB4S users in the given date range, plus a breakdown per user
+// agent.  This refines the unique IP counts, estimating the number of users behind each IP address from the user
+// agents seen from it and its daily number of version checks.  The breakdown is nil when the users aren't estimated
+func (db *DB) GetEstimatedUsers(ctx context.Context, startDate time.Time, endDate time.Time) (users int, userAgentUsers map[string]int, err error) {
+	if db.checksPerUser <= 0 {
+		return
+	}
+	if db.clickHouse != nil {
+		return db.clickHouseEstimatedUsers(ctx, startDate, endDate)
+	}
+	counter := NewUserCounter(db.filters.NormalizeUserAgent, db.bots, db.checksPerUser)
+	counter, err = db.countUsers(ctx, startDate, endDate, counter)
+	if err != nil {
+		return
+	}
+	counter.Counts()
+	users, userAgentUsers = counter.Estimates()
+	return
+}
+
+// countUsers adds the version checks in the given date range to a UserCounter, returning it
+func (db *DB) countUsers(ctx context.Context, startDate, endDate time.Time, counter *UserCounter) (*UserCounter, error) {
+	// Retrieve the valid `/currentrelease` requests for the desired time range, ordered by IP address.  This lets the
+	// unique IP addresses be counted as the rows stream in, rather than holding every IP address of a busy month in
+	// memory.  The IP address used is the same as IPCounter uses, unless X-Forwarded-For resolution or IPv6 /64
+	// aggregation is enabled.  ORDER BY repeats the expression, as PostgreSQL only resolves output column names there
+	// when they aren't part of a larger expression (eg with COLLATE)
+	ip, ipArgs := db.userIP(3)
+	excluded, args := notInNetworks(ip, db.excluded, append([]any{&startDate, &endDate}, ipArgs...))
+	dbQuery := fmt.Sprintf(`
+		SELECT %[2]s AS ip, http_user_agent, request_time
+		FROM {logs}
+		WHERE request = '/currentrelease'
+			AND %[3]s
+			AND request_time >= $1
+			AND request_time < $2
+			AND status = 200
+			%[4]s
+		ORDER BY %[2]s COLLATE "C"`, ip, db.ipKey(ip), db.filters.pgUserAgents(), excluded)
+	dbQuery = db.logsQuery(dbQuery, startDate, endDate)
+	rows, cancel, err := db.queryLogs(ctx, dbQuery, args...)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var IP, userAgent pgtype.Text
+		var requestTime time.Time
+		err = rows.Scan(&IP, &userAgent, &requestTime)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		if !IP.Valid {
+			// Rows without any client IP address can't be counted, so are left out.  CheckQuality reports them
+			continue
+		}
+		counter.Add(IP.String, userAgent.String, requestTime)
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return counter, nil
+}
+
+// SortedIPCounter counts the unique IP addresses doing version checks, both overall and per user agent, from requests
+// given in IP address order.  Only the user agents of the current IP address are held in memory, so memory use stays
+// bounded regardless of the number of requests
+type SortedIPCounter struct {
+	current      string
+	started      bool
+	userAgents   map[string]struct{}
+	unique       int
+	perUserAgent map[string]int
+}
+
+// NewSortedIPCounter returns an empty SortedIPCounter
+func NewSortedIPCounter() *SortedIPCounter {
+	return &SortedIPCounter{
+		userAgents:   make(map[string]struct{}),
+		perUserAgent: make(map[string]int),
+	}
+}
+
+// Add counts a single version check request.  Requests must be added grouped by IP address (eg sorted by it)
+func (c *SortedIPCounter) Add(IP, userAgent string) {
+	if !c.started || IP != c.current {
+		c.flush()
+		c.current, c.started = IP, true
+		c.unique++
+	}
+	c.userAgents[userAgent] = struct{}{}
+}
+
+// flush counts the user agents of the current IP address, ready for the next one
+func (c *SortedIPCounter) flush() {
+	for userAgent := range c.userAgents {
+		c.perUserAgent[userAgent]++
+		delete(c.userAgents, userAgent)
+	}
+}
+
+// Counts returns the number of unique IP addresses, plus the number of unique IP addresses per user agent
+func (c *SortedIPCounter) Counts() (IPs int, userAgentIPs map[string]int) {
+	c.flush()
+	userAgentIPs = make(map[string]int, len(c.perUserAgent))
+	for userAgent, n := range c.perUserAgent {
+		userAgentIPs[userAgent] = n
+	}
+	return c.unique, userAgentIPs
+}
+
+// UserCounter counts the unique IP addresses doing version checks as per SortedIPCounter, leaving out those excluded
+// by a BotFilter.  It can also estimate the number of users behind each IP address, going by the number of user
+// agents seen from it and its daily number of version checks.  Only the requests of the current IP address are held
+// in memory
+type UserCounter struct {
+	// Returns the key the version checks of a user agent are counted under
+	Key func(userAgent string) string
+
+	// When set, only the version checks it returns true for are counted
+	Include func(userAgent string, requestTime time.Time) bool
+
+	counter       *SortedIPCounter
+	filter        *BotFilter
+	checksPerUser int
+	current       string
+	started       bool
+	excluded      int
+
+	// The version checks of the current IP address, per user agent then day
+	perDay map[string]map[time.Time]int
+
+	// The estimated number of users, overall and per user agent
+	users        int
+	perUserAgent map[string]int
+}
+
+// NewUserCounter returns an empty UserCounter, counting the version checks under the keys returned by key (eg
+// Filters.NormalizeUserAgent).  Nothing is excluded when the filter is nil, and the users are only estimated when
+// checksPerUser (the typical number of version checks a single user makes per day) isn't zero
+func NewUserCounter(key func(userAgent string) string, filter *BotFilter, checksPerUser int) *UserCounter {
+	return &UserCounter{
+		Key:           key,
+		counter:       NewSortedIPCounter(),
+		filter:        filter,
+		checksPerUser: checksPerUser,
+		perDay:        make(map[string]map[time.Time]int),
+		perUserAgent:  make(map[string]int),
+	}
+}
+
+// Add counts a single version check request.  Requests must be added grouped by IP address (eg sorted by it)
+func (c *UserCounter) Add(IP, userAgent string, requestTime time.Time) {
+	if c.Include != nil && !c.Include(userAgent, requestTime) {
+		return
+	}
+	key := c.Key(userAgent)
+	if c.filter == nil && c.checksPerUser <= 0 {
+		c.counter.Add(IP, key)
+		return
+	}
+	if !c.started || IP != c.current {
+		c.flush()
+		c.current, c.started = IP, true
+	}
+	if c.filter != nil && c.filter.excludes(IP, userAgent) {
+		c.excluded++
+		return
+	}
+	if c.perDay[key] == nil {
+		c.perDay[key] = make(map[time.Time]int)
+	}
+	c.perDay[key][requestTime.UTC().Truncate(24*time.Hour)]++
+}
+
+// flush counts the version checks of the current IP address, unless it made too many of them on any day
+func (c *UserCounter) flush() {
+	if c.filter != nil && c.filter.maxRequests > 0 {
+		total := make(map[time.Time]int)
+		for _, days := range c.perDay {
+			for day, n := range days {
+				total[day] += n
+			}
+		}
+		for _, n := range total {
+			if n > c.filter.maxRequests {
+				for _, days := range c.perDay {
+					for _, n := range days {
+						c.excluded += n
+					}
+				}
+				clear(c.perDay)
+				return
+			}
+		}
+	}
+	for userAgent, days := range c.perDay {
+		c.counter.Add(c.current, userAgent)
+
+		// Each user agent seen from an IP address is at least one user.  More version checks on a single day than
+		// a single user makes suggests several users behind the IP address (eg carrier grade NAT)
+		if c.checksPerUser > 0 {
+			peak := 0
+			for _, n := range days {
+				peak = max(peak, n)
+			}
+			users := max(1, (peak+c.checksPerUser-1)/c.checksPerUser)
+			c.users += users
+			c.perUserAgent[userAgent] += users
+		}
+	}
+	clear(c.perDay)
+}
+
+// Counts returns the number of unique IP addresses and the number of unique IP addresses per user agent, plus the
+// number of version checks excluded as being from bots
+func (c *UserCounter) Counts() (IPs int, userAgentIPs map[string]int, excluded int) {
+	c.flush()
+	IPs, userAgentIPs = c.counter.Counts()
+	return IPs, userAgentIPs, c.excluded
+}
+
+// Estimates returns the estimated number of users, plus the estimated number of users per user agent.  Counts must
+// be called first
+func (c *UserCounter) Estimates() (users int, userAgentUsers map[string]int) {
+	userAgentUsers = make(map[string]int, len(c.perUserAgent))
+	for userAgent, n := range c.perUserAgent {
+		userAgentUsers[userAgent] = n
+	}
+	return c.users, userAgentUsers
+}
+
+// IsVersionCheck reports whether a download_log entry is a valid DB4S version check, matching the filtering done by the
+// GetIPs() and UpdateUserAgents() queries
+func (f *Filters) IsVersionCheck(request, userAgent string, status int) bool {
+	return request == "/currentrelease" && status == 200 && f.isVersionCheckUserAgent(userAgent)
+}
+
+// IPCounter counts the unique IP addresses doing version checks, both overall and per user agent
+type IPCounter struct {
+	// This nested map approach (inside of a combined key) should allow for counting the # of unique IP's per user agent
+	perUserAgent map[string]map[[16]byte]int
+	unique       map[[16]byte]int
+}
+
+// NewIPCounter returns an empty IPCounter
+func NewIPCounter() *IPCounter {
+	return &IPCounter{
+		perUserAgent: make(map[string]map[[16]byte]int),
+		unique:       make(map[[16]byte]int),
+	}
+}
+
+// Add counts a single version check request.  The IP address fields are as per the download_log table, with an empty
+// string for NULL
+func (c *IPCounter) Add(userAgent, IPv4, IPv6, IPStrange string) error {
+	// Work out the key to use.  We use a hash of the IP address, to stop weird characters in the IP Strange field
+	// being a problem
+	var IPHash [16]byte
+	if IPStrange != "" {
+		IPHash = md5.Sum([]byte(IPStrange))
+	} else if IPv6 != "" {
+		IPHash = md5.Sum([]byte(IPv6))
+	} else if IPv4 != "" {
+		IPHash = md5.Sum([]byte(IPv4))
+	} else {
+		// This shouldn't happen, but check for it just in case
+		return ErrNoClientIP
+	}
+
+	// Update the unique IP address counter as appropriate
+	c.unique[IPHash]++
+
+	// Increment the counter for the user agent + IP address combination
+	ipMap, ok := c.perUserAgent[userAgent]
+	if !ok {
+		ipMap = make(map[[16]byte]int)
+		c.perUserAgent[userAgent] = ipMap
+	}
+	ipMap[IPHash]++
+	return nil
+}
+
+// Counts returns the number of unique IP addresses, plus the number of unique IP addresses per user agent
+func (c *IPCounter) Counts() (IPs int, userAgentIPs map[string]int) {
+	userAgentIPs = make(map[string]int)
+	for i, j := range c.perUserAgent {
+		userAgentIPs[i] = len(j)
+	}
+	return len(c.unique), userAgentIPs
+}
+
+// ReleaseIDs returns the release ID for each version number in the db4s_release_info table
+func (db *DB) ReleaseIDs(ctx context.Context) (releases map[string]int, err error) {
+	dbQuery := `
+		SELECT release_id, version_number
+		FROM db4s_release_info
+		WHERE version_number IS NOT NULL`
+	rows, cancel, err := db.query(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer cancel()
+	defer rows.Close()
+	releases = make(map[string]int)
+	for rows.Next() {
+		var id int
+		var version string
+		err = rows.Scan(&id, &version)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return
+		}
+		releases[version] = id
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return
+	}
+	return
+}
+
+// SaveDailyUsersStats inserts new or updated daily stats counts into the db4s_users_daily table
+func (db *DB) SaveDailyUsersStats(ctx context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error {
+	if db.bulkLoad {
+		return db.bulkSaveUsers(ctx, "db4s_users_daily", date, count, IPsPerUserAgent)
+	}
+
+	// Update the non-version-specific daily stats
+	// NOTE - The hard coded 1 value for the release version corresponds to the manually added "Unique IPs" entry in
+	// the DB4S release info table
+	dbQuery := `
+		INSERT INTO db4s_users_daily (stats_date, db4s_release, unique_ips)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (stats_date, db4s_release)
+			DO UPDATE
+				SET unique_ips = $2
+				WHERE db4s_users_daily.stats_date = $1
+					AND db4s_users_daily.db4s_release = 1`
+	commandTag, err := db.exec(ctx, dbQuery, date, count)
+	if err != nil {
+		// For now, don't bother logging a failure here.  This *might* need changing later on
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding a daily stats row: %v\n", numRows, date)
+	}
+
+	// Update the version-specific daily stats
+	for i, verCount := range IPsPerUserAgent {
+		// Strip the leading 'sqlitebrowser ' string (and any extra tokens) from the version number
+		versionString := db.filters.UserAgentVersion(i)
+		dbQuery = `
+		WITH ver AS (
+			SELECT release_id
+			FROM db4s_release_info
+			WHERE version_number = $2
+		)
+		INSERT INTO db4s_users_daily (stats_date, db4s_release, unique_ips)
+		SELECT $1, (SELECT release_id FROM ver), $3
+		ON CONFLICT (stats_date, db4s_release)
+			DO UPDATE
+				SET unique_ips = $3
+				WHERE db4s_users_daily.stats_date = $1
+					AND db4s_users_daily.db4s_release = (SELECT release_id FROM ver)`
+		commandTag, err := db.exec(ctx, dbQuery, date, versionString, verCount)
+		if err != nil {
+			// For now, don't bother logging a failure here.  This *might* need changing later on
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows > 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding a daily stats row: %v\n", numRows, date)
+		}
+	}
+	return nil
+}
+
+// SaveMonthlyUsersStats inserts new or updated weekly stats counts into the db4s_users_monthly table
+func (db *DB) SaveMonthlyUsersStats(ctx context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error {
+	if db.bulkLoad {
+		return db.bulkSaveUsers(ctx, "db4s_users_monthly", date, count, IPsPerUserAgent)
+	}
+
+	// Update the non-version-specific monthly stats
+	// NOTE - The hard coded 1 value for the release version corresponds to the manually added "Unique IPs" entry in
+	// the release version table
+	dbQuery := `
+		INSERT INTO db4s_users_monthly (stats_date, db4s_release, unique_ips)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (stats_date, db4s_release)
+			DO UPDATE
+				SET unique_ips = $2
+				WHERE db4s_users_monthly.stats_date = $1
+					AND db4s_users_monthly.db4s_release = 1`
+	commandTag, err := db.exec(ctx, dbQuery, date, count)
+	if err != nil {
+		// For now, don't bother logging a failure here.  This *might* need changing later on
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding a monthly stats row: %v\n", numRows, date)
+	}
+
+	// Update the version-specific monthly stats
+	for i, verCount := range IPsPerUserAgent {
+		// Strip the leading 'sqlitebrowser ' string (and any extra tokens) from the version number
+		versionString := db.filters.UserAgentVersion(i)
+		dbQuery = `
+		WITH ver AS (
+			SELECT release_id
+			FROM db4s_release_info
+			WHERE version_number = $2
+		)
+		INSERT INTO db4s_users_monthly (stats_date, db4s_release, unique_ips)
+		SELECT $1, (SELECT release_id FROM ver), $3
+		ON CONFLICT (stats_date, db4s_release)
+			DO UPDATE
+				SET unique_ips = $3
+				WHERE db4s_users_monthly.stats_date = $1
+					AND db4s_users_monthly.db4s_release = (SELECT release_id FROM ver)`
+		commandTag, err := db.exec(ctx, dbQuery, date, versionString, verCount)
+		if err != nil {
+			// For now, don't bother logging a failure here.  This *might* need changing later on
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows > 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding a monthly stats row: %v\n", numRows, date)
+		}
+	}
+	return nil
+}
+
+// SaveWeeklyUsersStats inserts new or updated weekly stats counts into the db4s_users_weekly table
+func (db *DB) SaveWeeklyUsersStats(ctx context.Context, date time.Time, count int, IPsPerUserAgent map[string]int) error {
+	if db.bulkLoad {
+		return db.bulkSaveUsers(ctx, "db4s_users_weekly", date, count, IPsPerUserAgent)
+	}
+
+	// Update the non-version-specific weekly stats
+	// NOTE - The hard coded 1 value for the release version corresponds to the manually added "Unique IPs" entry in
+	// the release version table
+	dbQuery := `
+		INSERT INTO db4s_users_weekly (stats_date, db4s_release, unique_ips)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (stats_date, db4s_release)
+			DO UPDATE
+				SET unique_ips = $2
+				WHERE db4s_users_weekly.stats_date = $1
+					AND db4s_users_weekly.db4s_release = 1`
+	commandTag, err := db.exec(ctx, dbQuery, date, count)
+	if err != nil {
+		// For now, don't bother logging a failure here.  This *might* need changing later on
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding a weekly stats row: %v\n", numRows, date)
+	}
+
+	// Update the version-specific weekly stats
+	for i, verCount := range IPsPerUserAgent {
+		// Strip the leading 'sqlitebrowser ' string (and any extra tokens) from the version number
+		versionString := db.filters.UserAgentVersion(i)
+		dbQuery = `
+		WITH ver AS (
+			SELECT release_id
+			FROM db4s_release_info
+			WHERE version_number = $2
+		)
+		INSERT INTO db4s_users_weekly (stats_date, db4s_release, unique_ips)
+		SELECT $1, (SELECT release_id FROM ver), $3
+		ON CONFLICT (stats_date, db4s_release)
+			DO UPDATE
+				SET unique_ips = $3
+				WHERE db4s_users_weekly.stats_date = $1
+					AND db4s_users_weekly.db4s_release = (SELECT release_id FROM ver)`
+		commandTag, err := db.exec(ctx, dbQuery, date, versionString, verCount)
+		if err != nil {
+			// For now, don't bother logging a failure here.  This *might* need changing later on
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows > 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding a weekly stats row: %v\n", numRows, date)
+		}
+	}
+	return nil
+}
+
+// UpdateUserAgents retrieves the full list of user agents present in the daily request logs, then ensures there's an
+// entry for each one in the main stats processing reference table
+func (db *DB) UpdateUserAgents(ctx context.Context) error {
+	if db.Verbosity >= verbosity.Verbose {
+		log.Printf("Updating DB4S user agents list in the database...")
+	}
+
+	// Get list of all (valid) user agents in the logs
+	userAgents, err := db.userAgents(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Add them in version order, so new releases get their IDs in order too
+	slices.SortFunc(userAgents, CompareVersions)
+
+	// Insert any missing user agents into the db4s_release_info table
+	for _, j := range userAgents {
+		if db.Verbosity >= verbosity.Verbose {
+			log.Printf("Adding user agent '%v'", j)
+		}
+
+		dbQuery := `
+			INSERT INTO db4s_release_info (version_number)
+			VALUES ($1)
+			ON CONFLICT DO NOTHING`
+		commandTag, err := db.exec(ctx, dbQuery, j)
+		if err != nil {
+			// For now, don't bother logging a failure here.  This *might* need changing later on
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows > 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding release: %v\n", numRows, j)
+		}
+	}
+
+	// Fill in the version sort keys of the new entries
+	return db.updateVersionKeys(ctx)
+}
+
+// userAgents returns the version numbers of the (valid) user agents in the download logs
+func (db *DB) userAgents(ctx context.Context) (versions []string, err error) {
+	if db.clickHouse != nil {
+		var userAgents []string
+		userAgents, err = db.clickHouseUserAgents(ctx)
+		for _, userAgent := range userAgents {
+			versions = append(versions, db.filters.UserAgentVersion(userAgent))
+		}
+		return
+	}
+
+	dbQuery := `
+		SELECT DISTINCT (http_user_agent)
+		FROM download_log
+		WHERE request = '/currentrelease'
+			AND ` + db.filters.pgUserAgents() + `
+		ORDER BY http_user_agent ASC`
+	rows, cancel, err := db.queryLogs(ctx, dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer cancel()
+	defer rows.Close()
+	for rows.Next() {
+		var userAgent pgtype.Text
+		err = rows.Scan(&userAgent)
+		if err != nil {
+			log.Printf("Error retrieving rows: %v\n", err)
+			return nil, err
+		}
+		if userAgent.String != "" && userAgent.Valid {
+			versions = append(versions, db.filters.UserAgentVersion(userAgent.String))
+		}
+	}
+	if err = db.checkTimeout(ctx, rows.Err()); err != nil {
+		log.Printf("Error retrieving rows: %v\n", err)
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+// versionCheck is a single version check request, as added to the IP counters
+type versionCheck struct {
+	IP, userAgent string
+}
+
+func TestSortedIPCounter(t *testing.T) {
+	tests := []struct {
+		name    string
+		checks  []versionCheck
+		wantIPs int
+		wantUAs map[string]int
+	}{
+		{"empty", nil, 0, map[string]int{}},
+		{"single", []versionCheck{{"10.0.0.1", "sqlitebrowser 3.12.2"}}, 1, map[string]int{"sqlitebrowser 3.12.2": 1}},
+		{"adjacent duplicates", []versionCheck{
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.2", "sqlitebrowser 3.12.2"},
+			{"10.0.0.2", "sqlitebrowser 3.12.2"},
+		}, 2, map[string]int{"sqlitebrowser 3.12.2": 2}},
+		{"several user agents per IP", []versionCheck{
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.13.0"},
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+			{"10.0.0.2", "sqlitebrowser 3.13.0"},
+		}, 2, map[string]int{"sqlitebrowser 3.12.2": 1, "sqlitebrowser 3.13.0": 2}},
+		{"empty IP string", []versionCheck{
+			{"", "sqlitebrowser 3.12.2"},
+			{"", "sqlitebrowser 3.12.2"},
+			{"10.0.0.1", "sqlitebrowser 3.12.2"},
+		}, 2, map[string]int{"sqlitebrowser 3.12.2": 2}},
+	}
+	for _, test := range tests {
+		c := NewSortedIPCounter()
+		for _, check := range test.checks {
+			c.Add(check.IP, check.userAgent)
+		}
+		IPs, userAgentIPs := c.Counts()
+		if IPs != test.wantIPs || !maps.Equal(userAgentIPs, test.wantUAs) {
+			t.Errorf("%v: SortedIPCounter.Counts() = %d, %v, expected %d, %v", test.name, IPs, userAgentIPs,
+				test.wantIPs, test.wantUAs)
+		}
+	}
+}
+
+func TestUserCounter(t *testing.T) {
+	day := time.Date(2018, 8, 13, 9, 0, 0, 0, time.UTC)
+	checks := []versionCheck{
+		{"10.0.0.1", "sqlitebrowser 3.12.2"},
+		{"10.0.0.1", "sqlitebrowser 3.12.2"},
+		{"10.0.0.1", "sqlitebrowser 3.12.2 (Windows 10; x86_64)"},
+		{"10.0.0.2", "sqlitebrowser 3.13.0"},
+		{"10.0.0.2", "sqlitebrowser 3.13.0"},
+		{"10.0.0.3", "sqlitebrowser 3.12.2"},
+	}
+	f := NewFilters()
+	tests := []struct {
+		name          string
+		key           func(userAgent string) string
+		checksPerUser int
+		wantIPs       int
+		wantUAs       map[string]int
+	}{
+		{"raw user agents", func(userAgent string) string { return userAgent }, 0, 3,
+			map[string]int{
+				"sqlitebrowser 3.12.2":                      2,
+				"sqlitebrowser 3.12.2 (Windows 10; x86_64)": 1,
+				"sqlitebrowser 3.13.0":                      1,
+			}},
+		{"normalised user agents", f.NormalizeUserAgent, 0, 3,
+			map[string]int{"sqlitebrowser 3.12.2": 2, "sqlitebrowser 3.13.0": 1}},
+		{"estimating users", f.NormalizeUserAgent, 1, 3,
+			map[string]int{"sqlitebrowser 3.12.2": 2, "sqlitebrowser 3.13.0": 1}},
+	}
+	for _, test := range tests {
+		c := NewUserCounter(test.key, nil, test.checksPerUser)
+		for _, check := range checks {
+			c.Add(check.IP, check.userAgent, day)
+		}
+		IPs, userAgentIPs, excluded := c.Counts()
+		if IPs != test.wantIPs || !maps.Equal(userAgentIPs, test.wantUAs) || excluded != 0 {
+			t.Errorf("%v: UserCounter.Counts() = %d, %v, %d, expected %d, %v, 0", test.name, IPs, userAgentIPs,
+				excluded, test.wantIPs, test.wantUAs)
+		}
+	}
+}
+
+func TestUserCounterInclude(t *testing.T) {
+	// Version checks left out by Include aren't counted, nor reported as excluded
+	start := time.Date(2018, 8, 13, 0, 0, 0, 0, time.UTC)
+	c := NewUserCounter(func(userAgent string) string { return userAgent }, nil, 0)
+	c.Include = func(_ string, requestTime time.Time) bool { return !requestTime.Before(start) }
+	c.Add("10.0.0.1", "sqlitebrowser 3.12.2", start.Add(-time.Hour))
+	c.Add("10.0.0.1", "sqlitebrowser 3.12.2", start.Add(time.Hour))
+	c.Add("10.0.0.2", "sqlitebrowser 3.12.2", start.Add(-time.Hour))
+	IPs, userAgentIPs, excluded := c.Counts()
+	if want := map[string]int{"sqlitebrowser 3.12.2": 1}; IPs != 1 || !maps.Equal(userAgentIPs, want) || excluded != 0 {
+		t.Errorf("UserCounter.Counts() = %d, %v, %d, expected 1, %v, 0", IPs, userAgentIPs, excluded, want)
+	}
+}
@@ -0,0 +1,62 @@
+// Package verbosity holds the levels of logging output, from errors only up to every SQL query
+package verbosity
+
+import "fmt"
+
+// Level is how much logging output to give
+type Level int
+
+const (
+	// Quiet only reports errors, eg for cron
+	Quiet Level = iota - 1
+
+	// Normal reports errors, warnings and the results of sub-commands
+	Normal
+
+	// Verbose also reports what's being done, including a summary of each time period processed
+	Verbose
+
+	// Trace also reports every SQL query run, with its duration
+	Trace
+)
+
+// String returns the name of the level
+func (l Level) String() string {
+	switch l {
+	case Quiet:
+		return "quiet"
+	case Normal:
+		return "normal"
+	case Verbose:
+		return "verbose"
+	case Trace:
+		return "trace"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// FromArgs returns the level given by the -q, -v and -vv command line arguments, plus the remaining arguments with
+// those left out.  Arguments after a "--" are left alone.  When more than one is given the last one wins, falling back
+// to the given default level when there are none
+func FromArgs(args []string, def Level) (Level, []string) {
+	level := def
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		switch arg {
+		case "-q", "--q":
+			level = Quiet
+		case "-v", "--v":
+			level = Verbose
+		case "-vv", "--vv":
+			level = Trace
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return level, rest
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/verbosity"
+)
+
+// listen waits for notifications from the log ingestion process on a PostgreSQL channel, regenerating today's daily
+// stats as new batches of download log entries arrive, until interrupted.  This gives near real time numbers (eg on
+// release days) without running the batch job constantly
+func listen(_ context.Context, conf config.Config, db *store.DB, _ []string) error {
+	channel := conf.RealtimeChannel()
+	refresh := time.Minute
+	if conf.Realtime.Refresh > 0 {
+		refresh = time.Duration(conf.Realtime.Refresh) * time.Second
+	}
+
+	// As with the serve mode, the run deadline doesn't apply
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := db.Listen(ctx, channel)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("Listening for new download log batches on channel '%v'\n", channel)
+
+	// Catch up with any batches which arrived while we weren't listening
+	today := newTodayStats(db)
+	if err = today.update(ctx); err != nil {
+		return err
+	}
+	lastRefresh := time.Now()
+	for {
+		_, err = listener.Wait(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Batches often arrive in bursts, so the ones arriving within the refresh interval of the last update are
+		// handled together
+		batches := 1
+		for wait := refresh - time.Since(lastRefresh); wait > 0; wait = refresh - time.Since(lastRefresh) {
+			waitCtx, cancel := context.WithTimeout(ctx, wait)
+			_, err = listener.Wait(waitCtx)
+			cancel()
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			batches++
+		}
+		if err = today.update(ctx); err != nil {
+			return err
+		}
+		lastRefresh = time.Now()
+		if db.Verbosity >= verbosity.Verbose {
+			log.Printf("Updated today's stats after %d new batch(es)\n", batches)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// listReleases prints the db4s_release_info entries the users stats are keyed by
+func listReleases(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	if err := flag.NewFlagSet("list-releases", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+	releases, err := db.ListReleases(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tVERSION\tNAME\tRELEASED\tPRERELEASE")
+	for _, r := range releases {
+		fmt.Fprintf(w, "%d\t%v\t%v\t%v\t%v\n", r.ID, r.Version, r.Name, listDate(r.Released), r.Prerelease)
+	}
+	return w.Flush()
+}
+
+// listDownloads prints the db4s_download_info entries the downloads stats are keyed by, with one line for each of
+// their request path mappings
+func listDownloads(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	if err := flag.NewFlagSet("list-downloads", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+	downloads, err := db.ListDownloads(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPATTERN\tTYPE\tFROM\tUNTIL")
+	for _, d := range downloads {
+		switch {
+		case len(d.Mappings) > 0:
+			for _, m := range d.Mappings {
+				kind := "exact"
+				if m.Regex {
+					kind = "regex"
+				}
+				fmt.Fprintf(w, "%d\t%v\t%v\t%v\t%v\t%v\n", d.ID, d.Name, m.Pattern, kind, listDate(m.ActiveFrom),
+					listDate(m.ActiveUntil))
+			}
+		case d.Request != "":
+			fmt.Fprintf(w, "%d\t%v\t%v\tauto\t-\t-\n", d.ID, d.Name, d.Request)
+		default:
+			fmt.Fprintf(w, "%d\t%v\t-\t-\t-\t-\n", d.ID, d.Name)
+		}
+	}
+	return w.Flush()
+}
+
+// listDate formats a date for the list commands, with a dash when there isn't one
+func listDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// refreshMatviews adds any new user agents to the db4s_release_info table (and new artifacts to db4s_download_info),
+// then refreshes the materialized views holding the users and downloads stats, for the materialized views backend
+func refreshMatviews(ctx context.Context, db *store.DB) error {
+	err := db.UpdateUserAgents(ctx)
+	if err != nil {
+		return err
+	}
+	err = db.UpdateDownloads(ctx)
+	if err != nil {
+		return err
+	}
+	return db.RefreshMatviews(ctx)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/notify"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// runSummary returns the summary of the run, including the headline numbers when it succeeded
+func runSummary(ctx context.Context, product string, db *store.DB, gen *stats.Generator, duration time.Duration, runErr error) notify.Summary {
+	summary := notify.Summary{
+		Product:   product,
+		Duration:  duration,
+		Err:       runErr,
+		Processed: gen.Processed(),
+		Warnings:  gen.Warnings(),
+	}
+	if runErr == nil {
+		families := gen.Families
+		if families == nil {
+			families = stats.Families()
+		}
+		headlines, err := notify.Headlines(ctx, db, families, time.Now())
+		if err != nil {
+			log.Printf("Retrieving headline numbers for the notification failed: %v\n", err)
+		}
+		summary.Headlines = headlines
+	}
+	return summary
+}
+
+// notifyRun posts a summary of the run to the configured webhook.  Failing to do so is logged rather than failing the
+// run, as the stats themselves are already saved
+func notifyRun(webhookURL, product string, db *store.DB, gen *stats.Generator, duration time.Duration, runErr error) {
+	// Use a fresh context, as the run's one may have expired (which could be why the run failed)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	summary := runSummary(ctx, product, db, gen, duration, runErr)
+	err := notify.Send(ctx, webhookURL, summary)
+	if err != nil {
+		log.Printf("Sending the run notification failed: %v\n", err)
+	}
+}
+
+// postRun posts the JSON summary of the run to the generic webhook, so downstream systems can react to it straight
+// away.  Failing to do so is logged rather than failing the run
+func postRun(conf config.WebhookInfo, product, mode string, db *store.DB, gen *stats.Generator, duration time.Duration, runErr error) {
+	// Use a fresh context, as the run's one may have expired (which could be why the run failed)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	summary := runSummary(ctx, product, db, gen, duration, runErr)
+	err := notify.PostRun(ctx, conf.URL, conf.Secret, summary.Payload(mode, time.Now()))
+	if err != nil {
+		log.Printf("Posting the run summary to the webhook failed: %v\n", err)
+	}
+}
+
+// pingStart tells the monitoring service the run has started.  Failing to do so is logged rather than failing the run
+func pingStart(ctx context.Context, conf config.MonitorInfo) {
+	err := notify.PingStart(ctx, conf.Service, conf.URL)
+	if err != nil {
+		log.Printf("Sending the start ping to the monitoring service failed: %v\n", err)
+	}
+}
+
+// pingFinish tells the monitoring service the run has finished, or failed.  Failing to do so is logged rather than
+// failing the run
+func pingFinish(conf config.MonitorInfo, gen *stats.Generator, duration time.Duration, runErr error) {
+	// Use a fresh context, as the run's one may have expired (which could be why the run failed)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	summary := notify.Summary{
+		Duration:  duration,
+		Err:       runErr,
+		Processed: gen.Processed(),
+		Warnings:  gen.Warnings(),
+	}
+	err := notify.PingFinish(ctx, conf.Service, conf.URL, summary)
+	if err != nil {
+		log.Printf("Sending the finish ping to the monitoring service failed: %v\n", err)
+	}
+}
+
+// tootMonth posts the numbers for the month just closed to Mastodon, if this run finished processing a new month.
+// Failing to do so is logged rather than failing the run
+func tootMonth(ctx context.Context, conf config.MastodonInfo, db *store.DB, monthBefore time.Time) {
+	monthAfter, ok, err := db.Watermark(ctx, stats.FamilyDownloadsMonthly)
+	if err != nil || !ok || !monthAfter.After(monthBefore) {
+		return
+	}
+
+	// The watermark is the end of the last fully processed month, so the closed month is the one before it
+	month := stats.Monthly.Previous(monthAfter)
+	m, err := notify.MonthMilestone(ctx, db, month)
+	if err == nil {
+		err = notify.Toot(ctx, conf.Server, conf.Token, conf.Template, m)
+	}
+	if err != nil {
+		log.Printf("Posting the monthly numbers to Mastodon failed: %v\n", err)
+		return
+	}
+	log.Printf("Posted the numbers for %v to Mastodon\n", m.Month)
+}
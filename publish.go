@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/ingest"
+)
+
+// publishFiles uploads generated files to the [publish] bucket, so the website can serve them from the CDN.  Each file
+// is uploaded twice: under a path given by a hash of its contents (eg stats/3f2a9c1be07d4e58/db4s_stats.json), which
+// never changes so can be cached forever, and under stats/latest/ which is replaced each time
+func publishFiles(ctx context.Context, conf config.BucketInfo, files []string) error {
+	if conf.Bucket == "" {
+		return errors.New("publishing needs a [publish] bucket in the config file")
+	}
+	bucket, err := ingest.NewBucket(conf)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		name := filepath.Base(f)
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			// Go only knows these types when the system has a MIME types file
+			switch filepath.Ext(name) {
+			case ".csv":
+				contentType = "text/csv; charset=utf-8"
+			case ".xlsx":
+				contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+			}
+		}
+		for _, dir := range []string{hex.EncodeToString(hash[:8]), "latest"} {
+			key := path.Join(conf.Prefix, dir, name)
+			if err = bucket.PutContent(ctx, key, contentType, data); err != nil {
+				return err
+			}
+			log.Printf("Published %v to %v\n", f, key)
+		}
+	}
+	return nil
+}
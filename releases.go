@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/github"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// DefaultGitHubRepo is the GitHub repository the DB4S releases are published in
+const DefaultGitHubRepo = "sqlitebrowser/sqlitebrowser"
+
+// syncReleases copies the names, dates and pre-release flags of the DB4S releases on GitHub into the
+// db4s_release_info table.  Failures are only logged, as the stats don't depend on them
+func syncReleases(ctx context.Context, conf config.GitHubInfo, db *store.DB) {
+	repo := conf.Repo
+	if repo == "" {
+		repo = DefaultGitHubRepo
+	}
+	releases, err := github.NewClient(conf.URL, conf.Token).Releases(ctx, repo)
+	if err != nil {
+		log.Printf("Fetching the GitHub releases failed: %v\n", err)
+		return
+	}
+	synced := 0
+	for _, r := range releases {
+		if r.Draft {
+			continue
+		}
+
+		// The tags are the version numbers the user agents report, with a "v" in front.  eg v3.12.2
+		version := strings.TrimPrefix(r.TagName, "v")
+		err = db.SaveReleaseInfo(ctx, version, r.Name, r.PublishedAt, r.Prerelease)
+		if err != nil {
+			return
+		}
+		synced++
+	}
+	log.Printf("Synced %d release(s) from GitHub\n", synced)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/report"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// reportStats renders the saved stats into a static HTML page, ready for copying to the website, or a monthly PDF report
+func reportStats(ctx context.Context, conf config.Config, db *store.DB, args []string) error {
+	var from, to dateFlag
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := fs.String("format", "html", "output format (html, or pdf for the monthly report)")
+	out := fs.String("out", "", "file to write the report to (default db4s_stats.html, or db4s_stats.pdf)")
+	month := fs.String("month", "", "month of the pdf report (YYYY-MM, default the last completed month)")
+	top := fs.Int("top", 10, "only list the top N releases or downloads, folding the rest into \"Other\" (0 for all)")
+	fs.Var(&from, "from", "first date to include (YYYY-MM-DD)")
+	fs.Var(&to, "to", "last date to include (YYYY-MM-DD)")
+	publish := fs.Bool("publish", false, "also upload the report to the [publish] bucket")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from.Time) {
+		return errors.New("the --to date is before the --from date")
+	}
+
+	if *out == "" {
+		*out = "db4s_stats." + *format
+	}
+
+	var render func(f *os.File) error
+	switch *format {
+	case "html":
+		if *month != "" {
+			return errors.New("--month is only used by the pdf report")
+		}
+
+		// The --to date is inclusive, so include up to the start of the following day
+		endDate := to.Time
+		if !endDate.IsZero() {
+			endDate = endDate.AddDate(0, 0, 1)
+		}
+		render = func(f *os.File) error {
+			return report.Render(ctx, db, stats.Families(), f, from.Time, endDate, *top)
+		}
+	case "pdf":
+		if !from.IsZero() || !to.IsZero() {
+			return errors.New("the pdf report covers a single --month, rather than --from and --to dates")
+		}
+		reportMonth := stats.Monthly.Previous(stats.Monthly.Start(time.Now()))
+		if *month != "" {
+			var err error
+			reportMonth, err = time.Parse("2006-01", *month)
+			if err != nil {
+				return fmt.Errorf("invalid --month '%v', it should be YYYY-MM", *month)
+			}
+		}
+		render = func(f *os.File) error {
+			return report.RenderPDF(ctx, db, f, reportMonth, *top)
+		}
+	default:
+		return fmt.Errorf("unknown report format '%v'", *format)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = render(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote %v\n", *out)
+	if *publish {
+		return publishFiles(ctx, conf.Publish, []string{*out})
+	}
+	return nil
+}
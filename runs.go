@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// recordRun saves the details of a run in the stats_runs table, as an audit trail for when the saved stats change.
+// Failing to do so is logged rather than failing the run
+func recordRun(db *store.DB, mode string, gen *stats.Generator, started time.Time, runErr error) {
+	// Use a fresh context, as the run's one may have expired (which could be why the run failed)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	run := store.Run{
+		Started:   started,
+		Finished:  time.Now(),
+		Mode:      mode,
+		Processed: gen.Processed(),
+		Rows:      gen.Rows(),
+		Warnings:  gen.Warnings(),
+		Version:   toolVersion(),
+	}
+	run.RangeStart, run.RangeEnd, _ = gen.Range()
+	run.ExitStatus = runExitCode(gen, runErr)
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	err := db.SaveRun(ctx, run)
+	if err != nil {
+		log.Printf("Recording the run failed: %v\n", err)
+	}
+}
+
+// toolVersion returns the version of this program, going by the module version and VCS revision it was built from
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if revision != "" {
+		version += " " + revision + modified
+	}
+	return version
+}
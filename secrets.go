@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/secrets"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// loadSecrets fills in the PostgreSQL connection settings from any configured secret store.  The returned function
+// fetches the credentials again, for when the database rejects them (eg after a rotation), and is nil when there's no
+// secret store
+func loadSecrets(ctx context.Context, conf *config.Config, debug bool) (store.CredentialsFunc, error) {
+	var creds store.CredentialsFunc
+	switch {
+	case conf.Vault.Address != "":
+		vaultConf := conf.Vault
+		creds = func(ctx context.Context) (string, string, error) {
+			return secrets.VaultCredentials(ctx, vaultConf)
+		}
+	case conf.AWS.SecretID != "" || conf.AWS.Parameter != "":
+		awsConf := conf.AWS
+		creds = func(ctx context.Context) (string, string, error) {
+			s, err := secrets.AWSSecret(ctx, awsConf)
+			return s.Username, s.Password, err
+		}
+
+		// AWS database secrets can hold the rest of the connection settings too
+		s, err := secrets.AWSSecret(ctx, awsConf)
+		if err != nil {
+			return nil, err
+		}
+		if s.Host != "" {
+			conf.Pg.Server = s.Host
+		}
+		if port := s.PortNumber(); port != 0 {
+			conf.Pg.Port = port
+		}
+		if s.DBName != "" {
+			conf.Pg.Database = s.DBName
+		}
+	default:
+		return nil, nil
+	}
+
+	// Fetch the credentials up front, so problems with the secret store show up straight away
+	username, password, err := creds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conf.Pg.Username, conf.Pg.Password = username, password
+	if debug {
+		log.Printf("Retrieved PostgreSQL credentials for user '%v' from the secret store\n", username)
+	}
+	return creds, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/server"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// serve answers HTTP requests for the saved stats until interrupted
+func serve(_ context.Context, conf config.Config, db *store.DB, _ []string) error {
+	// The run deadline is for batch runs, so isn't applied to the server.  Each request still has the query timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := server.Server{
+		DB:          db,
+		Public:      conf.Server.Public,
+		CORSOrigins: conf.Server.CORSOrigins,
+		CacheMaxAge: conf.CacheMaxAge(),
+		RateLimit:   conf.Server.RateLimit,
+		RateBurst:   conf.RateBurst(),
+		Verbosity:   db.Verbosity,
+	}
+	for _, p := range conf.Server.TrustedProxies {
+		network, err := store.ParseNetwork(p)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy IP address or range '%v': %w", p, err)
+		}
+		srv.TrustedProxies = append(srv.TrustedProxies, network)
+	}
+	if err := server.CheckScopes(conf.Server.Public); err != nil {
+		return err
+	}
+	for _, t := range conf.Server.Tokens {
+		if t.Token == "" && (t.Username == "" || t.Password == "") {
+			return fmt.Errorf("the '%v' server token needs either a token, or a username and password", t.Name)
+		}
+		if err := server.CheckScopes(t.Scopes); err != nil {
+			return fmt.Errorf("the '%v' server token: %w", t.Name, err)
+		}
+		srv.Credentials = append(srv.Credentials, server.Credential{Name: t.Name, Token: t.Token, Username: t.Username,
+			Password: t.Password, Scopes: t.Scopes})
+	}
+	httpServer := &http.Server{
+		Addr:              conf.Listen(),
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.ListenAndServe()
+	}()
+	log.Printf("Listening on %v\n", conf.Listen())
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Give in-flight requests a chance to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := httpServer.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// todayStats regenerates today's daily stats, for the modes keeping them up to date as new download log entries
+// arrive.  The batch runs still own every earlier time period
+type todayStats struct {
+	db    *store.DB
+	gen   stats.Generator
+	daily []stats.Family
+	day   time.Time
+}
+
+// newTodayStats returns a todayStats for the daily users and downloads metric families
+func newTodayStats(db *store.DB) *todayStats {
+	t := &todayStats{db: db, gen: stats.Generator{DB: db, Verbosity: db.Verbosity}}
+	for _, name := range []string{stats.FamilyDownloadsDaily, stats.FamilyUsersDaily} {
+		fam, _ := stats.FamilyByName(name)
+		t.daily = append(t.daily, fam)
+	}
+	return t
+}
+
+// update regenerates today's daily stats from the saved download log entries
+func (t *todayStats) update(ctx context.Context) error {
+	if today := stats.Daily.Start(time.Now().UTC()); !today.Equal(t.day) {
+		// Pick up any new DB4S versions once a day, as it's a scan of the whole download log
+		if err := t.db.UpdateUserAgents(ctx); err != nil {
+			return err
+		}
+		t.day = today
+	}
+	for _, fam := range t.daily {
+		if err := t.gen.ProcessPeriod(ctx, fam, t.day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/config"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/stats"
+	"github.com/sqlitebrowser/db4s_daily_stats_gen/internal/store"
+)
+
+// verify recomputes the stats for a date range and reports any which differ from the saved ones, optionally repairing
+// them
+func verify(ctx context.Context, _ config.Config, db *store.DB, args []string) error {
+	var from, to dateFlag
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.Var(&from, "from", "first date to verify (YYYY-MM-DD)")
+	fs.Var(&to, "to", "last date to verify (YYYY-MM-DD)")
+	fix := fs.Bool("fix", false, "reprocess time periods with mismatches, overwriting the saved stats")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if from.IsZero() || to.IsZero() {
+		return errors.New("verify needs both --from and --to dates")
+	}
+	if to.Before(from.Time) {
+		return errors.New("the --to date is before the --from date")
+	}
+
+	// The --to date is inclusive, so verify up to the start of the following day
+	gen := stats.Generator{DB: db, Verbosity: db.Verbosity}
+	mismatches, err := gen.Verify(ctx, from.Time, to.AddDate(0, 0, 1), *fix)
+	if err != nil {
+		return err
+	}
+	for _, m := range mismatches {
+		fmt.Printf("%v %v id %d: stored %d, recomputed %d\n", m.Family, m.Date.Format("2006-01-02"), m.ID,
+			m.Stored, m.Recomputed)
+	}
+
+	switch {
+	case len(mismatches) == 0:
+		log.Println("No mismatches found")
+	case *fix:
+		log.Printf("Repaired %d mismatch(es)\n", len(mismatches))
+	default:
+		return fmt.Errorf("found %d mismatch(es), rerun with --fix to repair them", len(mismatches))
+	}
+	return nil
+}